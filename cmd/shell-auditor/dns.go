@@ -0,0 +1,96 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cevin/shell-auditor/internal/audit"
+	"github.com/cevin/shell-auditor/internal/bpf"
+)
+
+// dnsTimeout 查询在此时间内没有收到响应时记录为 TIMEOUT
+const dnsTimeout = 5 * time.Second
+
+// maxPendingQueries 等待响应的查询上限，超过后不再跟踪新的查询
+const maxPendingQueries = 10000
+
+// dnsKey 关联同一次解析的查询和响应
+type dnsKey struct {
+	pid   uint32
+	id    uint16
+	name  string
+	qtype string
+}
+
+// dnsTracker 关联 DNS 查询和响应，每次解析记录一条 dns 事件：收到响应时记录响应的内容，
+// 超时未响应的查询记录为 TIMEOUT。重传的查询和重复的响应只记录一次
+type dnsTracker struct {
+	auditor  *audit.Auditor
+	mu       sync.Mutex
+	pending  map[dnsKey]*bpf.DNSEvent
+	answered map[dnsKey]time.Time
+}
+
+// newDNSTracker 创建 DNS 查询跟踪器
+func newDNSTracker(auditor *audit.Auditor) *dnsTracker {
+	return &dnsTracker{
+		auditor:  auditor,
+		pending:  make(map[dnsKey]*bpf.DNSEvent),
+		answered: make(map[dnsKey]time.Time),
+	}
+}
+
+// handle 处理内核采集的 DNS 报文，无法解析的报文（例如 53 端口上的其他协议）被忽略
+func (t *dnsTracker) handle(e *bpf.DNSEvent) {
+	if e.Err != nil {
+		return
+	}
+	key := dnsKey{pid: e.Process().PID, id: e.ID, name: e.Domain, qtype: e.Type}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !e.Response {
+		if _, ok := t.pending[key]; !ok && len(t.pending) < maxPendingQueries {
+			t.pending[key] = e
+		}
+		return
+	}
+	if _, ok := t.answered[key]; ok {
+		return
+	}
+	delete(t.pending, key)
+	t.answered[key] = e.Timestamp()
+	t.log(e, e.RCode)
+}
+
+// expire 记录超时的查询，并清理已过期的响应记录
+func (t *dnsTracker) expire(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key, q := range t.pending {
+		if now.Sub(q.Timestamp()) >= dnsTimeout {
+			delete(t.pending, key)
+			t.log(q, "TIMEOUT")
+		}
+	}
+	for key, at := range t.answered {
+		if now.Sub(at) >= dnsTimeout {
+			delete(t.answered, key)
+		}
+	}
+}
+
+// log 记录一次解析
+func (t *dnsTracker) log(e *bpf.DNSEvent, rcode string) {
+	p := e.Process()
+	t.auditor.LogDNS(int(p.PID), int(p.UID), int(p.GID), bpf.GetUsername(p.UID), audit.DNSDetails{
+		Domain:    e.Domain,
+		Type:      e.Type,
+		RCode:     rcode,
+		Answers:   e.Answers,
+		Server:    e.Server,
+		Truncated: e.Truncated,
+	})
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cevin/shell-auditor/internal/audit"
+	"github.com/cevin/shell-auditor/internal/bpf"
+	"golang.org/x/sys/unix"
+)
+
+// defaultWatchPaths 默认审计文件访问的路径，~ 表示每个可登录用户的家目录
+const defaultWatchPaths = "/etc,~/.ssh"
+
+// watchPaths 解析以逗号分隔的监视路径。~/ 开头的路径展开为 /etc/passwd 中可登录用户的家目录，
+// 已存在的路径解析符号链接，与内核中解析后的路径比较。文件只精确匹配；目录和尚不存在的路径
+// 以 "/" 结尾，匹配其本身和其下的所有路径，/etc 不会匹配 /etcd
+func watchPaths(spec string) ([]string, error) {
+	var paths []string
+	seen := make(map[string]bool)
+	add := func(p string) {
+		p = filepath.Clean(p)
+		if resolved, err := filepath.EvalSymlinks(p); err == nil {
+			p = resolved
+		}
+		if info, err := os.Stat(p); (err != nil || info.IsDir()) && p != "/" {
+			p += "/"
+		}
+		if !seen[p] {
+			seen[p] = true
+			paths = append(paths, p)
+		}
+	}
+
+	for _, p := range strings.Split(spec, ",") {
+		p = strings.TrimSpace(p)
+		switch {
+		case p == "":
+		case p == "~" || strings.HasPrefix(p, "~/"):
+			homes, err := homeDirs("/etc/passwd")
+			if err != nil {
+				return nil, err
+			}
+			for _, home := range homes {
+				add(home + p[1:])
+			}
+		case filepath.IsAbs(p):
+			add(p)
+		default:
+			return nil, fmt.Errorf("watch path %q must be absolute or start with ~/", p)
+		}
+	}
+	return paths, nil
+}
+
+// homeDirs 返回 passwd 文件中可登录用户存在的家目录，不包含根目录和 shell 为 nologin、false 的系统用户
+func homeDirs(passwd string) ([]string, error) {
+	f, err := os.Open(passwd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read home directories: %w", err)
+	}
+	defer f.Close()
+
+	var homes []string
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// name:password:uid:gid:gecos:home:shell
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 7 {
+			continue
+		}
+		home, shell := filepath.Clean(fields[5]), filepath.Base(fields[6])
+		if shell == "nologin" || shell == "false" || home == "/" || !filepath.IsAbs(home) || seen[home] {
+			continue
+		}
+		if info, err := os.Stat(home); err != nil || !info.IsDir() {
+			continue
+		}
+		seen[home] = true
+		homes = append(homes, home)
+	}
+	return homes, scanner.Err()
+}
+
+// flagNames 标志位及其名称，按顺序匹配，组合标志放在前面
+type flagNames []struct {
+	mask uint32
+	name string
+}
+
+// openFlagNames open 的标志，访问模式单独处理
+var openFlagNames = flagNames{
+	{unix.O_TMPFILE, "O_TMPFILE"},
+	{unix.O_CREAT, "O_CREAT"},
+	{unix.O_EXCL, "O_EXCL"},
+	{unix.O_TRUNC, "O_TRUNC"},
+	{unix.O_APPEND, "O_APPEND"},
+	{unix.O_NONBLOCK, "O_NONBLOCK"},
+	{unix.O_DIRECTORY, "O_DIRECTORY"},
+	{unix.O_NOFOLLOW, "O_NOFOLLOW"},
+	{unix.O_CLOEXEC, "O_CLOEXEC"},
+}
+
+// unlinkFlagNames unlinkat 的标志
+var unlinkFlagNames = flagNames{
+	{unix.AT_REMOVEDIR, "AT_REMOVEDIR"},
+}
+
+// renameFlagNames renameat2 的标志
+var renameFlagNames = flagNames{
+	{unix.RENAME_NOREPLACE, "RENAME_NOREPLACE"},
+	{unix.RENAME_EXCHANGE, "RENAME_EXCHANGE"},
+	{unix.RENAME_WHITEOUT, "RENAME_WHITEOUT"},
+}
+
+// format 以 | 连接标志名称，未知的标志位以十六进制表示
+func (names flagNames) format(flags uint32, parts ...string) string {
+	for _, n := range names {
+		if flags&n.mask == n.mask {
+			parts = append(parts, n.name)
+			flags &^= n.mask
+		}
+	}
+	if flags != 0 {
+		parts = append(parts, fmt.Sprintf("%#x", flags))
+	}
+	return strings.Join(parts, "|")
+}
+
+// openFlags 格式化 open 的标志，例如 O_WRONLY|O_CREAT|O_TRUNC
+func openFlags(flags uint32) string {
+	access := [...]string{"O_RDONLY", "O_WRONLY", "O_RDWR", "O_ACCMODE"}[flags&unix.O_ACCMODE]
+	return openFlagNames.format(flags&^unix.O_ACCMODE, access)
+}
+
+// fileDetails 将内核文件访问事件转换为审计详情
+func fileDetails(e *bpf.FileEvent) audit.FileDetails {
+	details := audit.FileDetails{
+		Operation: e.Op,
+		Path:      e.Path,
+		NewPath:   e.NewPath,
+		Result:    "success",
+	}
+	switch e.Op {
+	case "open":
+		details.Flags = openFlags(e.Flags)
+		if e.Flags&unix.O_CREAT != 0 || e.Flags&unix.O_TMPFILE == unix.O_TMPFILE {
+			details.Mode = fmt.Sprintf("%04o", e.Mode&07777)
+		}
+	case "unlink":
+		details.Flags = unlinkFlagNames.format(e.Flags)
+	case "rename":
+		details.Flags = renameFlagNames.format(e.Flags)
+	case "chmod":
+		details.Mode = fmt.Sprintf("%04o", e.Mode&07777)
+	case "chown":
+		details.UID = &e.Owner
+		details.GID = &e.Group
+	case "truncate":
+		details.Length = &e.Length
+	}
+	if e.Err != 0 {
+		details.Result = "failed"
+		details.Error = errnoName(e.Err)
+	}
+	return details
+}
@@ -0,0 +1,530 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cevin/shell-auditor/internal/audit"
+	"github.com/cevin/shell-auditor/internal/bpf"
+	"github.com/cevin/shell-auditor/internal/policy"
+	"github.com/cevin/shell-auditor/internal/shell"
+	"golang.org/x/sys/unix"
+)
+
+// 构建信息，由 Makefile 通过 -ldflags 注入
+var (
+	Version   = "dev"
+	BuildTime = "unknown"
+	GitCommit = "unknown"
+)
+
+// options 命令行选项
+type options struct {
+	shellMode  bool
+	logPath    string
+	logSize    int
+	noBPF      bool
+	verbose    bool
+	version    bool
+	maxArgs    int
+	watch      string // 以逗号分隔的文件访问监视路径
+	watchReads bool
+	logKey     string
+	policy     string
+	record     bool
+	recordDir  string
+	command    string   // -c 指定的命令
+	args       []string // 脚本及其参数，或 -c 时的 $0 和位置参数
+	login      bool     // 作为登录 shell 启动（argv[0] 以 - 开头）
+}
+
+// interactive 判断 shell 模式下是否进入交互式 shell
+func (o *options) interactive() bool {
+	return o.command == "" && len(o.args) == 0
+}
+
+// subcommands 子命令，参数为子命令之后的命令行参数
+var subcommands = map[string]func(args []string) error{
+	"verify": runVerify,
+	"record": runRecord,
+	"replay": runReplay,
+	"query":  runQuery,
+}
+
+// exitStatus 要求以指定的退出码结束，例如 record 传递被录制命令的退出码，
+// -c 和脚本传递最后一个命令的退出状态
+type exitStatus int
+
+func (e exitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			if err := cmd(os.Args[2:]); err != nil {
+				exit("shell-auditor "+os.Args[1], err)
+			}
+			return
+		}
+	}
+
+	opts := parseFlags()
+
+	if opts.version {
+		fmt.Printf("shell-auditor %s (commit %s, built %s)\n", Version, GitCommit, BuildTime)
+		return
+	}
+
+	if err := run(opts); err != nil {
+		exit("shell-auditor", err)
+	}
+}
+
+// exit 输出错误并结束进程，exitStatus 直接作为退出码
+func exit(prefix string, err error) {
+	var status exitStatus
+	if errors.As(err, &status) {
+		os.Exit(int(status))
+	}
+	fmt.Fprintf(os.Stderr, "%s: %v\n", prefix, err)
+	os.Exit(1)
+}
+
+// parseFlags 解析命令行参数
+func parseFlags() *options {
+	opts := &options{}
+	flag.BoolVar(&opts.shellMode, "shell", false, "Run in interactive shell mode")
+	flag.StringVar(&opts.command, "c", "", "Run the command in shell mode and exit, like sh -c")
+	opts.logFlags(flag.CommandLine)
+	flag.BoolVar(&opts.record, "record", false, "Record the terminal session in shell mode (asciicast v2)")
+	flag.StringVar(&opts.recordDir, "record-dir", "", "Directory for session recordings (default: ~/.shell-auditor/recordings)")
+	flag.StringVar(&opts.policy, "policy", "", "Path to command policy file (default: "+policy.DefaultPath+" if present)")
+	flag.BoolVar(&opts.noBPF, "no-bpf", false, "Disable BPF tracing (fallback mode)")
+	flag.IntVar(&opts.maxArgs, "max-args", bpf.DefaultMaxArgs, "Max number of execve arguments captured per command")
+	flag.StringVar(&opts.watch, "watch", defaultWatchPaths, "Comma-separated paths to audit file access under, ~/ expands to each login user's home directory (empty to disable)")
+	flag.BoolVar(&opts.watchReads, "watch-reads", false, "Also audit read-only opens of watched paths; off by default, so reads such as cat ~/.ssh/id_rsa are not recorded")
+	flag.BoolVar(&opts.verbose, "v", false, "Verbose mode")
+	flag.BoolVar(&opts.version, "version", false, "Print version and exit")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: shell-auditor [options] [-c command [name [args...]] | script [args...]]\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	// 作为登录 shell、指定 -c 或脚本时进入 shell 模式。sshd 以 "-shell-auditor" 启动登录 shell，
+	// 以 "shell-auditor -c <命令>" 执行 ssh 远程命令和子系统
+	opts.args = flag.Args()
+	opts.login = strings.HasPrefix(filepath.Base(os.Args[0]), "-")
+	if opts.login || opts.command != "" || len(opts.args) > 0 {
+		opts.shellMode = true
+	}
+
+	// ForceCommand 执行 shell-auditor 时，用户请求的命令在 SSH_ORIGINAL_COMMAND 中。
+	// 读取后清除，避免在会话中再次启动的 shell-auditor 重复执行
+	if opts.shellMode && opts.interactive() {
+		if command := os.Getenv("SSH_ORIGINAL_COMMAND"); command != "" {
+			opts.command = command
+			os.Unsetenv("SSH_ORIGINAL_COMMAND")
+		}
+	}
+	return opts
+}
+
+// logFlags 注册审计日志相关的选项，子命令也会使用
+func (o *options) logFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.logPath, "log", "", "Path to audit log file, \"-\" for stdout (default: ~/.shell-auditor/audit.log)")
+	fs.IntVar(&o.logSize, "log-size", 100, "Max log file size in MB before rotation, 0 disables rotation")
+	fs.StringVar(&o.logKey, "log-key", "", "HMAC key or ed25519 private key file used to sign audit log records")
+}
+
+// run 根据选项启动守护进程或交互式shell
+func run(opts *options) error {
+	logger, err := newLogger(opts)
+	if err != nil {
+		return err
+	}
+
+	auditor := audit.NewAuditor(logger, 0)
+
+	// 用现有进程初始化进程表，之后由 fork/exec/exit 事件维护
+	if err := auditor.Processes().Seed("/proc"); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+
+	var tracer *bpf.BPFTracer
+	var consumer *eventConsumer
+	if !opts.noBPF {
+		var watch []string
+		watch, err = watchPaths(opts.watch)
+		if err == nil {
+			tracer, consumer, err = startTracer(bpf.Config{MaxArgs: opts.maxArgs, WatchPaths: watch, WatchReads: opts.watchReads}, auditor)
+		}
+		if err != nil {
+			if !opts.shellMode {
+				auditor.Close()
+				return err
+			}
+			// shell 模式下降级为仅记录 shell 内执行的命令。非交互执行时输出可能被
+			// scp 等程序解析，只在 -v 时提示
+			if opts.interactive() || opts.verbose {
+				fmt.Fprintf(os.Stderr, "Warning: %v, falling back to shell-only auditing\n", err)
+			}
+		}
+	} else if !opts.shellMode {
+		auditor.Close()
+		return fmt.Errorf("daemon mode requires BPF tracing, remove -no-bpf or use -shell")
+	}
+
+	done := make(chan struct{})
+	var once sync.Once
+	shutdown := func() {
+		once.Do(func() {
+			close(done)
+			if tracer != nil {
+				tracer.Close()
+			}
+			if err := auditor.Close(); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to close audit log: %v\n", err)
+			}
+		})
+	}
+	defer shutdown()
+
+	if consumer != nil {
+		go consumer.run(done)
+	}
+
+	// 交互式 shell 自行处理 SIGINT，这里只响应终止信号
+	sigChan := make(chan os.Signal, 1)
+	if opts.shellMode && opts.interactive() {
+		signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGHUP)
+	} else {
+		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	}
+
+	if !opts.shellMode {
+		if opts.verbose {
+			log.Printf("shell-auditor %s started in daemon mode", Version)
+		}
+		sig := <-sigChan
+		if opts.verbose {
+			log.Printf("received %s, shutting down", sig)
+		}
+		return nil
+	}
+
+	pol, err := loadPolicy(opts.policy)
+	if err != nil {
+		return err
+	}
+	cfg := shell.Config{Policy: pol, Profile: shell.SystemProfile}
+	cfg.LogPath, _ = logPath(opts)
+	if dir, err := dataDir(); err == nil {
+		cfg.HistoryFile = filepath.Join(dir, "history")
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		cfg.RCFile = filepath.Join(home, shell.UserRC)
+	}
+	if opts.record {
+		if cfg.RecordDir, err = recordDir(opts.recordDir); err != nil {
+			return err
+		}
+	}
+	sh, err := shell.NewShell(auditor, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create shell: %w", err)
+	}
+
+	go func() {
+		sig := (<-sigChan).(syscall.Signal)
+		sh.Terminate(sig)
+		shutdown()
+		os.Exit(128 + int(sig))
+	}()
+
+	var status int
+	switch {
+	case opts.command != "":
+		status = sh.RunCommand(opts.command, opts.args)
+	case len(opts.args) > 0:
+		status = sh.RunScript(opts.args[0], opts.args[1:])
+	default:
+		return sh.Run()
+	}
+	if status != 0 {
+		return exitStatus(status)
+	}
+	return nil
+}
+
+// newLogger 根据选项创建日志记录器
+func newLogger(opts *options) (audit.Logger, error) {
+	if opts.logPath == "-" {
+		return audit.NewStdoutLogger(), nil
+	}
+
+	path, err := logPath(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var signer audit.Signer
+	if opts.logKey != "" {
+		if signer, err = audit.LoadSigner(opts.logKey); err != nil {
+			return nil, err
+		}
+	}
+
+	var logger audit.Logger
+	if opts.logSize > 0 {
+		logger, err = audit.NewRotatingLogger(path, opts.logSize, signer)
+	} else {
+		logger, err = audit.NewFileLogger(path, signer)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 守护进程模式下 -v 同时输出到标准输出
+	if opts.verbose && !opts.shellMode {
+		return audit.NewMultiLogger(logger, audit.NewStdoutLogger()), nil
+	}
+	return logger, nil
+}
+
+// logPath 返回审计日志路径，输出到标准输出时为空
+func logPath(opts *options) (string, error) {
+	switch opts.logPath {
+	case "-":
+		return "", nil
+	case "":
+		dir, err := dataDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, "audit.log"), nil
+	}
+	return opts.logPath, nil
+}
+
+// dataDir 返回默认的数据目录 ~/.shell-auditor
+func dataDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine home directory: %w", err)
+	}
+	return filepath.Join(home, ".shell-auditor"), nil
+}
+
+// recordDir 返回会话录制目录，未指定时为 ~/.shell-auditor/recordings
+func recordDir(dir string) (string, error) {
+	if dir != "" {
+		return dir, nil
+	}
+	data, err := dataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(data, "recordings"), nil
+}
+
+// loadPolicy 加载命令执行策略，未指定路径且默认策略文件不存在时返回 nil
+func loadPolicy(path string) (*policy.Policy, error) {
+	if path == "" {
+		if _, err := os.Stat(policy.DefaultPath); err != nil {
+			return nil, nil
+		}
+		path = policy.DefaultPath
+	}
+	return policy.Load(path)
+}
+
+// startTracer 加载BPF追踪器，订阅事件后再启动，启动后产生的事件都有订阅者接收
+func startTracer(cfg bpf.Config, auditor *audit.Auditor) (*bpf.BPFTracer, *eventConsumer, error) {
+	tracer, err := bpf.NewBPFTracer(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	consumer := newEventConsumer(tracer, auditor)
+	if err := tracer.Start(); err != nil {
+		tracer.Close()
+		return nil, nil, err
+	}
+	return tracer, consumer, nil
+}
+
+// lostReportInterval 丢失事件检查间隔
+const lostReportInterval = 30 * time.Second
+
+// eventHandlers 各类BPF事件到审计记录的转换
+func eventHandlers(auditor *audit.Auditor, dns *dnsTracker, ports *portTracker) map[bpf.EventKind]func(bpf.Event) {
+	return map[bpf.EventKind]func(bpf.Event){
+		bpf.KindExec: func(ev bpf.Event) {
+			e := ev.(*bpf.ExecEvent)
+			p := e.Process()
+			if e.AuditSessionID != bpf.AuditUnset {
+				auditor.Processes().SetAuditSession(int(p.PID), int(p.PPID), e.AuditSessionID)
+			}
+			auditor.LogExec(int(p.PID), int(p.PPID), int(p.UID), int(p.GID),
+				bpf.GetUsername(p.UID), e.Command, e.Filename, e.Args, e.WorkingDir,
+				e.ArgsTruncated || e.WorkingDirTruncated)
+		},
+		bpf.KindFork: func(ev bpf.Event) {
+			e := ev.(*bpf.ForkEvent)
+			p := e.Process()
+			auditor.Processes().Fork(int(e.ChildPID), int(p.PID), p.Comm)
+		},
+		bpf.KindExit: func(ev bpf.Event) {
+			e := ev.(*bpf.ExitEvent)
+			p := e.Process()
+			auditor.LogProcessExit(int(p.PID), int(p.UID), int(p.GID), bpf.GetUsername(p.UID), audit.ExitInfo{
+				ExitCode:   e.ExitCode,
+				Signal:     e.Signal,
+				CoreDumped: e.CoreDumped,
+				UserTimeMs: e.UserTime.Milliseconds(),
+				SysTimeMs:  e.SystemTime.Milliseconds(),
+				DurationMs: e.WallTime.Milliseconds(),
+			})
+		},
+		bpf.KindConnect: func(ev bpf.Event) {
+			e := ev.(*bpf.ConnectEvent)
+			p := e.Process()
+			details := audit.NetworkDetails{
+				Protocol: e.Protocol,
+				SrcIP:    e.SrcIP,
+				SrcPort:  e.SrcPort,
+				DstIP:    e.DstIP,
+				DstPort:  e.DstPort,
+				Status:   "connected",
+			}
+			if e.Err != 0 {
+				details.Status = "failed"
+				details.Error = errnoName(e.Err)
+			}
+			auditor.LogNetwork(int(p.PID), int(p.UID), int(p.GID), bpf.GetUsername(p.UID), details)
+		},
+		bpf.KindClose: func(ev bpf.Event) {
+			e := ev.(*bpf.CloseEvent)
+			p := e.Process()
+			direction := "outbound"
+			if e.Inbound {
+				direction = "inbound"
+			}
+			auditor.LogConnectionClose(int(p.PID), int(p.UID), int(p.GID), bpf.GetUsername(p.UID), p.Comm, audit.ConnectionCloseDetails{
+				Protocol:      e.Protocol,
+				Direction:     direction,
+				LocalIP:       e.LocalIP,
+				LocalPort:     e.LocalPort,
+				RemoteIP:      e.RemoteIP,
+				RemotePort:    e.RemotePort,
+				BytesSent:     e.BytesSent,
+				BytesReceived: e.BytesReceived,
+				DurationMs:    e.Duration.Milliseconds(),
+			})
+		},
+		bpf.KindAccept: func(ev bpf.Event) {
+			e := ev.(*bpf.AcceptEvent)
+			p := e.Process()
+			auditor.LogAccept(int(p.PID), int(p.UID), int(p.GID), bpf.GetUsername(p.UID), audit.AcceptDetails{
+				Protocol:   e.Protocol,
+				LocalIP:    e.LocalIP,
+				LocalPort:  e.LocalPort,
+				RemoteIP:   e.RemoteIP,
+				RemotePort: e.RemotePort,
+			})
+		},
+		bpf.KindListen: func(ev bpf.Event) {
+			ports.handle(ev.(*bpf.ListenEvent))
+		},
+		bpf.KindFile: func(ev bpf.Event) {
+			e := ev.(*bpf.FileEvent)
+			p := e.Process()
+			auditor.LogFile(int(p.PID), int(p.UID), int(p.GID), bpf.GetUsername(p.UID), p.Comm, fileDetails(e))
+		},
+		bpf.KindDNS: func(ev bpf.Event) {
+			dns.handle(ev.(*bpf.DNSEvent))
+		},
+	}
+}
+
+// errnoName 返回 errno 的名称，例如 ECONNREFUSED，未知的错误使用其描述
+func errnoName(errno unix.Errno) string {
+	if name := unix.ErrnoName(errno); name != "" {
+		return name
+	}
+	return errno.Error()
+}
+
+// eventGroups 每组事件由一个 goroutine 按内核提交顺序处理，
+// 进程生命周期事件之间有先后依赖，因此放在同一组
+var eventGroups = [][]bpf.EventKind{
+	{bpf.KindFork, bpf.KindExec, bpf.KindExit},
+	{bpf.KindConnect, bpf.KindAccept, bpf.KindClose},
+	{bpf.KindListen},
+	{bpf.KindDNS},
+	{bpf.KindFile},
+}
+
+// eventConsumer 按事件种类订阅BPF事件并转换为审计事件
+type eventConsumer struct {
+	tracer *bpf.BPFTracer
+	dns    *dnsTracker
+	ports  *portTracker
+}
+
+// newEventConsumer 订阅各组事件并启动处理 goroutine，须在追踪器启动前调用
+func newEventConsumer(tracer *bpf.BPFTracer, auditor *audit.Auditor) *eventConsumer {
+	c := &eventConsumer{
+		tracer: tracer,
+		dns:    newDNSTracker(auditor),
+		ports:  newPortTracker(auditor, tracer),
+	}
+	handlers := eventHandlers(auditor, c.dns, c.ports)
+	for _, kinds := range eventGroups {
+		go func(events <-chan bpf.Event) {
+			for ev := range events {
+				handlers[ev.Kind()](ev)
+			}
+		}(tracer.Subscribe(kinds...))
+	}
+	return c
+}
+
+// run 获取监听端口快照，之后定期处理超时的 DNS 查询并报告丢失的事件
+func (c *eventConsumer) run(done <-chan struct{}) {
+	tracer, dns, ports := c.tracer, c.dns, c.ports
+
+	// 追踪器已启动后再获取快照，快照期间开始监听的端口按 inode 去重
+	ports.snapshot()
+
+	ticker := time.NewTicker(lostReportInterval)
+	defer ticker.Stop()
+	dnsTicker := time.NewTicker(time.Second)
+	defer dnsTicker.Stop()
+
+	var reportedLost uint64
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-dnsTicker.C:
+			dns.expire(now)
+		case <-ticker.C:
+			// 审计事件丢失需要让运维人员知道
+			if lost := tracer.LostSamples(); lost > reportedLost {
+				log.Printf("warning: %d BPF events lost (total %d), consider a larger buffer", lost-reportedLost, lost)
+				reportedLost = lost
+			}
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/cevin/shell-auditor/internal/audit"
+	"github.com/cevin/shell-auditor/internal/bpf"
+)
+
+// portTracker 维护监听中的端口：启动时从 /proc 获取快照，之后由 BPF 的监听和关闭事件更新。
+// 端口开始监听时记录 port_open，停止监听时记录 port_closed。UDP 没有监听状态，显式绑定端口
+// 视为开始监听，释放端口视为停止监听；快照中的 UDP 端口交给内核跟踪其释放
+type portTracker struct {
+	auditor *audit.Auditor
+	tracer  *bpf.BPFTracer
+	mu      sync.Mutex
+	open    map[uint64]audit.Listener // 以套接字 inode 为键
+}
+
+// newPortTracker 创建监听端口跟踪器
+func newPortTracker(auditor *audit.Auditor, tracer *bpf.BPFTracer) *portTracker {
+	return &portTracker{
+		auditor: auditor,
+		tracer:  tracer,
+		open:    make(map[uint64]audit.Listener),
+	}
+}
+
+// snapshot 记录启动时已在监听的端口
+func (t *portTracker) snapshot() {
+	listeners, err := audit.ScanListeners("/proc", "tcp", "tcp6", "udp", "udp6")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.add(listeners, "snapshot")
+
+	var udp []uint64
+	for _, l := range listeners {
+		if l.Protocol == "udp" {
+			udp = append(udp, l.Inode)
+		}
+	}
+	if err := t.tracer.TrackUDPPorts(udp...); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+}
+
+// handle 处理内核观测到的开始和停止监听。已经记录的套接字（例如修改 backlog 的重复 listen）
+// 和未记录的套接字的关闭（例如 listen 失败）被忽略
+func (t *portTracker) handle(e *bpf.ListenEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if e.Closed {
+		if l, ok := t.open[e.Inode]; ok {
+			delete(t.open, e.Inode)
+			t.closed(l, "bpf")
+		}
+		return
+	}
+	if _, ok := t.open[e.Inode]; ok {
+		return
+	}
+	p := e.Process()
+	l := audit.Listener{
+		Protocol: e.Protocol,
+		Address:  e.Address,
+		Port:     e.Port,
+		Inode:    e.Inode,
+		UID:      int(p.UID),
+		PID:      int(p.PID),
+		GID:      int(p.GID),
+		Comm:     p.Comm,
+	}
+	t.open[e.Inode] = l
+	t.auditor.LogPortOpen(l.PID, l.UID, l.GID, bpf.GetUsername(p.UID), t.details(l, "bpf"))
+}
+
+// add 记录尚未记录的端口，并从 /proc 查找其所属进程
+func (t *portTracker) add(listeners []audit.Listener, source string) {
+	var added []audit.Listener
+	for _, l := range listeners {
+		if _, ok := t.open[l.Inode]; !ok {
+			added = append(added, l)
+		}
+	}
+	audit.ResolveOwners("/proc", added)
+	for _, l := range added {
+		t.open[l.Inode] = l
+		t.auditor.LogPortOpen(l.PID, l.UID, l.GID, bpf.GetUsername(uint32(l.UID)), t.details(l, source))
+	}
+}
+
+// closed 记录端口停止监听
+func (t *portTracker) closed(l audit.Listener, source string) {
+	t.auditor.LogPortClosed(l.PID, l.UID, l.GID, bpf.GetUsername(uint32(l.UID)), l.Comm, t.details(l, source))
+}
+
+// details 端口事件详情
+func (t *portTracker) details(l audit.Listener, source string) audit.PortDetails {
+	return audit.PortDetails{
+		Protocol: l.Protocol,
+		Port:     l.Port,
+		Address:  l.Address,
+		Source:   source,
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/cevin/shell-auditor/internal/audit"
+	"github.com/cevin/shell-auditor/internal/format"
+)
+
+// runQuery 按条件查询审计日志文件，条件与 shell 中的 audit 内置命令相同
+func runQuery(args []string) error {
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	logFile := fs.String("log", "", "Path to audit log file, rotated files are included (default: ~/.shell-auditor/audit.log)")
+	style := fs.String("format", "table", "Output format: table, compact, detailed or json")
+	color := fs.String("color", "auto", "Colorize output: auto, always or never")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: shell-auditor query [options] [key=value ...]\n\n")
+		fmt.Fprintf(fs.Output(), "Keys: type, user, since, until, cmd, dst, port, pid, session, limit, page.\n")
+		fmt.Fprintf(fs.Output(), "Example: shell-auditor query type=command user=alice since=2h cmd='^curl'\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	s, err := format.ParseStyle(*style)
+	if err != nil {
+		return err
+	}
+	f := format.New(s, false)
+	switch *color {
+	case "auto":
+		f.Color = format.ColorEnabled(os.Stdout)
+	case "always":
+		f.Color = true
+	case "never":
+	default:
+		return fmt.Errorf("invalid -color %q, expected auto, always or never", *color)
+	}
+
+	q, err := audit.ParseQuery(fs.Args())
+	if err != nil {
+		return err
+	}
+	q.MergeExits = s.MergesExits()
+	if *logFile == "-" {
+		return fmt.Errorf("cannot query a log written to stdout")
+	}
+	path, err := logPath(&options{logPath: *logFile})
+	if err != nil {
+		return err
+	}
+	files := audit.LogFiles(path)
+	if len(files) == 0 {
+		return fmt.Errorf("no audit log files found for %s", path)
+	}
+
+	result, err := q.Search(files)
+	if err != nil {
+		return err
+	}
+	f.Exits = result.Exits
+	if err := f.Write(os.Stdout, result.Events); err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, result.Summary())
+	return nil
+}
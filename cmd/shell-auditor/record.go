@@ -0,0 +1,163 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"os/user"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cevin/shell-auditor/internal/audit"
+	"github.com/cevin/shell-auditor/internal/record"
+	"github.com/cevin/shell-auditor/internal/shell"
+	"github.com/cevin/shell-auditor/internal/tty"
+)
+
+// runRecord 在伪终端中运行命令（默认为 $SHELL）并录制终端会话，
+// 会话开始和结束时写入 session_start、session_end 事件
+func runRecord(args []string) error {
+	fs := flag.NewFlagSet("record", flag.ExitOnError)
+	opts := &options{shellMode: true}
+	opts.logFlags(fs)
+	dir := fs.String("dir", "", "Directory for session recordings (default: ~/.shell-auditor/recordings)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: shell-auditor record [options] [-- command [args...]]\n\n")
+		fmt.Fprintf(fs.Output(), "Runs the command (default: $SHELL) under a PTY and records the session.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	argv := fs.Args()
+	if len(argv) == 0 {
+		sh := os.Getenv("SHELL")
+		if sh == "" {
+			sh = "/bin/sh"
+		}
+		argv = []string{sh}
+	}
+	path, err := exec.LookPath(argv[0])
+	if err != nil {
+		return err
+	}
+
+	recDir, err := recordDir(*dir)
+	if err != nil {
+		return err
+	}
+	logger, err := newLogger(opts)
+	if err != nil {
+		return err
+	}
+	auditor := audit.NewAuditor(logger, 0)
+	defer auditor.Close()
+
+	sessionID := audit.NewSessionID()
+	details := shell.LoginDetails()
+	details.Recording = record.Path(recDir, sessionID)
+	width, height := tty.Size(os.Stdin)
+	rec, err := record.Create(details.Recording, width, height, strings.Join(argv, " "))
+	if err != nil {
+		return err
+	}
+	defer rec.Close()
+
+	uid, gid := os.Getuid(), os.Getgid()
+	username := ""
+	if u, err := user.Current(); err == nil {
+		username = u.Username
+	}
+
+	cmd := exec.Command(path, argv[1:]...)
+	cmd.Args[0] = argv[0]
+
+	// 终止信号转发给被录制的命令，命令退出后再记录 session_end
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigChan)
+
+	started := time.Now()
+	auditor.RegisterSession(os.Getpid(), sessionID)
+	auditor.LogSessionStart(os.Getpid(), uid, gid, username, sessionID, details)
+
+	finish, err := record.NewTerminal(os.Stdin, os.Stdout, rec).Start(cmd)
+	if err != nil {
+		details.Reason = "exit"
+		auditor.LogSessionEnd(os.Getpid(), uid, gid, username, sessionID, details)
+		return fmt.Errorf("failed to start %s: %w", argv[0], err)
+	}
+
+	reasons := make(chan string, 1)
+	go func() {
+		reason := "exit"
+		defer func() { reasons <- reason }()
+		for sig := range sigChan {
+			reason = "terminated"
+			if sig == syscall.SIGHUP {
+				reason = "hangup"
+			}
+			cmd.Process.Signal(sig)
+		}
+	}()
+
+	cmd.Wait()
+	finish()
+	signal.Stop(sigChan)
+	close(sigChan)
+
+	details.Reason = <-reasons
+	details.DurationMs = time.Since(started).Milliseconds()
+	auditor.LogSessionEnd(os.Getpid(), uid, gid, username, sessionID, details)
+	auditor.UnregisterSession(os.Getpid())
+
+	if code := exitCode(cmd.ProcessState); code != 0 {
+		return exitStatus(code)
+	}
+	return nil
+}
+
+// exitCode 返回命令的退出码，被信号终止时为 128+信号
+func exitCode(state *os.ProcessState) int {
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	return state.ExitCode()
+}
+
+// runReplay 按录制时的节奏回放终端会话
+func runReplay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	speed := fs.Float64("speed", 1, "Playback speed multiplier")
+	idleLimit := fs.Duration("idle-limit", 0, "Cap pauses between outputs, e.g. 2s, 0 for no limit")
+	dir := fs.String("dir", "", "Directory for session recordings (default: ~/.shell-auditor/recordings)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: shell-auditor replay [options] <session-id | file.cast>\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected one session id or recording file")
+	}
+
+	path := fs.Arg(0)
+	if _, err := os.Stat(path); err != nil {
+		recDir, err := recordDir(*dir)
+		if err != nil {
+			return err
+		}
+		path = record.Path(recDir, fs.Arg(0))
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open recording: %w", err)
+	}
+	defer f.Close()
+
+	return record.Play(f, os.Stdout, record.PlayOptions{Speed: *speed, IdleLimit: *idleLimit})
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/cevin/shell-auditor/internal/audit"
+)
+
+// runVerify 校验审计日志的哈希链和签名，发现问题时返回错误
+func runVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	keyPath := fs.String("key", "", "HMAC key or ed25519 key file used to verify record signatures")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: shell-auditor verify [-key file] <log files...>\n\n")
+		fmt.Fprintf(fs.Output(), "Files are verified in the given order, the hash chain continues across files.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("no log files given")
+	}
+
+	var signer audit.Signer
+	if *keyPath != "" {
+		var err error
+		if signer, err = audit.LoadSigner(*keyPath); err != nil {
+			return err
+		}
+	}
+
+	verifier := audit.NewVerifier(signer)
+	problems := 0
+	for _, path := range fs.Args() {
+		issues, err := verifier.VerifyFile(path)
+		for _, issue := range issues {
+			fmt.Println(issue)
+		}
+		problems += len(issues)
+		if err != nil {
+			return err
+		}
+	}
+
+	if problems > 0 {
+		return fmt.Errorf("%d problem(s) found in %d record(s)", problems, verifier.Records())
+	}
+	fmt.Fprintf(os.Stderr, "OK: %d record(s) verified\n", verifier.Records())
+	return nil
+}
@@ -1,9 +1,13 @@
 package audit
 
 import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"os"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -12,58 +16,190 @@ import (
 type EventType string
 
 const (
-	EventCommand   EventType = "command"
-	EventPortOpen  EventType = "port_open"
-	EventNetwork   EventType = "network"
-	EventDNS       EventType = "dns"
-	EventFile      EventType = "file"
+	EventCommand         EventType = "command"
+	EventCommandExit     EventType = "command_exit"
+	EventPortOpen        EventType = "port_open"
+	EventPortClosed      EventType = "port_closed"
+	EventNetwork         EventType = "network"
+	EventAccept          EventType = "accept"
+	EventConnectionClose EventType = "connection_close"
+	EventDNS             EventType = "dns"
+	EventFile            EventType = "file"
+	EventSessionStart    EventType = "session_start"
+	EventSessionEnd      EventType = "session_end"
+	EventPolicyViolation EventType = "policy_violation"
+	EventFileTransfer    EventType = "file_transfer"
+	EventJobStart        EventType = "job_start"
+	EventJobStop         EventType = "job_stop"
+	EventJobContinue     EventType = "job_continue"
+	EventJobExit         EventType = "job_exit"
+	EventBuiltin         EventType = "builtin"
+	EventStartupFile     EventType = "startup_file"
+	EventAuditCleared    EventType = "audit_cleared"
 )
 
 // AuditEvent 审计事件
 type AuditEvent struct {
-	Timestamp   time.Time   `json:"timestamp"`
-	Type        EventType   `json:"type"`
-	PID         int         `json:"pid"`
-	PPID        int         `json:"ppid"`
-	UID         int         `json:"uid"`
-	GID         int         `json:"gid"`
-	Username    string      `json:"username"`
-	Command     string      `json:"command,omitempty"`
-	Args        []string    `json:"args,omitempty"`
-	ExitCode    int         `json:"exit_code,omitempty"`
-	WorkingDir  string      `json:"working_dir,omitempty"`
-	Details     interface{} `json:"details,omitempty"`
-}
-
-// PortDetails 端口详情
+	ID         string      `json:"id"`
+	Timestamp  time.Time   `json:"timestamp"`
+	Type       EventType   `json:"type"`
+	SessionID  string      `json:"session_id,omitempty"`
+	PipelineID string      `json:"pipeline_id,omitempty"`
+	RawLine    string      `json:"raw_line,omitempty"`
+	Alias      string      `json:"alias,omitempty"` // 命令来自别名展开时为别名的名称
+	PID        int         `json:"pid"`
+	PPID       int         `json:"ppid"`
+	UID        int         `json:"uid"`
+	GID        int         `json:"gid"`
+	Username   string      `json:"username"`
+	Command    string      `json:"command,omitempty"`
+	Executable string      `json:"executable,omitempty"`
+	Args       []string    `json:"args,omitempty"`
+	Truncated  bool        `json:"truncated,omitempty"`
+	WorkingDir string      `json:"working_dir,omitempty"`
+	Details    interface{} `json:"details,omitempty"`
+
+	// 进程树信息：祖先链从父进程开始，SessionRoot 为所属会话的首进程
+	Lineage     []ProcessRef `json:"lineage,omitempty"`
+	SessionRoot *ProcessRef  `json:"session_root,omitempty"`
+}
+
+// ExitInfo 进程退出信息。正常退出时 ExitCode 为退出码；被信号终止时 ExitCode 为 0，
+// Signal 为信号编号（shell 的 $? 为 128+信号，不写入日志）
+type ExitInfo struct {
+	ExitCode   int   `json:"exit_code"`
+	Signal     int   `json:"signal,omitempty"`
+	CoreDumped bool  `json:"core_dumped,omitempty"`
+	UserTimeMs int64 `json:"user_time_ms"`
+	SysTimeMs  int64 `json:"sys_time_ms"`
+	DurationMs int64 `json:"duration_ms"`
+}
+
+// CommandExitDetails 命令退出详情，CommandID 为对应 command 事件的 ID
+type CommandExitDetails struct {
+	CommandID string `json:"command_id"`
+	ExitInfo
+}
+
+// PortDetails 端口详情，Source 为发现端口的方式：bpf（内核观测到 listen 或关闭）、
+// snapshot（启动时已在监听）
 type PortDetails struct {
 	Protocol string `json:"protocol"` // tcp, udp
 	Port     int    `json:"port"`
 	Address  string `json:"address"`
+	Source   string `json:"source,omitempty"`
 }
 
-// NetworkDetails 网络请求详情
+// NetworkDetails 网络请求详情，Error 为连接失败时的 errno 名称，例如 ECONNREFUSED
 type NetworkDetails struct {
-	Protocol string `json:"protocol"` // tcp, udp
+	Protocol string `json:"protocol"` // tcp, udp, raw
 	SrcIP    string `json:"src_ip"`
 	SrcPort  int    `json:"src_port"`
 	DstIP    string `json:"dst_ip"`
 	DstPort  int    `json:"dst_port"`
+	Status   string `json:"status"` // connected, failed
+	Error    string `json:"error,omitempty"`
 }
 
-// DNSDetails DNS解析详情
+// ConnectionCloseDetails TCP 连接关闭详情，Direction 为 outbound（本机发起）或 inbound（本机接受）
+type ConnectionCloseDetails struct {
+	Protocol      string `json:"protocol"`
+	Direction     string `json:"direction"`
+	LocalIP       string `json:"local_ip"`
+	LocalPort     int    `json:"local_port"`
+	RemoteIP      string `json:"remote_ip"`
+	RemotePort    int    `json:"remote_port"`
+	BytesSent     uint64 `json:"bytes_sent"` // 已被对端确认的字节数
+	BytesReceived uint64 `json:"bytes_received"`
+	DurationMs    int64  `json:"duration_ms"`
+}
+
+// AcceptDetails 入站连接详情，Local 为接受连接的本机地址，Remote 为发起连接的对端
+type AcceptDetails struct {
+	Protocol   string `json:"protocol"` // tcp
+	LocalIP    string `json:"local_ip"`
+	LocalPort  int    `json:"local_port"`
+	RemoteIP   string `json:"remote_ip"`
+	RemotePort int    `json:"remote_port"`
+}
+
+// DNSDetails DNS解析详情，Answers 中 A/AAAA 记录为地址，CNAME 等为名称
 type DNSDetails struct {
-	Domain   string `json:"domain"`
-	Resolved string `json:"resolved"`
-	Type     string `json:"type"` // A, AAAA, CNAME, etc.
+	Domain    string   `json:"domain"`
+	Type      string   `json:"type"`  // A, AAAA, CNAME, etc.
+	RCode     string   `json:"rcode"` // NOERROR, NXDOMAIN, SERVFAIL 等，没有收到响应时为 TIMEOUT
+	Answers   []string `json:"answers,omitempty"`
+	Server    string   `json:"server,omitempty"`
+	Truncated bool     `json:"truncated,omitempty"` // 响应超过 512 字节，只记录了前面的回答
+}
+
+// FileDetails 文件访问详情，Path 为系统调用参数与工作目录拼接出的绝对路径，不解析 .. 和符号链接。
+// UID/GID 仅 chown 有，-1 表示不修改；Error 为失败时的 errno 名称，例如 EACCES
+type FileDetails struct {
+	Operation string `json:"operation"` // open, unlink, rename, chmod, chown, truncate
+	Path      string `json:"path"`
+	NewPath   string `json:"new_path,omitempty"` // rename 的新路径
+	Flags     string `json:"flags,omitempty"`    // 例如 O_WRONLY|O_CREAT|O_TRUNC
+	Mode      string `json:"mode,omitempty"`     // 八进制权限，例如 0644
+	UID       *int   `json:"uid,omitempty"`
+	GID       *int   `json:"gid,omitempty"`
+	Length    *int64 `json:"length,omitempty"` // truncate 的新长度
+	Result    string `json:"result"`           // success, failed
+	Error     string `json:"error,omitempty"`
+}
+
+// PolicyDetails 策略命中详情
+type PolicyDetails struct {
+	RuleID  string `json:"rule_id"`
+	Action  string `json:"action"`  // deny, warn, confirm, restrict
+	Outcome string `json:"outcome"` // denied, warned, confirmed, declined
+	Message string `json:"message,omitempty"`
+}
+
+// FileTransferDetails 文件传输详情，CommandID 为传输服务端命令的 command 事件 ID
+type FileTransferDetails struct {
+	CommandID string   `json:"command_id"`
+	Protocol  string   `json:"protocol"`            // scp, sftp, rsync, git
+	Direction string   `json:"direction,omitempty"` // upload, download，sftp 会话中两者都可能发生
+	Paths     []string `json:"paths,omitempty"`
+}
+
+// ClearedDetails 审计日志清空详情，Events 为清空的内存事件数，Files 为删除的轮转日志文件
+type ClearedDetails struct {
+	Events int      `json:"events"`
+	Files  []string `json:"files,omitempty"`
+}
+
+// runningCommand 尚未退出的命令，shell 为 true 时由本进程的 shell 启动，execSeen 表示已经
+// 收到内核观测到的对应 exec
+type runningCommand struct {
+	id       string
+	start    time.Time
+	shell    bool
+	execSeen bool
 }
 
 // Auditor 审计器
 type Auditor struct {
-	mu       sync.RWMutex
-	events   []AuditEvent
-	logger   Logger
-	maxSize  int
+	mu         sync.RWMutex
+	events     []AuditEvent
+	running    map[int]runningCommand
+	shellExecs map[int]bool // 内核先于 shell 观测到的子进程第一次 exec，等待 LogCommand 认领
+	self       int          // 本进程PID，shell 启动的命令是它的子进程
+	procs      *ProcessTable
+	logger     Logger
+	maxSize    int
+	queue      chan queued
+	written    chan struct{}
+	closed     bool
+	sending    sync.WaitGroup // 已分配序号、尚未入队的写入
+	seq        uint64         // 写入队列的序号，写入协程按序号顺序写入
+	idPrefix   string
+	idSeq      uint64
+
+	// sessions 登记的会话首进程 PID 到会话ID的映射
+	sessMu   sync.RWMutex
+	sessions map[int]string
 }
 
 // Logger 日志接口
@@ -72,20 +208,101 @@ type Logger interface {
 	Close() error
 }
 
+// Clearer 可以清空已写入日志的日志记录器，返回删除的文件
+type Clearer interface {
+	Clear() ([]string, error)
+}
+
+// queued 写入队列中的一项，fn 不为 nil 时在写入之前所有事件后执行。入队在锁外进行，
+// 到达顺序可能与 seq 不同，写入协程按 seq 重新排序
+type queued struct {
+	seq   uint64
+	event AuditEvent
+	fn    func()
+}
+
 // NewAuditor 创建审计器
 func NewAuditor(logger Logger, maxSize int) *Auditor {
 	if maxSize <= 0 {
 		maxSize = 10000
 	}
-	return &Auditor{
-		events:  make([]AuditEvent, 0, maxSize),
-		logger:  logger,
-		maxSize: maxSize,
+	a := &Auditor{
+		events:     make([]AuditEvent, 0, maxSize),
+		running:    make(map[int]runningCommand),
+		shellExecs: make(map[int]bool),
+		self:       os.Getpid(),
+		procs:      NewProcessTable(),
+		sessions:   make(map[int]string),
+		logger:     logger,
+		maxSize:    maxSize,
+		idPrefix:   newIDPrefix(),
+		queue:      make(chan queued, 1024),
+		written:    make(chan struct{}),
+	}
+	go a.writeLoop()
+	return a
+}
+
+// writeLoop 按事件产生的顺序（序号顺序）依次写入日志
+func (a *Auditor) writeLoop() {
+	defer close(a.written)
+	next := uint64(1)
+	pending := make(map[uint64]queued)
+	for q := range a.queue {
+		pending[q.seq] = q
+		for {
+			q, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			a.write(q)
+		}
+	}
+}
+
+// write 写入一项
+func (a *Auditor) write(q queued) {
+	if q.fn != nil {
+		q.fn()
+		return
+	}
+	if a.logger == nil {
+		return
+	}
+	if err := a.logger.Log(q.event); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to log event: %v\n", err)
 	}
 }
 
-// LogCommand 记录命令执行
-func (a *Auditor) LogCommand(pid, ppid, uid, gid int, username, command string, args []string, workingDir string) {
+// reserve 分配写入序号，调用方需持有锁且审计器未关闭。返回的函数在锁外调用，将项放入写入队列；
+// 队列满时只阻塞调用方，不影响需要锁的其他操作
+func (a *Auditor) reserve() func(queued) {
+	a.seq++
+	seq := a.seq
+	a.sending.Add(1)
+	return func(q queued) {
+		defer a.sending.Done()
+		q.seq = seq
+		a.queue <- q
+	}
+}
+
+// newIDPrefix 生成本次运行的事件ID前缀，保证多次运行之间不重复
+func newIDPrefix() string {
+	var b [6]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// LogCommand 记录 shell 启动的外部命令，返回事件ID，供 LogCommandExit 关联。args 为展开后的参数，
+// executable 为查找到的可执行文件路径，pipelineID 关联同一管道中的各个命令，rawLine 为用户输入的
+// 原始命令行，alias 为展开出该命令的别名。内核观测到的该进程的第一次 exec 与此事件是同一条命令，
+// 不再单独记录；命令的退出由 shell 通过 LogCommandExit 记录
+func (a *Auditor) LogCommand(pid, ppid, uid, gid int, username, command, executable string, args []string, workingDir, pipelineID, rawLine, alias string) string {
 	event := AuditEvent{
 		Timestamp:  time.Now(),
 		Type:       EventCommand,
@@ -95,100 +312,441 @@ func (a *Auditor) LogCommand(pid, ppid, uid, gid int, username, command string,
 		GID:        gid,
 		Username:   username,
 		Command:    command,
+		Executable: executable,
 		Args:       args,
 		WorkingDir: workingDir,
+		PipelineID: pipelineID,
+		RawLine:    rawLine,
+		Alias:      alias,
 	}
-	a.log(event)
+	a.procs.Exec(pid, ppid, command, executable)
+	id := a.log(event)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	rc := runningCommand{id: id, start: event.Timestamp, shell: true}
+	if a.shellExecs[pid] {
+		delete(a.shellExecs, pid)
+		rc.execSeen = true
+	}
+	// 内核已经记录了该进程之后的 exec（例如 env 执行的命令），以内核记录的命令为准
+	if cur, ok := a.running[pid]; ok && !cur.shell {
+		return id
+	}
+	a.addRunning(pid, rc)
+	return id
 }
 
-// LogCommandExit 记录命令退出
-func (a *Auditor) LogCommandExit(pid int, exitCode int) {
-	a.mu.RLock()
-	// 查找最近的命令事件并更新
-	for i := len(a.events) - 1; i >= 0; i-- {
-		if a.events[i].Type == EventCommand && a.events[i].PID == pid && a.events[i].ExitCode == 0 {
-			a.events[i].ExitCode = exitCode
+// LogExec 记录内核观测到的 execve，truncated 表示参数或工作目录未采集完整。本进程的 shell 启动的
+// 命令的第一次 exec 已由 LogCommand 记录，不重复记录，返回空字符串
+func (a *Auditor) LogExec(pid, ppid, uid, gid int, username, command, executable string, args []string, workingDir string, truncated bool) string {
+	if ppid == a.self && a.claimShellExec(pid) {
+		a.procs.Exec(pid, ppid, command, executable)
+		return ""
+	}
+
+	event := AuditEvent{
+		Timestamp:  time.Now(),
+		Type:       EventCommand,
+		PID:        pid,
+		PPID:       ppid,
+		UID:        uid,
+		GID:        gid,
+		Username:   username,
+		Command:    command,
+		Executable: executable,
+		Args:       args,
+		WorkingDir: workingDir,
+		Truncated:  truncated,
+	}
+	a.procs.Exec(pid, ppid, command, executable)
+	id := a.log(event)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.addRunning(pid, runningCommand{id: id, start: event.Timestamp})
+	return id
+}
+
+// claimShellExec 判断本进程的子进程的 exec 是否为 shell 启动命令时的第一次 exec。shell 在
+// 命令启动后才调用 LogCommand，内核事件可能先到达，此时先登记，由 LogCommand 认领
+func (a *Auditor) claimShellExec(pid int) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if rc, ok := a.running[pid]; ok {
+		if !rc.shell || rc.execSeen {
+			return false
+		}
+		rc.execSeen = true
+		a.running[pid] = rc
+		return true
+	}
+	if a.shellExecs[pid] {
+		return false
+	}
+	if len(a.shellExecs) >= a.maxSize {
+		for p := range a.shellExecs {
+			delete(a.shellExecs, p)
 			break
 		}
 	}
-	a.mu.RUnlock()
+	a.shellExecs[pid] = true
+	return true
+}
+
+// addRunning 登记运行中的命令，同一PID再次 exec 时以最新的命令为准，调用方需持有锁
+func (a *Auditor) addRunning(pid int, rc runningCommand) {
+	if _, ok := a.running[pid]; !ok && len(a.running) >= a.maxSize {
+		a.evictOldestRunning()
+	}
+	a.running[pid] = rc
+}
+
+// evictOldestRunning 丢弃最早的运行中命令，防止丢失退出事件时无限增长，调用方需持有锁
+func (a *Auditor) evictOldestRunning() {
+	oldestPID := -1
+	var oldest time.Time
+	for pid, rc := range a.running {
+		if oldestPID == -1 || rc.start.Before(oldest) {
+			oldestPID, oldest = pid, rc.start
+		}
+	}
+	delete(a.running, oldestPID)
+}
+
+// LogCommandExit 记录 shell 等待到的命令退出，commandID 为 LogCommand 返回的事件ID
+func (a *Auditor) LogCommandExit(commandID string, pid, uid, gid int, username string, info ExitInfo) {
+	a.mu.Lock()
+	if rc, ok := a.running[pid]; ok && rc.id == commandID {
+		delete(a.running, pid)
+	}
+	a.mu.Unlock()
+
+	a.logExit(commandID, pid, uid, gid, username, info)
+	a.procs.Exit(pid)
+}
+
+// LogProcessExit 记录内核观测到的进程退出，并关联到该进程最近一次执行的命令。找不到对应命令
+// （例如进程启动早于审计）或命令由 shell 启动、退出由 LogCommandExit 记录时返回 false，不写入日志
+func (a *Auditor) LogProcessExit(pid, uid, gid int, username string, info ExitInfo) bool {
+	a.mu.Lock()
+	rc, ok := a.running[pid]
+	if ok && !rc.shell {
+		delete(a.running, pid)
+	}
+	delete(a.shellExecs, pid)
+	a.mu.Unlock()
+
+	if !ok || rc.shell {
+		if !ok {
+			a.procs.Exit(pid)
+		}
+		return false
+	}
+	defer a.procs.Exit(pid)
+	if info.DurationMs == 0 {
+		info.DurationMs = time.Since(rc.start).Milliseconds()
+	}
+	a.logExit(rc.id, pid, uid, gid, username, info)
+	return true
 }
 
-// LogPortOpen 记录端口开放
-func (a *Auditor) LogPortOpen(pid, uid, gid int, username string, protocol string, port int, address string) {
+// logExit 写入 command_exit 事件
+func (a *Auditor) logExit(commandID string, pid, uid, gid int, username string, info ExitInfo) {
 	event := AuditEvent{
 		Timestamp: time.Now(),
-		Type:      EventPortOpen,
+		Type:      EventCommandExit,
 		PID:       pid,
 		UID:       uid,
 		GID:       gid,
 		Username:  username,
-		Details: PortDetails{
-			Protocol: protocol,
-			Port:     port,
-			Address:  address,
+		Details: CommandExitDetails{
+			CommandID: commandID,
+			ExitInfo:  info,
 		},
 	}
 	a.log(event)
 }
 
-// LogNetwork 记录网络请求
-func (a *Auditor) LogNetwork(pid, uid, gid int, username string, protocol string, srcIP string, srcPort int, dstIP string, dstPort int) {
+// LogPolicyViolation 记录命中策略规则的命令，pid 为发起命令的进程
+func (a *Auditor) LogPolicyViolation(pid, ppid, uid, gid int, username, command, executable string, args []string, workingDir string, details PolicyDetails) {
+	event := AuditEvent{
+		Timestamp:  time.Now(),
+		Type:       EventPolicyViolation,
+		PID:        pid,
+		PPID:       ppid,
+		UID:        uid,
+		GID:        gid,
+		Username:   username,
+		Command:    command,
+		Executable: executable,
+		Args:       args,
+		WorkingDir: workingDir,
+		Details:    details,
+	}
+	a.log(event)
+}
+
+// LogFileTransfer 记录 scp、sftp、rsync 等文件传输
+func (a *Auditor) LogFileTransfer(pid, ppid, uid, gid int, username, command string, args []string, workingDir string, details FileTransferDetails) {
+	event := AuditEvent{
+		Timestamp:  time.Now(),
+		Type:       EventFileTransfer,
+		PID:        pid,
+		PPID:       ppid,
+		UID:        uid,
+		GID:        gid,
+		Username:   username,
+		Command:    command,
+		Args:       args,
+		WorkingDir: workingDir,
+		Details:    details,
+	}
+	a.log(event)
+}
+
+// LogBuiltin 记录 shell 内置命令，pid 为 shell 自身。export、cd、alias 等内置命令会改变
+// 之后命令的执行环境，参数与 LogCommand 相同
+func (a *Auditor) LogBuiltin(pid, ppid, uid, gid int, username, command string, args []string, workingDir, pipelineID, rawLine, alias string) {
+	event := AuditEvent{
+		Timestamp:  time.Now(),
+		Type:       EventBuiltin,
+		PID:        pid,
+		PPID:       ppid,
+		UID:        uid,
+		GID:        gid,
+		Username:   username,
+		Command:    command,
+		Args:       args,
+		WorkingDir: workingDir,
+		PipelineID: pipelineID,
+		RawLine:    rawLine,
+		Alias:      alias,
+	}
+	a.log(event)
+}
+
+// LogPortOpen 记录套接字开始监听，pid 为持有套接字的进程
+func (a *Auditor) LogPortOpen(pid, uid, gid int, username string, details PortDetails) {
+	event := AuditEvent{
+		Timestamp: time.Now(),
+		Type:      EventPortOpen,
+		PID:       pid,
+		Command:   a.comm(pid),
+		UID:       uid,
+		GID:       gid,
+		Username:  username,
+		Details:   details,
+	}
+	a.log(event)
+}
+
+// LogPortClosed 记录套接字停止监听，pid 和 comm 为开始监听时持有套接字的进程，
+// 进程可能已经退出，因此进程表中没有记录时使用 comm
+func (a *Auditor) LogPortClosed(pid, uid, gid int, username, comm string, details PortDetails) {
+	if c := a.comm(pid); c != "" {
+		comm = c
+	}
+	event := AuditEvent{
+		Timestamp: time.Now(),
+		Type:      EventPortClosed,
+		PID:       pid,
+		Command:   comm,
+		UID:       uid,
+		GID:       gid,
+		Username:  username,
+		Details:   details,
+	}
+	a.log(event)
+}
+
+// LogNetwork 记录网络请求及其结果
+func (a *Auditor) LogNetwork(pid, uid, gid int, username string, details NetworkDetails) {
 	event := AuditEvent{
 		Timestamp: time.Now(),
 		Type:      EventNetwork,
 		PID:       pid,
+		Command:   a.comm(pid),
 		UID:       uid,
 		GID:       gid,
 		Username:  username,
-		Details: NetworkDetails{
-			Protocol: protocol,
-			SrcIP:    srcIP,
-			SrcPort:  srcPort,
-			DstIP:    dstIP,
-			DstPort:  dstPort,
-		},
+		Details:   details,
+	}
+	a.log(event)
+}
+
+// LogConnectionClose 记录 TCP 连接关闭，pid 为发起或接受连接的进程，comm 为其进程名，
+// 进程可能已经退出，因此进程表中没有记录时使用内核提供的进程名
+func (a *Auditor) LogConnectionClose(pid, uid, gid int, username, comm string, details ConnectionCloseDetails) {
+	if c := a.comm(pid); c != "" {
+		comm = c
+	}
+	event := AuditEvent{
+		Timestamp: time.Now(),
+		Type:      EventConnectionClose,
+		PID:       pid,
+		Command:   comm,
+		UID:       uid,
+		GID:       gid,
+		Username:  username,
+		Details:   details,
+	}
+	a.log(event)
+}
+
+// LogAccept 记录入站连接，pid 为接受连接的进程
+func (a *Auditor) LogAccept(pid, uid, gid int, username string, details AcceptDetails) {
+	event := AuditEvent{
+		Timestamp: time.Now(),
+		Type:      EventAccept,
+		PID:       pid,
+		Command:   a.comm(pid),
+		UID:       uid,
+		GID:       gid,
+		Username:  username,
+		Details:   details,
 	}
 	a.log(event)
 }
 
-// LogDNS 记录DNS解析
-func (a *Auditor) LogDNS(pid, uid, gid int, username string, domain string, resolved string, dnsType string) {
+// LogDNS 记录DNS解析，pid 为发出查询的进程
+func (a *Auditor) LogDNS(pid, uid, gid int, username string, details DNSDetails) {
 	event := AuditEvent{
 		Timestamp: time.Now(),
 		Type:      EventDNS,
 		PID:       pid,
+		Command:   a.comm(pid),
 		UID:       uid,
 		GID:       gid,
 		Username:  username,
-		Details: DNSDetails{
-			Domain:   domain,
-			Resolved: resolved,
-			Type:     dnsType,
-		},
+		Details:   details,
 	}
 	a.log(event)
 }
 
-// log 内部日志方法
-func (a *Auditor) log(event AuditEvent) {
+// LogFile 记录监视路径下的文件访问，pid 和 comm 为发起系统调用的进程，进程表中没有记录时使用 comm
+func (a *Auditor) LogFile(pid, uid, gid int, username, comm string, details FileDetails) {
+	if c := a.comm(pid); c != "" {
+		comm = c
+	}
+	event := AuditEvent{
+		Timestamp: time.Now(),
+		Type:      EventFile,
+		PID:       pid,
+		Command:   comm,
+		UID:       uid,
+		GID:       gid,
+		Username:  username,
+		Details:   details,
+	}
+	a.log(event)
+}
+
+// comm 返回进程表中记录的进程名，用于内核观测到的网络事件，进程未知时为空
+func (a *Auditor) comm(pid int) string {
+	if p, ok := a.procs.Lookup(pid); ok {
+		return p.Comm
+	}
+	return ""
+}
+
+// Processes 返回进程表，调用方负责用 /proc 初始化并提供 fork 事件
+func (a *Auditor) Processes() *ProcessTable {
+	return a.procs
+}
+
+// enrich 根据进程表补充祖先链、会话首进程和会话ID
+func (a *Auditor) enrich(event *AuditEvent) {
+	if event.PID <= 0 || event.Lineage != nil {
+		return
+	}
+	event.Lineage = a.procs.Lineage(event.PID)
+	if root, ok := a.procs.SessionRoot(event.PID); ok {
+		event.SessionRoot = &root
+	}
+	if event.SessionID == "" {
+		event.SessionID = a.sessionFor(event.PID, event.Lineage)
+	}
+}
+
+// log 内部日志方法，分配并返回事件ID
+func (a *Auditor) log(event AuditEvent) string {
+	a.enrich(&event)
+
 	a.mu.Lock()
-	defer a.mu.Unlock()
+	if a.closed {
+		a.mu.Unlock()
+		return ""
+	}
+
+	a.idSeq++
+	event.ID = a.idPrefix + "-" + strconv.FormatUint(a.idSeq, 10)
 
 	// 限制内存中的事件数量
 	if len(a.events) >= a.maxSize {
 		a.events = a.events[1:]
 	}
 	a.events = append(a.events, event)
+	enqueue := a.reserve()
+	a.mu.Unlock()
 
-	// 异步写入日志
-	if a.logger != nil {
-		go func(e AuditEvent) {
-			if err := a.logger.Log(e); err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to log event: %v\n", err)
-			}
-		}(event)
+	// 异步写入日志，锁内分配的序号保证写入顺序与ID顺序一致
+	enqueue(queued{event: event})
+	return event.ID
+}
+
+// Flush 等待已产生的事件全部写入日志
+func (a *Auditor) Flush() {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return
 	}
+	done := make(chan struct{})
+	enqueue := a.reserve()
+	a.mu.Unlock()
+	enqueue(queued{fn: func() { close(done) }})
+	<-done
+}
+
+// Clear 清空内存中的事件和日志记录器支持清空的日志文件，然后记录 audit_cleared 事件，
+// 因此清空后的日志以谁在何时清空开始。日志记录器不支持清空时只清空内存中的事件
+func (a *Auditor) Clear(pid, uid, gid int, username string) (ClearedDetails, error) {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return ClearedDetails{}, fmt.Errorf("auditor is closed")
+	}
+	details := ClearedDetails{Events: len(a.events)}
+	a.events = a.events[:0]
+
+	// 在写入协程中清空，之前入队的事件先写入旧文件
+	var err error
+	done := make(chan struct{})
+	enqueue := a.reserve()
+	a.mu.Unlock()
+	enqueue(queued{fn: func() {
+		defer close(done)
+		if c, ok := a.logger.(Clearer); ok {
+			details.Files, err = c.Clear()
+		}
+	}})
+	<-done
+	if err != nil {
+		return details, fmt.Errorf("failed to clear audit log: %w", err)
+	}
+
+	a.log(AuditEvent{
+		Timestamp: time.Now(),
+		Type:      EventAuditCleared,
+		PID:       pid,
+		UID:       uid,
+		GID:       gid,
+		Username:  username,
+		Details:   details,
+	})
+	return details, nil
 }
 
 // GetEvents 获取所有事件
@@ -213,15 +771,33 @@ func (a *Auditor) GetEventsByPID(pid int) []AuditEvent {
 	return events
 }
 
-// Close 关闭审计器
+// Close 关闭审计器，等待所有未完成的日志写入后关闭日志记录器
 func (a *Auditor) Close() error {
+	a.mu.Lock()
+	closing := !a.closed
+	a.closed = true
+	a.mu.Unlock()
+
+	if closing {
+		// 等待已分配序号的项全部入队后再关闭队列
+		a.sending.Wait()
+		close(a.queue)
+	}
+
+	<-a.written
 	if a.logger != nil {
 		return a.logger.Close()
 	}
 	return nil
 }
 
-// ToJSON 转换为JSON
+// ToJSON 转换为JSON，命令行中常见的 <、>、& 不转义
 func (e *AuditEvent) ToJSON() ([]byte, error) {
-	return json.Marshal(e)
-}
\ No newline at end of file
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(e); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
@@ -0,0 +1,317 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// EventLogHeader 日志文件头记录，每次打开或轮转日志文件时写入，用于把哈希链延续到新文件
+const EventLogHeader EventType = "log_header"
+
+// hashMarker 记录中哈希字段的起始位置，校验时从最后一个 hashMarker 处截断得到被哈希的内容。
+// JSON 字符串中的引号总会被转义，因此该标记不会出现在事件内容中
+var hashMarker = []byte(`,"hash":"`)
+
+// errNoChain 记录不包含哈希链字段
+var errNoChain = errors.New("record has no hash chain fields")
+
+// Signer 日志记录签名器
+type Signer interface {
+	// Algorithm 返回签名算法名称
+	Algorithm() string
+	// Sign 对记录哈希签名，只能校验的密钥返回错误
+	Sign(hash []byte) ([]byte, error)
+	// Verify 校验记录哈希的签名
+	Verify(hash, sig []byte) bool
+}
+
+// hmacSigner HMAC-SHA256 签名
+type hmacSigner struct {
+	key []byte
+}
+
+func (s *hmacSigner) Algorithm() string { return "hmac-sha256" }
+
+func (s *hmacSigner) Sign(hash []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(hash)
+	return mac.Sum(nil), nil
+}
+
+func (s *hmacSigner) Verify(hash, sig []byte) bool {
+	expected, _ := s.Sign(hash)
+	return hmac.Equal(expected, sig)
+}
+
+// ed25519Signer ed25519 签名，只有公钥时仅能校验
+type ed25519Signer struct {
+	priv ed25519.PrivateKey
+	pub  ed25519.PublicKey
+}
+
+func (s *ed25519Signer) Algorithm() string { return "ed25519" }
+
+func (s *ed25519Signer) Sign(hash []byte) ([]byte, error) {
+	if s.priv == nil {
+		return nil, errors.New("ed25519 public key cannot be used for signing")
+	}
+	return ed25519.Sign(s.priv, hash), nil
+}
+
+func (s *ed25519Signer) Verify(hash, sig []byte) bool {
+	return ed25519.Verify(s.pub, hash, sig)
+}
+
+// LoadSigner 从密钥文件加载签名器。PEM 格式的 ed25519 私钥（PKCS#8）用于签名和校验，
+// PEM 格式的 ed25519 公钥仅用于校验，其他内容视为 HMAC 密钥
+func LoadSigner(keyPath string) (Signer, error) {
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		key := bytes.TrimSpace(data)
+		if len(key) == 0 {
+			return nil, fmt.Errorf("key file %s is empty", keyPath)
+		}
+		return &hmacSigner{key: key}, nil
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse private key: %w", err)
+		}
+		priv, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T, only ed25519 is supported", key)
+		}
+		return &ed25519Signer{priv: priv, pub: priv.Public().(ed25519.PublicKey)}, nil
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public key: %w", err)
+		}
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("unsupported public key type %T, only ed25519 is supported", key)
+		}
+		return &ed25519Signer{pub: pub}, nil
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q in %s", block.Type, keyPath)
+	}
+}
+
+// logHeader 日志文件头记录
+type logHeader struct {
+	Timestamp time.Time `json:"timestamp"`
+	Type      EventType `json:"type"`
+	Reason    string    `json:"reason"` // open, rotate, clear
+	Algorithm string    `json:"sig_alg,omitempty"`
+}
+
+// chain 哈希链状态，每条记录包含序号、上一条记录的哈希和本条记录的哈希
+type chain struct {
+	seq      uint64
+	prevHash string
+	signer   Signer
+}
+
+// newChain 创建哈希链，signer 为 nil 时不签名
+func newChain(signer Signer) *chain {
+	return &chain{signer: signer}
+}
+
+// resume 从已有日志文件的最后一条记录继续哈希链。文件不存在、为空或最后一条记录
+// 无法解析（例如旧版本写入的日志或写入中断）时保持当前状态，由 verify 报告断链
+func (c *chain) resume(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	return c.resumeFile(f)
+}
+
+// resumeFile 从已打开的日志文件的最后一条记录继续哈希链，多个进程写入同一文件时
+// 在持有文件锁后调用，使各自的记录接在其他进程最后写入的记录之后
+func (c *chain) resumeFile(f *os.File) error {
+	rec, err := lastRecord(f)
+	if err != nil {
+		if errors.Is(err, errNoChain) {
+			return nil
+		}
+		return err
+	}
+	if rec != nil {
+		c.seq = rec.Seq
+		c.prevHash = rec.Hash
+	}
+	return nil
+}
+
+// seal 为一条 JSON 记录追加 seq、prev_hash、hash 以及可选的 sig 字段
+func (c *chain) seal(data []byte) ([]byte, error) {
+	if len(data) < 2 || data[len(data)-1] != '}' {
+		return nil, errors.New("record is not a JSON object")
+	}
+
+	seq := c.seq + 1
+	body := make([]byte, 0, len(data)+192)
+	body = append(body, data[:len(data)-1]...)
+	body = append(body, `,"seq":`...)
+	body = strconv.AppendUint(body, seq, 10)
+	body = append(body, `,"prev_hash":"`...)
+	body = append(body, c.prevHash...)
+	body = append(body, '"')
+
+	sum := sha256.Sum256(append(body, '}'))
+	hash := hex.EncodeToString(sum[:])
+
+	record := append(body, hashMarker...)
+	record = append(record, hash...)
+	record = append(record, '"')
+	if c.signer != nil {
+		sig, err := c.signer.Sign(sum[:])
+		if err != nil {
+			return nil, err
+		}
+		record = append(record, `,"sig":"`...)
+		record = append(record, hex.EncodeToString(sig)...)
+		record = append(record, '"')
+	}
+	record = append(record, '}')
+
+	c.seq = seq
+	c.prevHash = hash
+	return record, nil
+}
+
+// header 生成文件头记录
+func (c *chain) header(reason string) ([]byte, error) {
+	h := logHeader{
+		Timestamp: time.Now(),
+		Type:      EventLogHeader,
+		Reason:    reason,
+	}
+	if c.signer != nil {
+		h.Algorithm = c.signer.Algorithm()
+	}
+	data, err := json.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+	return c.seal(data)
+}
+
+// chainRecord 从一行日志中解析出的哈希链字段
+type chainRecord struct {
+	Type     EventType
+	Seq      uint64
+	PrevHash string
+	Hash     string
+	Sig      string
+
+	// body 为计算哈希时使用的内容
+	body []byte
+}
+
+// parseRecord 解析一行带哈希链字段的日志
+func parseRecord(line []byte) (*chainRecord, error) {
+	idx := bytes.LastIndex(line, hashMarker)
+	if idx < 0 {
+		return nil, errNoChain
+	}
+
+	var tail struct {
+		Hash string `json:"hash"`
+		Sig  string `json:"sig"`
+	}
+	if err := json.Unmarshal(append([]byte{'{'}, line[idx+1:]...), &tail); err != nil {
+		return nil, fmt.Errorf("malformed hash fields: %w", err)
+	}
+
+	body := make([]byte, 0, idx+1)
+	body = append(body, line[:idx]...)
+	body = append(body, '}')
+
+	var head struct {
+		Type     EventType `json:"type"`
+		Seq      *uint64   `json:"seq"`
+		PrevHash *string   `json:"prev_hash"`
+	}
+	if err := json.Unmarshal(body, &head); err != nil {
+		return nil, fmt.Errorf("malformed record: %w", err)
+	}
+	if head.Seq == nil || head.PrevHash == nil {
+		return nil, errNoChain
+	}
+
+	return &chainRecord{
+		Type:     head.Type,
+		Seq:      *head.Seq,
+		PrevHash: *head.PrevHash,
+		Hash:     tail.Hash,
+		Sig:      tail.Sig,
+		body:     body,
+	}, nil
+}
+
+// lastRecord 读取日志文件的最后一条记录，文件为空时返回 nil。从文件末尾向前读取，
+// 读到完整的一行为止，单条记录不会超过 1MB
+func lastRecord(f *os.File) (*chainRecord, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	const maxTail = 1 << 20
+	var data []byte
+	for tail := int64(4096); ; tail *= 2 {
+		if tail > maxTail {
+			tail = maxTail
+		}
+		offset := info.Size() - tail
+		if offset < 0 {
+			offset = 0
+		}
+		data, err = io.ReadAll(io.NewSectionReader(f, offset, info.Size()-offset))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", f.Name(), err)
+		}
+		data = bytes.TrimRight(data, "\n")
+		if offset == 0 || tail == maxTail || bytes.IndexByte(data, '\n') >= 0 {
+			break
+		}
+	}
+
+	if len(data) == 0 {
+		return nil, nil
+	}
+	if i := bytes.LastIndexByte(data, '\n'); i >= 0 {
+		data = data[i+1:]
+	}
+	rec, err := parseRecord(data)
+	if err != nil && !errors.Is(err, errNoChain) {
+		return nil, fmt.Errorf("%w: %v", errNoChain, err)
+	}
+	return rec, err
+}
@@ -0,0 +1,219 @@
+package audit
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// sealLines 用新的哈希链为事件生成日志行
+func sealLines(t *testing.T, signer Signer, n int) [][]byte {
+	t.Helper()
+	c := newChain(signer)
+	var lines [][]byte
+	for i := 0; i < n; i++ {
+		data, err := (&AuditEvent{
+			Type:    EventCommand,
+			PID:     100 + i,
+			Command: "echo",
+			// 参数中的哈希字段标记会被转义，不能影响解析
+			Args: []string{"echo", `,"hash":"forged"`},
+		}).ToJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		line, err := c.seal(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// verifyLines 将日志行写入临时文件并校验，返回问题类型
+func verifyLines(t *testing.T, signer Signer, lines [][]byte) []IssueKind {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	if err := os.WriteFile(path, append(bytes.Join(lines, []byte("\n")), '\n'), 0600); err != nil {
+		t.Fatal(err)
+	}
+	issues, err := NewVerifier(signer).VerifyFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kinds []IssueKind
+	for _, issue := range issues {
+		kinds = append(kinds, issue.Kind)
+	}
+	return kinds
+}
+
+func TestVerifyChain(t *testing.T) {
+	key := &hmacSigner{key: []byte("secret")}
+
+	tests := []struct {
+		name   string
+		verify Signer
+		mutate func(lines [][]byte) [][]byte
+		want   []IssueKind
+	}{
+		{
+			name:   "intact",
+			verify: key,
+			mutate: func(lines [][]byte) [][]byte { return lines },
+		},
+		{
+			name:   "tampered content",
+			verify: key,
+			mutate: func(lines [][]byte) [][]byte {
+				lines[1] = bytes.Replace(lines[1], []byte(`"pid":101`), []byte(`"pid":999`), 1)
+				return lines
+			},
+			want: []IssueKind{IssueModified},
+		},
+		{
+			name:   "deleted record",
+			verify: key,
+			mutate: func(lines [][]byte) [][]byte {
+				return append(lines[:1:1], lines[2:]...)
+			},
+			want: []IssueKind{IssueGap},
+		},
+		{
+			name:   "swapped records",
+			verify: key,
+			mutate: func(lines [][]byte) [][]byte {
+				lines[1], lines[2] = lines[2], lines[1]
+				return lines
+			},
+			// 1 3 2 4：3 处缺少 2，2 处序号回退，4 处缺少 3
+			want: []IssueKind{IssueGap, IssueReordered, IssueGap},
+		},
+		{
+			name:   "duplicated record",
+			verify: key,
+			mutate: func(lines [][]byte) [][]byte {
+				return append(lines[:2:2], append([][]byte{lines[1]}, lines[2:]...)...)
+			},
+			want: []IssueKind{IssueReordered},
+		},
+		{
+			name:   "record from another chain",
+			verify: key,
+			mutate: func(lines [][]byte) [][]byte {
+				// 序号和签名都正确，但 prev_hash 不属于本链
+				c := &chain{seq: 1, prevHash: "00", signer: key}
+				forged, err := c.seal([]byte(`{"type":"command","command":"rm"}`))
+				if err != nil {
+					t.Fatal(err)
+				}
+				lines[1] = forged
+				return lines
+			},
+			want: []IssueKind{IssueBrokenChain, IssueBrokenChain},
+		},
+		{
+			name:   "wrong key",
+			verify: &hmacSigner{key: []byte("other")},
+			mutate: func(lines [][]byte) [][]byte { return lines[:2] },
+			want:   []IssueKind{IssueBadSignature, IssueBadSignature},
+		},
+		{
+			name:   "record without chain fields",
+			verify: key,
+			mutate: func(lines [][]byte) [][]byte {
+				return append(lines, []byte(`{"type":"command","command":"ls"}`))
+			},
+			want: []IssueKind{IssueModified},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := tt.mutate(sealLines(t, key, 4))
+			if got := verifyLines(t, tt.verify, lines); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("issues = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyUnsignedRecord(t *testing.T) {
+	lines := sealLines(t, nil, 2)
+	got := verifyLines(t, &hmacSigner{key: []byte("secret")}, lines)
+	want := []IssueKind{IssueBadSignature, IssueBadSignature}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("issues = %v, want %v", got, want)
+	}
+	if got := verifyLines(t, nil, lines); got != nil {
+		t.Errorf("issues without signer = %v, want none", got)
+	}
+}
+
+func TestChainResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	first := sealLines(t, nil, 3)
+	if err := os.WriteFile(path, append(bytes.Join(first, []byte("\n")), '\n'), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newChain(nil)
+	if err := c.resume(path); err != nil {
+		t.Fatal(err)
+	}
+	if c.seq != 3 {
+		t.Fatalf("resumed seq = %d, want 3", c.seq)
+	}
+	line, err := c.header("open")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(append(line, '\n'))
+	f.Close()
+
+	v := NewVerifier(nil)
+	issues, err := v.VerifyFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issues) != 0 || v.Records() != 4 {
+		t.Errorf("issues = %v, records = %d, want none and 4", issues, v.Records())
+	}
+}
+
+func TestChainAcrossFiles(t *testing.T) {
+	lines := sealLines(t, nil, 4)
+	dir := t.TempDir()
+	paths := []string{filepath.Join(dir, "audit.log.1"), filepath.Join(dir, "audit.log")}
+	os.WriteFile(paths[0], append(bytes.Join(lines[:2], []byte("\n")), '\n'), 0600)
+	os.WriteFile(paths[1], append(bytes.Join(lines[2:], []byte("\n")), '\n'), 0600)
+
+	// 顺序正确时链跨文件延续，顺序颠倒时报告
+	for _, tt := range []struct {
+		order []string
+		want  int
+	}{
+		{paths, 0},
+		{[]string{paths[1], paths[0]}, 1},
+	} {
+		v := NewVerifier(nil)
+		var issues []VerifyIssue
+		for _, p := range tt.order {
+			found, err := v.VerifyFile(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			issues = append(issues, found...)
+		}
+		if len(issues) != tt.want {
+			t.Errorf("order %v: issues = %v, want %d", tt.order, issues, tt.want)
+		}
+	}
+}
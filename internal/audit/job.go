@@ -0,0 +1,58 @@
+package audit
+
+import "time"
+
+// JobDetails 作业详情。作业是 shell 中的一个管道（后台执行时为整个 && || 列表），
+// 其中的外部命令属于同一个进程组
+type JobDetails struct {
+	JobID       int    `json:"job_id"`
+	PGID        int    `json:"pgid"`
+	CommandLine string `json:"command_line"`
+	Background  bool   `json:"background"`
+	Signal      int    `json:"signal,omitempty"` // 仅 job_stop：使作业停止的信号
+}
+
+// JobExitDetails 作业结束详情
+type JobExitDetails struct {
+	JobDetails
+	ExitCode   int   `json:"exit_code"`
+	DurationMs int64 `json:"duration_ms"`
+}
+
+// LogJobStart 记录后台作业启动
+func (a *Auditor) LogJobStart(pid, uid, gid int, username string, details JobDetails) {
+	a.logJob(EventJobStart, pid, uid, gid, username, details)
+}
+
+// LogJobStop 记录作业被停止（例如 Ctrl-Z）
+func (a *Auditor) LogJobStop(pid, uid, gid int, username string, details JobDetails) {
+	a.logJob(EventJobStop, pid, uid, gid, username, details)
+}
+
+// LogJobContinue 记录作业通过 fg 或 bg 继续运行
+func (a *Auditor) LogJobContinue(pid, uid, gid int, username string, details JobDetails) {
+	a.logJob(EventJobContinue, pid, uid, gid, username, details)
+}
+
+// LogJobExit 记录作业结束
+func (a *Auditor) LogJobExit(pid, uid, gid int, username string, details JobDetails, exitCode int, duration time.Duration) {
+	a.logJob(EventJobExit, pid, uid, gid, username, JobExitDetails{
+		JobDetails: details,
+		ExitCode:   exitCode,
+		DurationMs: duration.Milliseconds(),
+	})
+}
+
+// logJob 写入作业事件
+func (a *Auditor) logJob(typ EventType, pid, uid, gid int, username string, details interface{}) {
+	event := AuditEvent{
+		Timestamp: time.Now(),
+		Type:      typ,
+		PID:       pid,
+		UID:       uid,
+		GID:       gid,
+		Username:  username,
+		Details:   details,
+	}
+	a.log(event)
+}
@@ -0,0 +1,204 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// /proc/net 套接字表中的状态，未连接的 UDP 套接字为 CLOSE
+const (
+	procNetClose  = "07"
+	procNetListen = "0A"
+)
+
+// portRange 端口范围，包含两端
+type portRange struct {
+	low, high int
+}
+
+// defaultEphemeralPorts 读取不到 ip_local_port_range 时使用的内核默认临时端口范围
+var defaultEphemeralPorts = portRange{32768, 60999}
+
+// Listener 监听中的套接字，Inode 与 /proc/<pid>/fd 中的 socket:[inode] 对应
+type Listener struct {
+	Protocol string // tcp, udp
+	Address  string
+	Port     int
+	Inode    uint64
+	UID      int // 创建套接字的用户
+
+	// 持有套接字的进程，多个进程共享时为 PID 最小的进程（通常是主进程），未找到时为 0
+	PID  int
+	GID  int
+	Comm string
+}
+
+// ScanListeners 读取 procRoot/net 下的套接字表（tcp、tcp6、udp、udp6），返回监听中的 TCP 套接字
+// 和绑定在临时端口范围之外、未连接的 UDP 套接字。UDP 没有监听状态，客户端发送时内核自动绑定的
+// 临时端口与服务端口无法区分，因此按端口范围排除。不存在的表（例如未启用 IPv6）被跳过，不查找所属进程
+func ScanListeners(procRoot string, tables ...string) ([]Listener, error) {
+	ephemeral := ephemeralPorts(procRoot)
+	var listeners []Listener
+	for _, table := range tables {
+		protocol := strings.TrimSuffix(table, "6")
+		f, err := os.Open(filepath.Join(procRoot, "net", table))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to open socket table: %w", err)
+		}
+		found, err := parseSocketTable(f, protocol, ephemeral)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", f.Name(), err)
+		}
+		listeners = append(listeners, found...)
+	}
+	return listeners, nil
+}
+
+// ephemeralPorts 读取 procRoot/sys/net/ipv4/ip_local_port_range，IPv6 使用同一范围
+func ephemeralPorts(procRoot string) portRange {
+	data, err := os.ReadFile(filepath.Join(procRoot, "sys/net/ipv4/ip_local_port_range"))
+	if err != nil {
+		return defaultEphemeralPorts
+	}
+	var r portRange
+	if _, err := fmt.Sscan(string(data), &r.low, &r.high); err != nil || r.low > r.high {
+		return defaultEphemeralPorts
+	}
+	return r
+}
+
+// parseSocketTable 解析一个套接字表，第一行为表头。UDP 套接字的端口在 ephemeral 范围内时跳过
+func parseSocketTable(f *os.File, protocol string, ephemeral portRange) ([]Listener, error) {
+	var listeners []Listener
+	scanner := bufio.NewScanner(f)
+	scanner.Scan()
+	for scanner.Scan() {
+		// sl local_address rem_address st tx:rx tr:when retrnsmt uid timeout inode ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		state, remote := fields[3], fields[2]
+		switch {
+		case protocol == "tcp" && state == procNetListen:
+		case protocol == "udp" && state == procNetClose && strings.Trim(remote, "0:") == "":
+		default:
+			continue
+		}
+
+		ip, port, err := parseSocketAddr(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		uid, _ := strconv.Atoi(fields[7])
+		inode, _ := strconv.ParseUint(fields[9], 10, 64)
+		if inode == 0 || port == 0 {
+			continue
+		}
+		if protocol == "udp" && port >= ephemeral.low && port <= ephemeral.high {
+			continue
+		}
+		listeners = append(listeners, Listener{
+			Protocol: protocol,
+			Address:  ip.String(),
+			Port:     port,
+			Inode:    inode,
+			UID:      uid,
+		})
+	}
+	return listeners, scanner.Err()
+}
+
+// parseSocketAddr 解析 ADDR:PORT 形式的地址。地址为按主机字节序打印的 32 位字，
+// 每 4 字节需要反转（仅支持小端主机）
+func parseSocketAddr(s string) (net.IP, int, error) {
+	addr, port, ok := strings.Cut(s, ":")
+	if !ok {
+		return nil, 0, fmt.Errorf("invalid socket address %q", s)
+	}
+	b, err := hex.DecodeString(addr)
+	if err != nil || (len(b) != net.IPv4len && len(b) != net.IPv6len) {
+		return nil, 0, fmt.Errorf("invalid socket address %q", s)
+	}
+	for i := 0; i < len(b); i += 4 {
+		b[i], b[i+1], b[i+2], b[i+3] = b[i+3], b[i+2], b[i+1], b[i]
+	}
+	p, err := strconv.ParseUint(port, 16, 16)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid socket port %q", s)
+	}
+	return net.IP(b), int(p), nil
+}
+
+// ResolveOwners 按 PID 从小到大遍历 procRoot 下进程的文件描述符，为尚未确定所属进程的套接字填写进程信息。
+// 第一个持有套接字的进程即为 PID 最小的进程，所有套接字都找到后停止遍历
+func ResolveOwners(procRoot string, listeners []Listener) {
+	wanted := make(map[string]int)
+	for i, l := range listeners {
+		if l.PID == 0 {
+			wanted[fmt.Sprintf("socket:[%d]", l.Inode)] = i
+		}
+	}
+	if len(wanted) == 0 {
+		return
+	}
+
+	dirs, err := os.ReadDir(procRoot)
+	if err != nil {
+		return
+	}
+	var pids []int
+	for _, d := range dirs {
+		if pid, err := strconv.Atoi(d.Name()); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	sort.Ints(pids)
+
+	for _, pid := range pids {
+		fdDir := filepath.Join(procRoot, strconv.Itoa(pid), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil {
+				continue
+			}
+			if i, ok := wanted[link]; ok {
+				listeners[i].PID = pid
+				delete(wanted, link)
+			}
+		}
+		if len(wanted) == 0 {
+			break
+		}
+	}
+
+	for i := range listeners {
+		l := &listeners[i]
+		if l.PID == 0 || l.Comm != "" {
+			continue
+		}
+		_, _, l.Comm, _ = readProcStat(procRoot, l.PID)
+		// /proc/<pid> 目录属于进程的有效用户和组
+		if info, err := os.Stat(filepath.Join(procRoot, strconv.Itoa(l.PID))); err == nil {
+			if st, ok := info.Sys().(*syscall.Stat_t); ok {
+				l.GID = int(st.Gid)
+			}
+		}
+	}
+}
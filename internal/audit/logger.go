@@ -1,38 +1,69 @@
 package audit
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
+	"syscall"
 	"time"
 )
 
-// FileLogger 文件日志记录器
+// FileLogger 文件日志记录器，每条记录带有哈希链字段。多个进程可以写入同一文件，
+// 写入时以 flock 互斥，并从文件中最后一条记录继续哈希链
 type FileLogger struct {
 	filePath string
 	file     *os.File
+	chain    *chain
 	mu       sync.Mutex
 }
 
-// NewFileLogger 创建文件日志记录器
-func NewFileLogger(filePath string) (*FileLogger, error) {
+// NewFileLogger 创建文件日志记录器，signer 不为 nil 时对每条记录签名
+func NewFileLogger(filePath string, signer Signer) (*FileLogger, error) {
 	// 确保目录存在
 	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
 		return nil, fmt.Errorf("failed to create log directory: %w", err)
 	}
 
 	// 打开文件（追加模式）
-	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open log file: %w", err)
 	}
 
-	return &FileLogger{
+	// 写入文件头标记本次打开
+	l := &FileLogger{
 		filePath: filePath,
 		file:     file,
-	}, nil
+		chain:    newChain(signer),
+	}
+	if err := l.append(func() ([]byte, error) { return l.chain.header("open") }); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// append 锁定文件，从文件末尾继续哈希链后写入 record 生成的记录
+func (l *FileLogger) append(record func() ([]byte, error)) error {
+	unlock, err := lockFile(l.file)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := syncChain(l.file, l.chain); err != nil {
+		return err
+	}
+	data, err := record()
+	if err != nil {
+		return err
+	}
+	_, err = writeRecord(l.file, data)
+	return err
 }
 
 // Log 记录事件
@@ -40,17 +71,11 @@ func (l *FileLogger) Log(event AuditEvent) error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	data, err := json.Marshal(event)
+	data, err := event.ToJSON()
 	if err != nil {
 		return err
 	}
-
-	// 写入JSON行
-	if _, err := l.file.Write(append(data, '\n')); err != nil {
-		return err
-	}
-
-	return l.file.Sync()
+	return l.append(func() ([]byte, error) { return l.chain.seal(data) })
 }
 
 // Close 关闭日志记录器
@@ -63,90 +88,284 @@ func (l *FileLogger) Close() error {
 	return nil
 }
 
-// RotatingLogger 支持日志轮转的日志记录器
+// Clear 清空日志文件，写入新的文件头，哈希链从被清空的记录继续
+func (l *FileLogger) Clear() ([]string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	err := l.append(func() ([]byte, error) {
+		if err := l.file.Truncate(0); err != nil {
+			return nil, fmt.Errorf("failed to truncate log file: %w", err)
+		}
+		return l.chain.header("clear")
+	})
+	return nil, err
+}
+
+// lockFile 对文件加排他的 flock，返回解锁函数。flock 作用于打开的文件描述，
+// 同一进程中分别打开的同一文件之间也互斥
+func lockFile(file *os.File) (func(), error) {
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		return nil, fmt.Errorf("failed to lock %s: %w", file.Name(), err)
+	}
+	return func() { syscall.Flock(int(file.Fd()), syscall.LOCK_UN) }, nil
+}
+
+// syncChain 从文件中最后一条记录继续哈希链，其他进程可能已在本进程上次写入之后追加了记录。
+// 调用方需持有锁
+func syncChain(file *os.File, c *chain) error {
+	if err := terminateLine(file); err != nil {
+		return fmt.Errorf("failed to repair log file: %w", err)
+	}
+	return c.resumeFile(file)
+}
+
+// writeRecord 写入一行记录并同步到磁盘，返回写入的字节数
+func writeRecord(file *os.File, data []byte) (int, error) {
+	n, err := file.Write(append(data, '\n'))
+	if err != nil {
+		return n, fmt.Errorf("failed to write log record: %w", err)
+	}
+	return n, file.Sync()
+}
+
+// terminateLine 上次写入中断导致文件末尾没有换行时补上换行，避免新记录与残缺记录连在一起
+func terminateLine(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil || info.Size() == 0 {
+		return err
+	}
+	last := make([]byte, 1)
+	if _, err := file.ReadAt(last, info.Size()-1); err != nil && err != io.EOF {
+		return err
+	}
+	if last[0] == '\n' {
+		return nil
+	}
+	_, err = file.Write([]byte{'\n'})
+	return err
+}
+
+// RotatingLogger 支持日志轮转的日志记录器，哈希链通过每个文件开头的文件头记录跨文件延续。
+// 多个进程可以写入同一组文件，写入时以 <basePath>.lock 上的 flock 互斥，总是写入最新的文件
 type RotatingLogger struct {
 	basePath    string
 	maxSize     int64
 	currentSize int64
 	currentFile *os.File
+	lock        *os.File
+	chain       *chain
 	mu          sync.Mutex
 }
 
-// NewRotatingLogger 创建支持轮转的日志记录器
-func NewRotatingLogger(basePath string, maxSizeMB int) (*RotatingLogger, error) {
+// NewRotatingLogger 创建支持轮转的日志记录器，signer 不为 nil 时对每条记录签名
+func NewRotatingLogger(basePath string, maxSizeMB int, signer Signer) (*RotatingLogger, error) {
 	if maxSizeMB <= 0 {
 		maxSizeMB = 100 // 默认100MB
 	}
 
+	// 确保目录存在
+	basePath = filepath.Clean(basePath)
+	if err := os.MkdirAll(filepath.Dir(basePath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	lock, err := os.OpenFile(basePath+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log lock file: %w", err)
+	}
+
 	rl := &RotatingLogger{
 		basePath: basePath,
 		maxSize:  int64(maxSizeMB) * 1024 * 1024,
+		lock:     lock,
+		chain:    newChain(signer),
 	}
 
-	if err := rl.rotate(); err != nil {
+	unlock, err := lockFile(lock)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	defer unlock()
+
+	// 从最近一个日志文件继续哈希链
+	if files := RotatedFiles(basePath); len(files) > 0 {
+		if err := rl.chain.resume(files[len(files)-1]); err != nil {
+			lock.Close()
+			return nil, err
+		}
+	}
+
+	if err := rl.rotate("open"); err != nil {
+		lock.Close()
 		return nil, err
 	}
 
 	return rl, nil
 }
 
-// rotate 轮转日志文件
-func (rl *RotatingLogger) rotate() error {
-	if rl.currentFile != nil {
-		rl.currentFile.Close()
-	}
+// RotatedFiles 返回 basePath 对应的所有轮转日志文件，按时间从旧到新排序
+func RotatedFiles(basePath string) []string {
+	files, _ := filepath.Glob(basePath + ".*.log")
+	sort.Strings(files)
+	return files
+}
 
-	// 生成新文件名
-	timestamp := time.Now().Format("20060102-150405")
-	filePath := fmt.Sprintf("%s.%s.log", rl.basePath, timestamp)
+// rotate 轮转日志文件，reason 记录在新文件的文件头中。调用方需持有锁
+func (rl *RotatingLogger) rotate(reason string) error {
+	file, err := createRotated(rl.basePath)
+	if err != nil {
+		return err
+	}
 
-	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	header, err := rl.chain.header(reason)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	n, err := writeRecord(file, header)
 	if err != nil {
+		file.Close()
 		return err
 	}
 
+	if rl.currentFile != nil {
+		rl.currentFile.Close()
+	}
 	rl.currentFile = file
-	rl.currentSize = 0
+	rl.currentSize = int64(n)
 
 	return nil
 }
 
+// rotatedTimeFormat 轮转文件名中的时间，精确到纳秒且定长，按文件名排序即为时间顺序
+const rotatedTimeFormat = "20060102-150405.000000000"
+
+// createRotated 创建新的轮转文件 <basePath>.<时间>.log。以 O_EXCL 创建，
+// 不会重新打开同一时刻已存在的文件
+func createRotated(basePath string) (*os.File, error) {
+	for {
+		filePath := fmt.Sprintf("%s.%s.log", basePath, time.Now().Format(rotatedTimeFormat))
+		file, err := os.OpenFile(filePath, os.O_CREATE|os.O_EXCL|os.O_RDWR|os.O_APPEND, 0644)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to create log file: %w", err)
+		}
+		return file, nil
+	}
+}
+
+// sync 切换到其他进程轮转或清空后产生的最新文件，并从文件末尾继续哈希链。调用方需持有锁
+func (rl *RotatingLogger) sync() error {
+	if files := RotatedFiles(rl.basePath); len(files) > 0 && files[len(files)-1] != rl.currentFile.Name() {
+		file, err := os.OpenFile(files[len(files)-1], os.O_RDWR|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open log file: %w", err)
+		}
+		rl.currentFile.Close()
+		rl.currentFile = file
+	}
+
+	if err := syncChain(rl.currentFile, rl.chain); err != nil {
+		return err
+	}
+	info, err := rl.currentFile.Stat()
+	if err != nil {
+		return err
+	}
+	rl.currentSize = info.Size()
+	return nil
+}
+
 // Log 记录事件
 func (rl *RotatingLogger) Log(event AuditEvent) error {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 
-	data, err := json.Marshal(event)
+	data, err := event.ToJSON()
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockFile(rl.lock)
 	if err != nil {
 		return err
 	}
+	defer unlock()
+	if err := rl.sync(); err != nil {
+		return err
+	}
 
-	// 检查是否需要轮转
+	// 检查是否需要轮转，新文件的文件头先于本条记录进入哈希链
 	if rl.currentSize+int64(len(data)) > rl.maxSize {
-		if err := rl.rotate(); err != nil {
+		if err := rl.rotate("rotate"); err != nil {
 			return err
 		}
 	}
-
-	// 写入数据
-	if _, err := rl.currentFile.Write(append(data, '\n')); err != nil {
+	if data, err = rl.chain.seal(data); err != nil {
 		return err
 	}
 
-	rl.currentSize += int64(len(data)) + 1
-	return rl.currentFile.Sync()
+	// 写入数据
+	n, err := writeRecord(rl.currentFile, data)
+	rl.currentSize += int64(n)
+	return err
 }
 
 // Close 关闭日志记录器
 func (rl *RotatingLogger) Close() error {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
+	rl.lock.Close()
 	if rl.currentFile != nil {
 		return rl.currentFile.Close()
 	}
 	return nil
 }
 
+// Clear 轮转到新文件并删除之前的所有日志文件，返回删除的文件
+func (rl *RotatingLogger) Clear() ([]string, error) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	unlock, err := lockFile(rl.lock)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if err := rl.sync(); err != nil {
+		return nil, err
+	}
+
+	if err := rl.rotate("clear"); err != nil {
+		return nil, err
+	}
+	current := rl.currentFile.Name()
+
+	var removed []string
+	for _, path := range RotatedFiles(rl.basePath) {
+		if path == current {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return removed, fmt.Errorf("failed to remove log file: %w", err)
+		}
+		removed = append(removed, path)
+	}
+	return removed, nil
+}
+
+// LogFiles 返回日志路径对应的所有日志文件，按时间从旧到新排序：轮转的日志文件，以及存在时的 path 本身
+func LogFiles(path string) []string {
+	files := RotatedFiles(path)
+	if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+		files = append(files, path)
+	}
+	return files
+}
+
 // StdoutLogger 标准输出日志记录器
 type StdoutLogger struct{}
 
@@ -157,7 +376,7 @@ func NewStdoutLogger() *StdoutLogger {
 
 // Log 记录事件到标准输出
 func (l *StdoutLogger) Log(event AuditEvent) error {
-	data, err := json.Marshal(event)
+	data, err := event.ToJSON()
 	if err != nil {
 		return err
 	}
@@ -168,4 +387,53 @@ func (l *StdoutLogger) Log(event AuditEvent) error {
 // Close 关闭日志记录器
 func (l *StdoutLogger) Close() error {
 	return nil
-}
\ No newline at end of file
+}
+
+// MultiLogger 同时写入多个日志记录器
+type MultiLogger struct {
+	loggers []Logger
+}
+
+// NewMultiLogger 创建组合日志记录器
+func NewMultiLogger(loggers ...Logger) *MultiLogger {
+	return &MultiLogger{loggers: loggers}
+}
+
+// Log 将事件写入所有日志记录器，返回第一个错误
+func (m *MultiLogger) Log(event AuditEvent) error {
+	var firstErr error
+	for _, l := range m.loggers {
+		if err := l.Log(event); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Clear 清空所有支持清空的日志记录器
+func (m *MultiLogger) Clear() ([]string, error) {
+	var removed []string
+	for _, l := range m.loggers {
+		c, ok := l.(Clearer)
+		if !ok {
+			continue
+		}
+		files, err := c.Clear()
+		removed = append(removed, files...)
+		if err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+// Close 关闭所有日志记录器
+func (m *MultiLogger) Close() error {
+	var firstErr error
+	for _, l := range m.loggers {
+		if err := l.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,146 @@
+package audit
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// verifyFiles 按顺序校验日志文件，返回问题和记录数
+func verifyFiles(t *testing.T, files []string) ([]VerifyIssue, int) {
+	t.Helper()
+	v := NewVerifier(nil)
+	var issues []VerifyIssue
+	for _, path := range files {
+		found, err := v.VerifyFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		issues = append(issues, found...)
+	}
+	return issues, v.Records()
+}
+
+// logConcurrently 每个记录器在各自的 goroutine 中写入 n 条事件
+func logConcurrently(t *testing.T, loggers []Logger, n int) {
+	t.Helper()
+	var wg sync.WaitGroup
+	errs := make(chan error, len(loggers)*n)
+	for i, l := range loggers {
+		wg.Add(1)
+		go func(i int, l Logger) {
+			defer wg.Done()
+			for j := 0; j < n; j++ {
+				errs <- l.Log(AuditEvent{ID: fmt.Sprintf("%d-%d", i, j), Type: EventCommand, PID: i, Command: "true"})
+			}
+		}(i, l)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestFileLoggerConcurrent 多个会话写入同一日志文件时哈希链保持完整
+func TestFileLoggerConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	var loggers []Logger
+	for i := 0; i < 3; i++ {
+		l, err := NewFileLogger(path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		loggers = append(loggers, l)
+	}
+
+	logConcurrently(t, loggers, 20)
+	if issues, records := verifyFiles(t, []string{path}); len(issues) != 0 || records != 3+3*20 {
+		t.Errorf("issues = %v, records = %d, want none and %d", issues, records, 3+3*20)
+	}
+
+	// 一个会话清空日志后，其他会话的记录接在新的文件头之后
+	if _, err := loggers[0].(*FileLogger).Clear(); err != nil {
+		t.Fatal(err)
+	}
+	logConcurrently(t, loggers[1:], 5)
+	if issues, records := verifyFiles(t, []string{path}); len(issues) != 0 || records != 1+2*5 {
+		t.Errorf("after clear: issues = %v, records = %d, want none and %d", issues, records, 1+2*5)
+	}
+}
+
+// TestRotatingLoggerConcurrent 多个会话写入同一组轮转文件时总是写入最新的文件，哈希链跨文件保持完整
+func TestRotatingLoggerConcurrent(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "audit.log")
+	var loggers []Logger
+	for i := 0; i < 3; i++ {
+		l, err := NewRotatingLogger(base, 1, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		l.maxSize = 4096
+		loggers = append(loggers, l)
+	}
+
+	logConcurrently(t, loggers, 30)
+	files := RotatedFiles(base)
+	if len(files) < 2 {
+		t.Fatalf("files = %v, want rotation", files)
+	}
+	if issues, records := verifyFiles(t, files); len(issues) != 0 || records < 3+3*30 {
+		t.Errorf("issues = %v, records = %d, want none and at least %d", issues, records, 3+3*30)
+	}
+}
+
+// TestRotatingLoggerClear 清空在同一秒内轮转之后也会删除之前的记录，其他会话之后的记录写入新文件
+func TestRotatingLoggerClear(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "audit.log")
+	a, err := NewRotatingLogger(base, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := NewRotatingLogger(base, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if err := a.Log(AuditEvent{ID: "before", Type: EventCommand, Command: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	before := RotatedFiles(base)
+	removed, err := a.Clear()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(removed, before) {
+		t.Errorf("removed = %v, want %v", removed, before)
+	}
+	if err := b.Log(AuditEvent{ID: "after", Type: EventCommand, Command: "true"}); err != nil {
+		t.Fatal(err)
+	}
+
+	files := RotatedFiles(base)
+	if len(files) != 1 {
+		t.Fatalf("files = %v, want only the new file", files)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte(`"before"`)) || !bytes.Contains(data, []byte(`"after"`)) {
+		t.Errorf("file after clear:\n%s", data)
+	}
+	if issues, records := verifyFiles(t, files); len(issues) != 0 || records != 2 {
+		t.Errorf("issues = %v, records = %d, want none and 2", issues, records)
+	}
+}
@@ -0,0 +1,320 @@
+package audit
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxLineageDepth 祖先链最大深度，防止 ppid 成环时死循环
+const maxLineageDepth = 64
+
+// ProcessRef 进程引用
+type ProcessRef struct {
+	PID  int    `json:"pid"`
+	Comm string `json:"comm"`
+	Exe  string `json:"exe,omitempty"`
+}
+
+// processEntry 进程表中的一项
+type processEntry struct {
+	pid      int
+	ppid     int
+	sid      int
+	auditSid uint32 // 内核审计会话ID，未知时为 auditSessionUnset
+	comm     string
+	exe      string
+	children int
+	exited   bool
+}
+
+// ProcessTable 内存中的进程表，用于重建进程树
+type ProcessTable struct {
+	mu    sync.RWMutex
+	procs map[int]*processEntry
+}
+
+// NewProcessTable 创建进程表
+func NewProcessTable() *ProcessTable {
+	return &ProcessTable{
+		procs: make(map[int]*processEntry),
+	}
+}
+
+// Seed 从 procRoot（通常为 /proc）加载当前所有进程
+func (t *ProcessTable) Seed(procRoot string) error {
+	dirs, err := os.ReadDir(procRoot)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", procRoot, err)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, d := range dirs {
+		pid, err := strconv.Atoi(d.Name())
+		if err != nil {
+			continue
+		}
+		ppid, sid, comm, ok := readProcStat(procRoot, pid)
+		if !ok {
+			continue
+		}
+		exe, _ := os.Readlink(filepath.Join(procRoot, d.Name(), "exe"))
+		t.procs[pid] = &processEntry{
+			pid:      pid,
+			ppid:     ppid,
+			sid:      sid,
+			auditSid: readAuditSession(procRoot, pid),
+			comm:     comm,
+			exe:      exe,
+		}
+	}
+
+	for _, p := range t.procs {
+		if parent, ok := t.procs[p.ppid]; ok {
+			parent.children++
+		}
+	}
+	return nil
+}
+
+// Fork 记录新进程，新进程继承父进程的会话和可执行文件
+func (t *ProcessTable) Fork(pid, ppid int, comm string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry := &processEntry{pid: pid, ppid: ppid, auditSid: auditSessionUnset, comm: comm}
+	if parent, ok := t.procs[ppid]; ok {
+		entry.sid = parent.sid
+		entry.auditSid = parent.auditSid
+		entry.exe = parent.exe
+		if entry.comm == "" {
+			entry.comm = parent.comm
+		}
+	}
+	t.insert(entry)
+}
+
+// Exec 记录进程执行新程序
+func (t *ProcessTable) Exec(pid, ppid int, comm, exe string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry, ok := t.procs[pid]
+	if !ok {
+		entry = &processEntry{pid: pid, ppid: ppid, auditSid: readAuditSession("/proc", pid)}
+		if parent, ok := t.procs[ppid]; ok {
+			entry.sid = parent.sid
+			if entry.auditSid == auditSessionUnset {
+				entry.auditSid = parent.auditSid
+			}
+		}
+		t.insert(entry)
+	}
+	entry.comm = comm
+	if exe != "" {
+		entry.exe = exe
+	}
+
+	// setsid 通常发生在 fork 与 exec 之间，进程仍存活时以 /proc 为准
+	if _, sid, _, ok := readProcStat("/proc", pid); ok {
+		entry.sid = sid
+	}
+}
+
+// SetAuditSession 记录内核观测到的审计会话ID（例如 BPF 在 execve 时读取的 sessionid），
+// 登录时 pam_loginuid 会在 fork 之后更新该值，因此以观测值为准
+func (t *ProcessTable) SetAuditSession(pid, ppid int, auditSession uint32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry, ok := t.procs[pid]
+	if !ok {
+		entry = &processEntry{pid: pid, ppid: ppid}
+		if parent, ok := t.procs[ppid]; ok {
+			entry.sid = parent.sid
+		}
+		t.insert(entry)
+	}
+	entry.auditSid = auditSession
+}
+
+// AuditSession 返回进程的内核审计会话ID
+func (t *ProcessTable) AuditSession(pid int) (uint32, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	entry, ok := t.procs[pid]
+	if !ok || entry.auditSid == auditSessionUnset {
+		return 0, false
+	}
+	return entry.auditSid, true
+}
+
+// Exit 记录进程退出。仍有子进程在表中的进程会保留，以便后代的祖先链保持完整
+func (t *ProcessTable) Exit(pid int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry, ok := t.procs[pid]
+	if !ok {
+		return
+	}
+	entry.exited = true
+	t.prune(entry)
+}
+
+// insert 插入进程，调用方需持有锁
+func (t *ProcessTable) insert(entry *processEntry) {
+	if old, ok := t.procs[entry.pid]; ok {
+		// PID 被复用，旧进程退出事件可能已丢失
+		entry.children = old.children
+		if parent, ok := t.procs[old.ppid]; ok {
+			parent.children--
+		}
+	}
+	t.procs[entry.pid] = entry
+	if parent, ok := t.procs[entry.ppid]; ok {
+		parent.children++
+	}
+}
+
+// prune 删除已退出且没有子进程的进程，并向上级联，调用方需持有锁
+func (t *ProcessTable) prune(entry *processEntry) {
+	for i := 0; i < maxLineageDepth && entry != nil; i++ {
+		if !entry.exited || entry.children > 0 {
+			return
+		}
+		delete(t.procs, entry.pid)
+		parent, ok := t.procs[entry.ppid]
+		if !ok {
+			return
+		}
+		parent.children--
+		entry = parent
+	}
+}
+
+// Lookup 查找进程
+func (t *ProcessTable) Lookup(pid int) (ProcessRef, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	entry, ok := t.procs[pid]
+	if !ok {
+		return ProcessRef{}, false
+	}
+	return entry.ref(), true
+}
+
+// Lineage 返回进程的祖先链，从父进程开始直到 init
+func (t *ProcessTable) Lineage(pid int) []ProcessRef {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var lineage []ProcessRef
+	entry, ok := t.procs[pid]
+	for i := 0; ok && i < maxLineageDepth; i++ {
+		if entry.ppid == 0 || entry.ppid == entry.pid {
+			break
+		}
+		entry, ok = t.procs[entry.ppid]
+		if ok {
+			lineage = append(lineage, entry.ref())
+		}
+	}
+	return lineage
+}
+
+// SessionRoot 返回进程所属会话的首进程（例如 sshd 派生的登录 shell 或 cron 任务）。
+// 会话首进程未知时，返回 init 之下最顶层的祖先
+func (t *ProcessTable) SessionRoot(pid int) (ProcessRef, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	entry, ok := t.procs[pid]
+	if !ok {
+		return ProcessRef{}, false
+	}
+
+	top := entry
+	for i := 0; i < maxLineageDepth; i++ {
+		if entry.sid != 0 && entry.pid == entry.sid {
+			return entry.ref(), true
+		}
+		parent, ok := t.procs[entry.ppid]
+		if !ok || parent.pid == entry.pid || parent.ppid == 0 {
+			break
+		}
+		top = parent
+		entry = parent
+	}
+	return top.ref(), true
+}
+
+// Children 返回进程的直接子进程，按 PID 排序
+func (t *ProcessTable) Children(pid int) []ProcessRef {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var children []ProcessRef
+	for _, entry := range t.procs {
+		if entry.ppid == pid && entry.pid != pid {
+			children = append(children, entry.ref())
+		}
+	}
+	sort.Slice(children, func(i, j int) bool { return children[i].PID < children[j].PID })
+	return children
+}
+
+// ref 转换为进程引用
+func (e *processEntry) ref() ProcessRef {
+	return ProcessRef{PID: e.pid, Comm: e.comm, Exe: e.exe}
+}
+
+// readProcStat 读取 /proc/<pid>/stat 中的 ppid、会话ID和进程名
+func readProcStat(procRoot string, pid int) (ppid, sid int, comm string, ok bool) {
+	data, err := os.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return 0, 0, "", false
+	}
+
+	// comm 可能包含空格和括号，以最后一个 ')' 为界
+	start := bytes.IndexByte(data, '(')
+	end := bytes.LastIndexByte(data, ')')
+	if start < 0 || end < start {
+		return 0, 0, "", false
+	}
+	comm = string(data[start+1 : end])
+
+	// ')' 之后依次为 state ppid pgrp session ...
+	fields := strings.Fields(string(data[end+1:]))
+	if len(fields) < 4 {
+		return 0, 0, "", false
+	}
+	ppid, err1 := strconv.Atoi(fields[1])
+	sid, err2 := strconv.Atoi(fields[3])
+	if err1 != nil || err2 != nil {
+		return 0, 0, "", false
+	}
+	return ppid, sid, comm, true
+}
+
+// readAuditSession 读取 /proc/<pid>/sessionid，内核未启用审计时返回 auditSessionUnset
+func readAuditSession(procRoot string, pid int) uint32 {
+	data, err := os.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "sessionid"))
+	if err != nil {
+		return auditSessionUnset
+	}
+	sid, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 32)
+	if err != nil {
+		return auditSessionUnset
+	}
+	return uint32(sid)
+}
@@ -0,0 +1,426 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultQueryLimit 查询默认每页的事件数
+const DefaultQueryLimit = 20
+
+// MaxQueryWindow limit*page 的上限。分页需要在内存中保留最近 limit*page 条匹配的事件
+const MaxQueryWindow = 100000
+
+// Query 审计事件查询，所有非空条件都满足时事件匹配，同一条件的多个取值满足其一即可
+type Query struct {
+	Types     []EventType
+	Users     []string // 用户名或 UID
+	Since     time.Time
+	Until     time.Time
+	Command   *regexp.Regexp // 匹配以空格连接的命令和参数
+	DstIP     net.IP
+	DstPort   int // network 事件的目标端口，或 port_open 事件的端口
+	PID       int
+	SessionID string
+
+	// 分页：匹配的事件按时间排列，第 1 页为最近的 Limit 条
+	Limit int
+	Page  int
+
+	// MergeExits 为 true 时，匹配的命令的 command_exit 事件只作为退出信息合并到命令中，
+	// 不计入 Total 也不占用分页，与表格和单行视图中显示的行一致
+	MergeExits bool
+}
+
+// ParseQuery 解析 key=value 形式的查询条件：
+//
+//	type=command,builtin  user=alice  since=1h  until=2024-01-02  cmd=^curl
+//	dst=1.2.3.4:443  dst=:22  port=443  pid=1234  session=ID  limit=50  page=2
+//
+// since/until 接受时长（相对现在，如 30m、2h、7d）、日期、日期时间（本地时间）或 RFC 3339
+func ParseQuery(terms []string) (*Query, error) {
+	q := &Query{Limit: DefaultQueryLimit, Page: 1}
+	now := time.Now()
+	for _, term := range terms {
+		key, value, ok := strings.Cut(term, "=")
+		if !ok || value == "" {
+			return nil, fmt.Errorf("bad query term %q, expected key=value", term)
+		}
+
+		var err error
+		switch key {
+		case "type":
+			for _, t := range strings.Split(value, ",") {
+				q.Types = append(q.Types, EventType(t))
+			}
+		case "user":
+			q.Users = append(q.Users, strings.Split(value, ",")...)
+		case "since":
+			q.Since, err = parseQueryTime(value, now)
+		case "until":
+			q.Until, err = parseQueryTime(value, now)
+		case "cmd":
+			if q.Command, err = regexp.Compile(value); err != nil {
+				err = fmt.Errorf("bad regexp: %w", err)
+			}
+		case "dst":
+			err = q.parseDst(value)
+		case "port":
+			q.DstPort, err = parsePort(value)
+		case "pid":
+			q.PID, err = strconv.Atoi(value)
+		case "session":
+			q.SessionID = value
+		case "limit":
+			if q.Limit, err = strconv.Atoi(value); err == nil && q.Limit <= 0 {
+				err = fmt.Errorf("must be positive")
+			}
+		case "page":
+			if q.Page, err = strconv.Atoi(value); err == nil && q.Page <= 0 {
+				err = fmt.Errorf("must be positive")
+			}
+		default:
+			return nil, fmt.Errorf("unknown query key %q", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("bad %s %q: %v", key, value, err)
+		}
+	}
+	if q.Limit > MaxQueryWindow/q.Page {
+		return nil, fmt.Errorf("limit*page must not exceed %d", MaxQueryWindow)
+	}
+	return q, nil
+}
+
+// parseDst 解析 IP、IP:端口 或 :端口，IPv6 地址带端口时使用 [addr]:port
+func (q *Query) parseDst(value string) error {
+	host, port := value, ""
+	if h, p, err := net.SplitHostPort(value); err == nil {
+		host, port = h, p
+	}
+	if port != "" {
+		n, err := parsePort(port)
+		if err != nil {
+			return err
+		}
+		q.DstPort = n
+	}
+	if host != "" {
+		if q.DstIP = net.ParseIP(host); q.DstIP == nil {
+			return fmt.Errorf("invalid IP address")
+		}
+	}
+	return nil
+}
+
+// parsePort 解析端口号
+func parsePort(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 || n > 65535 {
+		return 0, fmt.Errorf("invalid port")
+	}
+	return n, nil
+}
+
+// queryTimeLayouts 查询中接受的本地时间格式
+var queryTimeLayouts = []string{
+	"2006-01-02",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+}
+
+// parseQueryTime 解析查询中的时间，时长表示 now 之前
+func parseQueryTime(s string, now time.Time) (time.Time, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range queryTimeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("expected a duration (e.g. 2h, 7d), date or RFC 3339 time")
+}
+
+// Match 判断事件是否匹配查询
+func (q *Query) Match(e *AuditEvent) bool {
+	if len(q.Types) > 0 && !containsType(q.Types, e.Type) {
+		return false
+	}
+	if len(q.Users) > 0 && !containsString(q.Users, e.Username) && !containsString(q.Users, strconv.Itoa(e.UID)) {
+		return false
+	}
+	if !q.Since.IsZero() && e.Timestamp.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !e.Timestamp.Before(q.Until) {
+		return false
+	}
+	if q.PID != 0 && e.PID != q.PID {
+		return false
+	}
+	if q.SessionID != "" && e.SessionID != q.SessionID {
+		return false
+	}
+	if q.Command != nil {
+		if e.Command == "" {
+			return false
+		}
+		line := strings.Join(append([]string{e.Command}, e.Args...), " ")
+		if !q.Command.MatchString(line) {
+			return false
+		}
+	}
+	if q.DstIP != nil || q.DstPort != 0 {
+		ip, port := destination(e)
+		if q.DstIP != nil && !q.DstIP.Equal(net.ParseIP(ip)) {
+			return false
+		}
+		if q.DstPort != 0 && port != q.DstPort {
+			return false
+		}
+	}
+	return true
+}
+
+// destination 返回 network 事件的目标地址和端口，port_open 事件返回监听的地址和端口
+func destination(e *AuditEvent) (string, int) {
+	details := e.DetailsMap()
+	switch e.Type {
+	case EventNetwork:
+		ip, _ := details["dst_ip"].(string)
+		port, _ := details["dst_port"].(float64)
+		return ip, int(port)
+	case EventConnectionClose:
+		if details["direction"] == "outbound" {
+			ip, _ := details["remote_ip"].(string)
+			port, _ := details["remote_port"].(float64)
+			return ip, int(port)
+		}
+		ip, _ := details["local_ip"].(string)
+		port, _ := details["local_port"].(float64)
+		return ip, int(port)
+	case EventAccept:
+		ip, _ := details["local_ip"].(string)
+		port, _ := details["local_port"].(float64)
+		return ip, int(port)
+	case EventPortOpen, EventPortClosed:
+		ip, _ := details["address"].(string)
+		port, _ := details["port"].(float64)
+		return ip, int(port)
+	}
+	return "", 0
+}
+
+// DetailsMap 返回事件详情的通用形式。从日志文件读取的事件详情本身就是 map，
+// 内存中的事件详情为具体类型，经 JSON 转换
+func (e *AuditEvent) DetailsMap() map[string]interface{} {
+	if m, ok := e.Details.(map[string]interface{}); ok {
+		return m
+	}
+	var m map[string]interface{}
+	if data, err := json.Marshal(e.Details); err == nil {
+		json.Unmarshal(data, &m)
+	}
+	return m
+}
+
+// ExitDetails 返回 command_exit 事件的详情
+func (e *AuditEvent) ExitDetails() (CommandExitDetails, bool) {
+	switch d := e.Details.(type) {
+	case CommandExitDetails:
+		return d, true
+	case *CommandExitDetails:
+		return *d, true
+	}
+	var d CommandExitDetails
+	if e.Type != EventCommandExit || e.Details == nil {
+		return d, false
+	}
+	data, err := json.Marshal(e.Details)
+	if err != nil || json.Unmarshal(data, &d) != nil {
+		return d, false
+	}
+	return d, true
+}
+
+// QueryResult 查询结果，Events 为当前页的事件，按时间顺序排列，Total 为匹配的事件总数，
+// Page 和 Pages 为当前页码和总页数。Exits 为当前页中已退出命令的退出信息，以 command 事件ID为键，
+// 即使 command_exit 事件本身不匹配查询
+type QueryResult struct {
+	Events []AuditEvent
+	Total  int
+	Page   int
+	Pages  int
+	Exits  map[string]ExitInfo
+}
+
+// Summary 返回分页说明，audit 内置命令和 query 子命令共用
+func (r *QueryResult) Summary() string {
+	return fmt.Sprintf("page %d/%d, %d matching event(s)", r.Page, r.Pages, r.Total)
+}
+
+// pager 在匹配的事件中保留最近 Limit*Page 条，用于从后往前分页，同时收集其中命令的退出信息
+type pager struct {
+	q      *Query
+	ring   []AuditEvent
+	next   int
+	total  int
+	window int
+	exits  map[string]*ExitInfo // 窗口中的 command 事件ID，值在命令退出后设置
+	// running 为 MergeExits 时已匹配、尚未退出的命令，其 command_exit 事件被合并
+	running map[string]bool
+}
+
+// newPager 创建分页器
+func (q *Query) newPager() *pager {
+	return &pager{q: q, window: q.Limit * q.Page, exits: make(map[string]*ExitInfo), running: make(map[string]bool)}
+}
+
+// add 加入一条事件，不匹配时忽略
+func (p *pager) add(e AuditEvent) {
+	if e.Type == EventCommandExit {
+		if d, ok := e.ExitDetails(); ok {
+			if _, found := p.exits[d.CommandID]; found {
+				p.exits[d.CommandID] = &d.ExitInfo
+			}
+			if p.running[d.CommandID] {
+				delete(p.running, d.CommandID)
+				return
+			}
+		}
+	}
+	if !p.q.Match(&e) {
+		return
+	}
+	p.total++
+	if e.Type == EventCommand {
+		p.exits[e.ID] = nil
+		if p.q.MergeExits {
+			p.running[e.ID] = true
+		}
+	}
+	if len(p.ring) < p.window {
+		p.ring = append(p.ring, e)
+		return
+	}
+	delete(p.exits, p.ring[p.next].ID)
+	p.ring[p.next] = e
+	p.next = (p.next + 1) % p.window
+}
+
+// result 返回当前页
+func (p *pager) result() *QueryResult {
+	ordered := append(append([]AuditEvent(nil), p.ring[p.next:]...), p.ring[:p.next]...)
+	// ordered 中最后 Limit 条为第 1 页
+	end := len(ordered) - p.q.Limit*(p.q.Page-1)
+	if end < 0 {
+		end = 0
+	}
+	start := end - p.q.Limit
+	if start < 0 {
+		start = 0
+	}
+	pages := (p.total + p.q.Limit - 1) / p.q.Limit
+	if pages == 0 {
+		pages = 1
+	}
+	result := &QueryResult{
+		Events: ordered[start:end],
+		Total:  p.total,
+		Page:   p.q.Page,
+		Pages:  pages,
+		Exits:  make(map[string]ExitInfo),
+	}
+	for _, e := range result.Events {
+		if info := p.exits[e.ID]; info != nil {
+			result.Exits[e.ID] = *info
+		}
+	}
+	return result
+}
+
+// Filter 在内存中的事件中查询
+func (q *Query) Filter(events []AuditEvent) *QueryResult {
+	p := q.newPager()
+	for _, e := range events {
+		p.add(e)
+	}
+	return p.result()
+}
+
+// Search 按顺序读取日志文件查询，文件头记录和无法解析的行被跳过
+func (q *Query) Search(files []string) (*QueryResult, error) {
+	p := q.newPager()
+	for _, path := range files {
+		if err := scanEvents(path, p.add); err != nil {
+			return nil, err
+		}
+	}
+	return p.result(), nil
+}
+
+// scanEvents 逐条读取日志文件中的事件
+func scanEvents(path string, fn func(AuditEvent)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var e AuditEvent
+		if err := json.Unmarshal(line, &e); err != nil || e.Type == EventLogHeader {
+			continue
+		}
+		fn(e)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return nil
+}
+
+// containsType 判断列表中是否包含事件类型
+func containsType(list []EventType, t EventType) bool {
+	for _, v := range list {
+		if v == t {
+			return true
+		}
+	}
+	return false
+}
+
+// containsString 判断列表中是否包含 s
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,170 @@
+package audit
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseQuery(t *testing.T) {
+	q, err := ParseQuery([]string{
+		"type=command,builtin", "user=alice", "user=0", "cmd=^curl", "dst=[::1]:443",
+		"pid=42", "session=abc", "limit=50", "page=3", "since=7d", "until=2024-01-02",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(q.Types) != 2 || q.Types[1] != EventBuiltin {
+		t.Errorf("types = %v", q.Types)
+	}
+	if strings.Join(q.Users, ",") != "alice,0" {
+		t.Errorf("users = %v", q.Users)
+	}
+	if q.DstIP.String() != "::1" || q.DstPort != 443 {
+		t.Errorf("dst = %v port %d", q.DstIP, q.DstPort)
+	}
+	if q.PID != 42 || q.SessionID != "abc" || q.Limit != 50 || q.Page != 3 {
+		t.Errorf("pid %d session %q limit %d page %d", q.PID, q.SessionID, q.Limit, q.Page)
+	}
+	if since := time.Since(q.Since); since < 7*24*time.Hour-time.Hour || since > 7*24*time.Hour+time.Hour {
+		t.Errorf("since = %v", q.Since)
+	}
+	if want := time.Date(2024, 1, 2, 0, 0, 0, 0, time.Local); !q.Until.Equal(want) {
+		t.Errorf("until = %v, want %v", q.Until, want)
+	}
+
+	q, err = ParseQuery(nil)
+	if err != nil || q.Limit != DefaultQueryLimit || q.Page != 1 {
+		t.Errorf("defaults = %+v, %v", q, err)
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	for _, terms := range [][]string{
+		{"type"},
+		{"user="},
+		{"color=red"},
+		{"cmd=("},
+		{"dst=1.2.3"},
+		{"port=70000"},
+		{"since=yesterday"},
+		{"limit=0"},
+		{"page=-1"},
+		{"limit=x"},
+		{"limit=99999999999999999999"},
+		// limit*page 溢出或超过上限
+		{"limit=9223372036854775807", "page=2"},
+		{fmt.Sprintf("limit=%d", MaxQueryWindow), "page=2"},
+		{fmt.Sprintf("page=%d", MaxQueryWindow+1)},
+	} {
+		if q, err := ParseQuery(terms); err == nil {
+			t.Errorf("ParseQuery(%q) = %+v, want error", terms, q)
+		}
+	}
+
+	if _, err := ParseQuery([]string{fmt.Sprintf("limit=%d", MaxQueryWindow)}); err != nil {
+		t.Errorf("limit at the maximum: %v", err)
+	}
+}
+
+// commandEvents 生成 n 条 command 事件，ID 为 c1...cn
+func commandEvents(n int) []AuditEvent {
+	var events []AuditEvent
+	for i := 1; i <= n; i++ {
+		events = append(events, AuditEvent{ID: fmt.Sprintf("c%d", i), Type: EventCommand, PID: i, Command: "true"})
+	}
+	return events
+}
+
+func TestFilterPages(t *testing.T) {
+	events := commandEvents(45)
+	tests := []struct {
+		page        int
+		first, last int // 当前页第一条和最后一条事件的 PID，0 表示空页
+	}{
+		{1, 26, 45},
+		{2, 6, 25},
+		{3, 1, 5},
+		{4, 0, 0},
+	}
+	for _, tt := range tests {
+		q := &Query{Limit: 20, Page: tt.page}
+		result := q.Filter(events)
+		if result.Total != 45 {
+			t.Errorf("page %d: total = %d, want 45", tt.page, result.Total)
+		}
+		if want := fmt.Sprintf("page %d/3, 45 matching event(s)", tt.page); result.Summary() != want {
+			t.Errorf("summary = %q, want %q", result.Summary(), want)
+		}
+		if tt.first == 0 {
+			if len(result.Events) != 0 {
+				t.Errorf("page %d: got %d events, want none", tt.page, len(result.Events))
+			}
+			continue
+		}
+		if n := tt.last - tt.first + 1; len(result.Events) != n {
+			t.Fatalf("page %d: got %d events, want %d", tt.page, len(result.Events), n)
+		}
+		if first, last := result.Events[0].PID, result.Events[len(result.Events)-1].PID; first != tt.first || last != tt.last {
+			t.Errorf("page %d: events %d..%d, want %d..%d", tt.page, first, last, tt.first, tt.last)
+		}
+	}
+}
+
+func TestFilterExits(t *testing.T) {
+	events := commandEvents(5)
+	// c1 的退出在窗口之外，c4 的退出事件本身不匹配 type=command
+	events = append(events,
+		AuditEvent{Type: EventCommandExit, Details: CommandExitDetails{CommandID: "c1", ExitInfo: ExitInfo{ExitCode: 1}}},
+		AuditEvent{Type: EventCommandExit, Details: CommandExitDetails{CommandID: "c4", ExitInfo: ExitInfo{ExitCode: 2}}},
+		AuditEvent{Type: EventCommandExit, Details: map[string]interface{}{"command_id": "c5", "exit_code": float64(3)}},
+	)
+
+	q, err := ParseQuery([]string{"type=command", "limit=3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := q.Filter(events)
+	if result.Total != 5 || len(result.Events) != 3 {
+		t.Fatalf("total %d, %d events, want 5 and 3", result.Total, len(result.Events))
+	}
+	if len(result.Exits) != 2 || result.Exits["c4"].ExitCode != 2 || result.Exits["c5"].ExitCode != 3 {
+		t.Errorf("exits = %+v, want c4 and c5", result.Exits)
+	}
+}
+
+// TestFilterMergeExits 合并时匹配的命令的 command_exit 不计入总数和分页，未匹配命令的 command_exit 照常计入
+func TestFilterMergeExits(t *testing.T) {
+	var events []AuditEvent
+	for _, e := range commandEvents(10) {
+		events = append(events, e, AuditEvent{
+			ID:      "x" + e.ID,
+			Type:    EventCommandExit,
+			Details: CommandExitDetails{CommandID: e.ID, ExitInfo: ExitInfo{ExitCode: e.PID}},
+		})
+	}
+	events = append(events, AuditEvent{ID: "orphan", Type: EventCommandExit, Details: CommandExitDetails{CommandID: "gone"}})
+
+	for _, tt := range []struct {
+		merge bool
+		total int
+		ids   []string
+	}{
+		{false, 21, []string{"c10", "xc10", "orphan"}},
+		{true, 11, []string{"c9", "c10", "orphan"}},
+	} {
+		q := &Query{Limit: 3, Page: 1, MergeExits: tt.merge}
+		result := q.Filter(events)
+		var ids []string
+		for _, e := range result.Events {
+			ids = append(ids, e.ID)
+		}
+		if result.Total != tt.total || strings.Join(ids, ",") != strings.Join(tt.ids, ",") {
+			t.Errorf("merge=%v: total %d, events %v, want %d and %v", tt.merge, result.Total, ids, tt.total, tt.ids)
+		}
+		if info, ok := result.Exits["c10"]; !ok || info.ExitCode != 10 {
+			t.Errorf("merge=%v: exits = %+v, want c10", tt.merge, result.Exits)
+		}
+	}
+}
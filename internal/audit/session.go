@@ -0,0 +1,124 @@
+package audit
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+// auditSessionUnset 内核未设置审计会话ID时的取值
+const auditSessionUnset = ^uint32(0)
+
+// SessionDetails 登录会话详情
+type SessionDetails struct {
+	TTY            string `json:"tty,omitempty"`
+	RemoteAddr     string `json:"remote_addr,omitempty"`
+	RemotePort     int    `json:"remote_port,omitempty"`
+	LoginMethod    string `json:"login_method"` // ssh, sudo, console, local
+	AuditSessionID string `json:"audit_session_id,omitempty"`
+	Recording      string `json:"recording,omitempty"`  // 终端录制文件（asciicast v2）
+	Command        string `json:"command,omitempty"`    // 非交互式会话执行的命令（-c、SSH_ORIGINAL_COMMAND）或脚本
+	Restricted     string `json:"restricted,omitempty"` // 受限模式配置的ID
+	Reason         string `json:"reason,omitempty"`     // 仅 session_end：exit, hangup, terminated
+	DurationMs     int64  `json:"duration_ms,omitempty"`
+}
+
+// StartupFileDetails 启动文件详情，SHA256 为执行时文件内容的摘要
+type StartupFileDetails struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+	Size   int    `json:"size"`
+}
+
+// NewSessionID 生成新的会话ID
+func NewSessionID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// AuditSessionID 将内核审计会话ID转换为会话ID，未设置时返回空字符串
+func AuditSessionID(sessionID uint32) string {
+	if sessionID == auditSessionUnset {
+		return ""
+	}
+	return "audit-" + strconv.FormatUint(uint64(sessionID), 10)
+}
+
+// RegisterSession 将 pid 及其所有后代进程的事件归入 sessionID
+func (a *Auditor) RegisterSession(pid int, sessionID string) {
+	a.sessMu.Lock()
+	defer a.sessMu.Unlock()
+	a.sessions[pid] = sessionID
+}
+
+// UnregisterSession 取消 pid 的会话登记
+func (a *Auditor) UnregisterSession(pid int) {
+	a.sessMu.Lock()
+	defer a.sessMu.Unlock()
+	delete(a.sessions, pid)
+}
+
+// sessionFor 查找事件所属会话：优先使用登记过的会话（自身或最近的祖先），
+// 否则使用内核审计会话ID
+func (a *Auditor) sessionFor(pid int, lineage []ProcessRef) string {
+	a.sessMu.RLock()
+	if id, ok := a.sessions[pid]; ok {
+		a.sessMu.RUnlock()
+		return id
+	}
+	for _, p := range lineage {
+		if id, ok := a.sessions[p.PID]; ok {
+			a.sessMu.RUnlock()
+			return id
+		}
+	}
+	a.sessMu.RUnlock()
+
+	if sid, ok := a.procs.AuditSession(pid); ok {
+		return AuditSessionID(sid)
+	}
+	return ""
+}
+
+// LogSessionStart 记录会话开始
+func (a *Auditor) LogSessionStart(pid, uid, gid int, username, sessionID string, details SessionDetails) {
+	a.logSession(EventSessionStart, pid, uid, gid, username, sessionID, details)
+}
+
+// LogSessionEnd 记录会话结束
+func (a *Auditor) LogSessionEnd(pid, uid, gid int, username, sessionID string, details SessionDetails) {
+	a.logSession(EventSessionEnd, pid, uid, gid, username, sessionID, details)
+}
+
+// LogStartupFile 记录 shell 执行的启动文件，文件中的命令随后各自记录
+func (a *Auditor) LogStartupFile(pid, uid, gid int, username string, details StartupFileDetails) {
+	event := AuditEvent{
+		Timestamp: time.Now(),
+		Type:      EventStartupFile,
+		PID:       pid,
+		UID:       uid,
+		GID:       gid,
+		Username:  username,
+		Details:   details,
+	}
+	a.log(event)
+}
+
+// logSession 写入会话事件
+func (a *Auditor) logSession(typ EventType, pid, uid, gid int, username, sessionID string, details SessionDetails) {
+	event := AuditEvent{
+		Timestamp: time.Now(),
+		Type:      typ,
+		SessionID: sessionID,
+		PID:       pid,
+		UID:       uid,
+		GID:       gid,
+		Username:  username,
+		Details:   details,
+	}
+	a.log(event)
+}
@@ -0,0 +1,125 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// IssueKind 日志校验问题类型
+type IssueKind string
+
+const (
+	IssueModified     IssueKind = "modified"      // 记录内容与哈希不一致或无法解析
+	IssueGap          IssueKind = "gap"           // 序号跳跃，中间的记录被删除
+	IssueReordered    IssueKind = "reordered"     // 序号回退或重复
+	IssueBrokenChain  IssueKind = "broken_chain"  // prev_hash 与上一条记录的哈希不一致
+	IssueBadSignature IssueKind = "bad_signature" // 签名缺失或校验失败
+)
+
+// VerifyIssue 日志校验发现的问题
+type VerifyIssue struct {
+	File    string
+	Line    int
+	Kind    IssueKind
+	Message string
+}
+
+// String 格式化问题描述
+func (i VerifyIssue) String() string {
+	return fmt.Sprintf("%s:%d: %s: %s", i.File, i.Line, i.Kind, i.Message)
+}
+
+// Verifier 哈希链校验器，按顺序校验多个文件时哈希链跨文件延续
+type Verifier struct {
+	signer   Signer
+	started  bool
+	seq      uint64
+	prevHash string
+	records  int
+}
+
+// NewVerifier 创建校验器，signer 不为 nil 时同时校验每条记录的签名
+func NewVerifier(signer Signer) *Verifier {
+	return &Verifier{signer: signer}
+}
+
+// Records 返回已校验的记录数
+func (v *Verifier) Records() int {
+	return v.records
+}
+
+// VerifyFile 校验一个日志文件，返回发现的问题
+func (v *Verifier) VerifyFile(path string) ([]VerifyIssue, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	var issues []VerifyIssue
+	report := func(line int, kind IssueKind, format string, args ...interface{}) {
+		issues = append(issues, VerifyIssue{File: path, Line: line, Kind: kind, Message: fmt.Sprintf(format, args...)})
+	}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		v.records++
+
+		rec, err := parseRecord(line)
+		if err != nil {
+			if errors.Is(err, errNoChain) {
+				report(lineNo, IssueModified, "record has no hash chain fields")
+			} else {
+				report(lineNo, IssueModified, "%v", err)
+			}
+			continue
+		}
+		v.check(rec, lineNo, report)
+	}
+	if err := scanner.Err(); err != nil {
+		return issues, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return issues, nil
+}
+
+// check 校验单条记录，并以该记录为基准继续后续校验，避免一处篡改导致后面全部报错
+func (v *Verifier) check(rec *chainRecord, lineNo int, report func(int, IssueKind, string, ...interface{})) {
+	sum := sha256.Sum256(rec.body)
+	if hex.EncodeToString(sum[:]) != rec.Hash {
+		report(lineNo, IssueModified, "seq %d: content does not match its hash", rec.Seq)
+	} else if v.signer != nil {
+		sig, err := hex.DecodeString(rec.Sig)
+		switch {
+		case rec.Sig == "":
+			report(lineNo, IssueBadSignature, "seq %d: record is not signed", rec.Seq)
+		case err != nil || !v.signer.Verify(sum[:], sig):
+			report(lineNo, IssueBadSignature, "seq %d: signature does not match (%s)", rec.Seq, v.signer.Algorithm())
+		}
+	}
+
+	if v.started {
+		expected := v.seq + 1
+		switch {
+		case rec.Seq > expected:
+			report(lineNo, IssueGap, "expected seq %d, got %d: %d record(s) missing", expected, rec.Seq, rec.Seq-expected)
+		case rec.Seq < expected:
+			report(lineNo, IssueReordered, "expected seq %d, got %d", expected, rec.Seq)
+		case rec.PrevHash != v.prevHash:
+			report(lineNo, IssueBrokenChain, "seq %d: prev_hash does not match the hash of seq %d", rec.Seq, v.seq)
+		}
+	}
+
+	v.started = true
+	v.seq = rec.Seq
+	v.prevHash = rec.Hash
+}
@@ -3,234 +3,953 @@ package bpf
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"net"
-	"syscall"
-	"unsafe"
+	"os"
+	"os/user"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/features"
+	"github.com/cilium/ebpf/link"
+	"golang.org/x/sys/unix"
 )
 
+//go:generate sh -c "bpftool btf dump file /sys/kernel/btf/vmlinux format c > bpf/vmlinux.h"
 //go:generate go run github.com/cilium/ebpf/cmd/bpf2go -cc clang -cflags "-O2 -g -Wall -Werror" bpf ./bpf/trace.c -- -I/usr/include/bpf
 
-// EventTypes BPF事件类型
+// EventTypes BPF事件类型，与 trace.c 中的 EVENT_* 保持一致
 const (
-	EventExecve = iota
+	EventExecve = iota + 1
 	EventConnect
 	EventAccept
-	EventBind
+	EventListen
 	EventDNSQuery
+	EventExit
+	EventFork
+	EventClose
+	EventFile
 )
 
-// ExecveEvent 执行命令事件
-type ExecveEvent struct {
-	PID        uint32
-	PPID       uint32
-	UID        uint32
-	GID        uint32
-	Comm       [16]byte
-	ArgCount   uint32
-	Args       [512]byte
-	WorkingDir [256]byte
-}
-
-// ConnectEvent 连接事件
-type ConnectEvent struct {
-	PID     uint32
-	UID     uint32
-	GID     uint32
-	Comm    [16]byte
-	SrcAddr [16]byte
-	SrcPort uint16
-	DstAddr [16]byte
-	DstPort uint16
-	Protocol uint8
+// eventHeader 所有内核事件共用的头部
+type eventHeader struct {
+	Type      uint32
+	_         uint32
+	Timestamp uint64
+}
+
+// eventHeaderSize 事件头长度
+const eventHeaderSize = 16
+
+// 与 trace.c 中 execve_event_t 的缓冲区大小保持一致
+const (
+	DefaultMaxArgs = 64
+	MaxArgsLimit   = 256
+	maxFilenameLen = 512
+	cwdBufSize     = 4096
+	maxNameLen     = 256
+)
+
+// execve 事件标志
+const (
+	execveArgsTruncated = 1 << 0
+	execveCwdTruncated  = 1 << 1
+)
+
+// execveEvent 内核执行命令事件的定长部分，其后紧跟 ArgsSize 字节的参数
+type execveEvent struct {
+	Header    eventHeader
+	PID       uint32
+	PPID      uint32
+	UID       uint32
+	GID       uint32
+	LoginUID  uint32
+	SessionID uint32
+	Comm      [16]byte
+	ArgCount  uint32
+	ArgsSize  uint32
+	CwdOffset uint32
+	Flags     uint8
+	_         [3]byte
+	Filename  [maxFilenameLen]byte
+	Cwd       [cwdBufSize + maxNameLen]byte
 }
 
-// BindEvent 绑定端口事件
-type BindEvent struct {
-	PID     uint32
-	UID     uint32
-	GID     uint32
-	Comm    [16]byte
-	Address [16]byte
-	Port    uint16
+// connectEvent 内核连接事件，Protocol 为套接字类型，Error 为失败时的 errno
+type connectEvent struct {
+	Header   eventHeader
+	PID      uint32
+	UID      uint32
+	GID      uint32
+	Comm     [16]byte
+	SrcAddr  [16]byte
+	SrcPort  uint16
+	DstAddr  [16]byte
+	DstPort  uint16
 	Protocol uint8
+	_        [3]byte
+	Error    int32
 }
 
-// DNSQueryEvent DNS查询事件
-type DNSQueryEvent struct {
+// closeEvent 内核 TCP 连接关闭事件
+type closeEvent struct {
+	Header        eventHeader
+	BytesSent     uint64
+	BytesReceived uint64
+	DurationNs    uint64
+	PID           uint32
+	UID           uint32
+	GID           uint32
+	Comm          [16]byte
+	LocalAddr     [16]byte
+	LocalPort     uint16
+	RemoteAddr    [16]byte
+	RemotePort    uint16
+	Direction     uint8
+}
+
+// 连接方向，与 trace.c 中的 CONN_* 保持一致
+const (
+	connOutbound = 0
+	connInbound  = 1
+)
+
+// listenEvent 内核监听事件，Closed 非 0 时为停止监听
+type listenEvent struct {
+	Header   eventHeader
+	Inode    uint64
 	PID      uint32
 	UID      uint32
 	GID      uint32
 	Comm     [16]byte
-	Domain   [256]byte
-	Resolved [16]byte
-	Type     uint8
+	Address  [16]byte
+	Port     uint16
+	Protocol uint8
+	Closed   uint8
+}
+
+// acceptEvent 内核入站连接事件
+type acceptEvent struct {
+	Header     eventHeader
+	PID        uint32
+	UID        uint32
+	GID        uint32
+	Comm       [16]byte
+	LocalAddr  [16]byte
+	LocalPort  uint16
+	RemoteAddr [16]byte
+	RemotePort uint16
+}
+
+// exitEvent 内核进程退出事件
+type exitEvent struct {
+	Header     eventHeader
+	PID        uint32
+	PPID       uint32
+	UID        uint32
+	GID        uint32
+	Comm       [16]byte
+	ExitCode   int32
+	Signal     int32
+	UserNs     uint64
+	SystemNs   uint64
+	WallNs     uint64
+	CoreDumped uint8
+}
+
+// forkEvent 内核进程创建事件
+type forkEvent struct {
+	Header    eventHeader
+	ParentPID uint32
+	ChildPID  uint32
+	UID       uint32
+	GID       uint32
+	Comm      [16]byte
+}
+
+// dnsMaxLen 与 trace.c 中的 DNS_MAX_LEN 保持一致
+const dnsMaxLen = 512
+
+// DNS 报文方向
+const (
+	dnsQuery    = 0
+	dnsResponse = 1
+)
+
+// dnsEvent 内核 DNS 报文事件的定长部分，其后紧跟最多 dnsMaxLen 字节的报文
+type dnsEvent struct {
+	Header     eventHeader
+	PID        uint32
+	UID        uint32
+	GID        uint32
+	Comm       [16]byte
+	Server     [16]byte
+	ServerPort uint16
+	Direction  uint8
+	_          uint8
+	Len        uint32
+}
+
+// 文件操作，与 trace.c 中的 FILE_* 保持一致
+const (
+	fileOpen = iota
+	fileUnlink
+	fileRename
+	fileChmod
+	fileChown
+	fileTruncate
+)
+
+// fileOps 文件操作名称
+var fileOps = [...]string{
+	fileOpen:     "open",
+	fileUnlink:   "unlink",
+	fileRename:   "rename",
+	fileChmod:    "chmod",
+	fileChown:    "chown",
+	fileTruncate: "truncate",
+}
+
+// filePathSize 与 trace.c 中的 FILE_PATH_SIZE 保持一致
+const filePathSize = cwdBufSize + maxNameLen + maxFilenameLen
+
+// WatchPathMax 监视路径的最大长度，与 trace.c 中的 WATCH_PATH_MAX 保持一致。
+// 精确匹配的条目在路径后还有一个 NUL，因此路径必须短于 WatchPathMax
+const WatchPathMax = 256
+
+// MaxWatchPaths 监视路径的最大数量，每个目录占用 trace.c 中 MAX_WATCH_ENTRIES 的两个条目
+const MaxWatchPaths = 256
+
+// fileEvent 内核文件访问事件的定长部分，其后紧跟 filePathSize 字节的路径缓冲区，
+// rename 事件还有同样大小的新路径缓冲区
+type fileEvent struct {
+	Header     eventHeader
+	Length     int64
+	PID        uint32
+	UID        uint32
+	GID        uint32
+	Comm       [16]byte
+	Op         uint32
+	Flags      uint32
+	Mode       uint32
+	Owner      uint32
+	Group      uint32
+	Ret        int32
+	PathOff    uint32
+	PathLen    uint32
+	NewPathOff uint32
+	NewPathLen uint32
+}
+
+// watchKey 监视列表的键，Prefixlen 为前缀的位数
+type watchKey struct {
+	Prefixlen uint32
+	Path      [WatchPathMax]byte
+}
+
+// watchKeys 返回监视路径对应的键。以 "/" 结尾的目录匹配目录本身和其下的所有路径，
+// 其他路径只精确匹配：键中包含路径后的 NUL，内核查找时同样在路径后补 NUL
+func watchKeys(p string) []watchKey {
+	// key 取 s 的前 n 字节，n 比 s 长一字节时包含结尾的 NUL
+	key := func(s string, n int) watchKey {
+		k := watchKey{Prefixlen: uint32(n) * 8}
+		copy(k.Path[:], s)
+		return k
+	}
+	dir, isDir := strings.CutSuffix(p, "/")
+	switch {
+	case !isDir:
+		return []watchKey{key(p, len(p)+1)}
+	case dir == "":
+		return []watchKey{key(p, len(p))}
+	default:
+		return []watchKey{key(p, len(p)), key(dir, len(dir)+1)}
+	}
+}
+
+// attachment 追踪程序及其挂载方式，optional 的程序挂载失败时只给出警告，
+// 例如 IPv6 以模块形式编译且未加载时没有 udpv6_* 函数
+type attachment struct {
+	name     string
+	attach   func(o *bpfObjects) (link.Link, error)
+	optional bool
+}
+
+// tracepointAttachment 挂载到普通 tracepoint
+func tracepointAttachment(group, name string, prog func(*bpfObjects) *ebpf.Program) attachment {
+	return attachment{
+		name: group + "/" + name,
+		attach: func(o *bpfObjects) (link.Link, error) {
+			return link.Tracepoint(group, name, prog(o), nil)
+		},
+	}
+}
+
+// tracingAttachment 挂载 BTF tracepoint/fentry 等 tracing 程序，挂载点由程序的 SEC 决定
+func tracingAttachment(name string, prog func(*bpfObjects) *ebpf.Program) attachment {
+	return attachment{
+		name: name,
+		attach: func(o *bpfObjects) (link.Link, error) {
+			return link.AttachTracing(link.TracingOptions{Program: prog(o)})
+		},
+	}
+}
+
+// kprobeAttachment 挂载到内核函数入口，ret 为 true 时挂载到函数返回
+func kprobeAttachment(symbol string, ret, optional bool, prog func(*bpfObjects) *ebpf.Program) attachment {
+	name := "kprobe/" + symbol
+	if ret {
+		name = "kretprobe/" + symbol
+	}
+	return attachment{
+		name: name,
+		attach: func(o *bpfObjects) (link.Link, error) {
+			if ret {
+				return link.Kretprobe(symbol, prog(o), nil)
+			}
+			return link.Kprobe(symbol, prog(o), nil)
+		},
+		optional: optional,
+	}
+}
+
+// attachments 所有追踪程序
+var attachments = []attachment{
+	tracepointAttachment("syscalls", "sys_enter_execve", func(o *bpfObjects) *ebpf.Program { return o.TraceExecve }),
+	tracepointAttachment("syscalls", "sys_enter_connect", func(o *bpfObjects) *ebpf.Program { return o.TraceConnect }),
+	tracepointAttachment("syscalls", "sys_exit_connect", func(o *bpfObjects) *ebpf.Program { return o.TraceConnectRet }),
+	tracepointAttachment("sock", "inet_sock_set_state", func(o *bpfObjects) *ebpf.Program { return o.TraceSockState }),
+	tracepointAttachment("syscalls", "sys_enter_listen", func(o *bpfObjects) *ebpf.Program { return o.TraceListen }),
+	tracepointAttachment("syscalls", "sys_exit_listen", func(o *bpfObjects) *ebpf.Program { return o.TraceListenRet }),
+	kprobeAttachment("inet_csk_accept", true, false, func(o *bpfObjects) *ebpf.Program { return o.TraceAccept }),
+	kprobeAttachment("udp_lib_get_port", false, false, func(o *bpfObjects) *ebpf.Program { return o.TraceUdpGetPort }),
+	kprobeAttachment("udp_lib_get_port", true, false, func(o *bpfObjects) *ebpf.Program { return o.TraceUdpGetPortRet }),
+	kprobeAttachment("udp_lib_unhash", false, false, func(o *bpfObjects) *ebpf.Program { return o.TraceUdpUnhash }),
+	tracepointAttachment("sched", "sched_process_exit", func(o *bpfObjects) *ebpf.Program { return o.TraceExit }),
+	tracingAttachment("sched_process_fork", func(o *bpfObjects) *ebpf.Program { return o.TraceFork }),
+	kprobeAttachment("udp_sendmsg", false, false, func(o *bpfObjects) *ebpf.Program { return o.TraceUdpSendmsg }),
+	kprobeAttachment("udp_recvmsg", false, false, func(o *bpfObjects) *ebpf.Program { return o.TraceUdpRecvmsg }),
+	kprobeAttachment("udp_recvmsg", true, false, func(o *bpfObjects) *ebpf.Program { return o.TraceUdpRecvmsgRet }),
+	kprobeAttachment("udpv6_sendmsg", false, true, func(o *bpfObjects) *ebpf.Program { return o.TraceUdpv6Sendmsg }),
+	kprobeAttachment("udpv6_recvmsg", false, true, func(o *bpfObjects) *ebpf.Program { return o.TraceUdpv6Recvmsg }),
+	kprobeAttachment("udpv6_recvmsg", true, true, func(o *bpfObjects) *ebpf.Program { return o.TraceUdpv6RecvmsgRet }),
+}
+
+// fileSyscall 文件访问系统调用及其入口程序，optional 的系统调用在部分架构或旧内核上不存在，
+// 例如 arm64 没有 open、rename 等旧系统调用，openat2 需要 5.6 以上内核
+type fileSyscall struct {
+	name     string
+	prog     func(o *bpfObjects) *ebpf.Program
+	optional bool
+}
+
+// fileSyscalls 审计的文件访问系统调用，返回时统一由 trace_file_ret 提交事件。
+// open 和以 fd 指定文件的系统调用按打开的文件匹配路径，其他系统调用的路径由 pathHooks 取得
+var fileSyscalls = []fileSyscall{
+	{"openat", func(o *bpfObjects) *ebpf.Program { return o.TraceOpenat }, false},
+	{"openat2", func(o *bpfObjects) *ebpf.Program { return o.TraceOpenat2 }, true},
+	{"open", func(o *bpfObjects) *ebpf.Program { return o.TraceOpenLegacy }, true},
+	{"unlinkat", func(o *bpfObjects) *ebpf.Program { return o.TraceUnlinkat }, false},
+	{"unlink", func(o *bpfObjects) *ebpf.Program { return o.TraceUnlink }, true},
+	{"rmdir", func(o *bpfObjects) *ebpf.Program { return o.TraceRmdir }, true},
+	{"renameat2", func(o *bpfObjects) *ebpf.Program { return o.TraceRenameat2 }, false},
+	{"renameat", func(o *bpfObjects) *ebpf.Program { return o.TraceRenameat }, true},
+	{"rename", func(o *bpfObjects) *ebpf.Program { return o.TraceRenameLegacy }, true},
+	{"fchmodat", func(o *bpfObjects) *ebpf.Program { return o.TraceFchmodat }, false},
+	{"fchmod", func(o *bpfObjects) *ebpf.Program { return o.TraceFchmod }, false},
+	{"chmod", func(o *bpfObjects) *ebpf.Program { return o.TraceChmodLegacy }, true},
+	{"fchownat", func(o *bpfObjects) *ebpf.Program { return o.TraceFchownat }, false},
+	{"fchown", func(o *bpfObjects) *ebpf.Program { return o.TraceFchown }, false},
+	{"chown", func(o *bpfObjects) *ebpf.Program { return o.TraceChownLegacy }, true},
+	{"lchown", func(o *bpfObjects) *ebpf.Program { return o.TraceChownLegacy }, true},
+	{"truncate", func(o *bpfObjects) *ebpf.Program { return o.TraceTruncatePath }, false},
+	{"ftruncate", func(o *bpfObjects) *ebpf.Program { return o.TraceFtruncate }, false},
+}
+
+// pathHooks 内核解析路径之后的 LSM 钩子，只在内核开启 CONFIG_SECURITY_PATH 时存在
+// （AppArmor、TOMOYO 等依赖该选项）。缺少时以路径参数指定文件的 unlink、rename、chmod、chown
+// 和 truncate 不会被记录，因此挂载失败时给出警告
+var pathHooks = []attachment{
+	kprobeAttachment("security_path_unlink", false, true, func(o *bpfObjects) *ebpf.Program { return o.TracePathUnlink }),
+	kprobeAttachment("security_path_rmdir", false, true, func(o *bpfObjects) *ebpf.Program { return o.TracePathUnlink }),
+	kprobeAttachment("security_path_rename", false, true, func(o *bpfObjects) *ebpf.Program { return o.TracePathRename }),
+	kprobeAttachment("security_path_chmod", false, true, func(o *bpfObjects) *ebpf.Program { return o.TracePathChmod }),
+	kprobeAttachment("security_path_chown", false, true, func(o *bpfObjects) *ebpf.Program { return o.TracePathChown }),
+	kprobeAttachment("security_path_truncate", false, true, func(o *bpfObjects) *ebpf.Program { return o.TracePathTruncate }),
+}
+
+// fileAttachments 文件访问追踪程序，每个系统调用挂载入口和返回两个 tracepoint，另加 pathHooks
+func fileAttachments() []attachment {
+	list := append([]attachment(nil), pathHooks...)
+	for _, sc := range fileSyscalls {
+		enter := tracepointAttachment("syscalls", "sys_enter_"+sc.name, sc.prog)
+		exit := tracepointAttachment("syscalls", "sys_exit_"+sc.name, func(o *bpfObjects) *ebpf.Program { return o.TraceFileRet })
+		enter.optional = sc.optional
+		exit.optional = sc.optional
+		list = append(list, enter, exit)
+	}
+	return list
 }
 
 // BPFTracer BPF追踪器
 type BPFTracer struct {
-	objs       *bpfObjects
-	eventsChan chan interface{}
-	done       chan struct{}
+	objs      *bpfObjects
+	links     []link.Link
+	reader    recordReader
+	bootTime  time.Time
+	stopped   chan struct{}
+	started   bool
+	closeOnce sync.Once
+	lost      atomic.Uint64
+	watching  bool
+
+	subsMu     sync.Mutex
+	subs       []*subscription
+	subsClosed bool
+}
+
+// Config 追踪器配置
+type Config struct {
+	// MaxArgs 每次 execve 最多采集的参数个数，0 表示 DefaultMaxArgs
+	MaxArgs int
+
+	// WatchPaths 审计文件访问的路径，以 "/" 结尾的目录匹配目录本身和其下的所有路径，
+	// 其他路径只精确匹配。为空时不追踪文件访问
+	WatchPaths []string
+	// WatchReads 是否记录只读打开，默认只记录写入、创建和截断
+	WatchReads bool
 }
 
 // NewBPFTracer 创建BPF追踪器
-func NewBPFTracer() (*BPFTracer, error) {
+func NewBPFTracer(cfg Config) (*BPFTracer, error) {
+	if cfg.MaxArgs == 0 {
+		cfg.MaxArgs = DefaultMaxArgs
+	}
+	if cfg.MaxArgs < 0 || cfg.MaxArgs > MaxArgsLimit {
+		return nil, fmt.Errorf("max args must be between 1 and %d", MaxArgsLimit)
+	}
+
+	if len(cfg.WatchPaths) > MaxWatchPaths {
+		return nil, fmt.Errorf("at most %d watch paths are supported", MaxWatchPaths)
+	}
+	for _, p := range cfg.WatchPaths {
+		if !strings.HasPrefix(p, "/") || len(p) >= WatchPathMax {
+			return nil, fmt.Errorf("watch path %q must be absolute and shorter than %d bytes", p, WatchPathMax)
+		}
+	}
+
 	bt := &BPFTracer{
-		eventsChan: make(chan interface{}, 1000),
-		done:       make(chan struct{}),
+		watching: len(cfg.WatchPaths) > 0,
+		bootTime: bootTime(),
+		stopped:  make(chan struct{}),
+	}
+
+	spec, err := loadBpf()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load BPF spec: %w", err)
+	}
+
+	consts := map[string]interface{}{
+		"max_args":    uint32(cfg.MaxArgs),
+		"watch_reads": cfg.WatchReads,
+	}
+
+	// 旧内核（< 5.8）不支持 ringbuf，改用 perf buffer 传递事件
+	if err := features.HaveMapType(ebpf.RingBuf); err != nil {
+		spec.Maps["events"] = &ebpf.MapSpec{
+			Name:      "events",
+			Type:      ebpf.PerfEventArray,
+			KeySize:   4,
+			ValueSize: 4,
+		}
+		consts["use_ringbuf"] = false
+	}
+
+	if err := spec.RewriteConstants(consts); err != nil {
+		return nil, fmt.Errorf("failed to configure BPF programs: %w", err)
 	}
 
 	// 加载BPF程序
-	if err := loadBpfObjects(&bt.objs, nil); err != nil {
+	bt.objs = &bpfObjects{}
+	if err := spec.LoadAndAssign(bt.objs, nil); err != nil {
 		return nil, fmt.Errorf("failed to load BPF objects: %w", err)
 	}
 
+	for _, p := range cfg.WatchPaths {
+		for _, key := range watchKeys(p) {
+			if err := bt.objs.WatchPaths.Put(&key, uint8(1)); err != nil {
+				bt.objs.Close()
+				return nil, fmt.Errorf("failed to add watch path %q: %w", p, err)
+			}
+		}
+	}
+
+	eventsMap := bt.objs.Events
+	if eventsMap.Type() != ebpf.RingBuf {
+		eventsMap = bt.objs.EventsPerf
+	}
+	bt.reader, err = newRecordReader(eventsMap)
+	if err != nil {
+		bt.objs.Close()
+		return nil, err
+	}
+
 	return bt, nil
 }
 
 // Start 启动追踪
 func (bt *BPFTracer) Start() error {
-	// 挂载BPF程序到各个tracepoint
-	if err := bt.objs.TraceExecve.Attach(); err != nil {
-		return fmt.Errorf("failed to attach execve tracepoint: %w", err)
-	}
-
-	if err := bt.objs.TraceConnect.Attach(); err != nil {
-		return fmt.Errorf("failed to attach connect tracepoint: %w", err)
-	}
-
-	if err := bt.objs.TraceAccept.Attach(); err != nil {
-		return fmt.Errorf("failed to attach accept tracepoint: %w", err)
+	// 挂载BPF程序，没有监视路径时不追踪文件访问
+	all := attachments
+	if bt.watching {
+		all = append(all[:len(all):len(all)], fileAttachments()...)
 	}
-
-	if err := bt.objs.TraceBind.Attach(); err != nil {
-		return fmt.Errorf("failed to attach bind tracepoint: %w", err)
+	for _, a := range all {
+		l, err := a.attach(bt.objs)
+		if err != nil && a.optional {
+			fmt.Fprintf(os.Stderr, "Warning: failed to attach %s: %v\n", a.name, err)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to attach %s: %w", a.name, err)
+		}
+		bt.links = append(bt.links, l)
 	}
 
 	// 启动事件读取goroutine
+	bt.started = true
 	go bt.readEvents()
 
 	return nil
 }
 
-// readEvents 读取BPF事件
+// readEvents 阻塞读取BPF事件并按事件头分发
 func (bt *BPFTracer) readEvents() {
+	defer close(bt.stopped)
+	defer bt.closeSubscriptions()
+
 	for {
+		sample, lost, err := bt.reader.Read()
+		if err != nil {
+			if errors.Is(err, os.ErrClosed) {
+				return
+			}
+			fmt.Fprintf(os.Stderr, "Failed to read BPF event: %v\n", err)
+			continue
+		}
+		if lost > 0 {
+			bt.lost.Add(lost)
+		}
+		if len(sample) == 0 {
+			continue
+		}
+
+		event, err := bt.decodeEvent(sample)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to decode BPF event: %v\n", err)
+			continue
+		}
+
+		bt.dispatch(event)
+	}
+}
+
+// dispatch 将事件投递给所有感兴趣的订阅者。投递不阻塞，订阅者的缓冲区满时丢弃事件并计入
+// LostSamples，避免处理较慢的订阅者拖慢其他种类事件的投递
+func (bt *BPFTracer) dispatch(event Event) {
+	bt.subsMu.Lock()
+	subs := bt.subs
+	bt.subsMu.Unlock()
+
+	for _, sub := range subs {
+		if !sub.wants(event.Kind()) {
+			continue
+		}
 		select {
-		case <-bt.done:
-			return
+		case sub.ch <- event:
 		default:
-			bt.readExecveEvents()
-			bt.readConnectEvents()
-			bt.readBindEvents()
+			sub.dropped.Add(1)
 		}
 	}
 }
 
-// readExecveEvents 读取执行命令事件
-func (bt *BPFTracer) readExecveEvents() {
-	var event ExecveEvent
-	for {
-		err := bt.objs.ExecveEvents.Read(&event, nil)
-		if err != nil {
-			break
+// decodeEvent 根据事件头类型解码为对应的事件
+func (bt *BPFTracer) decodeEvent(sample []byte) (Event, error) {
+	if len(sample) < eventHeaderSize {
+		return nil, fmt.Errorf("short event: %d bytes", len(sample))
+	}
+
+	rd := bytes.NewReader(sample)
+	switch typ := binary.LittleEndian.Uint32(sample); typ {
+	case EventExecve:
+		var raw execveEvent
+		if err := binary.Read(rd, binary.LittleEndian, &raw); err != nil {
+			return nil, fmt.Errorf("failed to decode execve event: %w", err)
+		}
+		args := sample[binary.Size(raw):]
+		if int(raw.ArgsSize) < len(args) {
+			args = args[:raw.ArgsSize]
+		}
+		return bt.parseExecveEvent(&raw, args), nil
+	case EventConnect:
+		var raw connectEvent
+		if err := binary.Read(rd, binary.LittleEndian, &raw); err != nil {
+			return nil, fmt.Errorf("failed to decode connect event: %w", err)
+		}
+		return bt.parseConnectEvent(&raw), nil
+	case EventClose:
+		var raw closeEvent
+		if err := binary.Read(rd, binary.LittleEndian, &raw); err != nil {
+			return nil, fmt.Errorf("failed to decode close event: %w", err)
+		}
+		return bt.parseCloseEvent(&raw), nil
+	case EventAccept:
+		var raw acceptEvent
+		if err := binary.Read(rd, binary.LittleEndian, &raw); err != nil {
+			return nil, fmt.Errorf("failed to decode accept event: %w", err)
+		}
+		return bt.parseAcceptEvent(&raw), nil
+	case EventListen:
+		var raw listenEvent
+		if err := binary.Read(rd, binary.LittleEndian, &raw); err != nil {
+			return nil, fmt.Errorf("failed to decode listen event: %w", err)
+		}
+		return bt.parseListenEvent(&raw), nil
+	case EventDNSQuery:
+		var raw dnsEvent
+		if err := binary.Read(rd, binary.LittleEndian, &raw); err != nil {
+			return nil, fmt.Errorf("failed to decode dns event: %w", err)
+		}
+		data := sample[binary.Size(raw):]
+		if n := int(min(raw.Len, dnsMaxLen)); n < len(data) {
+			data = data[:n]
 		}
-		bt.eventsChan <- &event
+		return bt.parseDNSEvent(&raw, data), nil
+	case EventFile:
+		var raw fileEvent
+		if err := binary.Read(rd, binary.LittleEndian, &raw); err != nil {
+			return nil, fmt.Errorf("failed to decode file event: %w", err)
+		}
+		return bt.parseFileEvent(&raw, sample[binary.Size(raw):])
+	case EventExit:
+		var raw exitEvent
+		if err := binary.Read(rd, binary.LittleEndian, &raw); err != nil {
+			return nil, fmt.Errorf("failed to decode exit event: %w", err)
+		}
+		return bt.parseExitEvent(&raw), nil
+	case EventFork:
+		var raw forkEvent
+		if err := binary.Read(rd, binary.LittleEndian, &raw); err != nil {
+			return nil, fmt.Errorf("failed to decode fork event: %w", err)
+		}
+		return &ForkEvent{
+			baseEvent: bt.base(KindFork, raw.Header, process(raw.ParentPID, 0, raw.UID, raw.GID, raw.Comm[:])),
+			ChildPID:  raw.ChildPID,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown event type %d", typ)
 	}
 }
 
-// readConnectEvents 读取连接事件
-func (bt *BPFTracer) readConnectEvents() {
-	var event ConnectEvent
-	for {
-		err := bt.objs.ConnectEvents.Read(&event, nil)
-		if err != nil {
-			break
+// TrackUDPPorts 将追踪器启动前已绑定的 UDP 套接字（以 inode 表示）加入内核的端口表，
+// 使其释放端口时同样产生停止监听事件
+func (bt *BPFTracer) TrackUDPPorts(inodes ...uint64) error {
+	for _, inode := range inodes {
+		if err := bt.objs.UdpPorts.Put(inode, uint8(1)); err != nil {
+			return fmt.Errorf("failed to track UDP socket %d: %w", inode, err)
 		}
-		bt.eventsChan <- &event
 	}
+	return nil
 }
 
-// readBindEvents 读取绑定端口事件
-func (bt *BPFTracer) readBindEvents() {
-	var event BindEvent
-	for {
-		err := bt.objs.BindEvents.Read(&event, nil)
-		if err != nil {
-			break
+// LostSamples 返回因内核缓冲区或订阅者缓冲区满而丢失的事件总数
+func (bt *BPFTracer) LostSamples() uint64 {
+	lost := bt.lost.Load()
+
+	bt.subsMu.Lock()
+	for _, sub := range bt.subs {
+		lost += sub.dropped.Load()
+	}
+	bt.subsMu.Unlock()
+
+	var perCPU []uint64
+	if err := bt.objs.Dropped.Lookup(uint32(0), &perCPU); err == nil {
+		for _, n := range perCPU {
+			lost += n
 		}
-		bt.eventsChan <- &event
 	}
+	return lost
+}
+
+// subscriptionBuffer 每个订阅通道的缓冲区大小
+const subscriptionBuffer = 4096
+
+// Subscribe 订阅指定种类的事件，不指定种类时订阅全部事件。应在 Start 之前订阅，
+// 否则启动后、订阅前产生的事件不会投递给该订阅者。通道在追踪器关闭后关闭；
+// 订阅者读取不及时、缓冲区满时事件被丢弃并计入 LostSamples
+func (bt *BPFTracer) Subscribe(kinds ...EventKind) <-chan Event {
+	sub := &subscription{
+		kinds: make(map[EventKind]bool, len(kinds)),
+		ch:    make(chan Event, subscriptionBuffer),
+	}
+	for _, k := range kinds {
+		sub.kinds[k] = true
+	}
+
+	bt.subsMu.Lock()
+	defer bt.subsMu.Unlock()
+	if bt.subsClosed {
+		close(sub.ch)
+	} else {
+		bt.subs = append(bt.subs, sub)
+	}
+	return sub.ch
 }
 
-// Events 返回事件通道
-func (bt *BPFTracer) Events() <-chan interface{} {
-	return bt.eventsChan
+// Events 返回包含全部事件的通道
+func (bt *BPFTracer) Events() <-chan Event {
+	return bt.Subscribe()
+}
+
+// closeSubscriptions 关闭所有订阅通道
+func (bt *BPFTracer) closeSubscriptions() {
+	bt.subsMu.Lock()
+	defer bt.subsMu.Unlock()
+	for _, sub := range bt.subs {
+		close(sub.ch)
+	}
+	bt.subs = nil
+	bt.subsClosed = true
 }
 
 // Close 关闭追踪器
 func (bt *BPFTracer) Close() error {
-	close(bt.done)
-	if bt.objs != nil {
-		bt.objs.Close()
-	}
+	bt.closeOnce.Do(func() {
+		for _, l := range bt.links {
+			l.Close()
+		}
+		if bt.reader != nil {
+			bt.reader.Close()
+		}
+		if bt.started {
+			<-bt.stopped
+		} else {
+			bt.closeSubscriptions()
+			close(bt.stopped)
+		}
+		if bt.objs != nil {
+			bt.objs.Close()
+		}
+	})
 	return nil
 }
 
-// ParseExecveEvent 解析执行命令事件
-func ParseExecveEvent(e *ExecveEvent) (command string, args []string, workingDir string) {
-	command = bytesToString(e.Comm[:])
-	workingDir = bytesToString(e.WorkingDir[:])
+// process 根据内核字段构造进程信息
+func process(pid, ppid, uid, gid uint32, comm []byte) Process {
+	return Process{
+		PID:  pid,
+		PPID: ppid,
+		UID:  uid,
+		GID:  gid,
+		Comm: bytesToString(comm),
+	}
+}
+
+// base 构造事件公共部分，内核时间戳为开机以来的单调时钟
+func (bt *BPFTracer) base(kind EventKind, hdr eventHeader, proc Process) baseEvent {
+	return baseEvent{
+		kind:      kind,
+		timestamp: bt.bootTime.Add(time.Duration(hdr.Timestamp)),
+		process:   proc,
+	}
+}
+
+// parseExecveEvent 解析执行命令事件，argData 为以 NUL 分隔的参数
+func (bt *BPFTracer) parseExecveEvent(e *execveEvent, argData []byte) *ExecEvent {
+	ev := &ExecEvent{
+		baseEvent:           bt.base(KindExec, e.Header, process(e.PID, e.PPID, e.UID, e.GID, e.Comm[:])),
+		Filename:            bytesToString(e.Filename[:]),
+		LoginUID:            e.LoginUID,
+		AuditSessionID:      e.SessionID,
+		ArgsTruncated:       e.Flags&execveArgsTruncated != 0,
+		WorkingDirTruncated: e.Flags&execveCwdTruncated != 0,
+	}
+
+	// 工作目录从 CwdOffset 开始写到缓冲区末尾
+	if off := int(e.CwdOffset); off < cwdBufSize {
+		ev.WorkingDir = string(e.Cwd[off:cwdBufSize])
+	}
 
 	// 解析参数
-	argData := e.Args[:]
-	var offset uint32
-	for i := uint32(0); i < e.ArgCount; i++ {
-		if offset >= uint32(len(argData)) {
-			break
-		}
-		// 读取字符串长度
-		if offset+4 > uint32(len(argData)) {
+	for i := uint32(0); i < e.ArgCount && len(argData) > 0; i++ {
+		end := bytes.IndexByte(argData, 0)
+		if end == -1 {
+			// 最后一个参数被截断，没有结尾的 NUL
+			ev.Args = append(ev.Args, string(argData))
+			ev.ArgsTruncated = true
 			break
 		}
-		strLen := binary.LittleEndian.Uint32(argData[offset : offset+4])
-		offset += 4
+		ev.Args = append(ev.Args, string(argData[:end]))
+		argData = argData[end+1:]
+	}
 
-		// 读取字符串
-		if offset+strLen > uint32(len(argData)) {
-			break
-		}
-		arg := bytesToString(argData[offset : offset+strLen])
-		args = append(args, arg)
-		offset += strLen
+	// sys_enter_execve 时 comm 仍是调用者的进程名，命令名取自可执行文件
+	ev.Command = path.Base(ev.Filename)
+	if ev.Filename == "" {
+		ev.Command = ev.Process().Comm
 	}
 
-	return command, args, workingDir
+	return ev
 }
 
-// ParseConnectEvent 解析连接事件
-func ParseConnectEvent(e *ConnectEvent) (srcIP, dstIP string, srcPort, dstPort int, protocol string) {
-	srcIP = ipToString(e.SrcAddr[:])
-	dstIP = ipToString(e.DstAddr[:])
-	srcPort = int(e.SrcPort)
-	dstPort = int(e.DstPort)
-	protocol = "tcp"
-	if e.Protocol == 1 {
-		protocol = "udp"
+// parseConnectEvent 解析连接事件
+func (bt *BPFTracer) parseConnectEvent(e *connectEvent) *ConnectEvent {
+	return &ConnectEvent{
+		baseEvent: bt.base(KindConnect, e.Header, process(e.PID, 0, e.UID, e.GID, e.Comm[:])),
+		Protocol:  sockProtocol(e.Protocol),
+		SrcIP:     ipToString(e.SrcAddr[:]),
+		SrcPort:   int(e.SrcPort),
+		DstIP:     ipToString(e.DstAddr[:]),
+		DstPort:   int(e.DstPort),
+		Err:       unix.Errno(e.Error),
 	}
-	return
 }
 
-// ParseBindEvent 解析绑定端口事件
-func (bt *BPFTracer) ParseBindEvent(e *BindEvent) (address string, port int, protocol string) {
-	address = ipToString(e.Address[:])
-	port = int(e.Port)
-	protocol = "tcp"
-	if e.Protocol == 1 {
-		protocol = "udp"
+// parseCloseEvent 解析 TCP 连接关闭事件
+func (bt *BPFTracer) parseCloseEvent(e *closeEvent) *CloseEvent {
+	return &CloseEvent{
+		baseEvent:     bt.base(KindClose, e.Header, process(e.PID, 0, e.UID, e.GID, e.Comm[:])),
+		Protocol:      "tcp",
+		Inbound:       e.Direction == connInbound,
+		LocalIP:       ipToString(e.LocalAddr[:]),
+		LocalPort:     int(e.LocalPort),
+		RemoteIP:      ipToString(e.RemoteAddr[:]),
+		RemotePort:    int(e.RemotePort),
+		BytesSent:     e.BytesSent,
+		BytesReceived: e.BytesReceived,
+		Duration:      time.Duration(e.DurationNs),
+	}
+}
+
+// sockProtocol 由套接字类型得到传输层协议
+func sockProtocol(sockType uint8) string {
+	switch sockType {
+	case unix.SOCK_STREAM:
+		return "tcp"
+	case unix.SOCK_DGRAM:
+		return "udp"
+	case unix.SOCK_RAW:
+		return "raw"
+	default:
+		return fmt.Sprintf("sock%d", sockType)
+	}
+}
+
+// parseAcceptEvent 解析入站连接事件，inet_csk_accept 只用于面向连接的协议
+func (bt *BPFTracer) parseAcceptEvent(e *acceptEvent) *AcceptEvent {
+	return &AcceptEvent{
+		baseEvent:  bt.base(KindAccept, e.Header, process(e.PID, 0, e.UID, e.GID, e.Comm[:])),
+		Protocol:   "tcp",
+		LocalIP:    ipToString(e.LocalAddr[:]),
+		LocalPort:  int(e.LocalPort),
+		RemoteIP:   ipToString(e.RemoteAddr[:]),
+		RemotePort: int(e.RemotePort),
+	}
+}
+
+// parseListenEvent 解析监听事件
+func (bt *BPFTracer) parseListenEvent(e *listenEvent) *ListenEvent {
+	return &ListenEvent{
+		baseEvent: bt.base(KindListen, e.Header, process(e.PID, 0, e.UID, e.GID, e.Comm[:])),
+		Protocol:  sockProtocol(e.Protocol),
+		Address:   ipToString(e.Address[:]),
+		Port:      int(e.Port),
+		Inode:     e.Inode,
+		Closed:    e.Closed != 0,
+	}
+}
+
+// parseDNSEvent 解析 DNS 报文事件，报文无法解析时只包含服务器和报文长度
+func (bt *BPFTracer) parseDNSEvent(e *dnsEvent, data []byte) *DNSEvent {
+	ev := &DNSEvent{
+		baseEvent:  bt.base(KindDNS, e.Header, process(e.PID, 0, e.UID, e.GID, e.Comm[:])),
+		Response:   e.Direction == dnsResponse,
+		Server:     ipToString(e.Server[:]),
+		ServerPort: int(e.ServerPort),
+		Truncated:  int(e.Len) > len(data),
+	}
+	msg, err := parseDNSMessage(data)
+	if err != nil {
+		ev.Err = err
+		return ev
+	}
+	ev.ID = msg.id
+	ev.Domain = msg.name
+	ev.Type = msg.qtype
+	ev.RCode = msg.rcode
+	ev.Answers = msg.answers
+	return ev
+}
+
+// parseFileEvent 解析文件访问事件，paths 为路径缓冲区，rename 事件之后紧跟新路径缓冲区
+func (bt *BPFTracer) parseFileEvent(e *fileEvent, paths []byte) (*FileEvent, error) {
+	if int(e.Op) >= len(fileOps) {
+		return nil, fmt.Errorf("unknown file operation %d", e.Op)
+	}
+	ev := &FileEvent{
+		baseEvent: bt.base(KindFile, e.Header, process(e.PID, 0, e.UID, e.GID, e.Comm[:])),
+		Op:        fileOps[e.Op],
+		Flags:     e.Flags,
+		Mode:      e.Mode,
+		Owner:     int(int32(e.Owner)),
+		Group:     int(int32(e.Group)),
+		Length:    e.Length,
+	}
+	if e.Ret < 0 {
+		ev.Err = unix.Errno(-e.Ret)
+	}
+
+	var ok bool
+	if ev.Path, ok = filePath(paths, e.PathOff, e.PathLen); !ok {
+		return nil, fmt.Errorf("invalid file event path")
+	}
+	if e.Op == fileRename {
+		if len(paths) < filePathSize {
+			return nil, fmt.Errorf("short rename event")
+		}
+		if ev.NewPath, ok = filePath(paths[filePathSize:], e.NewPathOff, e.NewPathLen); !ok {
+			return nil, fmt.Errorf("invalid file event path")
+		}
+	}
+	return ev, nil
+}
+
+// filePath 取出路径缓冲区中 [off, off+n) 的路径
+func filePath(buf []byte, off, n uint32) (string, bool) {
+	end := uint64(off) + uint64(n)
+	if end > filePathSize || end > uint64(len(buf)) {
+		return "", false
+	}
+	return string(buf[off:end]), true
+}
+
+// parseExitEvent 解析进程退出事件
+func (bt *BPFTracer) parseExitEvent(e *exitEvent) *ExitEvent {
+	return &ExitEvent{
+		baseEvent:  bt.base(KindExit, e.Header, process(e.PID, e.PPID, e.UID, e.GID, e.Comm[:])),
+		ExitCode:   int(e.ExitCode),
+		Signal:     int(e.Signal),
+		CoreDumped: e.CoreDumped != 0,
+		UserTime:   time.Duration(e.UserNs),
+		SystemTime: time.Duration(e.SystemNs),
+		WallTime:   time.Duration(e.WallNs),
 	}
-	return
 }
 
 // bytesToString 字节数组转字符串
@@ -252,26 +971,21 @@ func ipToString(b []byte) string {
 	return net.IP(b).String()
 }
 
+// bootTime 计算单调时钟零点对应的墙上时间，用于转换 bpf_ktime_get_ns 时间戳
+func bootTime() time.Time {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		return time.Now()
+	}
+	return time.Now().Add(-time.Duration(ts.Nano()))
+}
+
 // GetUsername 获取用户名
 func GetUsername(uid uint32) string {
-	uidStr := fmt.Sprintf("%d", uid)
-	// 使用 getpwuid 系统调用获取用户名
-	buf := make([]byte, 1024)
-	_, _, errno := syscall.Syscall(
-		syscall.SYS_GETPWUID,
-		uintptr(uid),
-		uintptr(unsafe.Pointer(&buf[0])),
-		uintptr(len(buf)),
-	)
-	if errno != 0 {
+	uidStr := strconv.FormatUint(uint64(uid), 10)
+	u, err := user.LookupId(uidStr)
+	if err != nil {
 		return uidStr
 	}
-
-	// 解析passwd结构
-	// 格式: name:passwd:uid:gid:gecos:dir:shell
-	parts := bytes.Split(buf, []byte(":"))
-	if len(parts) > 0 {
-		return string(parts[0])
-	}
-	return uidStr
-}
\ No newline at end of file
+	return u.Username
+}
@@ -1,42 +1,124 @@
 //go:build ignore
 // +build ignore
 
-#include <linux/bpf.h>
-#include <linux/ptrace.h>
-#include <linux/sched.h>
+#include "vmlinux.h"
 #include <bpf/bpf_helpers.h>
 #include <bpf/bpf_tracing.h>
-#include <linux/socket.h>
-#include <linux/in.h>
-#include <linux/in6.h>
-#include <linux/ipv6.h>
-
-#define MAX_ARGS 8
-#define MAX_ARG_LEN 64
-#define MAX_PATH_LEN 256
+#include <bpf/bpf_core_read.h>
+
+#define DEFAULT_MAX_ARGS 64
+#define MAX_ARGS_LIMIT 256
+#define MAX_ARG_LEN 1024
+#define ARGS_BUF_SIZE 8192
+#define MAX_FILENAME_LEN 512
+#define CWD_BUF_SIZE 4096
+#define MAX_NAME_LEN 256
+#define MAX_PATH_DEPTH 48
 #define MAX_COMM_LEN 16
 
+// execve 事件标志
+#define EXECVE_ARGS_TRUNCATED (1 << 0)
+#define EXECVE_CWD_TRUNCATED (1 << 1)
+
+#define AF_INET 2
+#define AF_INET6 10
+
+#define EALREADY 114
+#define EINPROGRESS 115
+#define ECONNABORTED 103
+
+#define S_IFMT 00170000
+#define S_IFSOCK 0140000
+
+// signal_struct.flags，整个线程组正在退出（exit_group 或致命信号）
+#define SIGNAL_GROUP_EXIT 0x00000004
+
+// 连接方向
+#define CONN_OUTBOUND 0
+#define CONN_INBOUND 1
+
+#define AT_FDCWD -100
+#define AT_REMOVEDIR 0x200
+#define O_ACCMODE 00000003
+#define O_CREAT 00000100
+#define O_TRUNC 00001000
+
+// 文件操作
+#define FILE_OPEN 0
+#define FILE_UNLINK 1
+#define FILE_RENAME 2
+#define FILE_CHMOD 3
+#define FILE_CHOWN 4
+#define FILE_TRUNCATE 5
+
+// 文件路径缓冲区：相对路径的目录部分由 read_path 从 CWD_BUF_SIZE 处向前写入，
+// 随后是 '/' 和系统调用传入的文件名，绝对路径直接从 FILE_NAME_OFF 开始
+#define FILE_NAME_OFF (CWD_BUF_SIZE + 1)
+#define FILE_PATH_SIZE (CWD_BUF_SIZE + MAX_NAME_LEN + MAX_FILENAME_LEN)
+
+// 监视列表按路径前缀匹配，只比较前 WATCH_PATH_MAX 字节，每个监视路径最多占用两个条目
+#define WATCH_PATH_MAX 256
+#define MAX_WATCH_ENTRIES 512
+
+// DNS 报文只采集 UDP 53 端口，超过 DNS_MAX_LEN 的部分丢弃
+#define DNS_PORT 53
+#define DNS_MAX_LEN 512
+#define DNS_QUERY 0
+#define DNS_RESPONSE 1
+
 // 事件类型
 #define EVENT_EXECVE 1
 #define EVENT_CONNECT 2
 #define EVENT_ACCEPT 3
-#define EVENT_BIND 4
+#define EVENT_LISTEN 4
 #define EVENT_DNS 5
+#define EVENT_EXIT 6
+#define EVENT_FORK 7
+#define EVENT_CLOSE 8
+#define EVENT_FILE 9
+
+// 运行时由用户态改写：内核不支持 ringbuf 时回退到 perf buffer
+const volatile bool use_ringbuf = true;
+
+// 每次 execve 最多采集的参数个数，由用户态配置，不超过 MAX_ARGS_LIMIT
+const volatile __u32 max_args = DEFAULT_MAX_ARGS;
+
+// 是否记录只读打开，默认只记录写入、创建和截断
+const volatile bool watch_reads = false;
+
+// 所有事件共用的头部，用户态据此分发
+struct event_header {
+    __u32 type;
+    __u32 _pad;
+    __u64 timestamp;
+};
 
 // 执行命令事件
+// cwd 从缓冲区末尾向前写入，有效内容为 cwd[cwd_offset, CWD_BUF_SIZE)；
+// args 为以 NUL 结尾的参数依次拼接，只提交前 args_size 字节。
+// 两个缓冲区尾部的余量仅用于满足校验器的边界检查。
 struct execve_event_t {
+    struct event_header hdr;
     __u32 pid;
     __u32 ppid;
     __u32 uid;
     __u32 gid;
+    __u32 loginuid;
+    __u32 sessionid;
     char comm[MAX_COMM_LEN];
     __u32 arg_count;
-    char args[512];
-    char working_dir[MAX_PATH_LEN];
+    __u32 args_size;
+    __u32 cwd_offset;
+    __u8 flags;
+    __u8 _pad[3];
+    char filename[MAX_FILENAME_LEN];
+    char cwd[CWD_BUF_SIZE + MAX_NAME_LEN];
+    char args[ARGS_BUF_SIZE + MAX_ARG_LEN];
 };
 
-// 连接事件
+// 连接事件，protocol 为套接字类型（SOCK_STREAM、SOCK_DGRAM 等），error 为失败时的 errno
 struct connect_event_t {
+    struct event_header hdr;
     __u32 pid;
     __u32 uid;
     __u32 gid;
@@ -46,10 +128,23 @@ struct connect_event_t {
     __u8 dst_addr[16];
     __u16 dst_port;
     __u8 protocol;
+    __u8 _pad[3];
+    __s32 error;
 };
 
-// 绑定端口事件
-struct bind_event_t {
+// 尚未得到结果的 connect：sys_enter_connect 时记录目标地址，
+// 系统调用返回或 TCP 握手结束时补全结果后提交
+struct pending_connect_t {
+    struct sock *sk;
+    struct connect_event_t event;
+    __u8 in_syscall;
+};
+
+// 监听事件，closed 为 0 时套接字开始监听，为 1 时停止监听。inode 与 /proc/net/tcp 中的 inode 对应，
+// 用户态据此关联开始和停止监听的同一个套接字
+struct listen_event_t {
+    struct event_header hdr;
+    __u64 inode;
     __u32 pid;
     __u32 uid;
     __u32 gid;
@@ -57,159 +152,1442 @@ struct bind_event_t {
     __u8 address[16];
     __u16 port;
     __u8 protocol;
+    __u8 closed;
+};
+
+// 入站连接事件，local 为接受连接的本机地址，remote 为发起连接的对端地址
+struct accept_event_t {
+    struct event_header hdr;
+    __u32 pid;
+    __u32 uid;
+    __u32 gid;
+    char comm[MAX_COMM_LEN];
+    __u8 local_addr[16];
+    __u16 local_port;
+    __u8 remote_addr[16];
+    __u16 remote_port;
+};
+
+// TCP 连接关闭事件，pid 为发起或接受连接的进程
+struct close_event_t {
+    struct event_header hdr;
+    __u64 bytes_sent;
+    __u64 bytes_received;
+    __u64 duration_ns;
+    __u32 pid;
+    __u32 uid;
+    __u32 gid;
+    char comm[MAX_COMM_LEN];
+    __u8 local_addr[16];
+    __u16 local_port;
+    __u8 remote_addr[16];
+    __u16 remote_port;
+    __u8 direction;
+};
+
+// 已建立的 TCP 连接，start_ns 为连接建立的时间
+struct conn_t {
+    __u64 start_ns;
+    struct close_event_t event;
+};
+
+// DNS 报文事件，data 为 UDP 负载的前 len 字节（最多 DNS_MAX_LEN），由用户态解析
+struct dns_event_t {
+    struct event_header hdr;
+    __u32 pid;
+    __u32 uid;
+    __u32 gid;
+    char comm[MAX_COMM_LEN];
+    __u8 server[16];
+    __u16 server_port;
+    __u8 direction;
+    __u8 _pad;
+    __u32 len;
+    __u8 data[DNS_MAX_LEN];
+};
+
+// udp_recvmsg 入口保存的参数，返回时才知道收到的长度
+struct recv_args_t {
+    struct sock *sk;
+    struct msghdr *msg;
+    const void *buf;
+};
+
+// 文件访问事件，路径为 path[path_off, path_off + path_len)，rename 的新路径在 new_path 中。
+// flags 为 open 的 flags、unlinkat 的 flag 或 renameat2 的 flags，ret 为系统调用的返回值
+struct file_event_t {
+    struct event_header hdr;
+    __s64 length;
+    __u32 pid;
+    __u32 uid;
+    __u32 gid;
+    char comm[MAX_COMM_LEN];
+    __u32 op;
+    __u32 flags;
+    __u32 mode;
+    __u32 owner;
+    __u32 group;
+    __s32 ret;
+    __u32 path_off;
+    __u32 path_len;
+    __u32 new_path_off;
+    __u32 new_path_len;
+    char path[FILE_PATH_SIZE];
+    char new_path[FILE_PATH_SIZE];
+};
+
+// 以路径参数指定文件的系统调用在 sys_enter 保存的参数。open 在返回时按结果取得路径，
+// 其他系统调用在内核解析路径之后的 security_path_* 钩子中取得路径
+struct file_op_t {
+    const char *filename;
+    __s64 length;
+    __s32 dfd;
+    __u32 op;
+    __u32 flags;
+    __u32 mode;
+    __u32 owner;
+    __u32 group;
+};
+
+// 监视列表的键，prefixlen 为前缀的位数
+struct watch_key {
+    __u32 prefixlen;
+    char path[WATCH_PATH_MAX];
+};
+
+// 进程退出事件
+struct exit_event_t {
+    struct event_header hdr;
+    __u32 pid;
+    __u32 ppid;
+    __u32 uid;
+    __u32 gid;
+    char comm[MAX_COMM_LEN];
+    __s32 exit_code;
+    __s32 signal;
+    __u64 user_ns;
+    __u64 system_ns;
+    __u64 wall_ns;
+    __u8 core_dumped;
+};
+
+// 进程创建事件
+struct fork_event_t {
+    struct event_header hdr;
+    __u32 parent_pid;
+    __u32 child_pid;
+    __u32 uid;
+    __u32 gid;
+    char comm[MAX_COMM_LEN];
 };
 
 // BPF maps
 struct {
-    __uint(type, BPF_MAP_TYPE_PERF_EVENT_ARRAY);
-    __uint(key_size, sizeof(__u32));
-    __uint(value_size, sizeof(__u32));
-} execve_events SEC(".maps");
+    __uint(type, BPF_MAP_TYPE_RINGBUF);
+    __uint(max_entries, 1 << 24);
+} events SEC(".maps");
 
 struct {
     __uint(type, BPF_MAP_TYPE_PERF_EVENT_ARRAY);
     __uint(key_size, sizeof(__u32));
     __uint(value_size, sizeof(__u32));
-} connect_events SEC(".maps");
+} events_perf SEC(".maps");
 
+// 提交失败（缓冲区已满）的事件计数
 struct {
-    __uint(type, BPF_MAP_TYPE_PERF_EVENT_ARRAY);
-    __uint(key_size, sizeof(__u32));
-    __uint(value_size, sizeof(__u32));
-} bind_events SEC(".maps");
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, __u64);
+} dropped SEC(".maps");
 
-// 辅助函数：复制字符串
-static __always_inline int copy_str(char *dst, const char *src, int max_len) {
-    int i;
-    for (i = 0; i < max_len - 1; i++) {
-        char c = src[i];
-        if (c == 0) break;
-        dst[i] = c;
+// 大事件超过 512 字节栈限制，在 per-CPU 暂存区中构造
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, struct execve_event_t);
+} execve_heap SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, struct dns_event_t);
+} dns_heap SEC(".maps");
+
+// 正在执行的 udp_recvmsg，以 pid_tgid 为键
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 10240);
+    __type(key, __u64);
+    __type(value, struct recv_args_t);
+} dns_recv SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, struct file_event_t);
+} file_heap SEC(".maps");
+
+// 正在执行的、以路径参数指定文件的系统调用，以 pid_tgid 为键，残留的记录由 LRU 淘汰
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __uint(max_entries, 10240);
+    __type(key, __u64);
+    __type(value, struct file_op_t);
+} file_ops SEC(".maps");
+
+// 正在执行的、路径在监视列表中的文件系统调用，以 pid_tgid 为键。
+// 线程在系统调用中被杀死时不会触发 sys_exit，残留的记录由 LRU 淘汰
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __uint(max_entries, 256);
+    __type(key, __u64);
+    __type(value, struct file_event_t);
+} file_args SEC(".maps");
+
+// 监视列表查找键的暂存区，键太大不适合放在栈上
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, struct watch_key);
+} watch_heap SEC(".maps");
+
+// 文件访问监视列表，由用户态写入。以 '/' 结尾的条目匹配目录下的所有路径，
+// 其他条目以 NUL 结尾，只精确匹配
+struct {
+    __uint(type, BPF_MAP_TYPE_LPM_TRIE);
+    __uint(max_entries, MAX_WATCH_ENTRIES);
+    __type(key, struct watch_key);
+    __type(value, __u8);
+    __uint(map_flags, BPF_F_NO_PREALLOC);
+} watch_paths SEC(".maps");
+
+// 正在执行的 listen 系统调用，以 pid_tgid 为键，值为 struct socket 地址
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 10240);
+    __type(key, __u64);
+    __type(value, __u64);
+} listen_args SEC(".maps");
+
+// 正在执行的 udp_lib_get_port，以 pid_tgid 为键，值为 struct sock 地址
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 10240);
+    __type(key, __u64);
+    __type(value, __u64);
+} udp_bind_args SEC(".maps");
+
+// 已报告的 UDP 端口，以套接字 inode 为键，释放端口时据此提交停止监听事件。
+// 启动时快照中的 UDP 端口由用户态加入
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 10240);
+    __type(key, __u64);
+    __type(value, __u8);
+} udp_ports SEC(".maps");
+
+// 正在执行的 connect 系统调用，以 pid_tgid 为键
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 10240);
+    __type(key, __u64);
+    __type(value, struct pending_connect_t);
+} connect_args SEC(".maps");
+
+// 握手尚未结束的 TCP 连接，以 sock 地址为键，被放弃的连接由 LRU 淘汰
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __uint(max_entries, 10240);
+    __type(key, __u64);
+    __type(value, struct pending_connect_t);
+} tcp_pending SEC(".maps");
+
+// 已建立的 TCP 连接，以 sock 地址为键
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __uint(max_entries, 65536);
+    __type(key, __u64);
+    __type(value, struct conn_t);
+} conns SEC(".maps");
+
+// 辅助函数：填充事件头
+static __always_inline void fill_header(struct event_header *hdr, __u32 type) {
+    hdr->type = type;
+    hdr->timestamp = bpf_ktime_get_ns();
+}
+
+// 辅助函数：提交事件到 ringbuf 或 perf buffer
+static __always_inline void submit_event(void *ctx, void *data, __u64 size) {
+    long err;
+    if (use_ringbuf)
+        err = bpf_ringbuf_output(&events, data, size, 0);
+    else
+        err = bpf_perf_event_output(ctx, &events_perf, BPF_F_CURRENT_CPU, data, size);
+
+    if (err) {
+        __u32 key = 0;
+        __u64 *count = bpf_map_lookup_elem(&dropped, &key);
+        if (count)
+            __sync_fetch_and_add(count, 1);
     }
-    dst[i] = 0;
-    return i;
 }
 
-// 辅助函数：获取IP地址
-static __always_inline void get_ip_addr(struct sockaddr *addr, __u8 *out, __u16 *port) {
-    if (addr->sa_family == AF_INET) {
-        struct sockaddr_in *sin = (struct sockaddr_in *)addr;
-        __builtin_memcpy(out, &sin->sin_addr, 4);
+// AUDIT_SID_UNSET / INVALID_UID：未启用审计或未经 PAM 登录的进程
+#define AUDIT_UNSET ((__u32)-1)
+
+// 辅助函数：读取内核审计的登录用户和会话ID（需要 CONFIG_AUDITSYSCALL）
+static __always_inline void read_login(struct task_struct *task, __u32 *loginuid, __u32 *sessionid) {
+    *loginuid = AUDIT_UNSET;
+    *sessionid = AUDIT_UNSET;
+    if (bpf_core_field_exists(task->sessionid)) {
+        *loginuid = BPF_CORE_READ(task, loginuid.val);
+        *sessionid = BPF_CORE_READ(task, sessionid);
+    }
+}
+
+// 辅助函数：解析 sockaddr_in/sockaddr_in6，IPv4 地址映射为 IPv6 格式
+static __always_inline void parse_sockaddr(struct sockaddr_in6 *sin6, __u8 *out, __u16 *port, __u16 *family) {
+    *family = sin6->sin6_family;
+    if (sin6->sin6_family == AF_INET) {
+        struct sockaddr_in *sin = (struct sockaddr_in *)sin6;
         // IPv4映射到IPv6格式
-        __builtin_memset(out + 4, 0, 10);
+        __builtin_memset(out, 0, 10);
         out[10] = 0xff;
         out[11] = 0xff;
+        __builtin_memcpy(out + 12, &sin->sin_addr, 4);
         *port = __builtin_bswap16(sin->sin_port);
-    } else if (addr->sa_family == AF_INET6) {
-        struct sockaddr_in6 *sin6 = (struct sockaddr_in6 *)addr;
+    } else if (sin6->sin6_family == AF_INET6) {
         __builtin_memcpy(out, &sin6->sin6_addr, 16);
         *port = __builtin_bswap16(sin6->sin6_port);
     }
 }
 
-// 追踪 execve 系统调用
-SEC("tracepoint/syscalls/sys_enter_execve")
-int trace_execve(struct trace_event_raw_sys_enter *ctx) {
-    struct execve_event_t event = {};
-    struct task_struct *task = (struct task_struct *)bpf_get_current_task();
-    __u64 pid_tgid = bpf_get_current_pid_tgid();
-    __u32 pid = pid_tgid >> 32;
-    __u32 tid = (__u32)pid_tgid;
+// 辅助函数：获取IP地址
+static __always_inline void get_ip_addr(struct sockaddr *uaddr, __u8 *out, __u16 *port, __u16 *family) {
+    struct sockaddr_in6 sin6 = {};
+    if (bpf_probe_read_user(&sin6, sizeof(sin6), uaddr) < 0)
+        return;
 
-    event.pid = pid;
-    event.ppid = BPF_CORE_READ(task, real_parent, tgid);
-    event.uid = bpf_get_current_uid_gid() >> 32;
-    event.gid = bpf_get_current_uid_gid();
+    parse_sockaddr(&sin6, out, port, family);
+}
 
-    // 获取命令名
-    bpf_get_current_comm(&event.comm, sizeof(event.comm));
+// 辅助函数：沿 dentry 和挂载点向上遍历重建绝对路径，从 buf[CWD_BUF_SIZE] 处向前写入，返回路径起始偏移。
+// buf 至少为 CWD_BUF_SIZE + MAX_NAME_LEN 字节；层级过深或路径过长时 *truncated 为 true，只保留末尾部分
+static __always_inline __u32 read_path(struct dentry *dentry, struct vfsmount *vfsmnt, char *buf, bool *truncated) {
+    struct mount *mnt = (struct mount *)((void *)vfsmnt - bpf_core_field_offset(struct mount, mnt));
+    struct mount *mnt_parent = BPF_CORE_READ(mnt, mnt_parent);
+    __u32 off = CWD_BUF_SIZE;
+    int i;
 
-    // 获取工作目录
-    char cwd[MAX_PATH_LEN] = {};
-    bpf_probe_read_kernel_str(&cwd, sizeof(cwd), &task->fs->pwd.dentry->d_iname);
-    copy_str(event.working_dir, cwd, sizeof(event.working_dir));
+    for (i = 0; i < MAX_PATH_DEPTH; i++) {
+        struct dentry *mnt_root = BPF_CORE_READ(vfsmnt, mnt_root);
+        struct dentry *parent = BPF_CORE_READ(dentry, d_parent);
 
-    // 获取参数
-    const char *const *argv = (const char *const *)ctx->args[1];
-    __u32 offset = 0;
+        if (dentry == mnt_root || dentry == parent) {
+            if (mnt == mnt_parent)
+                break; // 到达根目录
+            // 跨越挂载点，继续从挂载点所在的 dentry 向上
+            dentry = BPF_CORE_READ(mnt, mnt_mountpoint);
+            mnt = mnt_parent;
+            mnt_parent = BPF_CORE_READ(mnt, mnt_parent);
+            vfsmnt = (struct vfsmount *)((void *)mnt + bpf_core_field_offset(struct mount, mnt));
+            continue;
+        }
+
+        struct qstr d_name = BPF_CORE_READ(dentry, d_name);
+        __u32 len = d_name.len;
+        if (len >= MAX_NAME_LEN || len + 1 > off) {
+            *truncated = true;
+            break;
+        }
+
+        off -= len;
+        bpf_probe_read_kernel(&buf[off & (CWD_BUF_SIZE - 1)], len & (MAX_NAME_LEN - 1), d_name.name);
+        off -= 1;
+        buf[off & (CWD_BUF_SIZE - 1)] = '/';
+
+        dentry = parent;
+    }
+
+    if (i == MAX_PATH_DEPTH)
+        *truncated = true;
+
+    if (off == CWD_BUF_SIZE) {
+        off -= 1;
+        buf[off & (CWD_BUF_SIZE - 1)] = '/';
+    }
+    return off;
+}
+
+// 辅助函数：重建当前工作目录的绝对路径
+static __always_inline void read_cwd(struct task_struct *task, struct execve_event_t *event) {
+    bool truncated = false;
+    event->cwd_offset = read_path(BPF_CORE_READ(task, fs, pwd.dentry), BPF_CORE_READ(task, fs, pwd.mnt), event->cwd,
+                                  &truncated);
+    if (truncated)
+        event->flags |= EXECVE_CWD_TRUNCATED;
+}
+
+// 辅助函数：读取 argv，最多 max_args 个参数
+static __always_inline void read_argv(const char *const *argv, struct execve_event_t *event) {
+    __u32 off = 0;
     __u32 count = 0;
 
-    #pragma unroll
-    for (int i = 0; i < MAX_ARGS; i++) {
+    for (int i = 0; i < MAX_ARGS_LIMIT; i++) {
         const char *argp = NULL;
         bpf_probe_read_user(&argp, sizeof(argp), &argv[i]);
-        if (!argp) break;
-
-        char arg[MAX_ARG_LEN] = {};
-        bpf_probe_read_user_str(&arg, sizeof(arg), argp);
-
-        __u32 len = 0;
-        #pragma unroll
-        for (int j = 0; j < MAX_ARG_LEN; j++) {
-            if (arg[j] == 0) break;
-            if (offset + 4 + len < sizeof(event.args)) {
-                event.args[4 + offset + len] = arg[j];
-                len++;
-            }
-        }
+        if (!argp)
+            break;
 
-        if (offset + 4 + len <= sizeof(event.args)) {
-            __builtin_memcpy(&event.args[offset], &len, 4);
-            offset += 4 + len;
-            count++;
+        if (i >= max_args || off >= ARGS_BUF_SIZE) {
+            event->flags |= EXECVE_ARGS_TRUNCATED;
+            break;
         }
+
+        long len = bpf_probe_read_user_str(&event->args[off & (ARGS_BUF_SIZE - 1)], MAX_ARG_LEN, argp);
+        if (len <= 0)
+            break;
+        if (len == MAX_ARG_LEN)
+            event->flags |= EXECVE_ARGS_TRUNCATED;
+
+        off += len;
+        count++;
     }
 
-    event.arg_count = count;
-    bpf_perf_event_output(ctx, &execve_events, BPF_F_CURRENT_CPU, &event, sizeof(event));
+    event->arg_count = count;
+    event->args_size = off;
+}
+
+// 追踪 execve 系统调用
+SEC("tracepoint/syscalls/sys_enter_execve")
+int trace_execve(struct trace_event_raw_sys_enter *ctx) {
+    __u32 zero = 0;
+    struct execve_event_t *event = bpf_map_lookup_elem(&execve_heap, &zero);
+    if (!event)
+        return 0;
+
+    struct task_struct *task = (struct task_struct *)bpf_get_current_task();
+    __u64 uid_gid = bpf_get_current_uid_gid();
+
+    fill_header(&event->hdr, EVENT_EXECVE);
+    event->pid = bpf_get_current_pid_tgid() >> 32;
+    event->ppid = BPF_CORE_READ(task, real_parent, tgid);
+    event->uid = uid_gid;
+    event->gid = uid_gid >> 32;
+    event->flags = 0;
+    read_login(task, &event->loginuid, &event->sessionid);
+
+    // 获取命令名（此时仍为调用 execve 的旧进程名）
+    bpf_get_current_comm(&event->comm, sizeof(event->comm));
+
+    // 获取可执行文件路径
+    bpf_probe_read_user_str(&event->filename, sizeof(event->filename), (const char *)ctx->args[0]);
+
+    // 获取工作目录
+    read_cwd(task, event);
+
+    // 获取参数
+    read_argv((const char *const *)ctx->args[1], event);
+
+    // 只提交实际使用的参数部分
+    __u64 size = __builtin_offsetof(struct execve_event_t, args) + event->args_size;
+    if (size > sizeof(*event))
+        size = sizeof(*event);
+    submit_event(ctx, event, size);
 
     return 0;
 }
 
-// 追踪 connect 系统调用
+// 辅助函数：查找当前进程 fd 对应的文件
+static __always_inline struct file *file_from_fd(int fd) {
+    struct task_struct *task = (struct task_struct *)bpf_get_current_task();
+    struct fdtable *fdt = BPF_CORE_READ(task, files, fdt);
+    if (fd < 0 || fd >= BPF_CORE_READ(fdt, max_fds))
+        return NULL;
+
+    struct file **fds = BPF_CORE_READ(fdt, fd);
+    struct file *file = NULL;
+    bpf_probe_read_kernel(&file, sizeof(file), &fds[fd]);
+    return file;
+}
+
+// 辅助函数：根据 fd 查找套接字，fd 不是套接字时返回 NULL
+static __always_inline struct socket *sock_from_fd(int fd) {
+    struct file *file = file_from_fd(fd);
+    if (!file)
+        return NULL;
+    if ((BPF_CORE_READ(file, f_inode, i_mode) & S_IFMT) != S_IFSOCK)
+        return NULL;
+    return BPF_CORE_READ(file, private_data);
+}
+
+// 辅助函数：IPv4 地址映射为 IPv6 格式
+static __always_inline void map_ipv4(__u8 *out, __u32 addr) {
+    __builtin_memset(out, 0, 10);
+    out[10] = 0xff;
+    out[11] = 0xff;
+    __builtin_memcpy(out + 12, &addr, 4);
+}
+
+// 辅助函数：读取 sock 的本机地址，返回端口
+static __always_inline __u16 sock_local(struct sock *sk, __u8 *addr) {
+    __u16 family = BPF_CORE_READ(sk, __sk_common.skc_family);
+    if (family == AF_INET)
+        map_ipv4(addr, BPF_CORE_READ(sk, __sk_common.skc_rcv_saddr));
+    else if (family == AF_INET6)
+        BPF_CORE_READ_INTO(addr, sk, __sk_common.skc_v6_rcv_saddr.in6_u.u6_addr8);
+    // skc_num 为主机字节序
+    return BPF_CORE_READ(sk, __sk_common.skc_num);
+}
+
+// 辅助函数：读取 sock 的对端地址，返回端口
+static __always_inline __u16 sock_remote(struct sock *sk, __u8 *addr) {
+    __u16 family = BPF_CORE_READ(sk, __sk_common.skc_family);
+    if (family == AF_INET)
+        map_ipv4(addr, BPF_CORE_READ(sk, __sk_common.skc_daddr));
+    else if (family == AF_INET6)
+        BPF_CORE_READ_INTO(addr, sk, __sk_common.skc_v6_daddr.in6_u.u6_addr8);
+    return __builtin_bswap16(BPF_CORE_READ(sk, __sk_common.skc_dport));
+}
+
+// 辅助函数：记录已建立的 TCP 连接，关闭时据此提交 close 事件
+static __always_inline void track_conn(struct sock *sk, __u8 direction, __u32 pid, __u32 uid, __u32 gid,
+                                       const char *comm) {
+    struct conn_t conn = {};
+    conn.start_ns = bpf_ktime_get_ns();
+    conn.event.pid = pid;
+    conn.event.uid = uid;
+    conn.event.gid = gid;
+    __builtin_memcpy(conn.event.comm, comm, MAX_COMM_LEN);
+    conn.event.local_port = sock_local(sk, conn.event.local_addr);
+    conn.event.remote_port = sock_remote(sk, conn.event.remote_addr);
+    conn.event.direction = direction;
+
+    __u64 key = (__u64)sk;
+    bpf_map_update_elem(&conns, &key, &conn, BPF_ANY);
+}
+
+// 辅助函数：补全连接结果和源地址后提交连接事件，未绑定时源地址为空
+static __always_inline void submit_connect(void *ctx, struct pending_connect_t *pending, __s32 error) {
+    fill_header(&pending->event.hdr, EVENT_CONNECT);
+    pending->event.error = error;
+    pending->event.src_port = sock_local(pending->sk, pending->event.src_addr);
+    submit_event(ctx, &pending->event, sizeof(pending->event));
+}
+
+// 追踪 connect 系统调用，记录目标地址和套接字类型，结果在返回或握手结束时提交
 SEC("tracepoint/syscalls/sys_enter_connect")
 int trace_connect(struct trace_event_raw_sys_enter *ctx) {
-    struct connect_event_t event = {};
-    __u64 pid_tgid = bpf_get_current_pid_tgid();
-    __u32 pid = pid_tgid >> 32;
+    struct pending_connect_t pending = {};
+    struct connect_event_t *event = &pending.event;
+    __u64 id = bpf_get_current_pid_tgid();
+    __u64 uid_gid = bpf_get_current_uid_gid();
+    __u16 family = 0;
+
+    struct sockaddr *addr = (struct sockaddr *)ctx->args[1];
+    if (addr)
+        get_ip_addr(addr, event->dst_addr, &event->dst_port, &family);
+    if (family != AF_INET && family != AF_INET6)
+        return 0;
+
+    struct socket *sock = sock_from_fd(ctx->args[0]);
+    if (!sock)
+        return 0;
+
+    pending.sk = BPF_CORE_READ(sock, sk);
+    pending.in_syscall = 1;
+    event->pid = id >> 32;
+    event->uid = uid_gid;
+    event->gid = uid_gid >> 32;
+    event->protocol = BPF_CORE_READ(sock, type);
+    bpf_get_current_comm(&event->comm, sizeof(event->comm));
+
+    bpf_map_update_elem(&connect_args, &id, &pending, BPF_ANY);
+    // 同一套接字上重复的非阻塞 connect 不覆盖正在进行的握手
+    if (event->protocol == SOCK_STREAM) {
+        __u64 key = (__u64)pending.sk;
+        bpf_map_update_elem(&tcp_pending, &key, &pending, BPF_NOEXIST);
+    }
+
+    return 0;
+}
+
+// 追踪 connect 返回。非 TCP 套接字直接提交结果；TCP 的结果由握手结束时的状态变化提交，
+// 这里只提交没有进入握手就失败的连接
+SEC("tracepoint/syscalls/sys_exit_connect")
+int trace_connect_ret(struct trace_event_raw_sys_exit *ctx) {
+    __u64 id = bpf_get_current_pid_tgid();
+    struct pending_connect_t *args = bpf_map_lookup_elem(&connect_args, &id);
+    if (!args)
+        return 0;
+
+    int ret = ctx->ret;
+    if (args->event.protocol != SOCK_STREAM) {
+        submit_connect(ctx, args, -ret);
+        bpf_map_delete_elem(&connect_args, &id);
+        return 0;
+    }
+
+    __u64 key = (__u64)args->sk;
+    bpf_map_delete_elem(&connect_args, &id);
+
+    struct pending_connect_t *pending = bpf_map_lookup_elem(&tcp_pending, &key);
+    if (!pending)
+        return 0;
+    if (ret == -EINPROGRESS || ret == -EALREADY) {
+        pending->in_syscall = 0;
+        return 0;
+    }
+    submit_connect(ctx, pending, -ret);
+    bpf_map_delete_elem(&tcp_pending, &key);
 
-    event.pid = pid;
-    event.uid = bpf_get_current_uid_gid() >> 32;
-    event.gid = bpf_get_current_uid_gid();
+    return 0;
+}
+
+// 辅助函数：已记录的连接关闭时提交 close 事件，发送字节数为已被对端确认的部分
+static __always_inline void submit_close(void *ctx, struct sock *sk) {
+    __u64 key = (__u64)sk;
+    struct conn_t *conn = bpf_map_lookup_elem(&conns, &key);
+    if (!conn)
+        return;
+
+    struct tcp_sock *tp = (struct tcp_sock *)sk;
+    fill_header(&conn->event.hdr, EVENT_CLOSE);
+    conn->event.bytes_sent = BPF_CORE_READ(tp, bytes_acked);
+    conn->event.bytes_received = BPF_CORE_READ(tp, bytes_received);
+    conn->event.duration_ns = conn->event.hdr.timestamp - conn->start_ns;
+    submit_event(ctx, &conn->event, sizeof(conn->event));
+    bpf_map_delete_elem(&conns, &key);
+}
+
+// 辅助函数：监听的套接字关闭时提交停止监听事件。此时端口已从 sock 中释放，地址取自 tracepoint 参数
+static __always_inline void submit_unlisten(struct trace_event_raw_inet_sock_set_state *ctx, struct sock *sk) {
+    struct listen_event_t event = {};
+    __u64 uid_gid = bpf_get_current_uid_gid();
+
+    fill_header(&event.hdr, EVENT_LISTEN);
+    event.inode = BPF_CORE_READ(sk, sk_socket, file, f_inode, i_ino);
+    event.pid = bpf_get_current_pid_tgid() >> 32;
+    event.uid = uid_gid;
+    event.gid = uid_gid >> 32;
     bpf_get_current_comm(&event.comm, sizeof(event.comm));
+    if (ctx->family == AF_INET) {
+        __u32 saddr;
+        __builtin_memcpy(&saddr, ctx->saddr, 4);
+        map_ipv4(event.address, saddr);
+    } else {
+        __builtin_memcpy(event.address, ctx->saddr_v6, 16);
+    }
+    event.port = ctx->sport;
+    event.protocol = SOCK_STREAM;
+    event.closed = 1;
 
-    struct sockaddr *addr = (struct sockaddr *)ctx->args[1];
-    if (addr) {
-        get_ip_addr(addr, event.dst_addr, &event.dst_port);
-        event.protocol = (addr->sa_family == AF_INET6) ? 1 : 0;
+    submit_event(ctx, &event, sizeof(event));
+}
+
+// 追踪 TCP 状态变化：SYN_SENT 之后的状态即 connect 的结果，进入 CLOSE 时连接关闭或停止监听
+SEC("tracepoint/sock/inet_sock_set_state")
+int trace_sock_state(struct trace_event_raw_inet_sock_set_state *ctx) {
+    if (ctx->protocol != IPPROTO_TCP)
+        return 0;
+
+    struct sock *sk = (struct sock *)ctx->skaddr;
+    __u64 key = (__u64)sk;
+
+    if (ctx->oldstate == TCP_SYN_SENT) {
+        struct pending_connect_t *pending = bpf_map_lookup_elem(&tcp_pending, &key);
+        if (!pending)
+            return 0;
+
+        if (ctx->newstate == TCP_ESTABLISHED) {
+            submit_connect(ctx, pending, 0);
+            track_conn(sk, CONN_OUTBOUND, pending->event.pid, pending->event.uid, pending->event.gid,
+                       pending->event.comm);
+        } else if (ctx->newstate == TCP_CLOSE) {
+            // 被拒绝或超时时内核先设置 sk_err；没有错误且仍在系统调用中时由 sys_exit_connect 提交返回值，
+            // 否则是应用在握手结束前关闭了套接字
+            __s32 err = BPF_CORE_READ(sk, sk_err);
+            if (!err && pending->in_syscall)
+                return 0;
+            submit_connect(ctx, pending, err ? err : ECONNABORTED);
+        } else {
+            return 0;
+        }
+        bpf_map_delete_elem(&tcp_pending, &key);
+        return 0;
     }
 
-    bpf_perf_event_output(ctx, &connect_events, BPF_F_CURRENT_CPU, &event, sizeof(event));
+    if (ctx->oldstate == TCP_LISTEN && ctx->newstate == TCP_CLOSE)
+        submit_unlisten(ctx, sk);
+    else if (ctx->newstate == TCP_CLOSE)
+        submit_close(ctx, sk);
+
+    return 0;
+}
+
+// 追踪 listen 系统调用，返回时才知道是否成功以及自动分配的端口
+SEC("tracepoint/syscalls/sys_enter_listen")
+int trace_listen(struct trace_event_raw_sys_enter *ctx) {
+    struct socket *sock = sock_from_fd(ctx->args[0]);
+    if (!sock)
+        return 0;
+
+    __u64 id = bpf_get_current_pid_tgid();
+    __u64 addr = (__u64)sock;
+    bpf_map_update_elem(&listen_args, &id, &addr, BPF_ANY);
 
     return 0;
 }
 
-// 追踪 bind 系统调用
-SEC("tracepoint/syscalls/sys_enter_bind")
-int trace_bind(struct trace_event_raw_sys_enter *ctx) {
-    struct bind_event_t event = {};
-    __u64 pid_tgid = bpf_get_current_pid_tgid();
-    __u32 pid = pid_tgid >> 32;
+SEC("tracepoint/syscalls/sys_exit_listen")
+int trace_listen_ret(struct trace_event_raw_sys_exit *ctx) {
+    __u64 id = bpf_get_current_pid_tgid();
+    __u64 *addr = bpf_map_lookup_elem(&listen_args, &id);
+    if (!addr)
+        return 0;
+
+    struct socket *sock = (struct socket *)*addr;
+    bpf_map_delete_elem(&listen_args, &id);
+    if (ctx->ret != 0)
+        return 0;
+
+    struct sock *sk = BPF_CORE_READ(sock, sk);
+    __u16 family = BPF_CORE_READ(sk, __sk_common.skc_family);
+    if (family != AF_INET && family != AF_INET6)
+        return 0;
 
-    event.pid = pid;
-    event.uid = bpf_get_current_uid_gid() >> 32;
-    event.gid = bpf_get_current_uid_gid();
+    struct listen_event_t event = {};
+    __u64 uid_gid = bpf_get_current_uid_gid();
+    fill_header(&event.hdr, EVENT_LISTEN);
+    event.inode = BPF_CORE_READ(sock, file, f_inode, i_ino);
+    event.pid = id >> 32;
+    event.uid = uid_gid;
+    event.gid = uid_gid >> 32;
     bpf_get_current_comm(&event.comm, sizeof(event.comm));
+    event.port = sock_local(sk, event.address);
+    event.protocol = BPF_CORE_READ(sock, type);
 
-    struct sockaddr *addr = (struct sockaddr *)ctx->args[1];
-    if (addr) {
-        get_ip_addr(addr, event.address, &event.port);
-        event.protocol = (addr->sa_family == AF_INET6) ? 1 : 0;
+    submit_event(ctx, &event, sizeof(event));
+
+    return 0;
+}
+
+// 追踪 UDP 端口绑定。snum 为 0 时由内核分配临时端口（bind 端口 0 或发送前自动绑定），不记录
+SEC("kprobe/udp_lib_get_port")
+int BPF_KPROBE(trace_udp_get_port, struct sock *sk, unsigned short snum) {
+    if (!snum)
+        return 0;
+
+    __u64 id = bpf_get_current_pid_tgid();
+    __u64 addr = (__u64)sk;
+    bpf_map_update_elem(&udp_bind_args, &id, &addr, BPF_ANY);
+
+    return 0;
+}
+
+// 绑定成功后提交开始监听事件。端口记录失败时不提交，保证每个 port_open 都有对应的 port_closed
+SEC("kretprobe/udp_lib_get_port")
+int BPF_KRETPROBE(trace_udp_get_port_ret, int ret) {
+    __u64 id = bpf_get_current_pid_tgid();
+    __u64 *addr = bpf_map_lookup_elem(&udp_bind_args, &id);
+    if (!addr)
+        return 0;
+
+    struct sock *sk = (struct sock *)*addr;
+    bpf_map_delete_elem(&udp_bind_args, &id);
+    if (ret != 0)
+        return 0;
+
+    struct listen_event_t event = {};
+    event.inode = BPF_CORE_READ(sk, sk_socket, file, f_inode, i_ino);
+    __u8 one = 1;
+    if (!event.inode || bpf_map_update_elem(&udp_ports, &event.inode, &one, BPF_NOEXIST) != 0)
+        return 0;
+
+    __u64 uid_gid = bpf_get_current_uid_gid();
+    fill_header(&event.hdr, EVENT_LISTEN);
+    event.pid = id >> 32;
+    event.uid = uid_gid;
+    event.gid = uid_gid >> 32;
+    bpf_get_current_comm(&event.comm, sizeof(event.comm));
+    event.port = sock_local(sk, event.address);
+    event.protocol = SOCK_DGRAM;
+
+    submit_event(ctx, &event, sizeof(event));
+
+    return 0;
+}
+
+// 追踪 UDP 端口释放（关闭套接字，或断开未显式绑定的套接字），已报告的端口提交停止监听事件。
+// 此时端口尚未从 sock 中清除
+SEC("kprobe/udp_lib_unhash")
+int BPF_KPROBE(trace_udp_unhash, struct sock *sk) {
+    __u64 inode = BPF_CORE_READ(sk, sk_socket, file, f_inode, i_ino);
+    if (!inode || !bpf_map_lookup_elem(&udp_ports, &inode))
+        return 0;
+    bpf_map_delete_elem(&udp_ports, &inode);
+
+    struct listen_event_t event = {};
+    __u64 uid_gid = bpf_get_current_uid_gid();
+    fill_header(&event.hdr, EVENT_LISTEN);
+    event.inode = inode;
+    event.pid = bpf_get_current_pid_tgid() >> 32;
+    event.uid = uid_gid;
+    event.gid = uid_gid >> 32;
+    bpf_get_current_comm(&event.comm, sizeof(event.comm));
+    event.port = sock_local(sk, event.address);
+    event.protocol = SOCK_DGRAM;
+    event.closed = 1;
+
+    submit_event(ctx, &event, sizeof(event));
+
+    return 0;
+}
+
+// 追踪 inet_csk_accept 返回，此时连接已建立，返回值为新连接的 sock
+SEC("kretprobe/inet_csk_accept")
+int BPF_KRETPROBE(trace_accept, struct sock *sk) {
+    if (!sk)
+        return 0;
+
+    __u16 family = BPF_CORE_READ(sk, __sk_common.skc_family);
+    if (family != AF_INET && family != AF_INET6)
+        return 0;
+
+    struct accept_event_t event = {};
+    event.local_port = sock_local(sk, event.local_addr);
+    event.remote_port = sock_remote(sk, event.remote_addr);
+
+    __u64 uid_gid = bpf_get_current_uid_gid();
+    fill_header(&event.hdr, EVENT_ACCEPT);
+    event.pid = bpf_get_current_pid_tgid() >> 32;
+    event.uid = uid_gid;
+    event.gid = uid_gid >> 32;
+    bpf_get_current_comm(&event.comm, sizeof(event.comm));
+
+    submit_event(ctx, &event, sizeof(event));
+    track_conn(sk, CONN_INBOUND, event.pid, event.uid, event.gid, event.comm);
+
+    return 0;
+}
+
+// 辅助函数：读取 UDP 报文的对端地址，返回端口。未连接的套接字使用 msg_name
+// （系统调用已复制到内核中），已连接的套接字使用 sock 中的目标地址
+static __always_inline __u16 udp_peer(struct sock *sk, struct msghdr *msg, __u8 *addr) {
+    __u16 port = 0, family = 0;
+
+    void *name = BPF_CORE_READ(msg, msg_name);
+    if (name) {
+        struct sockaddr_in6 sin6 = {};
+        if (bpf_probe_read_kernel(&sin6, sizeof(sin6), name) == 0)
+            parse_sockaddr(&sin6, addr, &port, &family);
+    }
+    if (port)
+        return port;
+
+    return sock_remote(sk, addr);
+}
+
+// 辅助函数：判断地址是否为 IPv4 映射的 IPv6 地址
+static __always_inline bool is_v4mapped(const __u8 *addr) {
+    for (int i = 0; i < 10; i++) {
+        if (addr[i])
+            return false;
+    }
+    return addr[10] == 0xff && addr[11] == 0xff;
+}
+
+// iov_iter 中指向 iovec 数组（或 ITER_UBUF 时直接指向用户缓冲区）的字段，6.4 起改名为 __iov
+struct iov_iter___old {
+    const struct iovec *iov;
+} __attribute__((preserve_access_index));
+
+struct iov_iter___new {
+    const struct iovec *__iov;
+} __attribute__((preserve_access_index));
+
+// 辅助函数：返回 msghdr 中第一段用户缓冲区。ITER_UBUF 时字段直接指向用户内存，
+// 按内核地址读取会失败，以此与 iovec 数组区分，不依赖各版本不同的 iter_type 取值
+static __always_inline const void *msg_buf(struct msghdr *msg) {
+    void *iter = &msg->msg_iter;
+    const void *ptr = NULL;
+
+    if (bpf_core_field_exists(((struct iov_iter___new *)iter)->__iov))
+        BPF_CORE_READ_INTO(&ptr, (struct iov_iter___new *)iter, __iov);
+    else
+        BPF_CORE_READ_INTO(&ptr, (struct iov_iter___old *)iter, iov);
+    if (!ptr)
+        return NULL;
+
+    struct iovec iov;
+    if (bpf_probe_read_kernel(&iov, sizeof(iov), ptr) == 0)
+        return iov.iov_base;
+    return ptr;
+}
+
+// 辅助函数：对端为 53 端口时提交 DNS 报文，报文由用户态解析
+static __always_inline void submit_dns(void *ctx, struct sock *sk, struct msghdr *msg, const void *buf,
+                                       __u32 len, __u8 direction) {
+    __u32 zero = 0;
+    struct dns_event_t *event = bpf_map_lookup_elem(&dns_heap, &zero);
+    if (!event || !buf)
+        return;
+
+    event->server_port = udp_peer(sk, msg, event->server);
+    if (event->server_port != DNS_PORT)
+        return;
+
+    __u32 n = len;
+    if (n > DNS_MAX_LEN)
+        n = DNS_MAX_LEN;
+    if (bpf_probe_read_user(event->data, n, buf) < 0)
+        return;
+
+    __u64 uid_gid = bpf_get_current_uid_gid();
+    fill_header(&event->hdr, EVENT_DNS);
+    event->pid = bpf_get_current_pid_tgid() >> 32;
+    event->uid = uid_gid;
+    event->gid = uid_gid >> 32;
+    event->direction = direction;
+    event->len = len;
+    bpf_get_current_comm(&event->comm, sizeof(event->comm));
+
+    submit_event(ctx, event, __builtin_offsetof(struct dns_event_t, data) + n);
+}
+
+// 追踪发出的 DNS 查询
+SEC("kprobe/udp_sendmsg")
+int BPF_KPROBE(trace_udp_sendmsg, struct sock *sk, struct msghdr *msg, size_t len) {
+    submit_dns(ctx, sk, msg, msg_buf(msg), len, DNS_QUERY);
+    return 0;
+}
+
+// IPv6 套接字发往 IPv4 映射地址时 udpv6_sendmsg 会转交 udp_sendmsg，由后者记录
+SEC("kprobe/udpv6_sendmsg")
+int BPF_KPROBE(trace_udpv6_sendmsg, struct sock *sk, struct msghdr *msg, size_t len) {
+    __u8 addr[16] = {};
+    if (udp_peer(sk, msg, addr) != DNS_PORT || is_v4mapped(addr))
+        return 0;
+    submit_dns(ctx, sk, msg, msg_buf(msg), len, DNS_QUERY);
+    return 0;
+}
+
+// 辅助函数：记录 recvmsg 的参数，缓冲区在接收过程中会被推进，因此在入口处取得
+static __always_inline void save_recv_args(struct sock *sk, struct msghdr *msg) {
+    __u64 id = bpf_get_current_pid_tgid();
+    struct recv_args_t args = {
+        .sk = sk,
+        .msg = msg,
+        .buf = msg_buf(msg),
+    };
+    bpf_map_update_elem(&dns_recv, &id, &args, BPF_ANY);
+}
+
+// 辅助函数：recvmsg 返回时提交收到的 DNS 响应
+static __always_inline void submit_recv(void *ctx, int ret) {
+    __u64 id = bpf_get_current_pid_tgid();
+    struct recv_args_t *args = bpf_map_lookup_elem(&dns_recv, &id);
+    if (!args)
+        return;
+    if (ret > 0)
+        submit_dns(ctx, args->sk, args->msg, args->buf, ret, DNS_RESPONSE);
+    bpf_map_delete_elem(&dns_recv, &id);
+}
+
+// 追踪收到的 DNS 响应
+SEC("kprobe/udp_recvmsg")
+int BPF_KPROBE(trace_udp_recvmsg, struct sock *sk, struct msghdr *msg) {
+    save_recv_args(sk, msg);
+    return 0;
+}
+
+SEC("kretprobe/udp_recvmsg")
+int BPF_KRETPROBE(trace_udp_recvmsg_ret, int ret) {
+    submit_recv(ctx, ret);
+    return 0;
+}
+
+SEC("kprobe/udpv6_recvmsg")
+int BPF_KPROBE(trace_udpv6_recvmsg, struct sock *sk, struct msghdr *msg) {
+    save_recv_args(sk, msg);
+    return 0;
+}
+
+SEC("kretprobe/udpv6_recvmsg")
+int BPF_KRETPROBE(trace_udpv6_recvmsg_ret, int ret) {
+    submit_recv(ctx, ret);
+    return 0;
+}
+
+// 辅助函数：将 dfd 和 filename 拼接为绝对路径写入 buf。相对路径拼接工作目录或 dfd 对应的目录，
+// 不解析 .. 和符号链接，只用于没有打开文件的失败的 open
+static __always_inline bool read_file_path(int dfd, const char *filename, char *buf, __u32 *off, __u32 *len) {
+    long n = bpf_probe_read_user_str(&buf[FILE_NAME_OFF], MAX_FILENAME_LEN, filename);
+    if (n <= 1)
+        return false;
+    if (buf[FILE_NAME_OFF] == '/') {
+        *off = FILE_NAME_OFF;
+        *len = n - 1;
+        return true;
+    }
+
+    struct dentry *dentry;
+    struct vfsmount *mnt;
+    if (dfd == AT_FDCWD) {
+        struct task_struct *task = (struct task_struct *)bpf_get_current_task();
+        dentry = BPF_CORE_READ(task, fs, pwd.dentry);
+        mnt = BPF_CORE_READ(task, fs, pwd.mnt);
+    } else {
+        struct file *file = file_from_fd(dfd);
+        if (!file)
+            return false;
+        dentry = BPF_CORE_READ(file, f_path.dentry);
+        mnt = BPF_CORE_READ(file, f_path.mnt);
+    }
+
+    bool truncated = false;
+    __u32 start = read_path(dentry, mnt, buf, &truncated);
+    // 目录为根目录时分隔符即路径开头
+    if (start == CWD_BUF_SIZE - 1)
+        start = CWD_BUF_SIZE;
+    buf[CWD_BUF_SIZE] = '/';
+    *off = start;
+    *len = FILE_NAME_OFF + n - 1 - start;
+    return true;
+}
+
+// 辅助函数：将 dentry 的绝对路径写入 buf，dentry 由内核解析路径得到，不含 .. 和符号链接
+static __always_inline void read_dentry_path(struct dentry *dentry, struct vfsmount *mnt, char *buf, __u32 *off,
+                                             __u32 *len) {
+    bool truncated = false;
+    *off = read_path(dentry, mnt, buf, &truncated);
+    *len = CWD_BUF_SIZE - *off;
+}
+
+// 辅助函数：将 fd 对应文件的绝对路径写入 buf
+static __always_inline bool read_fd_path(int fd, char *buf, __u32 *off, __u32 *len) {
+    struct file *file = file_from_fd(fd);
+    if (!file)
+        return false;
+
+    read_dentry_path(BPF_CORE_READ(file, f_path.dentry), BPF_CORE_READ(file, f_path.mnt), buf, off, len);
+    return true;
+}
+
+// 辅助函数：判断路径是否在监视列表中
+static __always_inline bool watched(const char *buf, __u32 off, __u32 len) {
+    __u32 zero = 0;
+    struct watch_key *key = bpf_map_lookup_elem(&watch_heap, &zero);
+    if (!key || off > FILE_NAME_OFF)
+        return false;
+
+    // 路径后补 NUL 一起比较，以 NUL 结尾的条目只匹配完全相同的路径，/etc/passwd 不会匹配 /etc/passwd-。
+    // 只比较前 prefixlen 位，之后的残留字节不影响匹配
+    bpf_probe_read_kernel(key->path, sizeof(key->path), &buf[off]);
+    if (len < WATCH_PATH_MAX) {
+        key->path[len] = 0;
+        key->prefixlen = (len + 1) * 8;
+    } else {
+        key->prefixlen = WATCH_PATH_MAX * 8;
+    }
+    return bpf_map_lookup_elem(&watch_paths, key) != NULL;
+}
+
+
+// 辅助函数：在暂存区中初始化文件事件
+static __always_inline struct file_event_t *file_event(__u32 op) {
+    __u32 zero = 0;
+    struct file_event_t *event = bpf_map_lookup_elem(&file_heap, &zero);
+    if (!event)
+        return NULL;
+
+    __u64 uid_gid = bpf_get_current_uid_gid();
+    event->pid = bpf_get_current_pid_tgid() >> 32;
+    event->uid = uid_gid;
+    event->gid = uid_gid >> 32;
+    bpf_get_current_comm(&event->comm, sizeof(event->comm));
+    event->op = op;
+    event->flags = 0;
+    event->mode = 0;
+    event->owner = 0;
+    event->group = 0;
+    event->length = 0;
+    event->new_path_len = 0;
+    return event;
+}
+
+// 辅助函数：按 sys_enter 保存的参数初始化文件事件
+static __always_inline struct file_event_t *file_op_event(const struct file_op_t *args) {
+    struct file_event_t *event = file_event(args->op);
+    if (!event)
+        return NULL;
+    event->flags = args->flags;
+    event->mode = args->mode;
+    event->owner = args->owner;
+    event->group = args->group;
+    event->length = args->length;
+    return event;
+}
+
+// 辅助函数：保存路径在监视列表中的事件，系统调用返回时补全结果后提交
+static __always_inline void save_file_event(struct file_event_t *event) {
+    __u64 id = bpf_get_current_pid_tgid();
+    bpf_map_update_elem(&file_args, &id, event, BPF_ANY);
+}
+
+// 辅助函数：保存以路径参数指定文件的系统调用的参数，路径在 security_path_* 中取得
+static __always_inline void save_file_op(struct file_op_t *args) {
+    __u64 id = bpf_get_current_pid_tgid();
+    bpf_map_update_elem(&file_ops, &id, args, BPF_ANY);
+}
+
+// 辅助函数：以 fd 指定文件的系统调用，返回路径在监视列表中的事件
+static __always_inline struct file_event_t *watch_fd(__u32 op, int fd) {
+    struct file_event_t *event = file_event(op);
+    if (!event)
+        return NULL;
+    if (!read_fd_path(fd, event->path, &event->path_off, &event->path_len))
+        return NULL;
+    if (!watched(event->path, event->path_off, event->path_len))
+        return NULL;
+    return event;
+}
+
+// 辅助函数：打开文件，未开启 watch_reads 时忽略只读打开。路径在 trace_file_ret 中取得
+static __always_inline void save_open(int dfd, const char *filename, __u32 flags, __u32 mode) {
+    if (!watch_reads && (flags & O_ACCMODE) == 0 && !(flags & (O_CREAT | O_TRUNC)))
+        return;
+
+    struct file_op_t args = {.op = FILE_OPEN, .dfd = dfd, .filename = filename, .flags = flags, .mode = mode};
+    save_file_op(&args);
+}
+
+// 辅助函数：open 返回时提交路径在监视列表中的事件。成功时路径取自返回的 fd 对应的文件，
+// 失败时没有文件，按参数拼接路径
+static __always_inline void submit_open(void *ctx, const struct file_op_t *args, long ret) {
+    struct file_event_t *event = file_op_event(args);
+    if (!event)
+        return;
+
+    bool ok;
+    if (ret >= 0)
+        ok = read_fd_path(ret, event->path, &event->path_off, &event->path_len);
+    else
+        ok = read_file_path(args->dfd, args->filename, event->path, &event->path_off, &event->path_len);
+    if (!ok || !watched(event->path, event->path_off, event->path_len))
+        return;
+
+    fill_header(&event->hdr, EVENT_FILE);
+    event->ret = ret;
+    submit_event(ctx, event, __builtin_offsetof(struct file_event_t, new_path));
+}
+
+// 辅助函数：修改所有者，owner 或 group 为 -1 时不修改
+static __always_inline void trace_chown(struct file_event_t *event, __u32 owner, __u32 group) {
+    if (!event)
+        return;
+    event->owner = owner;
+    event->group = group;
+    save_file_event(event);
+}
+
+// 辅助函数：修改权限
+static __always_inline void trace_chmod(struct file_event_t *event, __u32 mode) {
+    if (!event)
+        return;
+    event->mode = mode;
+    save_file_event(event);
+}
+
+// 辅助函数：截断文件
+static __always_inline void trace_truncate(struct file_event_t *event, __s64 length) {
+    if (!event)
+        return;
+    event->length = length;
+    save_file_event(event);
+}
+
+// 追踪文件访问系统调用。open 和以路径参数指定文件的系统调用只保存参数，
+// 以 fd 指定文件的系统调用直接按 fd 对应的文件匹配，结果在 trace_file_ret 中提交
+SEC("tracepoint/syscalls/sys_enter_openat")
+int trace_openat(struct trace_event_raw_sys_enter *ctx) {
+    save_open(ctx->args[0], (const char *)ctx->args[1], ctx->args[2], ctx->args[3]);
+    return 0;
+}
+
+SEC("tracepoint/syscalls/sys_enter_openat2")
+int trace_openat2(struct trace_event_raw_sys_enter *ctx) {
+    struct {
+        __u64 flags;
+        __u64 mode;
+    } how = {};
+    if (bpf_probe_read_user(&how, sizeof(how), (void *)ctx->args[2]) < 0)
+        return 0;
+    save_open(ctx->args[0], (const char *)ctx->args[1], how.flags, how.mode);
+    return 0;
+}
+
+SEC("tracepoint/syscalls/sys_enter_open")
+int trace_open_legacy(struct trace_event_raw_sys_enter *ctx) {
+    save_open(AT_FDCWD, (const char *)ctx->args[0], ctx->args[1], ctx->args[2]);
+    return 0;
+}
+
+SEC("tracepoint/syscalls/sys_enter_unlinkat")
+int trace_unlinkat(struct trace_event_raw_sys_enter *ctx) {
+    struct file_op_t args = {.op = FILE_UNLINK, .flags = ctx->args[2]};
+    save_file_op(&args);
+    return 0;
+}
+
+SEC("tracepoint/syscalls/sys_enter_unlink")
+int trace_unlink(struct trace_event_raw_sys_enter *ctx) {
+    struct file_op_t args = {.op = FILE_UNLINK};
+    save_file_op(&args);
+    return 0;
+}
+
+// rmdir 记录为带 AT_REMOVEDIR 的 unlink
+SEC("tracepoint/syscalls/sys_enter_rmdir")
+int trace_rmdir(struct trace_event_raw_sys_enter *ctx) {
+    struct file_op_t args = {.op = FILE_UNLINK, .flags = AT_REMOVEDIR};
+    save_file_op(&args);
+    return 0;
+}
+
+SEC("tracepoint/syscalls/sys_enter_renameat2")
+int trace_renameat2(struct trace_event_raw_sys_enter *ctx) {
+    struct file_op_t args = {.op = FILE_RENAME, .flags = ctx->args[4]};
+    save_file_op(&args);
+    return 0;
+}
+
+SEC("tracepoint/syscalls/sys_enter_renameat")
+int trace_renameat(struct trace_event_raw_sys_enter *ctx) {
+    struct file_op_t args = {.op = FILE_RENAME};
+    save_file_op(&args);
+    return 0;
+}
+
+SEC("tracepoint/syscalls/sys_enter_rename")
+int trace_rename_legacy(struct trace_event_raw_sys_enter *ctx) {
+    struct file_op_t args = {.op = FILE_RENAME};
+    save_file_op(&args);
+    return 0;
+}
+
+SEC("tracepoint/syscalls/sys_enter_fchmodat")
+int trace_fchmodat(struct trace_event_raw_sys_enter *ctx) {
+    struct file_op_t args = {.op = FILE_CHMOD, .mode = ctx->args[2]};
+    save_file_op(&args);
+    return 0;
+}
+
+SEC("tracepoint/syscalls/sys_enter_fchmod")
+int trace_fchmod(struct trace_event_raw_sys_enter *ctx) {
+    trace_chmod(watch_fd(FILE_CHMOD, ctx->args[0]), ctx->args[1]);
+    return 0;
+}
+
+SEC("tracepoint/syscalls/sys_enter_chmod")
+int trace_chmod_legacy(struct trace_event_raw_sys_enter *ctx) {
+    struct file_op_t args = {.op = FILE_CHMOD, .mode = ctx->args[1]};
+    save_file_op(&args);
+    return 0;
+}
+
+SEC("tracepoint/syscalls/sys_enter_fchownat")
+int trace_fchownat(struct trace_event_raw_sys_enter *ctx) {
+    struct file_op_t args = {.op = FILE_CHOWN, .owner = ctx->args[2], .group = ctx->args[3]};
+    save_file_op(&args);
+    return 0;
+}
+
+SEC("tracepoint/syscalls/sys_enter_fchown")
+int trace_fchown(struct trace_event_raw_sys_enter *ctx) {
+    trace_chown(watch_fd(FILE_CHOWN, ctx->args[0]), ctx->args[1], ctx->args[2]);
+    return 0;
+}
+
+// chown 和 lchown 的参数相同，共用同一个程序
+SEC("tracepoint/syscalls/sys_enter_chown")
+int trace_chown_legacy(struct trace_event_raw_sys_enter *ctx) {
+    struct file_op_t args = {.op = FILE_CHOWN, .owner = ctx->args[1], .group = ctx->args[2]};
+    save_file_op(&args);
+    return 0;
+}
+
+SEC("tracepoint/syscalls/sys_enter_truncate")
+int trace_truncate_path(struct trace_event_raw_sys_enter *ctx) {
+    struct file_op_t args = {.op = FILE_TRUNCATE, .length = ctx->args[1]};
+    save_file_op(&args);
+    return 0;
+}
+
+SEC("tracepoint/syscalls/sys_enter_ftruncate")
+int trace_ftruncate(struct trace_event_raw_sys_enter *ctx) {
+    trace_truncate(watch_fd(FILE_TRUNCATE, ctx->args[0]), ctx->args[1]);
+    return 0;
+}
+
+// 辅助函数：取得当前系统调用在 sys_enter 保存的参数。钩子不是由对应的系统调用触发时
+// （例如 open 的 O_TRUNC 或 fchmod 也会经过 security_path_*）返回 NULL
+static __always_inline struct file_event_t *path_event(__u32 op) {
+    __u64 id = bpf_get_current_pid_tgid();
+    struct file_op_t *args = bpf_map_lookup_elem(&file_ops, &id);
+    if (!args || args->op != op)
+        return NULL;
+    return file_op_event(args);
+}
+
+// 辅助函数：dentry 的路径在监视列表中时保存事件
+static __always_inline void watch_dentry(struct file_event_t *event, struct dentry *dentry, struct vfsmount *mnt) {
+    if (!event)
+        return;
+    read_dentry_path(dentry, mnt, event->path, &event->path_off, &event->path_len);
+    if (watched(event->path, event->path_off, event->path_len))
+        save_file_event(event);
+}
+
+// 追踪内核解析路径之后的 LSM 钩子（需要 CONFIG_SECURITY_PATH），路径由 dentry 和挂载点重建，
+// 已解析 .. 和符号链接。unlink 和 rmdir 的参数相同，共用同一个程序
+SEC("kprobe/security_path_unlink")
+int BPF_KPROBE(trace_path_unlink, const struct path *dir, struct dentry *dentry) {
+    watch_dentry(path_event(FILE_UNLINK), dentry, BPF_CORE_READ(dir, mnt));
+    return 0;
+}
+
+// 新旧路径任一在监视列表中时记录。目标不存在时 new_dentry 为负 dentry，名称和父目录仍然有效
+SEC("kprobe/security_path_rename")
+int BPF_KPROBE(trace_path_rename, const struct path *old_dir, struct dentry *old_dentry, const struct path *new_dir,
+               struct dentry *new_dentry) {
+    struct file_event_t *event = path_event(FILE_RENAME);
+    if (!event)
+        return 0;
+    read_dentry_path(old_dentry, BPF_CORE_READ(old_dir, mnt), event->path, &event->path_off, &event->path_len);
+    read_dentry_path(new_dentry, BPF_CORE_READ(new_dir, mnt), event->new_path, &event->new_path_off,
+                     &event->new_path_len);
+    if (watched(event->path, event->path_off, event->path_len) ||
+        watched(event->new_path, event->new_path_off, event->new_path_len))
+        save_file_event(event);
+    return 0;
+}
+
+SEC("kprobe/security_path_chmod")
+int BPF_KPROBE(trace_path_chmod, const struct path *path) {
+    watch_dentry(path_event(FILE_CHMOD), BPF_CORE_READ(path, dentry), BPF_CORE_READ(path, mnt));
+    return 0;
+}
+
+SEC("kprobe/security_path_chown")
+int BPF_KPROBE(trace_path_chown, const struct path *path) {
+    watch_dentry(path_event(FILE_CHOWN), BPF_CORE_READ(path, dentry), BPF_CORE_READ(path, mnt));
+    return 0;
+}
+
+SEC("kprobe/security_path_truncate")
+int BPF_KPROBE(trace_path_truncate, const struct path *path) {
+    watch_dentry(path_event(FILE_TRUNCATE), BPF_CORE_READ(path, dentry), BPF_CORE_READ(path, mnt));
+    return 0;
+}
+
+// 追踪文件访问系统调用返回，挂载到所有 trace_* 文件程序对应的 sys_exit tracepoint。
+// open 在这里按结果取得路径，其他系统调用提交之前保存的事件；没有保存的事件说明路径不在监视列表中，
+// 或者在解析路径时就已失败（例如 ENOENT）
+SEC("tracepoint/syscalls/sys_exit_openat")
+int trace_file_ret(struct trace_event_raw_sys_exit *ctx) {
+    __u64 id = bpf_get_current_pid_tgid();
+    struct file_op_t *args = bpf_map_lookup_elem(&file_ops, &id);
+    if (args) {
+        if (args->op == FILE_OPEN)
+            submit_open(ctx, args, ctx->ret);
+        bpf_map_delete_elem(&file_ops, &id);
     }
 
-    bpf_perf_event_output(ctx, &bind_events, BPF_F_CURRENT_CPU, &event, sizeof(event));
+    struct file_event_t *event = bpf_map_lookup_elem(&file_args, &id);
+    if (!event)
+        return 0;
+
+    fill_header(&event->hdr, EVENT_FILE);
+    event->ret = ctx->ret;
+    if (event->op == FILE_RENAME)
+        submit_event(ctx, event, sizeof(*event));
+    else
+        submit_event(ctx, event, __builtin_offsetof(struct file_event_t, new_path));
+    bpf_map_delete_elem(&file_args, &id);
+
+    return 0;
+}
+
+// 追踪进程退出。线程组中最后一个线程退出时整个进程才退出，主线程可能先调用 pthread_exit
+// 退出，此时进程仍在运行。内核在该 tracepoint 之前递减 signal->live，为 0 即最后一个线程
+SEC("tracepoint/sched/sched_process_exit")
+int trace_exit(struct trace_event_raw_sched_process_template *ctx) {
+    struct task_struct *task = (struct task_struct *)bpf_get_current_task();
+    struct signal_struct *sig = BPF_CORE_READ(task, signal);
+    if (BPF_CORE_READ(sig, live.counter) != 0)
+        return 0;
+
+    struct exit_event_t event = {};
+    struct task_struct *leader = BPF_CORE_READ(task, group_leader);
+    __u64 uid_gid = bpf_get_current_uid_gid();
+
+    fill_header(&event.hdr, EVENT_EXIT);
+    event.pid = bpf_get_current_pid_tgid() >> 32;
+    event.ppid = BPF_CORE_READ(task, real_parent, tgid);
+    event.uid = uid_gid;
+    event.gid = uid_gid >> 32;
+    BPF_CORE_READ_INTO(&event.comm, leader, comm);
+
+    // 退出状态与 wait(2) 取得的相同：整个线程组退出时为 group_exit_code，否则为主线程的 exit_code，
+    // 编码方式同 wait(2) 的 status
+    int code;
+    if (BPF_CORE_READ(sig, flags) & SIGNAL_GROUP_EXIT)
+        code = BPF_CORE_READ(sig, group_exit_code);
+    else
+        code = BPF_CORE_READ(leader, exit_code);
+    event.exit_code = (code >> 8) & 0xff;
+    event.signal = code & 0x7f;
+    event.core_dumped = (code & 0x80) != 0;
+
+    // 已回收线程的 CPU 时间累计在 signal 中；先退出的主线程在整个进程退出前不会被回收，需要单独累加
+    event.user_ns = BPF_CORE_READ(task, utime) + BPF_CORE_READ(sig, utime);
+    event.system_ns = BPF_CORE_READ(task, stime) + BPF_CORE_READ(sig, stime);
+    if (leader != task) {
+        event.user_ns += BPF_CORE_READ(leader, utime);
+        event.system_ns += BPF_CORE_READ(leader, stime);
+    }
+    event.wall_ns = event.hdr.timestamp - BPF_CORE_READ(leader, start_time);
+
+    submit_event(ctx, &event, sizeof(event));
+
+    return 0;
+}
+
+// 追踪进程创建，忽略新建线程
+SEC("tp_btf/sched_process_fork")
+int BPF_PROG(trace_fork, struct task_struct *parent, struct task_struct *child) {
+    if (child->pid != child->tgid)
+        return 0;
+
+    struct fork_event_t event = {};
+    __u64 uid_gid = bpf_get_current_uid_gid();
+
+    fill_header(&event.hdr, EVENT_FORK);
+    event.parent_pid = parent->tgid;
+    event.child_pid = child->tgid;
+    event.uid = uid_gid;
+    event.gid = uid_gid >> 32;
+    bpf_probe_read_kernel_str(&event.comm, sizeof(event.comm), parent->comm);
+
+    submit_event(ctx, &event, sizeof(event));
 
     return 0;
 }
 
-char LICENSE[] SEC("license") = "GPL";
\ No newline at end of file
+char LICENSE[] SEC("license") = "GPL";
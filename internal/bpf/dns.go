@@ -0,0 +1,168 @@
+package bpf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// dnsHeaderLen DNS 报文头长度
+const dnsHeaderLen = 12
+
+// dnsMaxPointers 名称压缩指针的最大跳转次数，防止构造的报文形成循环
+const dnsMaxPointers = 16
+
+// errDNSShort 报文在解析完成前结束，内核只采集前 512 字节时常见
+var errDNSShort = errors.New("dns message too short")
+
+// dnsTypes 常见的记录类型
+var dnsTypes = map[uint16]string{
+	1:   "A",
+	2:   "NS",
+	5:   "CNAME",
+	6:   "SOA",
+	12:  "PTR",
+	15:  "MX",
+	16:  "TXT",
+	28:  "AAAA",
+	33:  "SRV",
+	64:  "SVCB",
+	65:  "HTTPS",
+	255: "ANY",
+}
+
+// dnsRCodes 响应码
+var dnsRCodes = map[uint16]string{
+	0: "NOERROR",
+	1: "FORMERR",
+	2: "SERVFAIL",
+	3: "NXDOMAIN",
+	4: "NOTIMP",
+	5: "REFUSED",
+}
+
+// dnsMessage 解析出的 DNS 报文，只包含审计关心的部分：第一个问题和回答中的记录
+type dnsMessage struct {
+	id       uint16
+	response bool
+	rcode    string
+	name     string
+	qtype    string
+	answers  []string // A/AAAA 为地址，CNAME/PTR/NS 为名称，其他类型为 TYPE:长度
+}
+
+// parseDNSMessage 解析 DNS 报文。回答部分被截断时返回已解析的记录
+func parseDNSMessage(data []byte) (*dnsMessage, error) {
+	if len(data) < dnsHeaderLen {
+		return nil, errDNSShort
+	}
+	flags := binary.BigEndian.Uint16(data[2:])
+	qdCount := binary.BigEndian.Uint16(data[4:])
+	anCount := binary.BigEndian.Uint16(data[6:])
+
+	msg := &dnsMessage{
+		id:       binary.BigEndian.Uint16(data),
+		response: flags&0x8000 != 0,
+	}
+	if msg.response {
+		msg.rcode = dnsName(dnsRCodes, flags&0xf, "RCODE")
+	}
+	if qdCount == 0 {
+		return nil, fmt.Errorf("dns message without question")
+	}
+
+	off := dnsHeaderLen
+	for i := 0; i < int(qdCount); i++ {
+		name, next, err := readDNSName(data, off)
+		if err != nil {
+			return nil, err
+		}
+		if next+4 > len(data) {
+			return nil, errDNSShort
+		}
+		if i == 0 {
+			msg.name = name
+			msg.qtype = dnsName(dnsTypes, binary.BigEndian.Uint16(data[next:]), "TYPE")
+		}
+		off = next + 4
+	}
+
+	for i := 0; i < int(anCount); i++ {
+		_, next, err := readDNSName(data, off)
+		if err != nil || next+10 > len(data) {
+			break
+		}
+		rtype := binary.BigEndian.Uint16(data[next:])
+		rdLen := int(binary.BigEndian.Uint16(data[next+8:]))
+		rdata := next + 10
+		if rdata+rdLen > len(data) {
+			break
+		}
+		msg.answers = append(msg.answers, dnsRData(data, rtype, rdata, rdLen))
+		off = rdata + rdLen
+	}
+	return msg, nil
+}
+
+// dnsRData 格式化一条记录的数据
+func dnsRData(data []byte, rtype uint16, off, n int) string {
+	switch rtype {
+	case 1, 28:
+		if n == net.IPv4len || n == net.IPv6len {
+			return net.IP(data[off : off+n]).String()
+		}
+	case 2, 5, 12:
+		if name, _, err := readDNSName(data, off); err == nil {
+			return name
+		}
+	}
+	return fmt.Sprintf("%s:%d", dnsName(dnsTypes, rtype, "TYPE"), n)
+}
+
+// readDNSName 读取 off 处的名称，支持压缩指针，返回名称之后的偏移
+func readDNSName(data []byte, off int) (string, int, error) {
+	var labels []string
+	next := -1
+	for jumps := 0; ; {
+		if off >= len(data) {
+			return "", 0, errDNSShort
+		}
+		n := int(data[off])
+		switch {
+		case n == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return strings.Join(labels, "."), next, nil
+		case n&0xc0 == 0xc0:
+			if off+1 >= len(data) {
+				return "", 0, errDNSShort
+			}
+			if jumps++; jumps > dnsMaxPointers {
+				return "", 0, fmt.Errorf("dns name has too many compression pointers")
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(data[off:]) & 0x3fff)
+		case n&0xc0 != 0:
+			return "", 0, fmt.Errorf("invalid dns label type %#x", n&0xc0)
+		default:
+			if off+1+n > len(data) {
+				return "", 0, errDNSShort
+			}
+			labels = append(labels, string(data[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
+
+// dnsName 查找类型或响应码的名称，未知时为 prefix 加数值
+func dnsName(names map[uint16]string, v uint16, prefix string) string {
+	if name, ok := names[v]; ok {
+		return name
+	}
+	return fmt.Sprintf("%s%d", prefix, v)
+}
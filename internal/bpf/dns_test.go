@@ -0,0 +1,174 @@
+package bpf
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// dnsTestName 编码未压缩的名称
+func dnsTestName(labels ...string) []byte {
+	var b []byte
+	for _, l := range labels {
+		b = append(b, byte(len(l)))
+		b = append(b, l...)
+	}
+	return append(b, 0)
+}
+
+// dnsTestMessage 构造报文：报文头、一个 example.com 的 A 问题和给定的回答记录
+func dnsTestMessage(flags uint16, answers ...[]byte) []byte {
+	msg := make([]byte, dnsHeaderLen)
+	binary.BigEndian.PutUint16(msg, 0x1234)
+	binary.BigEndian.PutUint16(msg[2:], flags)
+	binary.BigEndian.PutUint16(msg[4:], 1)
+	binary.BigEndian.PutUint16(msg[6:], uint16(len(answers)))
+	msg = append(msg, dnsTestName("www", "example", "com")...)
+	msg = append(msg, 0, 1, 0, 1)
+	for _, a := range answers {
+		msg = append(msg, a...)
+	}
+	return msg
+}
+
+// dnsTestRecord 构造回答记录，名称为指向问题名称的压缩指针
+func dnsTestRecord(rtype uint16, rdata []byte) []byte {
+	r := []byte{0xc0, dnsHeaderLen}
+	r = binary.BigEndian.AppendUint16(r, rtype)
+	r = append(r, 0, 1, 0, 0, 0x0e, 0x10)
+	r = binary.BigEndian.AppendUint16(r, uint16(len(rdata)))
+	return append(r, rdata...)
+}
+
+func TestParseDNSMessage(t *testing.T) {
+	query := dnsTestMessage(0x0100)
+	msg, err := parseDNSMessage(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &dnsMessage{id: 0x1234, name: "www.example.com", qtype: "A"}
+	if !reflect.DeepEqual(msg, want) {
+		t.Errorf("query = %+v, want %+v", msg, want)
+	}
+
+	// CNAME 的数据压缩指向问题名称中的 example.com
+	response := dnsTestMessage(0x8180,
+		dnsTestRecord(5, []byte{3, 'c', 'd', 'n', 0xc0, dnsHeaderLen + 4}),
+		dnsTestRecord(1, []byte{93, 184, 216, 34}),
+		dnsTestRecord(28, []byte{0x20, 0x01, 0x0d, 0xb8, 15: 1}),
+		dnsTestRecord(16, []byte{4, 't', 'e', 'x', 't'}),
+	)
+	msg, err = parseDNSMessage(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = &dnsMessage{
+		id: 0x1234, response: true, rcode: "NOERROR", name: "www.example.com", qtype: "A",
+		answers: []string{"cdn.example.com", "93.184.216.34", "2001:db8::1", "TXT:5"},
+	}
+	if !reflect.DeepEqual(msg, want) {
+		t.Errorf("response = %+v, want %+v", msg, want)
+	}
+
+	// 截断的回答只保留完整的记录
+	for _, n := range []int{1, 5, 11, 13} {
+		msg, err := parseDNSMessage(response[:len(response)-len(dnsTestRecord(16, []byte{4, 't', 'e', 'x', 't'}))-n])
+		if err != nil {
+			t.Fatalf("truncated by %d: %v", n, err)
+		}
+		if len(msg.answers) != 2 {
+			t.Errorf("truncated by %d: answers = %v, want the first two", n, msg.answers)
+		}
+	}
+
+	nx, err := parseDNSMessage(dnsTestMessage(0x8183))
+	if err != nil || nx.rcode != "NXDOMAIN" {
+		t.Errorf("nxdomain = %+v, %v", nx, err)
+	}
+	if odd, err := parseDNSMessage(dnsTestMessage(0x818b)); err != nil || odd.rcode != "RCODE11" {
+		t.Errorf("unknown rcode = %+v, %v", odd, err)
+	}
+}
+
+func TestParseDNSMessageErrors(t *testing.T) {
+	query := dnsTestMessage(0x0100)
+	noQuestion := append([]byte(nil), query...)
+	binary.BigEndian.PutUint16(noQuestion[4:], 0)
+
+	tests := []struct {
+		name  string
+		data  []byte
+		short bool
+	}{
+		{"empty", nil, true},
+		{"header only", query[:dnsHeaderLen-1], true},
+		{"no question", noQuestion, false},
+		{"truncated name", query[:dnsHeaderLen+6], true},
+		{"truncated question type", query[:len(query)-2], true},
+	}
+	for _, tt := range tests {
+		msg, err := parseDNSMessage(tt.data)
+		if err == nil {
+			t.Errorf("%s: got %+v, want error", tt.name, msg)
+			continue
+		}
+		if errors.Is(err, errDNSShort) != tt.short {
+			t.Errorf("%s: error %v, short = %v", tt.name, err, tt.short)
+		}
+	}
+}
+
+func TestReadDNSName(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  []byte
+		off   int
+		want  string
+		next  int
+		short bool // 期望 errDNSShort；want 为空且不是 short 时期望其他错误
+	}{
+		{name: "plain", data: dnsTestName("a", "bc"), want: "a.bc", next: 6},
+		{name: "root", data: []byte{0}, want: "", next: 1},
+		{name: "pointer", data: append(dnsTestName("x", "com"), 1, 'y', 0xc0, 0), off: 7, want: "y.x.com", next: 11},
+		{name: "chained pointers", data: []byte{1, 'a', 0, 0xc0, 0, 0xc0, 3}, off: 5, want: "a", next: 7},
+		{name: "past end", data: []byte{1, 'a', 0}, off: 3, short: true},
+		{name: "missing terminator", data: []byte{1, 'a'}, short: true},
+		{name: "label past end", data: []byte{5, 'a', 'b'}, short: true},
+		{name: "half pointer", data: []byte{1, 'a', 0xc0}, short: true},
+		{name: "pointer past end", data: []byte{0xc0, 0x40}, short: true},
+		{name: "self loop", data: []byte{0xc0, 0}},
+		{name: "two pointer loop", data: []byte{0xc0, 2, 0xc0, 0}},
+		{name: "label loop", data: []byte{1, 'a', 0xc0, 0}},
+		{name: "reserved label type", data: []byte{0x40, 'a', 0}},
+	}
+	for _, tt := range tests {
+		name, next, err := readDNSName(tt.data, tt.off)
+		switch {
+		case tt.want != "" || tt.next != 0:
+			if err != nil || name != tt.want || next != tt.next {
+				t.Errorf("%s: got %q, %d, %v, want %q, %d", tt.name, name, next, err, tt.want, tt.next)
+			}
+		case err == nil:
+			t.Errorf("%s: got %q, %d, want error", tt.name, name, next)
+		case errors.Is(err, errDNSShort) != tt.short:
+			t.Errorf("%s: error %v, short = %v", tt.name, err, tt.short)
+		}
+	}
+}
+
+// TestParseDNSMessageLoop 回答中的循环指针不影响已解析的问题和之前的记录
+func TestParseDNSMessageLoop(t *testing.T) {
+	// 问题占 12..32，第一条回答的数据从 45 开始并指向自己，第二条回答从 47 开始，名称指向自己
+	data := dnsTestMessage(0x8180,
+		dnsTestRecord(5, []byte{0xc0, 45}),
+		[]byte{0xc0, 47, 0, 1, 0, 1, 0, 0, 0, 0, 0, 0},
+	)
+	msg, err := parseDNSMessage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.name != "www.example.com" || !reflect.DeepEqual(msg.answers, []string{"CNAME:2"}) {
+		t.Errorf("got %+v", msg)
+	}
+}
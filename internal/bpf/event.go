@@ -0,0 +1,206 @@
+package bpf
+
+import (
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// EventKind 事件种类
+type EventKind uint32
+
+const (
+	KindExec    EventKind = EventExecve
+	KindConnect EventKind = EventConnect
+	KindAccept  EventKind = EventAccept
+	KindListen  EventKind = EventListen
+	KindDNS     EventKind = EventDNSQuery
+	KindExit    EventKind = EventExit
+	KindFork    EventKind = EventFork
+	KindClose   EventKind = EventClose
+	KindFile    EventKind = EventFile
+)
+
+// String 返回事件种类名称
+func (k EventKind) String() string {
+	switch k {
+	case KindExec:
+		return "exec"
+	case KindConnect:
+		return "connect"
+	case KindAccept:
+		return "accept"
+	case KindListen:
+		return "listen"
+	case KindDNS:
+		return "dns"
+	case KindExit:
+		return "exit"
+	case KindFork:
+		return "fork"
+	case KindClose:
+		return "close"
+	case KindFile:
+		return "file"
+	default:
+		return "unknown"
+	}
+}
+
+// Process 触发事件的进程信息
+type Process struct {
+	PID  uint32
+	PPID uint32
+	UID  uint32
+	GID  uint32
+	Comm string
+}
+
+// Event 解码后的内核事件，只能由本包实现
+type Event interface {
+	Kind() EventKind
+	Timestamp() time.Time
+	Process() Process
+
+	sealed()
+}
+
+// baseEvent 所有事件共用的字段
+type baseEvent struct {
+	kind      EventKind
+	timestamp time.Time
+	process   Process
+}
+
+func (e *baseEvent) Kind() EventKind      { return e.kind }
+func (e *baseEvent) Timestamp() time.Time { return e.timestamp }
+func (e *baseEvent) Process() Process     { return e.process }
+func (e *baseEvent) sealed()              {}
+
+// ExecEvent 命令执行事件
+type ExecEvent struct {
+	baseEvent
+	Command    string   // 可执行文件名
+	Filename   string   // 传给 execve 的路径
+	Args       []string // 完整 argv，包含 argv[0]
+	WorkingDir string   // 绝对路径
+
+	// 参数个数超过上限或单个参数过长时为 true
+	ArgsTruncated bool
+	// 目录层级过深时为 true，WorkingDir 只包含末尾部分
+	WorkingDirTruncated bool
+
+	// 内核审计子系统记录的登录用户和会话，未登录或未启用审计时为 AuditUnset
+	LoginUID       uint32
+	AuditSessionID uint32
+}
+
+// AuditUnset 内核未设置 loginuid/sessionid 时的取值
+const AuditUnset = ^uint32(0)
+
+// ConnectEvent 出站连接事件，TCP 在握手结束时产生，其他协议在 connect 返回时产生
+type ConnectEvent struct {
+	baseEvent
+	Protocol string // 由套接字类型得到：tcp、udp、raw
+	SrcIP    string // 内核分配的源地址，连接失败时可能为空地址
+	SrcPort  int
+	DstIP    string
+	DstPort  int
+	Err      unix.Errno // 连接失败时的错误，成功为 0
+}
+
+// AcceptEvent 入站连接事件
+type AcceptEvent struct {
+	baseEvent
+	Protocol   string
+	LocalIP    string
+	LocalPort  int
+	RemoteIP   string
+	RemotePort int
+}
+
+// CloseEvent TCP 连接关闭事件，Process 为发起或接受连接的进程，
+// 只包含追踪器启动后建立的连接
+type CloseEvent struct {
+	baseEvent
+	Protocol      string
+	Inbound       bool // 连接由本机 accept 接受
+	LocalIP       string
+	LocalPort     int
+	RemoteIP      string
+	RemotePort    int
+	BytesSent     uint64 // 已被对端确认的发送字节数
+	BytesReceived uint64
+	Duration      time.Duration // 从连接建立到关闭的时间
+}
+
+// ListenEvent 套接字开始或停止监听，Inode 为套接字的 inode，用于关联同一个套接字。
+// UDP 套接字显式绑定端口时开始监听，释放端口时停止监听。停止监听时 Process 为关闭套接字的进程
+type ListenEvent struct {
+	baseEvent
+	Protocol string
+	Address  string
+	Port     int
+	Inode    uint64
+	Closed   bool
+}
+
+// DNSEvent 发出的 DNS 查询或收到的响应，Process 为发出查询或接收响应的进程
+type DNSEvent struct {
+	baseEvent
+	Response   bool
+	ID         uint16 // DNS 报文ID，用于关联查询和响应
+	Domain     string // 查询的名称，不带结尾的点
+	Type       string // 记录类型，例如 A、AAAA，未知类型为 TYPE<n>
+	RCode      string // 仅响应：NOERROR、NXDOMAIN 等
+	Answers    []string
+	Server     string
+	ServerPort int
+	Truncated  bool  // 报文超过 512 字节，只解析了前面部分
+	Err        error // 报文无法解析时的错误
+}
+
+// FileEvent 监视路径下的文件访问事件，在系统调用返回时产生。路径由内核解析：成功的 open 取自打开的文件，
+// 其他操作取自 security_path_* 钩子中的 dentry，不含 .. 和符号链接；失败的 open 由参数和工作目录拼接而成
+type FileEvent struct {
+	baseEvent
+	Op      string // open、unlink、rename、chmod、chown、truncate
+	Path    string
+	NewPath string     // 仅 rename：新路径
+	Flags   uint32     // open 的 flags，unlinkat 的 AT_REMOVEDIR，renameat2 的 RENAME_* 标志
+	Mode    uint32     // open 创建文件或 chmod 的权限
+	Owner   int        // 仅 chown：新的所有者，-1 表示不修改
+	Group   int        // 仅 chown：新的组，-1 表示不修改
+	Length  int64      // 仅 truncate：新的长度
+	Err     unix.Errno // 失败时的错误，成功为 0
+}
+
+// ExitEvent 进程退出事件，在线程组的最后一个线程退出时产生
+type ExitEvent struct {
+	baseEvent
+	ExitCode   int  // 正常退出时的退出码
+	Signal     int  // 被信号终止时的信号编号，正常退出为 0
+	CoreDumped bool // 是否产生 core dump
+	UserTime   time.Duration
+	SystemTime time.Duration
+	WallTime   time.Duration // 从进程创建到退出的时间
+}
+
+// ForkEvent 进程创建事件，Process 为父进程
+type ForkEvent struct {
+	baseEvent
+	ChildPID uint32
+}
+
+// subscription 事件订阅
+type subscription struct {
+	kinds   map[EventKind]bool
+	ch      chan Event
+	dropped atomic.Uint64 // 缓冲区满而丢弃的事件数
+}
+
+// wants 判断订阅是否关心该种类事件，未指定种类时接收全部
+func (s *subscription) wants(kind EventKind) bool {
+	return len(s.kinds) == 0 || s.kinds[kind]
+}
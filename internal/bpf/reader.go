@@ -0,0 +1,81 @@
+package bpf
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/perf"
+	"github.com/cilium/ebpf/ringbuf"
+)
+
+// perfBufferPages perf buffer 每个CPU的页数
+const perfBufferPages = 64
+
+// recordReader 阻塞读取内核提交的原始事件
+type recordReader interface {
+	// Read 阻塞直到有事件到达，lost 为此前丢失的事件数
+	Read() (sample []byte, lost uint64, err error)
+	Close() error
+}
+
+// newRecordReader 根据 map 类型创建 ringbuf 或 perf 读取器
+func newRecordReader(m *ebpf.Map) (recordReader, error) {
+	switch m.Type() {
+	case ebpf.RingBuf:
+		rd, err := ringbuf.NewReader(m)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create ring buffer reader: %w", err)
+		}
+		return &ringbufReader{rd: rd}, nil
+	case ebpf.PerfEventArray:
+		rd, err := perf.NewReader(m, perfBufferPages*os.Getpagesize())
+		if err != nil {
+			return nil, fmt.Errorf("failed to create perf buffer reader: %w", err)
+		}
+		return &perfReader{rd: rd}, nil
+	default:
+		return nil, fmt.Errorf("unsupported event map type %s", m.Type())
+	}
+}
+
+// ringbufReader BPF_MAP_TYPE_RINGBUF 读取器
+type ringbufReader struct {
+	rd *ringbuf.Reader
+}
+
+func (r *ringbufReader) Read() ([]byte, uint64, error) {
+	rec, err := r.rd.Read()
+	if err != nil {
+		if errors.Is(err, ringbuf.ErrClosed) {
+			return nil, 0, os.ErrClosed
+		}
+		return nil, 0, err
+	}
+	return rec.RawSample, 0, nil
+}
+
+func (r *ringbufReader) Close() error {
+	return r.rd.Close()
+}
+
+// perfReader BPF_MAP_TYPE_PERF_EVENT_ARRAY 读取器，用于不支持 ringbuf 的旧内核
+type perfReader struct {
+	rd *perf.Reader
+}
+
+func (r *perfReader) Read() ([]byte, uint64, error) {
+	rec, err := r.rd.Read()
+	if err != nil {
+		if errors.Is(err, perf.ErrClosed) {
+			return nil, 0, os.ErrClosed
+		}
+		return nil, 0, err
+	}
+	return rec.RawSample, rec.LostSamples, nil
+}
+
+func (r *perfReader) Close() error {
+	return r.rd.Close()
+}
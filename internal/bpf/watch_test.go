@@ -0,0 +1,67 @@
+package bpf
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// watchMatch 模拟内核 watched() 在 LPM 监视列表中的查找：路径后补 NUL，任一条目是其前缀即匹配
+func watchMatch(keys []watchKey, path string) bool {
+	var lookup [WatchPathMax]byte
+	n := copy(lookup[:], path)
+	if n < WatchPathMax {
+		n++
+	}
+	for _, k := range keys {
+		plen := int(k.Prefixlen / 8)
+		if plen <= n && bytes.Equal(k.Path[:plen], lookup[:plen]) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestWatchKeys(t *testing.T) {
+	var keys []watchKey
+	for _, p := range []string{"/etc/", "/root/.ssh/", "/etc/shadow", "/srv/passwd"} {
+		keys = append(keys, watchKeys(p)...)
+	}
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/etc", true},
+		{"/etc/", true},
+		{"/etc/hosts", true},
+		{"/etc/ssh/sshd_config", true},
+		{"/etcd", false},
+		{"/etcd/x", false},
+		{"/root/.ssh", true},
+		{"/root/.ssh/id_rsa", true},
+		{"/root/.sshx", false},
+		{"/root", false},
+		{"/srv/passwd", true},
+		{"/srv/passwd-", false},
+		{"/srv/passwdX", false},
+		{"/srv/passwd/x", false},
+		{"/srv/pass", false},
+		{"/srv", false},
+	}
+	for _, tt := range tests {
+		if got := watchMatch(keys, tt.path); got != tt.want {
+			t.Errorf("%s: matched = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+
+	// 根目录匹配所有路径
+	if root := watchKeys("/"); len(root) != 1 || !watchMatch(root, "/") || !watchMatch(root, "/anything") {
+		t.Errorf("root keys = %+v", root)
+	}
+	// 超过 WatchPathMax 的路径只比较前 WatchPathMax 字节
+	dir := "/" + strings.Repeat("a", 100) + "/"
+	if !watchMatch(watchKeys(dir), dir+strings.Repeat("b", WatchPathMax)) {
+		t.Error("long path under a watched directory is not matched")
+	}
+}
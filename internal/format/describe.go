@@ -0,0 +1,280 @@
+package format
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cevin/shell-auditor/internal/audit"
+)
+
+// describe 事件的单行描述，不包含时间和用户
+func (f *Formatter) describe(e *audit.AuditEvent, exits map[string]audit.ExitInfo) string {
+	d := e.DetailsMap()
+	str := func(key string) string { return value(d[key]) }
+
+	switch e.Type {
+	case audit.EventCommand:
+		s := f.paint(colorBold, commandLine(e))
+		if info, ok := exits[e.ID]; ok {
+			color := colorGreen
+			if info.ExitCode != 0 || info.Signal != 0 {
+				color = colorRed
+			}
+			s += " " + f.paint(color, "["+exitText(info)+"]")
+		}
+		return s
+	case audit.EventBuiltin:
+		return f.paint(colorBold, commandLine(e)) + f.paint(colorDim, " (builtin)")
+	case audit.EventCommandExit:
+		if info, ok := e.ExitDetails(); ok {
+			return exitText(info.ExitInfo) + f.paint(colorDim, " (command "+escape(info.CommandID)+")")
+		}
+	case audit.EventNetwork:
+		s := fmt.Sprintf("%s -> %s", process(e), f.paint(colorYellow, endpoint(str("dst_ip"), str("dst_port"), str("protocol"))))
+		if str("status") == "failed" {
+			s += " " + f.paint(colorRed, "failed "+str("error"))
+		}
+		return s
+	case audit.EventConnectionClose:
+		peer := endpoint(str("remote_ip"), str("remote_port"), str("protocol"))
+		if str("direction") == "inbound" {
+			peer = endpoint(str("remote_ip"), str("remote_port"), "") + " on " + endpoint(str("local_ip"), str("local_port"), str("protocol"))
+		}
+		return fmt.Sprintf("%s closed %s", process(e), f.paint(colorYellow, peer)) +
+			f.paint(colorDim, fmt.Sprintf(" (sent %s, received %s, %s)", byteSize(d["bytes_sent"]), byteSize(d["bytes_received"]), duration(d["duration_ms"])))
+	case audit.EventAccept:
+		return fmt.Sprintf("%s accepted %s on %s", process(e), endpoint(str("remote_ip"), str("remote_port"), ""), f.paint(colorYellow, endpoint(str("local_ip"), str("local_port"), str("protocol"))))
+	case audit.EventPortOpen:
+		s := fmt.Sprintf("%s listening on %s", process(e), f.paint(colorYellow, endpoint(str("address"), str("port"), str("protocol"))))
+		if str("source") == "snapshot" {
+			s += f.paint(colorDim, " (at startup)")
+		}
+		return s
+	case audit.EventPortClosed:
+		return fmt.Sprintf("%s stopped listening on %s", process(e), f.paint(colorYellow, endpoint(str("address"), str("port"), str("protocol"))))
+	case audit.EventDNS:
+		s := fmt.Sprintf("%s resolved %s %s", process(e), f.paint(colorYellow, str("domain")), str("type"))
+		if rcode := str("rcode"); rcode != "" && rcode != "NOERROR" {
+			return s + " " + f.paint(colorRed, rcode)
+		}
+		if answers := stringList(d["answers"]); len(answers) > 0 {
+			s += " -> " + strings.Join(answers, ", ")
+		}
+		return s
+	case audit.EventFile:
+		target := str("path")
+		if newPath := str("new_path"); newPath != "" {
+			target += " -> " + newPath
+		}
+		s := fmt.Sprintf("%s %s %s", process(e), str("operation"), f.paint(colorYellow, target))
+		if extra := fileChange(d); extra != "" {
+			s += f.paint(colorDim, " ("+extra+")")
+		}
+		if str("result") == "failed" {
+			s += " " + f.paint(colorRed, "failed "+str("error"))
+		}
+		return s
+	case audit.EventPolicyViolation:
+		outcome := strings.ToUpper(str("outcome"))
+		color := colorYellow
+		if outcome == "DENIED" || outcome == "DECLINED" {
+			color = colorRed
+		}
+		s := f.paint(color, outcome) + " " + commandLine(e)
+		rule := str("rule_id")
+		if msg := str("message"); msg != "" {
+			rule += ": " + msg
+		}
+		if rule != "" {
+			s += f.paint(colorDim, " ("+rule+")")
+		}
+		return s
+	case audit.EventSessionStart:
+		return "session start " + joinNonEmpty(str("login_method"), labeled("tty", str("tty")), from(str("remote_addr"), str("remote_port")), quoted(d["command"]))
+	case audit.EventSessionEnd:
+		return "session end " + joinNonEmpty(str("reason"), duration(d["duration_ms"]))
+	case audit.EventFileTransfer:
+		return joinNonEmpty(str("protocol"), str("direction"), strings.Join(stringList(d["paths"]), " "))
+	case audit.EventJobStart, audit.EventJobStop, audit.EventJobContinue, audit.EventJobExit:
+		verb := strings.TrimPrefix(string(e.Type), "job_")
+		s := fmt.Sprintf("job [%s] %s: %s", str("job_id"), verb, str("command_line"))
+		if e.Type == audit.EventJobExit {
+			s += fmt.Sprintf(" [exit %s]", str("exit_code"))
+		}
+		return s
+	case audit.EventStartupFile:
+		sum := str("sha256")
+		if len(sum) > 12 {
+			sum = sum[:12]
+		}
+		return "startup file " + str("path") + f.paint(colorDim, " (sha256 "+sum+")")
+	case audit.EventAuditCleared:
+		return f.paint(colorRed, "audit log cleared") + fmt.Sprintf(" (%s events, %d files removed)", str("events"), len(stringList(d["files"])))
+	}
+
+	if len(d) == 0 {
+		return commandLine(e)
+	}
+	return joinNonEmpty(commandLine(e), jsonText(d))
+}
+
+// commandLine 命令和参数，含空格或特殊字符的参数加引号
+func commandLine(e *audit.AuditEvent) string {
+	if e.Command == "" {
+		return ""
+	}
+	return strings.Join(quoteArgs(append([]string{e.Command}, e.Args...)), " ")
+}
+
+// process 网络事件的进程名，未知时显示 PID
+func process(e *audit.AuditEvent) string {
+	if e.Command != "" {
+		return escape(e.Command)
+	}
+	return fmt.Sprintf("pid %d", e.PID)
+}
+
+// fileChange 文件访问的标志、权限、所有者或长度
+func fileChange(d map[string]interface{}) string {
+	var parts []string
+	if flags := value(d["flags"]); flags != "" {
+		parts = append(parts, flags)
+	}
+	if mode := value(d["mode"]); mode != "" {
+		parts = append(parts, "mode "+mode)
+	}
+	for _, key := range []string{"uid", "gid"} {
+		if id, ok := d[key].(float64); ok && id >= 0 {
+			parts = append(parts, fmt.Sprintf("%s %d", key, int64(id)))
+		}
+	}
+	if length, ok := d["length"].(float64); ok {
+		parts = append(parts, "length "+byteSize(length))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// endpoint 格式化地址、端口和协议，例如 1.2.3.4:443/tcp，IPv6 地址加方括号
+func endpoint(ip, port, protocol string) string {
+	if strings.Contains(ip, ":") {
+		ip = "[" + ip + "]"
+	}
+	s := ip + ":" + port
+	if protocol != "" {
+		s += "/" + protocol
+	}
+	return s
+}
+
+// from 会话的远程地址
+func from(addr, port string) string {
+	if addr == "" {
+		return ""
+	}
+	if port == "" || port == "0" {
+		return "from " + addr
+	}
+	return "from " + endpoint(addr, port, "")
+}
+
+// exitText 退出状态，被信号终止时显示信号
+func exitText(info audit.ExitInfo) string {
+	if info.Signal != 0 {
+		return fmt.Sprintf("signal %d", info.Signal)
+	}
+	return fmt.Sprintf("exit %d", info.ExitCode)
+}
+
+// duration 格式化详情中的毫秒数
+func duration(ms interface{}) string {
+	n, ok := ms.(float64)
+	if !ok {
+		return ""
+	}
+	return (time.Duration(n) * time.Millisecond).String()
+}
+
+// byteSize 格式化详情中的字节数，例如 1.5KB
+func byteSize(v interface{}) string {
+	n, _ := v.(float64)
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", int64(n))
+	}
+	exp := 0
+	for n >= unit*unit && exp < 3 {
+		n /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%cB", n/unit, "KMGT"[exp])
+}
+
+// ansiCQuote 转义 $'...' 中的反斜杠和单引号
+var ansiCQuote = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
+// quoteArgs 为含空格或特殊字符的参数加单引号，含不可打印字符的参数使用 $'...' 并转义，
+// 例如 $'\x1b[2J'，可以直接粘贴到 shell 中
+func quoteArgs(args []string) []string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		switch {
+		case !printable(a):
+			quoted[i] = "$'" + escape(ansiCQuote.Replace(a)) + "'"
+		case a != "" && !strings.ContainsAny(a, " \t\n'\"\\$`|&;<>()*?[]{}~#!"):
+			quoted[i] = a
+		default:
+			quoted[i] = "'" + strings.ReplaceAll(a, "'", `'\''`) + "'"
+		}
+	}
+	return quoted
+}
+
+// labeled 为非空的值加上名称
+func labeled(label, v string) string {
+	if v == "" {
+		return ""
+	}
+	return label + " " + v
+}
+
+// quoted 为详情中非空的字符串加双引号，不可打印字符按 Go 字符串的形式转义
+func quoted(v interface{}) string {
+	s, _ := v.(string)
+	if s == "" {
+		return ""
+	}
+	return strconv.Quote(s)
+}
+
+// joinNonEmpty 以空格连接非空字符串
+func joinNonEmpty(parts ...string) string {
+	var nonEmpty []string
+	for _, p := range parts {
+		if p != "" {
+			nonEmpty = append(nonEmpty, p)
+		}
+	}
+	return strings.Join(nonEmpty, " ")
+}
+
+// stringList 详情中的字符串数组
+func stringList(v interface{}) []string {
+	list, _ := v.([]interface{})
+	var s []string
+	for _, item := range list {
+		s = append(s, value(item))
+	}
+	return s
+}
+
+// jsonText 紧凑的 JSON 形式。JSON 不转义 DEL 和 C1 控制字符，这里一并转义
+func jsonText(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return escape(fmt.Sprint(v))
+	}
+	return escape(string(data))
+}
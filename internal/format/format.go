@@ -0,0 +1,339 @@
+// Package format 将审计事件格式化为便于阅读的表格、单行和详细视图。事件中的文本可能由被审计的用户控制，
+// 除 JSON 外的视图都转义其中的控制字符，避免在查看者的终端上执行转义序列
+package format
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/cevin/shell-auditor/internal/audit"
+	"github.com/cevin/shell-auditor/internal/tty"
+)
+
+// Style 输出格式
+type Style string
+
+const (
+	StyleTable    Style = "table"    // 表格，每个事件一行
+	StyleCompact  Style = "compact"  // 单行描述，例如 12:00:01 alice ls -la /tmp [exit 0]
+	StyleDetailed Style = "detailed" // 每个事件多行，列出所有字段
+	StyleJSON     Style = "json"     // 每行一条 JSON 事件，与日志文件格式相同
+)
+
+// Styles 所有支持的输出格式
+var Styles = []Style{StyleTable, StyleCompact, StyleDetailed, StyleJSON}
+
+// ParseStyle 解析输出格式名称
+func ParseStyle(name string) (Style, error) {
+	for _, s := range Styles {
+		if string(s) == name {
+			return s, nil
+		}
+	}
+	return "", fmt.Errorf("unknown format %q, expected one of table, compact, detailed, json", name)
+}
+
+// MergesExits 表格和单行视图把 command_exit 合并到命令中显示，查询时应设置 audit.Query.MergeExits
+func (s Style) MergesExits() bool {
+	return s == StyleTable || s == StyleCompact
+}
+
+// ColorEnabled 判断输出是否使用颜色：只有输出为终端、且没有设置 NO_COLOR、TERM 不为 dumb 时使用
+func ColorEnabled(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok || !tty.IsTerminal(f) {
+		return false
+	}
+	_, noColor := os.LookupEnv("NO_COLOR")
+	return !noColor && os.Getenv("TERM") != "dumb"
+}
+
+// ANSI 颜色
+const (
+	colorReset   = "\x1b[0m"
+	colorBold    = "\x1b[1m"
+	colorDim     = "\x1b[2m"
+	colorRed     = "\x1b[31m"
+	colorGreen   = "\x1b[32m"
+	colorYellow  = "\x1b[33m"
+	colorBlue    = "\x1b[34m"
+	colorMagenta = "\x1b[35m"
+	colorCyan    = "\x1b[36m"
+)
+
+// Formatter 审计事件格式化器
+type Formatter struct {
+	Style Style
+	Color bool
+	// Exits 命令事件ID到退出信息，见 audit.QueryResult。事件中的 command_exit 也会被合并，
+	// 表格和单行视图中在命令后显示退出状态，并省略已合并的 command_exit 事件
+	Exits map[string]audit.ExitInfo
+}
+
+// New 创建格式化器
+func New(style Style, color bool) *Formatter {
+	return &Formatter{Style: style, Color: color}
+}
+
+// Write 按格式输出事件
+func (f *Formatter) Write(w io.Writer, events []audit.AuditEvent) error {
+	if f.Style == StyleJSON {
+		for i := range events {
+			data, err := events[i].ToJSON()
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintln(w, string(data)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	exits, merged := f.collectExits(events)
+	switch f.Style {
+	case StyleDetailed:
+		for i := range events {
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			f.writeDetailed(w, &events[i], exits)
+		}
+	case StyleCompact:
+		for i := range events {
+			e := &events[i]
+			if merged[e.ID] {
+				continue
+			}
+			fmt.Fprintf(w, "%s %s %s\n", f.paint(colorDim, compactTime(e.Timestamp)), f.paint(colorCyan, user(e)), f.describe(e, exits))
+		}
+	default:
+		f.writeTable(w, events, exits, merged)
+	}
+	return nil
+}
+
+// collectExits 合并 Exits 和事件中的 command_exit，返回退出信息和已合并到命令的 command_exit 事件ID
+func (f *Formatter) collectExits(events []audit.AuditEvent) (map[string]audit.ExitInfo, map[string]bool) {
+	exits := make(map[string]audit.ExitInfo, len(f.Exits))
+	for id, info := range f.Exits {
+		exits[id] = info
+	}
+	commands := make(map[string]bool)
+	for i := range events {
+		if events[i].Type == audit.EventCommand {
+			commands[events[i].ID] = true
+		}
+	}
+
+	merged := make(map[string]bool)
+	for i := range events {
+		e := &events[i]
+		if e.Type != audit.EventCommandExit {
+			continue
+		}
+		if d, ok := e.ExitDetails(); ok && commands[d.CommandID] {
+			exits[d.CommandID] = d.ExitInfo
+			merged[e.ID] = true
+		}
+	}
+	return exits, merged
+}
+
+// writeTable 输出表格。列宽按可见字符计算，因此带颜色时也能对齐
+func (f *Formatter) writeTable(w io.Writer, events []audit.AuditEvent, exits map[string]audit.ExitInfo, merged map[string]bool) {
+	type cell struct{ text, color string }
+	rows := [][]cell{{{"TIME", colorBold}, {"TYPE", colorBold}, {"USER", colorBold}, {"PID", colorBold}, {"SUMMARY", colorBold}}}
+	for i := range events {
+		e := &events[i]
+		if merged[e.ID] {
+			continue
+		}
+		rows = append(rows, []cell{
+			{e.Timestamp.Local().Format("2006-01-02 15:04:05"), colorDim},
+			{escape(string(e.Type)), typeColor(e)},
+			{user(e), colorCyan},
+			{strconv.Itoa(e.PID), ""},
+			{f.describe(e, exits), ""},
+		})
+	}
+
+	widths := make([]int, len(rows[0])-1)
+	for _, row := range rows {
+		for i := range widths {
+			if n := utf8.RuneCountInString(row[i].text); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+	for _, row := range rows {
+		var b strings.Builder
+		for i, c := range row {
+			b.WriteString(f.paint(c.color, c.text))
+			if i < len(widths) {
+				b.WriteString(strings.Repeat(" ", widths[i]-utf8.RuneCountInString(c.text)+2))
+			}
+		}
+		fmt.Fprintln(w, b.String())
+	}
+}
+
+// writeDetailed 输出一个事件的所有字段，详情中的字段按名称排序
+func (f *Formatter) writeDetailed(w io.Writer, e *audit.AuditEvent, exits map[string]audit.ExitInfo) {
+	fmt.Fprintf(w, "%s %s %s\n", f.paint(colorDim, e.Timestamp.Local().Format("2006-01-02 15:04:05.000")),
+		f.paint(typeColor(e)+colorBold, escape(string(e.Type))), f.paint(colorDim, escape(e.ID)))
+
+	field := func(name string, value interface{}) {
+		fmt.Fprintf(w, "  %-10s %v\n", name+":", value)
+	}
+	field("summary", f.describe(e, exits))
+	field("user", fmt.Sprintf("%s (uid %d, gid %d)", f.paint(colorCyan, user(e)), e.UID, e.GID))
+	field("process", fmt.Sprintf("%d (ppid %d)", e.PID, e.PPID))
+	if e.SessionID != "" {
+		field("session", escape(e.SessionID))
+	}
+	if e.Command != "" {
+		field("command", escape(e.Command))
+	}
+	if e.Executable != "" {
+		field("executable", escape(e.Executable))
+	}
+	if len(e.Args) > 0 {
+		field("args", strings.Join(quoteArgs(e.Args), " "))
+	}
+	if e.Truncated {
+		field("truncated", true)
+	}
+	if e.WorkingDir != "" {
+		field("cwd", escape(e.WorkingDir))
+	}
+	if e.RawLine != "" {
+		field("raw_line", escape(e.RawLine))
+	}
+	if e.Alias != "" {
+		field("alias", escape(e.Alias))
+	}
+	if e.PipelineID != "" {
+		field("pipeline", escape(e.PipelineID))
+	}
+	if info, ok := exits[e.ID]; ok {
+		field("exit", exitText(info))
+	}
+
+	details := e.DetailsMap()
+	keys := make([]string, 0, len(details))
+	for k := range details {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "  %-10s %s = %s\n", "details:", escape(k), value(details[k]))
+	}
+
+	if len(e.Lineage) > 0 {
+		parents := make([]string, len(e.Lineage))
+		for i, p := range e.Lineage {
+			parents[i] = fmt.Sprintf("%s(%d)", escape(p.Comm), p.PID)
+		}
+		field("lineage", strings.Join(parents, " <- "))
+	}
+}
+
+// paint 为文本加上颜色，不使用颜色时原样返回
+func (f *Formatter) paint(color, s string) string {
+	if !f.Color || color == "" || s == "" {
+		return s
+	}
+	return color + s + colorReset
+}
+
+// typeColor 事件类型的颜色
+func typeColor(e *audit.AuditEvent) string {
+	switch e.Type {
+	case audit.EventPolicyViolation, audit.EventAuditCleared:
+		return colorRed
+	case audit.EventNetwork, audit.EventAccept, audit.EventConnectionClose, audit.EventPortOpen, audit.EventPortClosed, audit.EventDNS:
+		return colorYellow
+	case audit.EventFile:
+		return colorMagenta
+	case audit.EventSessionStart, audit.EventSessionEnd:
+		return colorBlue
+	case audit.EventCommand, audit.EventBuiltin:
+		return colorGreen
+	}
+	return ""
+}
+
+// compactTime 单行视图中的时间，当天的事件只显示时间
+func compactTime(t time.Time) string {
+	t = t.Local()
+	now := time.Now()
+	if t.Year() == now.Year() && t.YearDay() == now.YearDay() {
+		return t.Format("15:04:05")
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+// user 事件的用户，用户名未知时显示 UID
+func user(e *audit.AuditEvent) string {
+	if e.Username != "" {
+		return escape(e.Username)
+	}
+	return strconv.Itoa(e.UID)
+}
+
+// value 格式化详情中的值，字符串不加引号，其余使用 JSON 形式
+func value(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return escape(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		return ""
+	}
+	return jsonText(v)
+}
+
+// escape 以 strconv.Quote 的形式转义控制字符（C0、DEL、C1）、其他不可打印字符和无效的 UTF-8，
+// 例如 ESC 显示为 \x1b，不加引号
+func escape(s string) string {
+	if printable(s) {
+		return s
+	}
+	var b strings.Builder
+	for len(s) > 0 {
+		r, size := utf8.DecodeRuneInString(s)
+		switch {
+		case r == utf8.RuneError && size == 1:
+			fmt.Fprintf(&b, `\x%02x`, s[0])
+		case unicode.IsPrint(r):
+			b.WriteString(s[:size])
+		default:
+			q := strconv.QuoteRune(r)
+			b.WriteString(q[1 : len(q)-1])
+		}
+		s = s[size:]
+	}
+	return b.String()
+}
+
+// printable 判断字符串是否只包含可打印字符
+func printable(s string) bool {
+	if !utf8.ValidString(s) {
+		return false
+	}
+	for _, r := range s {
+		if !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,56 @@
+package format
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"unicode"
+
+	"github.com/cevin/shell-auditor/internal/audit"
+)
+
+func TestQuoteArgs(t *testing.T) {
+	for _, tt := range []struct{ arg, want string }{
+		{"ls", "ls"},
+		{"", "''"},
+		{"a b", "'a b'"},
+		{"it's", `'it'\''s'`},
+		{"\x1b[2J", `$'\x1b[2J'`},
+		{"a\nb", `$'a\nb'`},
+		{"it's\x07", `$'it\'s\a'`},
+		{`\` + "\x7f", `$'\\\x7f'`},
+		{"\u009b", `$'\u009b'`},
+		{"\u202e", `$'\u202e'`},
+		{"\xff", `$'\xff'`},
+		{"中文", "中文"},
+	} {
+		if got := quoteArgs([]string{tt.arg})[0]; got != tt.want {
+			t.Errorf("quoteArgs(%q) = %s, want %s", tt.arg, got, tt.want)
+		}
+	}
+}
+
+// TestWriteEscapes 除 JSON 外的视图都不输出事件中的控制字符
+func TestWriteEscapes(t *testing.T) {
+	evil := "x\x1b]0;title\x07\u009b2J\x7f"
+	events := []audit.AuditEvent{
+		{ID: evil, Type: audit.EventCommand, Username: evil, Command: evil, Args: []string{evil}, WorkingDir: evil, RawLine: evil},
+		{ID: "e2", Type: audit.EventDNS, Command: evil, Details: map[string]interface{}{"domain": evil, "answers": []interface{}{evil}}},
+		{ID: "e3", Type: audit.EventSessionStart, Details: map[string]interface{}{"command": evil, evil: evil}},
+		{ID: "e4", Type: audit.EventType(evil), Details: map[string]interface{}{"k": evil}},
+	}
+
+	for _, style := range []Style{StyleTable, StyleCompact, StyleDetailed} {
+		var buf bytes.Buffer
+		if err := New(style, false).Write(&buf, events); err != nil {
+			t.Fatal(err)
+		}
+		out := buf.String()
+		if i := strings.IndexFunc(out, func(r rune) bool { return r != '\n' && !unicode.IsPrint(r) }); i >= 0 {
+			t.Errorf("%s output contains %q:\n%s", style, out[i:i+1], out)
+		}
+		if !strings.Contains(out, `\x1b]0;title`) {
+			t.Errorf("%s output does not show the escaped text:\n%s", style, out)
+		}
+	}
+}
@@ -0,0 +1,593 @@
+// Package lineedit 提供原始模式下的行编辑：光标移动、历史浏览、反向搜索和补全
+package lineedit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/cevin/shell-auditor/internal/tty"
+)
+
+// ErrInterrupted 用户按下 Ctrl-C 放弃当前输入
+var ErrInterrupted = errors.New("interrupted")
+
+// escTimeout 单独按下 ESC 与转义序列的区分时间
+const escTimeout = 50 * time.Millisecond
+
+// Completer 补全光标前的文本 head，返回被补全单词在 head 中的起始位置和候选项（完整单词）
+type Completer func(head string) (start int, candidates []string)
+
+// Editor 行编辑器，in 必须是终端
+type Editor struct {
+	in  *os.File
+	out io.Writer
+
+	// History 历史，为 nil 时不能浏览历史
+	History *History
+	// Complete 按 Tab 时调用，为 nil 时不补全
+	Complete Completer
+	// OnInput 读取到的原始输入，用于会话录制
+	OnInput func([]byte)
+
+	pending []byte // 已读取尚未处理的输入
+}
+
+// New 创建行编辑器
+func New(in *os.File, out io.Writer) *Editor {
+	return &Editor{in: in, out: out}
+}
+
+// 特殊按键
+type key int
+
+const (
+	keyNone key = iota
+	keyUp
+	keyDown
+	keyLeft
+	keyRight
+	keyHome
+	keyEnd
+	keyDelete
+	keyWordLeft
+	keyWordRight
+	keyEsc
+	keyUnknown
+)
+
+// 控制字符
+const (
+	ctrlA     = 0x01
+	ctrlB     = 0x02
+	ctrlC     = 0x03
+	ctrlD     = 0x04
+	ctrlE     = 0x05
+	ctrlF     = 0x06
+	ctrlG     = 0x07
+	ctrlH     = 0x08
+	tab       = 0x09
+	ctrlJ     = 0x0a
+	ctrlK     = 0x0b
+	ctrlL     = 0x0c
+	enter     = 0x0d
+	ctrlN     = 0x0e
+	ctrlP     = 0x10
+	ctrlR     = 0x12
+	ctrlU     = 0x15
+	ctrlW     = 0x17
+	esc       = 0x1b
+	backspace = 0x7f
+)
+
+// state 一次 ReadLine 的编辑状态
+type state struct {
+	e       *Editor
+	prompt  string
+	buf     []rune
+	pos     int
+	history []string
+	histIdx int    // 正在浏览的历史，等于 len(history) 表示正在编辑的新行
+	draft   []rune // 浏览历史前正在编辑的内容
+	lastTab bool   // 上一个按键是否为 Tab
+
+	searching bool
+	query     []rune
+	match     int // 反向搜索命中的历史
+	failed    bool
+	orig      []rune // 开始搜索前的内容
+	origPos   int
+}
+
+// ReadLine 显示提示符并读取一行。Ctrl-D 在空行上返回 io.EOF，Ctrl-C 返回 ErrInterrupted
+func (e *Editor) ReadLine(prompt string) (string, error) {
+	saved, err := tty.MakeRaw(e.in)
+	if err != nil {
+		return "", fmt.Errorf("failed to set raw mode: %w", err)
+	}
+	defer tty.SetState(e.in, saved)
+
+	s := &state{e: e, prompt: prompt}
+	if e.History != nil {
+		s.history = e.History.Entries()
+	}
+	s.histIdx = len(s.history)
+	s.refresh()
+
+	for {
+		r, k, err := e.readKey()
+		if err != nil {
+			return "", err
+		}
+
+		if s.searching && s.searchKey(r, k) {
+			continue
+		}
+		tabbed := s.lastTab
+		s.lastTab = false
+
+		switch {
+		case k == keyLeft || (k == keyNone && r == ctrlB):
+			if s.pos > 0 {
+				s.pos--
+			}
+		case k == keyRight || (k == keyNone && r == ctrlF):
+			if s.pos < len(s.buf) {
+				s.pos++
+			}
+		case k == keyHome || (k == keyNone && r == ctrlA):
+			s.pos = 0
+		case k == keyEnd || (k == keyNone && r == ctrlE):
+			s.pos = len(s.buf)
+		case k == keyWordLeft:
+			s.pos = s.wordStart()
+		case k == keyWordRight:
+			for s.pos < len(s.buf) && s.buf[s.pos] == ' ' {
+				s.pos++
+			}
+			for s.pos < len(s.buf) && s.buf[s.pos] != ' ' {
+				s.pos++
+			}
+		case k == keyUp || (k == keyNone && r == ctrlP):
+			s.historyPrev()
+		case k == keyDown || (k == keyNone && r == ctrlN):
+			s.historyNext()
+		case k == keyDelete:
+			s.deleteAt()
+		case k != keyNone:
+			// 不支持的按键
+			continue
+
+		case r == enter || r == ctrlJ:
+			s.pos = len(s.buf)
+			s.refresh()
+			e.write("\r\n")
+			return string(s.buf), nil
+		case r == ctrlC:
+			e.write("^C\r\n")
+			return "", ErrInterrupted
+		case r == ctrlD:
+			if len(s.buf) == 0 {
+				e.write("\r\n")
+				return "", io.EOF
+			}
+			s.deleteAt()
+		case r == backspace || r == ctrlH:
+			if s.pos > 0 {
+				s.buf = append(s.buf[:s.pos-1], s.buf[s.pos:]...)
+				s.pos--
+			}
+		case r == ctrlK:
+			s.buf = s.buf[:s.pos]
+		case r == ctrlU:
+			s.buf = append([]rune(nil), s.buf[s.pos:]...)
+			s.pos = 0
+		case r == ctrlW:
+			start := s.wordStart()
+			s.buf = append(s.buf[:start], s.buf[s.pos:]...)
+			s.pos = start
+		case r == ctrlL:
+			e.write("\x1b[H\x1b[2J")
+		case r == ctrlR:
+			s.startSearch()
+		case r == tab:
+			s.complete(tabbed)
+			s.lastTab = true
+		case r < 0x20:
+			// 其他控制字符忽略
+			continue
+		default:
+			s.insert([]rune{r})
+		}
+		s.refresh()
+	}
+}
+
+// write 输出到终端
+func (e *Editor) write(s string) {
+	io.WriteString(e.out, s)
+}
+
+// readByte 读取一个字节，timeout 大于 0 时超时返回 false
+func (e *Editor) readByte(timeout time.Duration) (byte, bool, error) {
+	if len(e.pending) == 0 {
+		if timeout > 0 {
+			fds := []unix.PollFd{{Fd: int32(e.in.Fd()), Events: unix.POLLIN}}
+			n, err := unix.Poll(fds, int(timeout/time.Millisecond))
+			if err != nil && err != unix.EINTR {
+				return 0, false, err
+			}
+			if n <= 0 {
+				return 0, false, nil
+			}
+		}
+
+		buf := make([]byte, 256)
+		n, err := e.in.Read(buf)
+		if n > 0 && e.OnInput != nil {
+			e.OnInput(buf[:n])
+		}
+		if n == 0 {
+			if err == nil {
+				err = io.EOF
+			}
+			return 0, false, err
+		}
+		e.pending = buf[:n]
+	}
+	b := e.pending[0]
+	e.pending = e.pending[1:]
+	return b, true, nil
+}
+
+// readKey 读取一个按键，返回字符或特殊按键
+func (e *Editor) readKey() (rune, key, error) {
+	b, _, err := e.readByte(0)
+	if err != nil {
+		return 0, keyNone, err
+	}
+
+	if b == esc {
+		return 0, e.readEscape(), nil
+	}
+	if b < utf8.RuneSelf {
+		return rune(b), keyNone, nil
+	}
+
+	// 多字节 UTF-8 字符
+	seq := []byte{b}
+	for !utf8.FullRune(seq) {
+		b, ok, err := e.readByte(escTimeout)
+		if err != nil {
+			return 0, keyNone, err
+		}
+		if !ok {
+			break
+		}
+		seq = append(seq, b)
+	}
+	r, _ := utf8.DecodeRune(seq)
+	return r, keyNone, nil
+}
+
+// readEscape 解析 ESC 之后的转义序列
+func (e *Editor) readEscape() key {
+	b, ok, err := e.readByte(escTimeout)
+	if err != nil || !ok {
+		return keyEsc
+	}
+
+	switch b {
+	case 'b':
+		return keyWordLeft
+	case 'f':
+		return keyWordRight
+	case 'O':
+		if b, ok, _ = e.readByte(escTimeout); ok {
+			return finalKey(b)
+		}
+		return keyUnknown
+	case '[':
+	default:
+		return keyUnknown
+	}
+
+	// CSI 序列：参数字节之后是 0x40-0x7e 之间的结束字节
+	var params []byte
+	for {
+		b, ok, err = e.readByte(escTimeout)
+		if err != nil || !ok {
+			return keyUnknown
+		}
+		if b >= 0x40 && b <= 0x7e {
+			break
+		}
+		params = append(params, b)
+	}
+	if b != '~' {
+		return finalKey(b)
+	}
+	switch string(params) {
+	case "1", "7":
+		return keyHome
+	case "4", "8":
+		return keyEnd
+	case "3":
+		return keyDelete
+	}
+	return keyUnknown
+}
+
+// finalKey 转义序列结束字节对应的按键
+func finalKey(b byte) key {
+	switch b {
+	case 'A':
+		return keyUp
+	case 'B':
+		return keyDown
+	case 'C':
+		return keyRight
+	case 'D':
+		return keyLeft
+	case 'H':
+		return keyHome
+	case 'F':
+		return keyEnd
+	}
+	return keyUnknown
+}
+
+// refresh 重绘当前行，光标移动到编辑位置
+func (s *state) refresh() {
+	var b strings.Builder
+	b.WriteString("\r")
+	if s.searching {
+		if s.failed {
+			b.WriteString("(failed ")
+		} else {
+			b.WriteString("(")
+		}
+		fmt.Fprintf(&b, "reverse-i-search)`%s': ", string(s.query))
+	} else {
+		b.WriteString(s.prompt)
+	}
+	b.WriteString(string(s.buf))
+	b.WriteString("\x1b[K")
+	if w := width(s.buf[s.pos:]); w > 0 {
+		fmt.Fprintf(&b, "\x1b[%dD", w)
+	}
+	s.e.write(b.String())
+}
+
+// insert 在光标处插入文本
+func (s *state) insert(rs []rune) {
+	buf := make([]rune, 0, len(s.buf)+len(rs))
+	buf = append(buf, s.buf[:s.pos]...)
+	buf = append(buf, rs...)
+	s.buf = append(buf, s.buf[s.pos:]...)
+	s.pos += len(rs)
+}
+
+// deleteAt 删除光标处的字符
+func (s *state) deleteAt() {
+	if s.pos < len(s.buf) {
+		s.buf = append(s.buf[:s.pos], s.buf[s.pos+1:]...)
+	}
+}
+
+// wordStart 返回光标前一个单词的起始位置
+func (s *state) wordStart() int {
+	i := s.pos
+	for i > 0 && s.buf[i-1] == ' ' {
+		i--
+	}
+	for i > 0 && s.buf[i-1] != ' ' {
+		i--
+	}
+	return i
+}
+
+// historyPrev 显示上一条历史
+func (s *state) historyPrev() {
+	if s.histIdx == 0 {
+		return
+	}
+	if s.histIdx == len(s.history) {
+		s.draft = s.buf
+	}
+	s.histIdx--
+	s.buf = []rune(s.history[s.histIdx])
+	s.pos = len(s.buf)
+}
+
+// historyNext 显示下一条历史，越过最新一条时恢复正在编辑的内容
+func (s *state) historyNext() {
+	if s.histIdx == len(s.history) {
+		return
+	}
+	s.histIdx++
+	if s.histIdx == len(s.history) {
+		s.buf = s.draft
+	} else {
+		s.buf = []rune(s.history[s.histIdx])
+	}
+	s.pos = len(s.buf)
+}
+
+// startSearch 开始反向搜索历史
+func (s *state) startSearch() {
+	s.searching = true
+	s.query = nil
+	s.failed = false
+	s.match = len(s.history)
+	s.orig, s.origPos = s.buf, s.pos
+}
+
+// search 从第 from 条历史开始向前查找包含搜索词的历史
+func (s *state) search(from int) {
+	q := string(s.query)
+	for i := from; i >= 0 && i < len(s.history); i-- {
+		if idx := strings.Index(s.history[i], q); idx >= 0 {
+			s.match = i
+			s.buf = []rune(s.history[i])
+			s.pos = utf8.RuneCountInString(s.history[i][:idx])
+			s.failed = false
+			return
+		}
+	}
+	s.failed = true
+}
+
+// searchKey 处理搜索模式下的按键，返回 false 表示退出搜索并按普通按键处理
+func (s *state) searchKey(r rune, k key) bool {
+	switch {
+	case k == keyNone && r == ctrlR:
+		if len(s.query) > 0 {
+			s.search(s.match - 1)
+		}
+	case k == keyNone && (r == ctrlG || r == ctrlC):
+		s.searching = false
+		s.buf, s.pos = s.orig, s.origPos
+	case k == keyNone && (r == backspace || r == ctrlH):
+		if len(s.query) > 0 {
+			s.query = s.query[:len(s.query)-1]
+			s.search(len(s.history) - 1)
+		}
+	case k == keyNone && r >= 0x20:
+		s.query = append(s.query, r)
+		s.search(min(s.match, len(s.history)-1))
+	case k == keyEsc:
+		s.searching = false
+	default:
+		// 其他按键结束搜索，保留命中的历史
+		s.searching = false
+		s.histIdx = len(s.history)
+		return false
+	}
+	s.refresh()
+	return true
+}
+
+// complete 补全光标前的单词。只有一个候选项时直接补全；有多个时补全公共前缀，
+// 无法继续补全时再按一次 Tab 列出所有候选项
+func (s *state) complete(listAll bool) {
+	if s.e.Complete == nil {
+		return
+	}
+	head := string(s.buf[:s.pos])
+	start, candidates := s.e.Complete(head)
+	if len(candidates) == 0 || start < 0 || start > len(head) {
+		s.e.write("\a")
+		return
+	}
+
+	word := head[start:]
+	replace := commonPrefix(candidates)
+	if len(candidates) == 1 && !strings.HasSuffix(replace, "/") {
+		replace += " "
+	}
+	if len(replace) > len(word) {
+		rest := s.buf[s.pos:]
+		s.buf = append([]rune(head[:start]+replace), rest...)
+		s.pos = utf8.RuneCountInString(head[:start] + replace)
+		return
+	}
+
+	if !listAll {
+		s.e.write("\a")
+		return
+	}
+	s.list(word, candidates)
+}
+
+// list 在当前行下方按列显示候选项
+func (s *state) list(word string, candidates []string) {
+	// 路径只显示最后一级
+	trim := strings.LastIndex(word, "/") + 1
+	names := make([]string, len(candidates))
+	colWidth := 0
+	for i, c := range candidates {
+		names[i] = c
+		if trim <= len(c) {
+			names[i] = c[trim:]
+		}
+		if w := width([]rune(names[i])); w > colWidth {
+			colWidth = w
+		}
+	}
+	sort.Strings(names)
+	colWidth += 2
+
+	termWidth, _ := tty.Size(s.e.in)
+	cols := termWidth / colWidth
+	if cols < 1 {
+		cols = 1
+	}
+	rows := (len(names) + cols - 1) / cols
+
+	var b strings.Builder
+	b.WriteString("\r\n")
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			i := col*rows + row
+			if i >= len(names) {
+				break
+			}
+			b.WriteString(names[i])
+			if col < cols-1 {
+				b.WriteString(strings.Repeat(" ", colWidth-width([]rune(names[i]))))
+			}
+		}
+		b.WriteString("\r\n")
+	}
+	s.e.write(b.String())
+}
+
+// commonPrefix 返回所有字符串的最长公共前缀
+func commonPrefix(list []string) string {
+	prefix := list[0]
+	for _, s := range list[1:] {
+		for !strings.HasPrefix(s, prefix) {
+			_, size := utf8.DecodeLastRuneInString(prefix)
+			prefix = prefix[:len(prefix)-size]
+		}
+	}
+	return prefix
+}
+
+// width 返回文本在终端中占用的列数
+func width(rs []rune) int {
+	w := 0
+	for _, r := range rs {
+		w += runeWidth(r)
+	}
+	return w
+}
+
+// runeWidth 返回字符占用的列数：组合字符为 0，东亚宽字符为 2
+func runeWidth(r rune) int {
+	switch {
+	case unicode.Is(unicode.Mn, r) || r == 0x200b:
+		return 0
+	case r >= 0x1100 && r <= 0x115f,
+		r >= 0x2e80 && r <= 0xa4cf && r != 0x303f,
+		r >= 0xac00 && r <= 0xd7a3,
+		r >= 0xf900 && r <= 0xfaff,
+		r >= 0xfe30 && r <= 0xfe4f,
+		r >= 0xff00 && r <= 0xff60,
+		r >= 0xffe0 && r <= 0xffe6,
+		r >= 0x1f300 && r <= 0x1f64f,
+		r >= 0x1f900 && r <= 0x1f9ff,
+		r >= 0x20000 && r <= 0x3fffd:
+		return 2
+	}
+	return 1
+}
@@ -0,0 +1,135 @@
+package lineedit
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// History 命令历史，可以持久化到文件
+type History struct {
+	mu      sync.Mutex
+	entries []string
+	max     int
+	file    *os.File // 追加写入，为 nil 时不持久化
+}
+
+// NewHistory 创建只保存在内存中的历史，最多保留 max 条
+func NewHistory(max int) *History {
+	return &History{max: max}
+}
+
+// OpenHistory 加载并打开历史文件，新的历史会追加到文件中。历史中可能包含敏感信息，
+// 文件权限为 0600，不跟随符号链接，并且必须属于当前用户
+func OpenHistory(path string, max int) (*History, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create history directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND|syscall.O_NOFOLLOW, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history file: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to stat history file: %w", err)
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok && int(st.Uid) != os.Getuid() {
+		file.Close()
+		return nil, fmt.Errorf("history file %s is not owned by the current user", path)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		if err := file.Chmod(0600); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to protect history file: %w", err)
+		}
+	}
+
+	h := &History{max: max}
+	total := 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			h.add(line)
+			total++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read history file: %w", err)
+	}
+
+	// 文件中的历史远多于保留条数时重写文件，避免文件无限增长
+	if total > 2*max {
+		if err := h.rewrite(file); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	h.file = file
+	return h, nil
+}
+
+// Add 添加一条历史，与上一条相同时忽略
+func (h *History) Add(line string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	line = strings.TrimRight(line, "\n")
+	if !h.add(line) || h.file == nil {
+		return
+	}
+	h.file.WriteString(line + "\n")
+}
+
+// add 添加到内存中的历史，返回是否添加
+func (h *History) add(line string) bool {
+	line = strings.TrimRight(line, "\n")
+	if line == "" || (len(h.entries) > 0 && h.entries[len(h.entries)-1] == line) {
+		return false
+	}
+	h.entries = append(h.entries, line)
+	if len(h.entries) > h.max {
+		h.entries = h.entries[len(h.entries)-h.max:]
+	}
+	return true
+}
+
+// rewrite 用内存中的历史重写文件
+func (h *History) rewrite(file *os.File) error {
+	var b strings.Builder
+	for _, e := range h.entries {
+		b.WriteString(e + "\n")
+	}
+	if err := file.Truncate(0); err != nil {
+		return fmt.Errorf("failed to compact history file: %w", err)
+	}
+	if _, err := file.WriteString(b.String()); err != nil {
+		return fmt.Errorf("failed to compact history file: %w", err)
+	}
+	return nil
+}
+
+// Entries 返回历史的副本，按时间从旧到新排列
+func (h *History) Entries() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string(nil), h.entries...)
+}
+
+// Close 关闭历史文件
+func (h *History) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.file == nil {
+		return nil
+	}
+	err := h.file.Close()
+	h.file = nil
+	return err
+}
@@ -0,0 +1,144 @@
+package lineedit
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// openHistory 打开历史文件，失败时结束测试
+func openHistory(t *testing.T, path string, max int) *History {
+	t.Helper()
+	h, err := OpenHistory(path, max)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.Close() })
+	return h
+}
+
+func TestHistoryLoadAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", "history")
+	h := openHistory(t, path, 10)
+	for _, line := range []string{"ls", "ls", "", "cd /tmp\n", "ls"} {
+		h.Add(line)
+	}
+	want := []string{"ls", "cd /tmp", "ls"}
+	if got := h.Entries(); !reflect.DeepEqual(got, want) {
+		t.Errorf("entries = %q, want %q", got, want)
+	}
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+	h.Add("after close")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "ls\ncd /tmp\nls\n" {
+		t.Errorf("file = %q", data)
+	}
+
+	h = openHistory(t, path, 2)
+	if got := h.Entries(); !reflect.DeepEqual(got, want[1:]) {
+		t.Errorf("loaded entries = %q, want %q", got, want[1:])
+	}
+	h.Add("pwd")
+	h.Close()
+	if got := openHistory(t, path, 10).Entries(); !reflect.DeepEqual(got, append(want, "pwd")) {
+		t.Errorf("reloaded entries = %q", got)
+	}
+}
+
+// TestHistoryCompact 文件中的历史超过保留条数的两倍时重写文件
+func TestHistoryCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	var lines []string
+	for i := 0; i < 7; i++ {
+		lines = append(lines, strings.Repeat("x", i+1))
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	h := openHistory(t, path, 3)
+	h.Add("new")
+	h.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "xxxxx\nxxxxxx\nxxxxxxx\nnew\n"; string(data) != want {
+		t.Errorf("file = %q, want %q", data, want)
+	}
+}
+
+func TestHistoryFileMode(t *testing.T) {
+	dir := t.TempDir()
+
+	created := filepath.Join(dir, "created")
+	openHistory(t, created, 10)
+
+	existing := filepath.Join(dir, "existing")
+	if err := os.WriteFile(existing, []byte("ls\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(existing, 0644); err != nil {
+		t.Fatal(err)
+	}
+	openHistory(t, existing, 10)
+
+	for _, path := range []string{created, existing} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := info.Mode().Perm(); perm != 0600 {
+			t.Errorf("%s: mode = %o, want 600", filepath.Base(path), perm)
+		}
+	}
+}
+
+// TestHistorySymlink 不跟随符号链接，避免历史被写入其他文件
+func TestHistorySymlink(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "target")
+	if err := os.WriteFile(target, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "history")
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+
+	if h, err := OpenHistory(link, 10); err == nil {
+		h.Close()
+		t.Fatal("history file behind a symlink was opened")
+	}
+}
+
+func TestHistoryOwner(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("changing the file owner requires root")
+	}
+	path := filepath.Join(t.TempDir(), "history")
+	if err := os.WriteFile(path, []byte("ls\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chown(path, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := OpenHistory(path, 10)
+	if err == nil {
+		h.Close()
+		t.Fatal("history file owned by another user was opened")
+	}
+	if !strings.Contains(err.Error(), "not owned by the current user") {
+		t.Errorf("error = %v", err)
+	}
+}
@@ -0,0 +1,316 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPath 默认策略文件路径
+const DefaultPath = "/etc/shell-auditor/policy.json"
+
+// Action 策略动作
+type Action string
+
+const (
+	ActionAllow   Action = "allow"   // 直接执行
+	ActionDeny    Action = "deny"    // 拒绝执行
+	ActionWarn    Action = "warn"    // 提示警告后执行
+	ActionConfirm Action = "confirm" // 用户确认后执行
+)
+
+// valid 检查动作是否合法
+func (a Action) valid() bool {
+	switch a {
+	case ActionAllow, ActionDeny, ActionWarn, ActionConfirm:
+		return true
+	}
+	return false
+}
+
+// Policy 命令执行策略，规则按顺序匹配，第一条匹配的规则生效
+type Policy struct {
+	// Default 没有规则匹配时的动作，默认为 allow
+	Default Action  `json:"default,omitempty"`
+	Rules   []*Rule `json:"rules"`
+	// Restricted 受限模式配置，第一条匹配用户的配置生效
+	Restricted []*Restriction `json:"restricted,omitempty"`
+}
+
+// Rule 策略规则。所有非空条件都满足时规则匹配，同一条件中的多个取值满足其一即可
+type Rule struct {
+	ID          string `json:"id"`
+	Description string `json:"description,omitempty"`
+	Action      Action `json:"action"`
+	Message     string `json:"message,omitempty"`
+
+	// Commands 命令名，支持通配符，例如 "rm"、"mkfs.*"
+	Commands []string `json:"commands,omitempty"`
+	// Paths 解析后的可执行文件路径，支持通配符，以 "/**" 结尾表示目录及其所有子目录
+	Paths []string `json:"paths,omitempty"`
+	// Args 参数正则，匹配以空格连接的参数（不含命令名）
+	Args string `json:"args,omitempty"`
+	// WorkingDirs 工作目录，规则同 Paths
+	WorkingDirs []string `json:"working_dirs,omitempty"`
+	// Users 用户名或 UID
+	Users []string `json:"users,omitempty"`
+	// Groups 组名或 GID，匹配用户所属的任意组
+	Groups []string `json:"groups,omitempty"`
+	// Time 生效时间段
+	Time *TimeWindow `json:"time,omitempty"`
+
+	argsRe *regexp.Regexp
+}
+
+// TimeWindow 时间段，使用本地时间。End 早于 Start 时表示跨越午夜
+type TimeWindow struct {
+	// Days 星期，例如 ["mon", "tue"]，为空表示每天
+	Days []string `json:"days,omitempty"`
+	// Start、End 格式为 HH:MM，为空表示全天
+	Start string `json:"start,omitempty"`
+	End   string `json:"end,omitempty"`
+
+	days       map[time.Weekday]bool
+	start, end int // 自零点起的分钟数
+}
+
+// Request 待评估的命令
+type Request struct {
+	Command    string   // 用户输入的命令名
+	Path       string   // 解析后的可执行文件路径
+	Args       []string // 参数，不含命令名
+	WorkingDir string
+	Username   string
+	UID        int
+	Groups     []string // 用户所属组的组名和 GID
+	Time       time.Time
+}
+
+// Decision 评估结果，RuleID 为空表示没有规则匹配
+type Decision struct {
+	Action  Action
+	RuleID  string
+	Message string
+}
+
+// Load 从文件加载策略
+func Load(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file: %w", err)
+	}
+	p, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy file %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// Parse 解析并校验 JSON 格式的策略
+func Parse(data []byte) (*Policy, error) {
+	var p Policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+
+	if p.Default == "" {
+		p.Default = ActionAllow
+	}
+	if !p.Default.valid() {
+		return nil, fmt.Errorf("unknown default action %q", p.Default)
+	}
+
+	ids := make(map[string]bool)
+	for i, r := range p.Rules {
+		if r.ID == "" {
+			return nil, fmt.Errorf("rule %d: missing id", i+1)
+		}
+		if ids[r.ID] {
+			return nil, fmt.Errorf("rule %s: duplicate id", r.ID)
+		}
+		ids[r.ID] = true
+		if err := r.compile(); err != nil {
+			return nil, fmt.Errorf("rule %s: %w", r.ID, err)
+		}
+	}
+
+	ids = make(map[string]bool)
+	for i, r := range p.Restricted {
+		if r.ID == "" {
+			return nil, fmt.Errorf("restriction %d: missing id", i+1)
+		}
+		if ids[r.ID] {
+			return nil, fmt.Errorf("restriction %s: duplicate id", r.ID)
+		}
+		ids[r.ID] = true
+		if err := r.validate(); err != nil {
+			return nil, fmt.Errorf("restriction %s: %w", r.ID, err)
+		}
+	}
+	return &p, nil
+}
+
+// compile 校验规则并预编译正则和时间段
+func (r *Rule) compile() error {
+	if !r.Action.valid() {
+		return fmt.Errorf("unknown action %q", r.Action)
+	}
+	for _, patterns := range [][]string{r.Commands, r.Paths, r.WorkingDirs} {
+		for _, pattern := range patterns {
+			if _, err := filepath.Match(strings.TrimSuffix(pattern, "/**"), ""); err != nil {
+				return fmt.Errorf("bad pattern %q: %w", pattern, err)
+			}
+		}
+	}
+	if r.Args != "" {
+		re, err := regexp.Compile(r.Args)
+		if err != nil {
+			return fmt.Errorf("bad args regexp: %w", err)
+		}
+		r.argsRe = re
+	}
+	if r.Time != nil {
+		if err := r.Time.compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Evaluate 评估命令，返回第一条匹配规则的动作，没有规则匹配时返回默认动作
+func (p *Policy) Evaluate(req Request) Decision {
+	if req.Time.IsZero() {
+		req.Time = time.Now()
+	}
+	for _, r := range p.Rules {
+		if r.matches(&req) {
+			return Decision{Action: r.Action, RuleID: r.ID, Message: r.Message}
+		}
+	}
+	return Decision{Action: p.Default}
+}
+
+// matches 判断规则是否匹配命令
+func (r *Rule) matches(req *Request) bool {
+	if len(r.Commands) > 0 && !matchAny(r.Commands, filepath.Base(req.Command)) {
+		return false
+	}
+	if len(r.Paths) > 0 && !matchAny(r.Paths, req.Path) {
+		return false
+	}
+	if r.argsRe != nil && !r.argsRe.MatchString(strings.Join(req.Args, " ")) {
+		return false
+	}
+	if len(r.WorkingDirs) > 0 && !matchAny(r.WorkingDirs, req.WorkingDir) {
+		return false
+	}
+	if len(r.Users) > 0 && !contains(r.Users, req.Username) && !contains(r.Users, strconv.Itoa(req.UID)) {
+		return false
+	}
+	if len(r.Groups) > 0 && !containsAny(r.Groups, req.Groups) {
+		return false
+	}
+	if r.Time != nil && !r.Time.contains(req.Time) {
+		return false
+	}
+	return true
+}
+
+// weekdays 星期名称
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// compile 解析星期和起止时间
+func (w *TimeWindow) compile() error {
+	w.days = make(map[time.Weekday]bool)
+	for _, d := range w.Days {
+		day, ok := weekdays[strings.ToLower(d)]
+		if !ok {
+			return fmt.Errorf("bad day %q in time window", d)
+		}
+		w.days[day] = true
+	}
+
+	var err error
+	if w.start, err = parseClock(w.Start, 0); err != nil {
+		return err
+	}
+	if w.end, err = parseClock(w.End, 24*60); err != nil {
+		return err
+	}
+	return nil
+}
+
+// contains 判断时间是否在时间段内。跨越午夜的时间段中，零点之后的部分属于前一天
+func (w *TimeWindow) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+
+	if w.start <= w.end {
+		return w.dayOK(day) && minute >= w.start && minute < w.end
+	}
+	if minute >= w.start {
+		return w.dayOK(day)
+	}
+	return minute < w.end && w.dayOK((day+6)%7)
+}
+
+// dayOK 判断星期是否在时间段内
+func (w *TimeWindow) dayOK(day time.Weekday) bool {
+	return len(w.days) == 0 || w.days[day]
+}
+
+// parseClock 解析 HH:MM，为空时返回 def
+func parseClock(s string, def int) (int, error) {
+	if s == "" {
+		return def, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("bad time %q, expected HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// matchAny 判断 name 是否匹配任一模式，以 "/**" 结尾的模式匹配目录本身及其下所有路径
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if dir, ok := strings.CutSuffix(pattern, "/**"); ok {
+			if name == dir || strings.HasPrefix(name, dir+"/") {
+				return true
+			}
+			continue
+		}
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// contains 判断列表中是否包含 s
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// containsAny 判断两个列表是否有交集
+func containsAny(list, values []string) bool {
+	for _, v := range values {
+		if contains(list, v) {
+			return true
+		}
+	}
+	return false
+}
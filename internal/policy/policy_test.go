@@ -0,0 +1,143 @@
+package policy
+
+import (
+	"testing"
+	"time"
+)
+
+// mustParse 解析测试用的策略
+func mustParse(t *testing.T, data string) *Policy {
+	t.Helper()
+	p, err := Parse([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// at 返回 2024-01-01（星期一）起第 day 天的 hh:mm
+func at(day, hour, minute int) time.Time {
+	return time.Date(2024, 1, 1+day, hour, minute, 0, 0, time.Local)
+}
+
+func TestEvaluate(t *testing.T) {
+	p := mustParse(t, `{
+		"default": "warn",
+		"rules": [
+			{"id": "allow-safe-rm", "action": "allow", "commands": ["rm"], "args": "^-i( |$)"},
+			{"id": "deny-rm", "action": "deny", "commands": ["rm"], "message": "no rm"},
+			{"id": "deny-mkfs", "action": "deny", "commands": ["mkfs.*"]},
+			{"id": "confirm-sbin", "action": "confirm", "paths": ["/usr/sbin/**"]},
+			{"id": "allow-bin", "action": "allow", "paths": ["/usr/bin/*"]},
+			{"id": "deny-force-push", "action": "deny", "args": "push .*--force"},
+			{"id": "warn-etc", "action": "warn", "working_dirs": ["/etc/**"]},
+			{"id": "allow-ops", "action": "allow", "groups": ["ops", "27"]},
+			{"id": "allow-admin", "action": "allow", "users": ["admin", "1001"]}
+		]
+	}`)
+
+	tests := []struct {
+		name string
+		req  Request
+		want string // 期望的 RuleID
+	}{
+		{"first matching rule wins", Request{Command: "rm", Args: []string{"-i", "x"}}, "allow-safe-rm"},
+		{"args regexp not matched", Request{Command: "rm", Args: []string{"-rf", "/"}}, "deny-rm"},
+		{"args regexp is anchored by the rule", Request{Command: "rm", Args: []string{"x", "-i"}}, "deny-rm"},
+		{"command matched by base name", Request{Command: "/bin/rm", Args: []string{"x"}}, "deny-rm"},
+		{"command glob", Request{Command: "mkfs.ext4"}, "deny-mkfs"},
+		{"command glob needs the dot", Request{Command: "mkfs", Path: "/opt/mkfs"}, ""},
+		{"/** matches the directory itself", Request{Command: "x", Path: "/usr/sbin"}, "confirm-sbin"},
+		{"/** matches nested paths", Request{Command: "x", Path: "/usr/sbin/a/b"}, "confirm-sbin"},
+		{"/** does not match a sibling prefix", Request{Command: "x", Path: "/usr/sbinx"}, ""},
+		{"* does not cross /", Request{Command: "x", Path: "/usr/bin/a/b"}, ""},
+		{"* matches one component", Request{Command: "ls", Path: "/usr/bin/ls"}, "allow-bin"},
+		{"args joined with spaces", Request{Command: "git", Args: []string{"push", "origin", "--force"}}, "deny-force-push"},
+		{"working dir", Request{Command: "vi", WorkingDir: "/etc/ssh"}, "warn-etc"},
+		{"group by name", Request{Command: "x", Groups: []string{"users", "ops"}}, "allow-ops"},
+		{"group by id", Request{Command: "x", Groups: []string{"wheel", "27"}}, "allow-ops"},
+		{"user by name", Request{Command: "x", Username: "admin", UID: 1000}, "allow-admin"},
+		{"user by id", Request{Command: "x", Username: "deploy", UID: 1001}, "allow-admin"},
+		{"no rule matches", Request{Command: "x", Username: "bob", UID: 1000, Groups: []string{"bob", "1000"}}, ""},
+	}
+	for _, tt := range tests {
+		d := p.Evaluate(tt.req)
+		if d.RuleID != tt.want {
+			t.Errorf("%s: rule = %q, want %q", tt.name, d.RuleID, tt.want)
+			continue
+		}
+		if tt.want == "" && d.Action != ActionWarn {
+			t.Errorf("%s: action = %s, want the default %s", tt.name, d.Action, ActionWarn)
+		}
+	}
+
+	if d := p.Evaluate(Request{Command: "rm"}); d.Action != ActionDeny || d.Message != "no rm" {
+		t.Errorf("decision = %+v", d)
+	}
+}
+
+// TestEvaluateAllConditions 同一规则的所有条件都满足才匹配
+func TestEvaluateAllConditions(t *testing.T) {
+	p := mustParse(t, `{"rules": [{"id": "r", "action": "deny", "commands": ["rm"], "users": ["bob"]}]}`)
+	if d := p.Evaluate(Request{Command: "rm", Username: "alice"}); d.RuleID != "" || d.Action != ActionAllow {
+		t.Errorf("decision = %+v, want the default allow", d)
+	}
+	if d := p.Evaluate(Request{Command: "rm", Username: "bob"}); d.RuleID != "r" {
+		t.Errorf("decision = %+v, want rule r", d)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, data := range []string{
+		`{"default": "maybe"}`,
+		`{"rules": [{"action": "deny"}]}`,
+		`{"rules": [{"id": "a", "action": "deny"}, {"id": "a", "action": "allow"}]}`,
+		`{"rules": [{"id": "a", "action": "block"}]}`,
+		`{"rules": [{"id": "a", "action": "deny", "commands": ["[a"]}]}`,
+		`{"rules": [{"id": "a", "action": "deny", "args": "("}]}`,
+		`{"rules": [{"id": "a", "action": "deny", "time": {"days": ["monday"]}}]}`,
+		`{"rules": [{"id": "a", "action": "deny", "time": {"start": "25:00"}}]}`,
+		`{"restricted": [{"id": "r", "roots": ["relative"]}]}`,
+	} {
+		if _, err := Parse([]byte(data)); err == nil {
+			t.Errorf("Parse(%s) succeeded", data)
+		}
+	}
+}
+
+func TestTimeWindowContains(t *testing.T) {
+	tests := []struct {
+		name   string
+		window TimeWindow
+		time   time.Time
+		want   bool
+	}{
+		{"all day", TimeWindow{}, at(0, 3, 0), true},
+		{"day only", TimeWindow{Days: []string{"Mon"}}, at(0, 23, 59), true},
+		{"other day", TimeWindow{Days: []string{"mon"}}, at(1, 0, 0), false},
+		{"start is inclusive", TimeWindow{Start: "09:00", End: "18:00"}, at(0, 9, 0), true},
+		{"end is exclusive", TimeWindow{Start: "09:00", End: "18:00"}, at(0, 18, 0), false},
+		{"before start", TimeWindow{Start: "09:00", End: "18:00"}, at(0, 8, 59), false},
+		{"start only", TimeWindow{Start: "22:00"}, at(0, 23, 59), true},
+
+		// 星期五 22:00 到次日 06:00：星期六凌晨属于星期五的时间段
+		{"overnight before midnight", TimeWindow{Days: []string{"fri"}, Start: "22:00", End: "06:00"}, at(4, 22, 0), true},
+		{"overnight after midnight", TimeWindow{Days: []string{"fri"}, Start: "22:00", End: "06:00"}, at(5, 5, 59), true},
+		{"overnight end is exclusive", TimeWindow{Days: []string{"fri"}, Start: "22:00", End: "06:00"}, at(5, 6, 0), false},
+		{"overnight during the day", TimeWindow{Days: []string{"fri"}, Start: "22:00", End: "06:00"}, at(4, 12, 0), false},
+		{"overnight early on the listed day", TimeWindow{Days: []string{"fri"}, Start: "22:00", End: "06:00"}, at(4, 1, 0), false},
+		{"overnight late on the next day", TimeWindow{Days: []string{"fri"}, Start: "22:00", End: "06:00"}, at(5, 23, 0), false},
+		// 星期日晚上的时间段延续到星期一凌晨
+		{"overnight across the week", TimeWindow{Days: []string{"sun"}, Start: "23:00", End: "01:00"}, at(7, 0, 30), true},
+		{"overnight every day", TimeWindow{Start: "22:00", End: "06:00"}, at(2, 3, 0), true},
+	}
+	for _, tt := range tests {
+		w := tt.window
+		if err := w.compile(); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := w.contains(tt.time); got != tt.want {
+			t.Errorf("%s: contains(%s) = %v, want %v", tt.name, tt.time.Format("Mon 15:04"), got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package policy
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Restriction 受限模式，与 rbash 类似：只能进入允许的目录，不能修改 PATH、SHELL、ENV，
+// 不能使用包含 / 的命令名，不能把输出重定向到文件。限制在启动文件执行之后生效，
+// 因此可以在系统启动文件中为受限用户设置 PATH
+type Restriction struct {
+	ID          string `json:"id"`
+	Description string `json:"description,omitempty"`
+
+	// Users、Groups 适用的用户名或 UID、组名或 GID，都为空时适用于所有用户
+	Users  []string `json:"users,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+	// Roots 允许进入的目录及其子目录，"~" 开头表示用户主目录，为空时只允许用户主目录
+	Roots []string `json:"roots,omitempty"`
+}
+
+// validate 校验受限模式配置
+func (r *Restriction) validate() error {
+	for _, root := range r.Roots {
+		if root != "~" && !strings.HasPrefix(root, "~/") && !filepath.IsAbs(root) {
+			return fmt.Errorf("root %q must be an absolute path", root)
+		}
+	}
+	return nil
+}
+
+// Restriction 返回适用于用户的受限模式配置，用户不受限时返回 nil。groups 为用户所属组的组名和 GID
+func (p *Policy) Restriction(username string, uid int, groups []string) *Restriction {
+	for _, r := range p.Restricted {
+		if len(r.Users) == 0 && len(r.Groups) == 0 {
+			return r
+		}
+		if contains(r.Users, username) || contains(r.Users, strconv.Itoa(uid)) || containsAny(r.Groups, groups) {
+			return r
+		}
+	}
+	return nil
+}
+
+// AllowsDir 判断受限用户能否进入目录，dir 应为解析过符号链接的绝对路径
+func (r *Restriction) AllowsDir(dir, homeDir string) bool {
+	roots := r.Roots
+	if len(roots) == 0 {
+		roots = []string{"~"}
+	}
+	for _, root := range roots {
+		if rest, ok := strings.CutPrefix(root, "~"); ok {
+			if homeDir == "" {
+				continue
+			}
+			root = homeDir + rest
+		}
+		// 允许的目录本身也可能是符号链接
+		if resolved, err := filepath.EvalSymlinks(root); err == nil {
+			root = resolved
+		}
+		root = filepath.Clean(root)
+		if dir == root || strings.HasPrefix(dir, root+"/") || root == "/" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package record
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Ext 录制文件扩展名
+const Ext = ".cast"
+
+// 事件类型，与 asciicast v2 一致
+const (
+	EventOutput = "o" // 终端输出
+	EventInput  = "i" // 用户输入
+	EventResize = "r" // 终端大小变化，数据为 "列x行"
+)
+
+// Header asciicast v2 文件头
+type Header struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Path 返回会话录制文件路径
+func Path(dir, sessionID string) string {
+	return filepath.Join(dir, sessionID+Ext)
+}
+
+// Recorder 以 asciicast v2 格式记录终端输入输出，可以被多个 goroutine 同时使用
+type Recorder struct {
+	mu      sync.Mutex
+	file    *os.File
+	start   time.Time
+	pending map[string][]byte // 各类事件尚未凑成完整 UTF-8 字符的字节
+	err     error
+}
+
+// Create 创建录制文件并写入文件头。录制内容可能包含敏感信息，文件只有属主可读写
+func Create(path string, width, height int, title string) (*Recorder, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create recording directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recording: %w", err)
+	}
+
+	r := &Recorder{file: file, start: time.Now(), pending: make(map[string][]byte)}
+	header := Header{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: r.start.Unix(),
+		Title:     title,
+		Env:       map[string]string{"SHELL": os.Getenv("SHELL"), "TERM": os.Getenv("TERM")},
+	}
+	data, err := json.Marshal(header)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to write recording header: %w", err)
+	}
+	return r, nil
+}
+
+// Output 记录终端输出
+func (r *Recorder) Output(data []byte) {
+	r.event(EventOutput, data)
+}
+
+// Input 记录用户输入
+func (r *Recorder) Input(data []byte) {
+	r.event(EventInput, data)
+}
+
+// Resize 记录终端大小变化
+func (r *Recorder) Resize(width, height int) {
+	r.event(EventResize, []byte(strconv.Itoa(width)+"x"+strconv.Itoa(height)))
+}
+
+// Tee 返回写入 w 并记录为终端输出的 Writer。终端会把输出中的 LF 转换为 CRLF，
+// 记录的是终端实际显示的内容
+func (r *Recorder) Tee(w io.Writer) io.Writer {
+	return &teeWriter{w: w, rec: r}
+}
+
+// teeWriter 写入终端并记录输出
+type teeWriter struct {
+	w   io.Writer
+	rec *Recorder
+}
+
+func (t *teeWriter) Write(p []byte) (int, error) {
+	n, err := t.w.Write(p)
+	if n > 0 {
+		t.rec.Output(bytes.ReplaceAll(p[:n], []byte("\n"), []byte("\r\n")))
+	}
+	return n, err
+}
+
+// Close 关闭录制文件
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return r.err
+	}
+	err := r.file.Close()
+	r.file = nil
+	if r.err == nil {
+		r.err = err
+	}
+	return r.err
+}
+
+// event 写入一条事件。事件数据必须是完整的 UTF-8 字符串，被截断的多字节字符留到下一次写入
+func (r *Recorder) event(kind string, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil || r.err != nil {
+		return
+	}
+
+	if pending := r.pending[kind]; len(pending) > 0 {
+		data = append(pending, data...)
+	}
+	n := completeUTF8(data)
+	r.pending[kind] = append([]byte(nil), data[n:]...)
+	if n == 0 {
+		return
+	}
+
+	elapsed := time.Since(r.start).Seconds()
+	line, err := json.Marshal([]interface{}{math.Round(elapsed*1e6) / 1e6, kind, string(data[:n])})
+	if err != nil {
+		return
+	}
+	if _, err := r.file.Write(append(line, '\n')); err != nil {
+		r.err = fmt.Errorf("failed to write recording: %w", err)
+	}
+}
+
+// completeUTF8 返回 data 中以完整字符结尾的前缀长度。非法字节视为完整字符，
+// 由 JSON 编码替换为 U+FFFD
+func completeUTF8(data []byte) int {
+	// 多字节字符最长 4 字节，只需检查末尾 3 字节
+	for i := len(data) - 1; i >= 0 && i >= len(data)-3; i-- {
+		if !utf8.RuneStart(data[i]) {
+			continue
+		}
+		if !utf8.FullRune(data[i:]) {
+			return i
+		}
+		break
+	}
+	return len(data)
+}
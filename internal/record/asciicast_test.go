@@ -0,0 +1,110 @@
+package record
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	t.Setenv("TERM", "xterm-256color")
+	path := Path(filepath.Join(t.TempDir(), "sessions"), "s1")
+	r, err := Create(path, 120, 40, "demo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Output([]byte("中"[:2]))
+	r.Input([]byte("ls\r"))
+	r.Output([]byte("中"[2:] + "\x1b[0m"))
+	r.Resize(80, 24)
+	var term bytes.Buffer
+	if _, err := r.Tee(&term).Write([]byte("a\nb\n")); err != nil {
+		t.Fatal(err)
+	}
+	r.Output([]byte{'x', 0xff})
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r.Output([]byte("after close"))
+
+	if term.String() != "a\nb\n" {
+		t.Errorf("terminal got %q", term.String())
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("mode = %o, want 600", perm)
+	}
+	if _, err := Create(path, 80, 24, ""); err == nil {
+		t.Error("Create overwrote an existing recording")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+
+	scanner.Scan()
+	var header Header
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		t.Fatal(err)
+	}
+	if header.Version != 2 || header.Width != 120 || header.Height != 40 || header.Title != "demo" ||
+		header.Timestamp == 0 || header.Env["TERM"] != "xterm-256color" {
+		t.Errorf("header = %+v", header)
+	}
+
+	// 被截断的多字节字符留到同类事件的下一次写入
+	want := []struct{ kind, data string }{
+		{EventInput, "ls\r"},
+		{EventOutput, "中\x1b[0m"},
+		{EventResize, "80x24"},
+		{EventOutput, "a\r\nb\r\n"},
+		{EventOutput, "x�"},
+	}
+	var last float64
+	for i := 0; scanner.Scan(); i++ {
+		at, kind, data, err := parseEvent(scanner.Bytes())
+		if err != nil {
+			t.Fatalf("event %d: %v", i, err)
+		}
+		if i >= len(want) {
+			t.Fatalf("unexpected event %q", scanner.Text())
+		}
+		if kind != want[i].kind || data != want[i].data {
+			t.Errorf("event %d = %s %q, want %s %q", i, kind, data, want[i].kind, want[i].data)
+		}
+		if at < last {
+			t.Errorf("event %d time %v is before %v", i, at, last)
+		}
+		last = at
+	}
+}
+
+func TestCompleteUTF8(t *testing.T) {
+	for _, tt := range []struct {
+		data string
+		want int
+	}{
+		{"", 0},
+		{"abc", 3},
+		{"中", 3},
+		{"a" + "中"[:1], 1},
+		{"a" + "中"[:2], 1},
+		{"😀"[:3], 0},
+		{"\xff", 1},
+		{"a\x80", 2},
+	} {
+		if got := completeUTF8([]byte(tt.data)); got != tt.want {
+			t.Errorf("completeUTF8(%q) = %d, want %d", tt.data, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package record
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// PlayOptions 回放选项
+type PlayOptions struct {
+	// Speed 回放速度倍数，默认为 1
+	Speed float64
+	// IdleLimit 两次输出之间的最长等待时间，0 表示不限制
+	IdleLimit time.Duration
+}
+
+// sleep 等待到下一次输出，测试中替换以免真正等待
+var sleep = time.Sleep
+
+// Play 按录制时的时间间隔把输出事件写入 w
+func Play(r io.Reader, w io.Writer, opts PlayOptions) error {
+	if opts.Speed <= 0 {
+		opts.Speed = 1
+	}
+
+	br := bufio.NewReader(r)
+	line, err := br.ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return fmt.Errorf("failed to read recording header: %w", err)
+	}
+	var header Header
+	if err := json.Unmarshal(line, &header); err != nil {
+		return fmt.Errorf("invalid recording header: %w", err)
+	}
+	if header.Version != 2 {
+		return fmt.Errorf("unsupported asciicast version %d", header.Version)
+	}
+
+	var last float64
+	for lineNo := 2; ; lineNo++ {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			at, kind, data, perr := parseEvent(line)
+			if perr != nil {
+				return fmt.Errorf("line %d: %w", lineNo, perr)
+			}
+			if kind != EventOutput {
+				continue
+			}
+
+			delay := time.Duration((at - last) / opts.Speed * float64(time.Second))
+			if opts.IdleLimit > 0 && delay > opts.IdleLimit {
+				delay = opts.IdleLimit
+			}
+			sleep(delay)
+			last = at
+
+			if _, err := io.WriteString(w, data); err != nil {
+				return err
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// parseEvent 解析 [时间, 类型, 数据] 形式的事件
+func parseEvent(line []byte) (at float64, kind, data string, err error) {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(line, &fields); err != nil {
+		return 0, "", "", fmt.Errorf("invalid event: %w", err)
+	}
+	if len(fields) != 3 {
+		return 0, "", "", fmt.Errorf("invalid event: expected 3 fields, got %d", len(fields))
+	}
+	if err := json.Unmarshal(fields[0], &at); err != nil {
+		return 0, "", "", fmt.Errorf("invalid event time: %w", err)
+	}
+	if err := json.Unmarshal(fields[1], &kind); err != nil {
+		return 0, "", "", fmt.Errorf("invalid event type: %w", err)
+	}
+	if err := json.Unmarshal(fields[2], &data); err != nil {
+		return 0, "", "", fmt.Errorf("invalid event data: %w", err)
+	}
+	return at, kind, data, nil
+}
@@ -0,0 +1,97 @@
+package record
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recording 测试用的录制内容，输入和终端大小变化不回放
+const recording = `{"version": 2, "width": 80, "height": 24}
+[0.5, "o", "$ "]
+[1.0, "i", "ls\r"]
+[2.5, "o", "ls\r\n"]
+[2.5, "r", "100x30"]
+[2.6, "o", "a  b\r\n"]
+`
+
+// stubSleep 记录回放时的等待时间而不真正等待
+func stubSleep(t *testing.T) *[]time.Duration {
+	var delays []time.Duration
+	sleep = func(d time.Duration) { delays = append(delays, d) }
+	t.Cleanup(func() { sleep = time.Sleep })
+	return &delays
+}
+
+func TestPlay(t *testing.T) {
+	tests := []struct {
+		name string
+		opts PlayOptions
+		want []time.Duration
+	}{
+		{"default speed", PlayOptions{}, []time.Duration{500 * time.Millisecond, 2 * time.Second, 100 * time.Millisecond}},
+		{"double speed", PlayOptions{Speed: 2}, []time.Duration{250 * time.Millisecond, time.Second, 50 * time.Millisecond}},
+		{"idle limit", PlayOptions{IdleLimit: 300 * time.Millisecond}, []time.Duration{300 * time.Millisecond, 300 * time.Millisecond, 100 * time.Millisecond}},
+		{"idle limit after speed", PlayOptions{Speed: 4, IdleLimit: 300 * time.Millisecond}, []time.Duration{125 * time.Millisecond, 300 * time.Millisecond, 25 * time.Millisecond}},
+	}
+	for _, tt := range tests {
+		delays := stubSleep(t)
+		var out strings.Builder
+		if err := Play(strings.NewReader(recording), &out, tt.opts); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if out.String() != "$ ls\r\na  b\r\n" {
+			t.Errorf("%s: output = %q", tt.name, out.String())
+		}
+		if len(*delays) != len(tt.want) {
+			t.Fatalf("%s: delays = %v, want %v", tt.name, *delays, tt.want)
+		}
+		for i, d := range *delays {
+			// 浮点时间换算允许 1 微秒误差
+			if diff := d - tt.want[i]; diff < -time.Microsecond || diff > time.Microsecond {
+				t.Errorf("%s: delays = %v, want %v", tt.name, *delays, tt.want)
+				break
+			}
+		}
+	}
+}
+
+// TestPlayNoTrailingNewline 最后一个事件后没有换行时同样回放
+func TestPlayNoTrailingNewline(t *testing.T) {
+	stubSleep(t)
+	var out strings.Builder
+	if err := Play(strings.NewReader(strings.TrimSuffix(recording, "\n")), &out, PlayOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(out.String(), "a  b\r\n") {
+		t.Errorf("output = %q", out.String())
+	}
+}
+
+func TestPlayErrors(t *testing.T) {
+	stubSleep(t)
+	for _, tt := range []struct{ data, err string }{
+		{"", "failed to read recording header"},
+		{"not json\n", "invalid recording header"},
+		{`{"version": 1}` + "\n", "unsupported asciicast version 1"},
+		{`{"version": 2}` + "\n" + `[0.1, "o", "x"]` + "\n" + `[0.2, "o"]` + "\n", "line 3: invalid event: expected 3 fields, got 2"},
+		{`{"version": 2}` + "\n" + `["0.1", "o", "x"]` + "\n", "line 2: invalid event time"},
+	} {
+		var out strings.Builder
+		err := Play(strings.NewReader(tt.data), &out, PlayOptions{})
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("Play(%q) error = %v, want %q", tt.data, err, tt.err)
+		}
+	}
+}
+
+func TestParseEvent(t *testing.T) {
+	at, kind, data, err := parseEvent([]byte(`[1.234567, "o", "\u001b[0m中"]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := []interface{}{at, kind, data}; !reflect.DeepEqual(got, []interface{}{1.234567, "o", "\x1b[0m中"}) {
+		t.Errorf("parseEvent = %v", got)
+	}
+}
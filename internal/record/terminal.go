@@ -0,0 +1,223 @@
+package record
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/cevin/shell-auditor/internal/tty"
+)
+
+// drainTimeout 命令结束后等待伪终端输出读完的最长时间。命令派生的后台进程可能一直持有伪终端
+const drainTimeout = 500 * time.Millisecond
+
+// Terminal 在伪终端中执行命令，在用户终端和伪终端之间转发输入输出并记录
+type Terminal struct {
+	in, out *os.File
+	rec     *Recorder
+
+	// NoSuspend 在伪终端中禁用挂起键（Ctrl-Z）。命令停止后无法再转发用户输入，
+	// 调用方自己不做作业控制时应设置
+	NoSuspend bool
+
+	mu    sync.Mutex
+	saved *tty.State // 切换到原始模式前的终端属性
+}
+
+// NewTerminal 创建终端，in、out 为用户终端
+func NewTerminal(in, out *os.File, rec *Recorder) *Terminal {
+	return &Terminal{in: in, out: out, rec: rec}
+}
+
+// Start 在伪终端中启动命令，cmd 中为 nil 的标准输入输出连接到伪终端。命令成为新会话的
+// 首进程并以伪终端为控制终端，vi、top 等全屏程序可以正常使用。命令运行期间用户终端处于
+// 原始模式，按键由伪终端处理。调用方等待命令结束后调用返回的函数，读完剩余的输出并恢复用户终端
+func (t *Terminal) Start(cmd *exec.Cmd) (func(), error) {
+	master, slave, err := tty.OpenPTY()
+	if err != nil {
+		return nil, err
+	}
+
+	isTTY := tty.IsTerminal(t.in)
+	if isTTY {
+		// 伪终端沿用用户终端的属性（退格键、回显等）和大小
+		if st, err := tty.GetState(t.in); err == nil {
+			if t.NoSuspend {
+				st.DisableSuspend()
+			}
+			tty.SetState(slave, st)
+		}
+		width, height := tty.Size(t.in)
+		tty.SetSize(master, width, height)
+	}
+
+	ctty := -1
+	if cmd.Stdin == nil {
+		cmd.Stdin = slave
+		ctty = 0
+	}
+	if cmd.Stdout == nil {
+		cmd.Stdout = slave
+		if ctty < 0 {
+			ctty = 1
+		}
+	}
+	if cmd.Stderr == nil {
+		cmd.Stderr = slave
+		if ctty < 0 {
+			ctty = 2
+		}
+	}
+	if ctty >= 0 {
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		cmd.SysProcAttr.Setsid = true
+		cmd.SysProcAttr.Setctty = true
+		cmd.SysProcAttr.Ctty = ctty
+	}
+
+	stopR, stopW, err := os.Pipe()
+	if err != nil {
+		master.Close()
+		slave.Close()
+		return nil, fmt.Errorf("failed to create pipe: %w", err)
+	}
+
+	err = cmd.Start()
+	slave.Close()
+	if err != nil {
+		master.Close()
+		stopR.Close()
+		stopW.Close()
+		return nil, err
+	}
+
+	if isTTY {
+		t.makeRaw()
+	}
+
+	outDone := make(chan struct{})
+	go func() {
+		defer close(outDone)
+		t.copyOutput(master)
+	}()
+	inDone := make(chan struct{})
+	go func() {
+		defer close(inDone)
+		t.copyInput(master, stopR)
+	}()
+
+	// 用户终端大小变化时同步到伪终端
+	winch := make(chan os.Signal, 1)
+	if isTTY {
+		signal.Notify(winch, syscall.SIGWINCH)
+		go func() {
+			for range winch {
+				width, height := tty.Size(t.in)
+				tty.SetSize(master, width, height)
+				t.rec.Resize(width, height)
+			}
+		}()
+	}
+
+	return func() {
+		select {
+		case <-outDone:
+		case <-time.After(drainTimeout):
+		}
+
+		signal.Stop(winch)
+		close(winch)
+		stopW.Write([]byte{0})
+		<-inDone
+		stopR.Close()
+		stopW.Close()
+		master.Close()
+		<-outDone
+
+		t.Restore()
+	}, nil
+}
+
+// Restore 恢复用户终端的属性
+func (t *Terminal) Restore() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.saved != nil {
+		tty.SetState(t.in, t.saved)
+		t.saved = nil
+	}
+}
+
+// makeRaw 把用户终端切换到原始模式
+func (t *Terminal) makeRaw() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.saved != nil {
+		return
+	}
+	if saved, err := tty.MakeRaw(t.in); err == nil {
+		t.saved = saved
+	}
+}
+
+// copyOutput 把伪终端的输出转发到用户终端，直到伪终端关闭
+func (t *Terminal) copyOutput(master *os.File) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := master.Read(buf)
+		if n > 0 {
+			t.out.Write(buf[:n])
+			t.rec.Output(buf[:n])
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// copyInput 把用户输入转发到伪终端，直到 stop 可读。用 poll 等待输入，
+// 命令结束后不会再从用户终端多读走属于 shell 的输入。伪终端关闭回显时（例如输入密码）
+// 不记录输入
+func (t *Terminal) copyInput(master, stop *os.File) {
+	in := int(t.in.Fd())
+	fds := []unix.PollFd{
+		{Fd: int32(in), Events: unix.POLLIN},
+		{Fd: int32(stop.Fd()), Events: unix.POLLIN},
+	}
+	buf := make([]byte, 4096)
+	for {
+		fds[0].Revents, fds[1].Revents = 0, 0
+		if _, err := unix.Poll(fds, -1); err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			return
+		}
+		if fds[1].Revents != 0 {
+			return
+		}
+		if fds[0].Revents == 0 {
+			continue
+		}
+
+		n, err := unix.Read(in, buf)
+		if n > 0 {
+			echo := tty.Echo(master)
+			master.Write(buf[:n])
+			if echo {
+				t.rec.Input(buf[:n])
+			}
+		}
+		if n == 0 || (err != nil && err != unix.EINTR && err != unix.EAGAIN) {
+			return
+		}
+	}
+}
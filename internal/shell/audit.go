@@ -0,0 +1,115 @@
+package shell
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/cevin/shell-auditor/internal/audit"
+	"github.com/cevin/shell-auditor/internal/format"
+)
+
+// handleAudit 处理audit命令：
+//
+//	audit [key=value ...] [format=STYLE]       查询审计日志，条件见 audit.ParseQuery，
+//	                                           STYLE 为 table、compact、detailed 或 json
+//	audit pid <pid>                            等同于 audit pid=<pid>
+//	audit tree <pid>                           显示进程树
+//	audit clear [-f]                           清空审计日志，需要 root
+func (s *Shell) handleAudit(c *execCtx, args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "clear":
+			return s.clearAudit(c, args[1:])
+		case "tree":
+			if len(args) < 2 {
+				return fmt.Errorf("usage: audit tree <pid>")
+			}
+			pid, err := strconv.Atoi(args[1])
+			if err != nil {
+				return fmt.Errorf("audit tree: invalid pid: %s", args[1])
+			}
+			return s.printProcessTree(c.stdout(), pid)
+		case "pid":
+			if len(args) < 2 {
+				return fmt.Errorf("usage: audit pid <pid>")
+			}
+			args = append([]string{"pid=" + args[1]}, args[2:]...)
+		}
+	}
+
+	style := format.StyleTable
+	var terms []string
+	for _, arg := range args {
+		if name, ok := strings.CutPrefix(arg, "format="); ok {
+			var err error
+			if style, err = format.ParseStyle(name); err != nil {
+				return fmt.Errorf("audit: %w", err)
+			}
+			continue
+		}
+		terms = append(terms, arg)
+	}
+	q, err := audit.ParseQuery(terms)
+	if err != nil {
+		return fmt.Errorf("audit: %w", err)
+	}
+	q.MergeExits = style.MergesExits()
+
+	result, err := s.queryAudit(q)
+	if err != nil {
+		return fmt.Errorf("audit: %w", err)
+	}
+
+	out := c.stdout()
+	f := format.New(style, format.ColorEnabled(out))
+	f.Exits = result.Exits
+	if err := f.Write(out, result.Events); err != nil {
+		return fmt.Errorf("audit: %w", err)
+	}
+	if style == format.StyleJSON {
+		return nil
+	}
+	fmt.Fprintf(out, "\n%s\n", result.Summary())
+	return nil
+}
+
+// queryAudit 在审计日志文件中查询，日志输出到标准输出或还没有日志文件时查询内存中的事件
+func (s *Shell) queryAudit(q *audit.Query) (*audit.QueryResult, error) {
+	if s.logPath != "" {
+		// 包括刚刚执行的命令
+		s.auditor.Flush()
+		if files := audit.LogFiles(s.logPath); len(files) > 0 {
+			return q.Search(files)
+		}
+	}
+	return q.Filter(s.auditor.GetEvents()), nil
+}
+
+// clearAudit 清空审计日志。只有 root 可以清空，其他用户的尝试记录为 policy_violation；
+// 交互式 shell 中需要确认，-f 跳过确认。清空后记录 audit_cleared 事件
+func (s *Shell) clearAudit(c *execCtx, args []string) error {
+	force := len(args) > 0 && args[0] == "-f"
+	if os.Geteuid() != 0 {
+		s.auditor.LogPolicyViolation(os.Getpid(), os.Getppid(), s.uid, s.gid, s.username, "audit", "",
+			append([]string{"clear"}, args...), c.dir,
+			audit.PolicyDetails{
+				RuleID:  "audit-clear",
+				Action:  "deny",
+				Outcome: "denied",
+				Message: "clearing the audit log requires root",
+			})
+		return fmt.Errorf("audit clear: permission denied, requires root")
+	}
+	if !force && !s.confirm("Clear the audit log? [y/N] ") {
+		return fmt.Errorf("audit clear: cancelled")
+	}
+
+	details, err := s.auditor.Clear(os.Getpid(), s.uid, s.gid, s.username)
+	if err != nil {
+		return fmt.Errorf("audit clear: %w", err)
+	}
+	fmt.Fprintf(c.stdout(), "审计日志已清空：%d 条内存中的事件，删除 %d 个日志文件\n", details.Events, len(details.Files))
+	return nil
+}
@@ -0,0 +1,279 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// handleUnset 处理unset命令，删除变量。没有函数，-f 不做任何事
+func (s *Shell) handleUnset(c *execCtx, args []string) error {
+	functions := false
+	if len(args) > 0 && (args[0] == "-v" || args[0] == "-f") {
+		functions = args[0] == "-f"
+		args = args[1:]
+	}
+	if functions {
+		return nil
+	}
+
+	var status error
+	for _, name := range args {
+		if !isName(name) {
+			c.report(fmt.Errorf("unset: `%s': not a valid identifier", name))
+			status = exitStatus(1)
+			continue
+		}
+		if err := s.checkVariable(c, append([]string{"unset"}, args...), name); err != nil {
+			c.report(err)
+			status = exitStatus(1)
+			continue
+		}
+		c.env.Unset(name)
+	}
+	return status
+}
+
+// handleAlias 处理alias命令。没有参数时列出所有别名，NAME=VALUE 定义别名，只有名称时显示该别名
+func (s *Shell) handleAlias(c *execCtx, args []string) error {
+	if len(args) == 0 || (len(args) == 1 && args[0] == "-p") {
+		names := make([]string, 0, len(c.aliases))
+		for name := range c.aliases {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(c.stdout(), "alias %s=%s\n", name, quote(c.aliases[name]))
+		}
+		return nil
+	}
+
+	var status error
+	for _, arg := range args {
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			value, found := c.aliases[name]
+			if !found {
+				c.report(fmt.Errorf("alias: %s: not found", name))
+				status = exitStatus(1)
+				continue
+			}
+			fmt.Fprintf(c.stdout(), "alias %s=%s\n", name, quote(value))
+			continue
+		}
+		if !isAliasName(name) {
+			c.report(fmt.Errorf("alias: `%s': invalid alias name", name))
+			status = exitStatus(1)
+			continue
+		}
+		c.aliases[name] = value
+	}
+	return status
+}
+
+// handleUnalias 处理unalias命令，-a 删除所有别名
+func (s *Shell) handleUnalias(c *execCtx, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: unalias [-a] name [name ...]")
+	}
+	if args[0] == "-a" {
+		for name := range c.aliases {
+			delete(c.aliases, name)
+		}
+		return nil
+	}
+
+	var status error
+	for _, name := range args {
+		if _, ok := c.aliases[name]; !ok {
+			c.report(fmt.Errorf("unalias: %s: not found", name))
+			status = exitStatus(1)
+			continue
+		}
+		delete(c.aliases, name)
+	}
+	return status
+}
+
+// isAliasName 判断别名的名称是否合法，不能包含引号、$、/、= 和结束单词的字符
+func isAliasName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if isMeta(name[i]) || strings.IndexByte("'\"`$\\/=", name[i]) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// quote 用单引号引用字符串，输出可以作为命令重新输入
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// handleSource 处理source和.命令，在当前环境中执行文件中的命令，其中的 cd、export、alias
+// 对之后的命令生效。文件中的每条命令都像输入的命令一样经过策略检查和审计。
+// 其余参数在执行期间作为位置参数
+func (s *Shell) handleSource(c *execCtx, cmd string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%s: filename argument required", cmd)
+	}
+	if err := s.checkCommandName(c, append([]string{cmd}, args...), args[0]); err != nil {
+		return err
+	}
+	path, err := findSource(args[0], c.dir, c.env)
+	if err != nil {
+		return fmt.Errorf("%s: %w", cmd, err)
+	}
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("%s: %s: %w", cmd, args[0], errors.Unwrap(err))
+	}
+
+	if len(args) > 1 {
+		saved := s.args
+		s.args = args[1:]
+		defer func() { s.args = saved }()
+	}
+
+	// 在 shell 自身环境中执行时，文件中的每个管道作为单独的前台作业，可以被停止
+	sc := c.with(c.frame, c.stdio)
+	if c.frame == s.root {
+		sc.job = nil
+	}
+	status, err := s.runSource(sc, args[0], string(src))
+	if err != nil {
+		return err
+	}
+	return exitStatus(status)
+}
+
+// findSource 查找 source 的文件：包含 / 时相对于工作目录，否则先在 PATH 中查找再使用工作目录
+func findSource(name, dir string, env *environ) (string, error) {
+	if !strings.Contains(name, "/") {
+		pathEnv, _ := env.Get("PATH")
+		for _, p := range filepath.SplitList(pathEnv) {
+			if p == "" || !filepath.IsAbs(p) {
+				continue
+			}
+			path := filepath.Join(p, name)
+			if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+				return path, nil
+			}
+		}
+	}
+	if !filepath.IsAbs(name) {
+		return filepath.Join(dir, name), nil
+	}
+	return name, nil
+}
+
+// handleUmask 处理umask命令，没有参数时显示当前的文件创建掩码，-S 以符号形式显示。
+// 只接受八进制的掩码
+func (s *Shell) handleUmask(c *execCtx, args []string) error {
+	symbolic := len(args) > 0 && args[0] == "-S"
+	if symbolic {
+		args = args[1:]
+	}
+
+	if len(args) == 0 {
+		mask := syscall.Umask(0)
+		syscall.Umask(mask)
+		if !symbolic {
+			fmt.Fprintf(c.stdout(), "%04o\n", mask)
+			return nil
+		}
+		var perms []string
+		for i, who := range []string{"u", "g", "o"} {
+			bits := ^mask >> (6 - 3*i) & 7
+			perm := who + "="
+			for j, r := range "rwx" {
+				if bits&(4>>j) != 0 {
+					perm += string(r)
+				}
+			}
+			perms = append(perms, perm)
+		}
+		fmt.Fprintln(c.stdout(), strings.Join(perms, ","))
+		return nil
+	}
+
+	mask, err := strconv.ParseUint(args[0], 8, 32)
+	if err != nil || mask > 0777 {
+		return fmt.Errorf("umask: %s: octal number out of range", args[0])
+	}
+	syscall.Umask(int(mask))
+	return nil
+}
+
+// handleType 处理type命令，说明名称作为命令时如何解释
+func (s *Shell) handleType(c *execCtx, args []string) error {
+	var status error
+	for _, name := range args {
+		if value, ok := c.aliases[name]; ok {
+			fmt.Fprintf(c.stdout(), "%s is aliased to `%s'\n", name, value)
+			continue
+		}
+		if s.isBuiltinCommand(name) {
+			fmt.Fprintf(c.stdout(), "%s is a shell builtin\n", name)
+			continue
+		}
+		path, err := lookPath(name, c.dir, c.env)
+		if err != nil {
+			c.report(fmt.Errorf("type: %s: not found", name))
+			status = exitStatus(1)
+			continue
+		}
+		fmt.Fprintf(c.stdout(), "%s is %s\n", name, path)
+	}
+	return status
+}
+
+// handleWhich 处理which命令，显示命令的路径，别名和内置命令也会说明。-a 显示 PATH 中所有匹配的文件
+func (s *Shell) handleWhich(c *execCtx, args []string) error {
+	all := len(args) > 0 && args[0] == "-a"
+	if all {
+		args = args[1:]
+	}
+
+	var status error
+	for _, name := range args {
+		found := false
+		if value, ok := c.aliases[name]; ok {
+			fmt.Fprintf(c.stdout(), "%s: aliased to %s\n", name, value)
+			found = true
+		}
+		if !found || all {
+			if s.isBuiltinCommand(name) {
+				fmt.Fprintf(c.stdout(), "%s: shell built-in command\n", name)
+				found = true
+			}
+		}
+		if !found || all {
+			var paths []string
+			if strings.Contains(name, "/") {
+				if path, err := lookPath(name, c.dir, c.env); err == nil {
+					paths = []string{path}
+				}
+			} else {
+				paths = searchPath(name, c.dir, c.env, all)
+			}
+			for _, path := range paths {
+				fmt.Fprintln(c.stdout(), path)
+			}
+			found = found || len(paths) > 0
+		}
+		if !found {
+			c.report(fmt.Errorf("which: %s: not found", name))
+			status = exitStatus(1)
+		}
+	}
+	return status
+}
@@ -0,0 +1,147 @@
+package shell
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// wordBreaks 分隔单词的字符
+const wordBreaks = " \t|;&()<>"
+
+// specialChars 补全文件名时需要转义的字符
+const specialChars = " \t\\'\"$`*?[]|&;<>()!#{}"
+
+// complete 补全光标前的最后一个单词：命令位置补全内置命令和 PATH 中的命令，其他位置补全文件路径
+func (s *Shell) complete(head string) (int, []string) {
+	start := 0
+	for i := 0; i < len(head); i++ {
+		switch {
+		case head[i] == '\\':
+			i++
+		case strings.IndexByte(wordBreaks, head[i]) >= 0:
+			start = i + 1
+		}
+	}
+	word := head[start:]
+
+	before := strings.TrimRight(head[:start], " \t")
+	command := before == "" || strings.IndexByte("|;&(", before[len(before)-1]) >= 0
+	if command && !strings.Contains(word, "/") {
+		return start, s.completeCommand(word)
+	}
+	return start, s.completePath(word, command)
+}
+
+// completeCommand 补全别名、内置命令和 PATH 中的可执行文件
+func (s *Shell) completeCommand(prefix string) []string {
+	seen := make(map[string]bool)
+	for name := range builtins {
+		if strings.HasPrefix(name, prefix) {
+			seen[name] = true
+		}
+	}
+	for name := range s.root.aliases {
+		if strings.HasPrefix(name, prefix) {
+			seen[name] = true
+		}
+	}
+
+	pathEnv, _ := s.root.env.Get("PATH")
+	for _, dir := range filepath.SplitList(pathEnv) {
+		if dir == "" || !filepath.IsAbs(dir) {
+			continue
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			name := e.Name()
+			if seen[name] || !strings.HasPrefix(name, prefix) {
+				continue
+			}
+			if checkExecutable(filepath.Join(dir, name)) == nil {
+				seen[name] = true
+			}
+		}
+	}
+	return sortedKeys(seen)
+}
+
+// completePath 补全文件路径，目录以 / 结尾。executable 为 true 时只补全目录和可执行文件
+func (s *Shell) completePath(word string, executable bool) []string {
+	raw := unescape(word)
+	dir, base := "", raw
+	if i := strings.LastIndex(raw, "/"); i >= 0 {
+		dir, base = raw[:i+1], raw[i+1:]
+	}
+
+	listDir := dir
+	if strings.HasPrefix(listDir, "~") {
+		c := &execCtx{frame: s.root}
+		listDir = s.expandTilde(c, listDir)
+	}
+	if !filepath.IsAbs(listDir) {
+		listDir = filepath.Join(s.root.dir, listDir)
+	}
+	entries, err := os.ReadDir(listDir)
+	if err != nil {
+		return nil
+	}
+
+	found := make(map[string]bool)
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, base) || (strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".")) {
+			continue
+		}
+		path := filepath.Join(listDir, name)
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		switch {
+		case info.IsDir():
+			found[escape(dir+name)+"/"] = true
+		case !executable || checkExecutable(path) == nil:
+			found[escape(dir+name)] = true
+		}
+	}
+	return sortedKeys(found)
+}
+
+// escape 用反斜杠转义文件名中的特殊字符，开头的 ~ 保留
+func escape(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if strings.ContainsRune(specialChars, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// unescape 去掉反斜杠转义
+func unescape(word string) string {
+	var b strings.Builder
+	for i := 0; i < len(word); i++ {
+		if word[i] == '\\' && i+1 < len(word) {
+			i++
+		}
+		b.WriteByte(word[i])
+	}
+	return b.String()
+}
+
+// sortedKeys 返回排序后的键
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
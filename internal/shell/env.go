@@ -0,0 +1,76 @@
+package shell
+
+import (
+	"os"
+	"sort"
+	"strings"
+)
+
+// environ shell 变量，子 shell 和管道中的命令使用副本，修改不影响父 shell。
+// 只有导出的变量会传给外部命令
+type environ struct {
+	vars     map[string]string
+	exported map[string]bool
+}
+
+// newEnviron 用当前进程的环境变量初始化，这些变量都是导出的
+func newEnviron() *environ {
+	e := &environ{vars: make(map[string]string), exported: make(map[string]bool)}
+	for _, kv := range os.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			e.vars[k] = v
+			e.exported[k] = true
+		}
+	}
+	return e
+}
+
+// Get 获取变量
+func (e *environ) Get(key string) (string, bool) {
+	v, ok := e.vars[key]
+	return v, ok
+}
+
+// Set 设置变量，不改变变量是否导出
+func (e *environ) Set(key, value string) {
+	e.vars[key] = value
+}
+
+// Export 导出变量，未设置的变量导出为空值
+func (e *environ) Export(key string) {
+	if _, ok := e.vars[key]; !ok {
+		e.vars[key] = ""
+	}
+	e.exported[key] = true
+}
+
+// Unset 删除变量
+func (e *environ) Unset(key string) {
+	delete(e.vars, key)
+	delete(e.exported, key)
+}
+
+// List 返回 KEY=VALUE 形式的导出变量列表，按名称排序
+func (e *environ) List() []string {
+	list := make([]string, 0, len(e.exported))
+	for k := range e.exported {
+		list = append(list, k+"="+e.vars[k])
+	}
+	sort.Strings(list)
+	return list
+}
+
+// clone 复制变量
+func (e *environ) clone() *environ {
+	c := &environ{
+		vars:     make(map[string]string, len(e.vars)),
+		exported: make(map[string]bool, len(e.exported)),
+	}
+	for k, v := range e.vars {
+		c.vars[k] = v
+	}
+	for k := range e.exported {
+		c.exported[k] = true
+	}
+	return c
+}
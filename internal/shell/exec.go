@@ -0,0 +1,576 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cevin/shell-auditor/internal/record"
+)
+
+// 特殊退出状态，与常见 shell 一致
+const (
+	statusNotExecutable = 126
+	statusNotFound      = 127
+	statusSyntaxError   = 2
+)
+
+// frame 执行环境，子 shell 和多级管道中的命令使用副本，修改不影响父 shell
+type frame struct {
+	dir     string
+	env     *environ
+	aliases map[string]string
+	status  int // 最近一个管道的退出状态
+}
+
+// clone 复制执行环境
+func (f *frame) clone() *frame {
+	aliases := make(map[string]string, len(f.aliases))
+	for k, v := range f.aliases {
+		aliases[k] = v
+	}
+	return &frame{dir: f.dir, env: f.env.clone(), aliases: aliases, status: f.status}
+}
+
+// execCtx 命令执行上下文
+type execCtx struct {
+	*frame
+	stdio      [3]*os.File
+	rec        *record.Recorder // 会话录制，为 nil 时不录制
+	job        *job             // 命令所属的作业，为 nil 时每个管道作为一个前台作业
+	pipelineID string
+	rawLine    string // 用户输入的原始命令行
+	alias      string // 展开出当前命令的别名
+}
+
+func (c *execCtx) stdin() io.Reader  { return c.stdio[0] }
+func (c *execCtx) stdout() io.Writer { return c.writer(1) }
+func (c *execCtx) stderr() io.Writer { return c.writer(2) }
+
+// writer 返回内置命令的输出，写到终端的输出同时写入录制文件
+func (c *execCtx) writer(fd int) io.Writer {
+	f := c.stdio[fd]
+	if c.rec != nil && (f == os.Stdout || f == os.Stderr) {
+		return c.rec.Tee(f)
+	}
+	return f
+}
+
+// with 返回使用新执行环境和标准输入输出的上下文
+func (c *execCtx) with(f *frame, stdio [3]*os.File) *execCtx {
+	return &execCtx{frame: f, stdio: stdio, rec: c.rec, job: c.job, pipelineID: c.pipelineID, rawLine: c.rawLine}
+}
+
+// report 输出错误信息
+func (c *execCtx) report(err error) {
+	fmt.Fprintf(c.stderr(), "Error: %v\n", err)
+}
+
+// waitFunc 等待命令结束并返回退出状态，exit 内置命令返回 errExit
+type waitFunc func() (int, error)
+
+// exitStatus 内置命令以指定的退出状态结束，不输出错误信息
+type exitStatus int
+
+func (e exitStatus) Error() string {
+	return "exit status " + strconv.Itoa(int(e))
+}
+
+// done 返回已经结束的命令
+func done(status int, err error) waitFunc {
+	return func() (int, error) { return status, err }
+}
+
+// runList 依次执行命令列表，返回最后一个命令的退出状态。以 & 结束的命令在后台执行
+func (s *Shell) runList(c *execCtx, l *list) (int, error) {
+	status := 0
+	for _, item := range l.items {
+		if item.background {
+			s.runBackground(c, item)
+			status = 0
+			c.status = 0
+			continue
+		}
+		var err error
+		if status, err = s.runAndOr(c, item); err != nil {
+			return status, err
+		}
+	}
+	return status, nil
+}
+
+// runAndOr 执行以 && 或 || 连接的管道，前一个管道的结果决定是否执行下一个
+func (s *Shell) runAndOr(c *execCtx, ao *andOr) (int, error) {
+	status, err := s.runPipeline(c, ao.pipelines[0])
+	for i, op := range ao.ops {
+		if err != nil {
+			return status, err
+		}
+		if (op == tokAnd) != (status == 0) {
+			continue
+		}
+		status, err = s.runPipeline(c, ao.pipelines[i+1])
+	}
+	return status, err
+}
+
+// runPipeline 执行管道，返回最后一个命令的退出状态。管道中的每个命令都以同一个管道ID
+// 记录审计事件。只有一个命令时在当前环境中执行，因此 cd、export 对后续命令生效。
+// 不属于其他作业的管道作为前台作业执行，被停止时加入作业表
+func (s *Shell) runPipeline(c *execCtx, pl *pipeline) (int, error) {
+	pc := c.with(c.frame, c.stdio)
+	pc.pipelineID = s.newPipelineID()
+	owner := c.job == nil
+	if owner {
+		pc.job = newJob(pl.text, false)
+	}
+
+	waits := s.startPipeline(c, pc, pl)
+	wait := func() (int, error) {
+		// 管道中的 exit 只结束所在的命令
+		status, err := 0, error(nil)
+		for _, w := range waits {
+			status, err = w()
+		}
+		if len(waits) > 1 {
+			err = nil
+		}
+		return status, err
+	}
+
+	var status int
+	var err error
+	if owner {
+		s.runJob(pc.job, wait)
+		status, err = s.waitForeground(pc.job)
+	} else {
+		status, err = wait()
+	}
+	c.status = status
+	return status, err
+}
+
+// startPipeline 启动管道中的各个命令
+func (s *Shell) startPipeline(c, pc *execCtx, pl *pipeline) []waitFunc {
+	if len(pl.commands) == 1 {
+		// 子 shell 在单独的 goroutine 中执行，被停止时 shell 可以回到提示符
+		cmd := pl.commands[0]
+		return []waitFunc{s.startCommand(pc, cmd, cmd.subshell != nil, func() {})}
+	}
+
+	waits := make([]waitFunc, len(pl.commands))
+	stdin := c.stdio[0]
+	for i, cmd := range pl.commands {
+		stdio := c.stdio
+		stdio[0] = stdin
+
+		// 本级命令持有的管道端，命令启动（外部命令）或结束（内置命令）后关闭
+		var owned []*os.File
+		if i > 0 {
+			owned = append(owned, stdin)
+		}
+		if i < len(pl.commands)-1 {
+			r, w, err := os.Pipe()
+			if err != nil {
+				for _, f := range owned {
+					f.Close()
+				}
+				c.report(fmt.Errorf("failed to create pipe: %w", err))
+				waits = waits[:i]
+				break
+			}
+			stdio[1] = w
+			owned = append(owned, w)
+			stdin = r
+		}
+
+		release := func() {
+			for _, f := range owned {
+				f.Close()
+			}
+		}
+		waits[i] = s.startCommand(pc.with(c.frame.clone(), stdio), cmd, true, release)
+	}
+	return waits
+}
+
+// startCommand 启动一个命令。async 为 true 时内置命令和子 shell 在单独的 goroutine 中执行。
+// release 在命令不再需要 c.stdio 中由调用方创建的文件时调用
+func (s *Shell) startCommand(c *execCtx, cmd *command, async bool, release func()) waitFunc {
+	vars, err := s.expandAssignments(c, cmd.assigns)
+	if err != nil {
+		release()
+		c.report(err)
+		return done(1, nil)
+	}
+	argv, err := s.expandWords(c, cmd.args)
+	if err != nil {
+		release()
+		c.report(err)
+		return done(1, nil)
+	}
+
+	for _, v := range vars {
+		if err := s.checkVariable(c, argv, v.name); err != nil {
+			release()
+			c.report(err)
+			return done(1, nil)
+		}
+	}
+	if err := s.checkRedirects(c, argv, cmd.redirs); err != nil {
+		release()
+		c.report(err)
+		return done(1, nil)
+	}
+	// 只有重定向的 exec 在其他 shell 中改变 shell 自身的标准输入输出，这里不支持，
+	// 在打开重定向的文件之前报错，而不是静默地什么都不做
+	if cmd.subshell == nil && len(argv) == 1 && argv[0] == "exec" && len(cmd.redirs) > 0 {
+		release()
+		s.logBuiltin(c, argv)
+		c.report(errors.New("exec: redirecting the shell's own input or output is not supported"))
+		return done(1, nil)
+	}
+
+	// 没有命令时赋值作用于当前环境，否则命令在带有这些导出变量的环境副本中执行
+	f := c.frame
+	if len(argv) == 0 {
+		for _, v := range vars {
+			f.env.Set(v.name, v.value)
+		}
+	} else if len(vars) > 0 {
+		f = &frame{dir: c.frame.dir, env: c.env.clone(), aliases: c.aliases, status: c.status}
+		for _, v := range vars {
+			f.env.Set(v.name, v.value)
+			f.env.Export(v.name)
+		}
+	}
+	c = c.with(f, c.stdio)
+
+	stdio, opened, err := s.openRedirects(c, cmd.redirs)
+	if err != nil {
+		release()
+		c.report(err)
+		return done(1, nil)
+	}
+	closeAll := func() {
+		for _, f := range opened {
+			f.Close()
+		}
+		release()
+	}
+	rc := c.with(f, stdio)
+	rc.alias = cmd.alias
+
+	// 外部命令启动后子进程已持有文件描述符，父进程可以立即关闭
+	if cmd.subshell == nil && len(argv) > 0 && !s.isBuiltinCommand(argv[0]) {
+		wait := s.startExternal(rc, argv)
+		closeAll()
+		return wait
+	}
+
+	// exec 不替换 shell 进程，否则之后的命令都不再被审计。命令作为普通的外部命令执行，
+	// 结束后 shell 以它的退出状态退出
+	if cmd.subshell == nil && len(argv) > 1 && argv[0] == "exec" {
+		s.logBuiltin(rc, argv)
+		wait := s.startExternal(rc, argv[1:])
+		closeAll()
+		return func() (int, error) {
+			status, _ := wait()
+			return status, errExit
+		}
+	}
+
+	run := func() (int, error) {
+		defer closeAll()
+		switch {
+		case cmd.subshell != nil:
+			// 子 shell 中的 exit 只结束子 shell
+			status, err := s.runList(rc.with(rc.frame.clone(), rc.stdio), cmd.subshell)
+			if errors.Is(err, errExit) {
+				err = nil
+			}
+			return status, err
+		case len(argv) == 0:
+			// 只有重定向（例如 "> file"）或展开结果为空
+			return rc.status, nil
+		default:
+			return s.runBuiltin(rc, argv)
+		}
+	}
+
+	if !async {
+		return done(run())
+	}
+	type result struct {
+		status int
+		err    error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		status, err := run()
+		ch <- result{status, err}
+	}()
+	return func() (int, error) {
+		r := <-ch
+		return r.status, r.err
+	}
+}
+
+// runBuiltin 记录 builtin 事件后执行内置命令
+func (s *Shell) runBuiltin(c *execCtx, argv []string) (int, error) {
+	s.logBuiltin(c, argv)
+	err := s.handleBuiltinCommand(c, argv[0], argv[1:])
+	var status exitStatus
+	switch {
+	case err == nil:
+		return 0, nil
+	case errors.As(err, &status):
+		return int(status), nil
+	case errors.Is(err, errExit):
+		return c.status, err
+	default:
+		c.report(err)
+		return 1, nil
+	}
+}
+
+// logBuiltin 记录内置命令。内置命令在 shell 进程内执行，内核只能看到 shell 自身，
+// export PATH=...、alias 等改变执行环境的操作只能由 shell 记录
+func (s *Shell) logBuiltin(c *execCtx, argv []string) {
+	s.auditor.LogBuiltin(os.Getpid(), os.Getppid(), s.uid, s.gid, s.username, argv[0], argv[1:], c.dir, c.pipelineID, c.rawLine, c.alias)
+}
+
+// startExternal 检查策略后启动外部命令，并记录 command 和 command_exit 事件
+func (s *Shell) startExternal(c *execCtx, argv []string) waitFunc {
+	name, args := argv[0], argv[1:]
+
+	if err := s.checkCommandName(c, argv, name); err != nil {
+		c.report(err)
+		return done(statusNotExecutable, nil)
+	}
+	path, err := lookPath(name, c.dir, c.env)
+	if err != nil {
+		c.report(err)
+		return done(statusNotFound, nil)
+	}
+
+	// 执行前检查策略
+	if err := s.checkPolicy(c, name, path, args); err != nil {
+		c.report(err)
+		return done(statusNotExecutable, nil)
+	}
+
+	proc := &exec.Cmd{
+		Path:   path,
+		Args:   argv,
+		Dir:    c.dir,
+		Env:    c.env.List(),
+		Stdin:  c.stdio[0],
+		Stdout: c.stdio[1],
+		Stderr: c.stdio[2],
+	}
+
+	start := time.Now()
+	wait, err := s.start(c, proc)
+	if err != nil {
+		c.report(fmt.Errorf("%s: %w", name, err))
+		return done(statusNotExecutable, nil)
+	}
+
+	// 记录命令执行
+	pid := proc.Process.Pid
+	commandID := s.auditor.LogCommand(
+		pid,
+		os.Getpid(),
+		s.uid,
+		s.gid,
+		s.username,
+		name,
+		path,
+		args,
+		c.dir,
+		c.pipelineID,
+		c.rawLine,
+		c.alias,
+	)
+
+	// scp、sftp-server 等文件传输命令额外记录 file_transfer 事件
+	if details, ok := detectTransfer(argv); ok {
+		details.CommandID = commandID
+		s.auditor.LogFileTransfer(pid, os.Getpid(), s.uid, s.gid, s.username, name, args, c.dir, details)
+	}
+
+	// 等待命令结束并记录退出状态
+	return func() (int, error) {
+		ws, ru := wait()
+		proc.Process.Release()
+		info := exitInfo(ws, &ru, time.Since(start))
+		s.auditor.LogCommandExit(commandID, pid, s.uid, s.gid, s.username, info)
+		return shellStatus(info), nil
+	}
+}
+
+// start 启动外部命令并加入所属的作业，返回的函数等待命令结束。录制会话时，输出到终端的
+// 前台命令在伪终端中执行，使用终端的标准输入输出改为连接到伪终端
+func (s *Shell) start(c *execCtx, proc *exec.Cmd) (func() (syscall.WaitStatus, syscall.Rusage), error) {
+	j := c.job
+	j.mu.Lock()
+	background := j.background
+	j.mu.Unlock()
+
+	finish := func() {}
+	if s.term != nil && !background && c.stdio[1] == os.Stdout {
+		if c.stdio[0] == os.Stdin {
+			proc.Stdin = nil
+		}
+		proc.Stdout = nil
+		if c.stdio[2] == os.Stdout || c.stdio[2] == os.Stderr {
+			proc.Stderr = nil
+		}
+		f, err := s.term.Start(proc)
+		if err != nil {
+			return nil, err
+		}
+		// 命令是伪终端会话的首进程，进程组即为自身
+		j.add(proc.Process.Pid, proc.Process.Pid)
+		finish = f
+	} else {
+		// 后台作业总是有自己的进程组，避免收到终端的 Ctrl-C；前台作业只在作业控制下
+		// 使用自己的进程组并占有终端
+		group := background || s.handsOff()
+		if err := j.start(proc, group, group && !background); err != nil {
+			return nil, err
+		}
+	}
+
+	type result struct {
+		ws syscall.WaitStatus
+		ru syscall.Rusage
+	}
+	done := make(chan result, 1)
+	pid := proc.Process.Pid
+	go func() {
+		ws, ru := j.watch(pid)
+		finish()
+		done <- result{ws, ru}
+	}()
+	return func() (syscall.WaitStatus, syscall.Rusage) {
+		r := <-done
+		return r.ws, r.ru
+	}, nil
+}
+
+// openRedirects 按顺序应用重定向，返回新的标准输入输出以及需要关闭的文件
+func (s *Shell) openRedirects(c *execCtx, redirs []redirect) ([3]*os.File, []*os.File, error) {
+	stdio := c.stdio
+	var opened []*os.File
+	fail := func(err error) ([3]*os.File, []*os.File, error) {
+		for _, f := range opened {
+			f.Close()
+		}
+		return stdio, nil, err
+	}
+
+	for _, r := range redirs {
+		target, err := s.expandString(c, r.target)
+		if err != nil {
+			return fail(err)
+		}
+		if r.fd < 0 || r.fd > 2 {
+			return fail(fmt.Errorf("%d: bad file descriptor", r.fd))
+		}
+
+		if r.op == ">&" || r.op == "<&" {
+			fd, err := strconv.Atoi(target)
+			if err != nil || fd < 0 || fd > 2 {
+				return fail(fmt.Errorf("%s: bad file descriptor", target))
+			}
+			stdio[r.fd] = stdio[fd]
+			continue
+		}
+
+		path := target
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(c.dir, path)
+		}
+		var flag int
+		switch r.op {
+		case "<":
+			flag = os.O_RDONLY
+		case ">":
+			flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		case ">>":
+			flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+		f, err := os.OpenFile(path, flag, 0644)
+		if err != nil {
+			return fail(fmt.Errorf("%s: %w", target, errors.Unwrap(err)))
+		}
+		opened = append(opened, f)
+		stdio[r.fd] = f
+	}
+	return stdio, opened, nil
+}
+
+// lookPath 在 PATH 中查找命令，包含 / 的命令名相对于工作目录解析
+func lookPath(name, dir string, env *environ) (string, error) {
+	if strings.Contains(name, "/") {
+		path := name
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		if err := checkExecutable(path); err != nil {
+			return "", fmt.Errorf("%s: %w", name, err)
+		}
+		return path, nil
+	}
+
+	if paths := searchPath(name, dir, env, false); len(paths) > 0 {
+		return paths[0], nil
+	}
+	return "", fmt.Errorf("%s: command not found", name)
+}
+
+// searchPath 在 PATH 中查找可执行文件，all 为 false 时只返回第一个
+func searchPath(name, dir string, env *environ, all bool) []string {
+	var paths []string
+	pathEnv, _ := env.Get("PATH")
+	for _, p := range filepath.SplitList(pathEnv) {
+		if p == "" {
+			p = "."
+		}
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(dir, p)
+		}
+		path := filepath.Join(p, name)
+		if checkExecutable(path) == nil {
+			paths = append(paths, path)
+			if !all {
+				break
+			}
+		}
+	}
+	return paths
+}
+
+// checkExecutable 检查文件是否为可执行的普通文件
+func checkExecutable(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return errors.Unwrap(err)
+	}
+	if info.IsDir() {
+		return errors.New("is a directory")
+	}
+	if info.Mode().Perm()&0111 == 0 {
+		return errors.New("permission denied")
+	}
+	return nil
+}
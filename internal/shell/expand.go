@@ -0,0 +1,433 @@
+package shell
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ifs 字段分割使用的分隔符
+const ifs = " \t\n"
+
+// segment 展开后的一段文本，quoted 的文本不做字段分割和文件名匹配
+type segment struct {
+	text   string
+	quoted bool
+}
+
+// field 展开后的一个参数
+type field []segment
+
+// String 返回参数文本
+func (f field) String() string {
+	var b strings.Builder
+	for _, s := range f {
+		b.WriteString(s.text)
+	}
+	return b.String()
+}
+
+// expandWords 依次进行波浪号展开、参数展开、命令替换、字段分割和文件名匹配，返回展开后的参数
+func (s *Shell) expandWords(c *execCtx, ws []word) ([]string, error) {
+	var argv []string
+	for _, w := range ws {
+		fields, err := s.expandWord(c, w)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range fields {
+			argv = append(argv, c.glob(f)...)
+		}
+	}
+	return argv, nil
+}
+
+// variable 展开后的变量赋值
+type variable struct {
+	name, value string
+}
+
+// expandAssignments 展开赋值的值，值不做字段分割和文件名匹配
+func (s *Shell) expandAssignments(c *execCtx, assigns []assignment) ([]variable, error) {
+	vars := make([]variable, 0, len(assigns))
+	for _, a := range assigns {
+		value := make(word, len(a.value))
+		for i, p := range a.value {
+			if p.kind != partLiteral && p.quote == 0 {
+				p.quote = '"'
+			}
+			value[i] = p
+		}
+		fields, err := s.expandWord(c, value)
+		if err != nil {
+			return nil, err
+		}
+		vars = append(vars, variable{name: a.name, value: joinFields(fields)})
+	}
+	return vars, nil
+}
+
+// expandString 展开单词并要求结果为单个参数，用于重定向目标
+func (s *Shell) expandString(c *execCtx, w word) (string, error) {
+	argv, err := s.expandWords(c, []word{w})
+	if err != nil {
+		return "", err
+	}
+	if len(argv) != 1 {
+		return "", fmt.Errorf("%s: ambiguous redirect", w)
+	}
+	return argv[0], nil
+}
+
+// joinFields 以空格连接展开结果，用于不做字段分割的上下文，例如 a="$@"
+func joinFields(fields []field) string {
+	parts := make([]string, len(fields))
+	for i, f := range fields {
+		parts[i] = f.String()
+	}
+	return strings.Join(parts, " ")
+}
+
+// expandWord 展开单词，未引用的展开结果按 IFS 分割为多个参数。"$@" 每个位置参数展开为一个参数，
+// 前后相邻的文本分别连接到第一个和最后一个参数，没有位置参数时不产生参数
+func (s *Shell) expandWord(c *execCtx, w word) ([]field, error) {
+	var fields []field
+	var cur field
+	hasCur := false
+	end := func() {
+		if hasCur {
+			fields = append(fields, cur)
+		}
+		cur, hasCur = nil, false
+	}
+
+	for i, p := range w {
+		switch p.kind {
+		case partLiteral:
+			text := p.text
+			if i == 0 && p.quote == 0 {
+				text = s.expandTilde(c, text)
+			}
+			cur = append(cur, segment{text: text, quoted: p.quote != 0})
+			// 双引号开头的空片段使 "" 成为一个参数，紧跟 "$@" 时由 "$@" 决定是否产生参数
+			if text != "" || (p.quote != 0 && !(i+1 < len(w) && isQuotedAt(w[i+1]))) {
+				hasCur = true
+			}
+			continue
+		}
+		if isQuotedAt(p) {
+			for j, arg := range s.args {
+				if j > 0 {
+					end()
+				}
+				cur = append(cur, segment{text: arg, quoted: true})
+				hasCur = true
+			}
+			continue
+		}
+
+		value, err := s.expandPart(c, p)
+		if err != nil {
+			return nil, err
+		}
+		if p.quote != 0 {
+			cur = append(cur, segment{text: value, quoted: true})
+			hasCur = true
+			continue
+		}
+
+		// 未引用的展开结果按 IFS 分割
+		for len(value) > 0 {
+			j := strings.IndexAny(value, ifs)
+			if j < 0 {
+				cur = append(cur, segment{text: value})
+				hasCur = true
+				break
+			}
+			if j > 0 {
+				cur = append(cur, segment{text: value[:j]})
+				hasCur = true
+			}
+			end()
+			value = strings.TrimLeft(value[j:], ifs)
+		}
+	}
+	end()
+	return fields, nil
+}
+
+// isQuotedAt 判断片段是否为引用的 "$@"
+func isQuotedAt(p wordPart) bool {
+	return p.kind == partParam && p.text == "@" && p.quote != 0
+}
+
+// expandPart 展开参数或命令替换
+func (s *Shell) expandPart(c *execCtx, p wordPart) (string, error) {
+	if p.kind == partCommand {
+		return s.commandSubst(c, p.text)
+	}
+	return s.expandParam(c, p.text)
+}
+
+// expandParam 展开参数，支持 $NAME、$?、$$、$!、$#、$0、$1...、$@、$*、${NAME}、${#NAME}，以及
+// ${NAME:-word}、${NAME-word}、${NAME:=word}、${NAME=word}、${NAME:+word}、${NAME+word}
+func (s *Shell) expandParam(c *execCtx, expr string) (string, error) {
+	switch expr {
+	case "?":
+		return strconv.Itoa(c.status), nil
+	case "$":
+		return strconv.Itoa(os.Getpid()), nil
+	case "#":
+		return strconv.Itoa(len(s.args)), nil
+	case "!":
+		if s.lastBackground == 0 {
+			return "", nil
+		}
+		return strconv.Itoa(s.lastBackground), nil
+	case "@", "*":
+		// 位置参数以空格连接，引用的 "$@" 在 expandWord 中按参数展开
+		return strings.Join(s.args, " "), nil
+	}
+
+	// ${#NAME} 为变量值的长度
+	if len(expr) > 1 && expr[0] == '#' {
+		value, _ := s.lookup(c, expr[1:])
+		return strconv.Itoa(len(value)), nil
+	}
+
+	n := 0
+	if isDigit(expr[0]) {
+		// 位置参数，${10} 为第十个参数
+		for n < len(expr) && isDigit(expr[n]) {
+			n++
+		}
+	} else {
+		for n < len(expr) && isNameChar(expr[n]) {
+			n++
+		}
+	}
+	name, rest := expr[:n], expr[n:]
+	if name == "" {
+		return "", fmt.Errorf("${%s}: bad substitution", expr)
+	}
+
+	value, set := s.lookup(c, name)
+	if rest == "" {
+		return value, nil
+	}
+
+	colon := strings.HasPrefix(rest, ":")
+	op := strings.TrimPrefix(rest, ":")
+	if op == "" {
+		return "", fmt.Errorf("${%s}: bad substitution", expr)
+	}
+	arg := op[1:]
+	// 带冒号时空值视为未设置
+	unset := !set || (colon && value == "")
+
+	switch op[0] {
+	case '-':
+		if unset {
+			return s.expandText(c, arg)
+		}
+		return value, nil
+	case '=':
+		if unset {
+			if isDigit(name[0]) {
+				return "", fmt.Errorf("$%s: cannot assign in this way", name)
+			}
+			if err := s.checkVariable(c, nil, name); err != nil {
+				return "", err
+			}
+			def, err := s.expandText(c, arg)
+			if err != nil {
+				return "", err
+			}
+			c.env.Set(name, def)
+			return def, nil
+		}
+		return value, nil
+	case '+':
+		if unset {
+			return "", nil
+		}
+		return s.expandText(c, arg)
+	default:
+		return "", fmt.Errorf("${%s}: bad substitution", expr)
+	}
+}
+
+// lookup 返回变量或位置参数的值，$0 为 shell 或脚本的名字
+func (s *Shell) lookup(c *execCtx, name string) (string, bool) {
+	if !isDigit(name[0]) {
+		return c.env.Get(name)
+	}
+	n, err := strconv.Atoi(name)
+	switch {
+	case err != nil:
+		return "", false
+	case n == 0:
+		return s.name, true
+	case n <= len(s.args):
+		return s.args[n-1], true
+	default:
+		return "", false
+	}
+}
+
+// expandText 按双引号中的规则展开文本，用于 ${NAME:-word} 中的 word
+func (s *Shell) expandText(c *execCtx, text string) (string, error) {
+	l := &lexer{input: `"` + strings.ReplaceAll(text, `"`, `\"`) + `"`}
+	parts, err := l.doubleQuoted()
+	if err != nil {
+		return "", err
+	}
+	fields, err := s.expandWord(c, parts)
+	if err != nil {
+		return "", err
+	}
+	return joinFields(fields), nil
+}
+
+// commandSubst 在子 shell 中执行命令并返回其标准输出，去掉末尾的换行
+func (s *Shell) commandSubst(c *execCtx, src string) (string, error) {
+	l, err := parse(src, c.aliases)
+	if err != nil {
+		return "", err
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return "", fmt.Errorf("failed to create pipe: %w", err)
+	}
+
+	var out bytes.Buffer
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		out.ReadFrom(r)
+		r.Close()
+	}()
+
+	sub := c.with(c.frame.clone(), [3]*os.File{c.stdio[0], w, c.stdio[2]})
+	status, _ := s.runList(sub, l)
+	w.Close()
+	wg.Wait()
+
+	// 与 POSIX 一致，$? 为命令替换中最后一个命令的退出状态
+	c.status = status
+	return strings.TrimRight(out.String(), "\n"), nil
+}
+
+// expandTilde 展开单词开头的 ~ 和 ~user
+func (s *Shell) expandTilde(c *execCtx, text string) string {
+	if !strings.HasPrefix(text, "~") {
+		return text
+	}
+	name, rest, _ := strings.Cut(text[1:], "/")
+	if rest != "" || strings.Contains(text, "/") {
+		rest = "/" + rest
+	}
+
+	var home string
+	if name == "" {
+		home, _ = c.env.Get("HOME")
+		if home == "" {
+			home = s.homeDir
+		}
+	} else if u, err := user.Lookup(name); err == nil {
+		home = u.HomeDir
+	} else {
+		return text
+	}
+	return home + rest
+}
+
+// glob 对包含未引用通配符的参数做文件名匹配，没有匹配时保留原样
+func (c *execCtx) glob(f field) []string {
+	var pattern strings.Builder
+	magic := false
+	for _, seg := range f {
+		if seg.quoted {
+			for _, r := range seg.text {
+				if strings.ContainsRune(`*?[\`, r) {
+					pattern.WriteByte('\\')
+				}
+				pattern.WriteRune(r)
+			}
+			continue
+		}
+		if strings.ContainsAny(seg.text, "*?[") {
+			magic = true
+		}
+		pattern.WriteString(seg.text)
+	}
+
+	text := f.String()
+	if !magic {
+		return []string{text}
+	}
+
+	pat := pattern.String()
+	abs := pat
+	if !filepath.IsAbs(pat) {
+		abs = filepath.Join(escapeGlob(c.dir), pat)
+	}
+	matches, err := filepath.Glob(abs)
+	if err != nil {
+		return []string{text}
+	}
+
+	// 与常见 shell 一致，通配符不匹配以 . 开头的文件
+	patElems := strings.Split(abs, "/")
+	var result []string
+	for _, m := range matches {
+		if hiddenMatch(patElems, strings.Split(m, "/")) {
+			continue
+		}
+		if !filepath.IsAbs(pat) {
+			m, _ = filepath.Rel(c.dir, m)
+			if strings.HasPrefix(pat, "./") {
+				m = "./" + m
+			}
+		}
+		result = append(result, m)
+	}
+	if len(result) == 0 {
+		return []string{text}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// hiddenMatch 判断匹配结果中是否有以 . 开头的路径元素是由不以 . 开头的模式匹配到的
+func hiddenMatch(pattern, path []string) bool {
+	if len(pattern) != len(path) {
+		return false
+	}
+	for i := range path {
+		if strings.HasPrefix(path[i], ".") && !strings.HasPrefix(pattern[i], ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// escapeGlob 转义路径中的通配符
+func escapeGlob(path string) string {
+	var b strings.Builder
+	for _, r := range path {
+		if strings.ContainsRune(`*?[\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
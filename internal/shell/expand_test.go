@@ -0,0 +1,149 @@
+package shell
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testExpand 词法分析一行输入并展开其中的单词
+func testExpand(t *testing.T, s *Shell, c *execCtx, line string) []string {
+	t.Helper()
+	l := &lexer{input: line}
+	var ws []word
+	for {
+		tok, err := l.next()
+		if err != nil {
+			t.Fatalf("%s: %v", line, err)
+		}
+		if tok.kind == tokEOF {
+			break
+		}
+		ws = append(ws, tok.word)
+	}
+	argv, err := s.expandWords(c, ws)
+	if err != nil {
+		t.Fatalf("%s: %v", line, err)
+	}
+	return argv
+}
+
+func newTestShell(dir string, args ...string) (*Shell, *execCtx) {
+	env := newEnviron()
+	env.Set("HOME", "/home/test")
+	env.Set("V", "1  2")
+	env.Set("EMPTY", "")
+	env.Set("G", "*.txt")
+	s := &Shell{name: "sh", args: args}
+	return s, &execCtx{frame: &frame{dir: dir, env: env, aliases: make(map[string]string)}}
+}
+
+func TestExpandWords(t *testing.T) {
+	tests := []struct {
+		line string
+		args []string
+		want []string
+	}{
+		{line: `a  b`, want: []string{"a", "b"}},
+		{line: `"a  b" 'c $V'`, want: []string{"a  b", "c $V"}},
+		{line: `$V`, want: []string{"1", "2"}},
+		{line: `"$V"`, want: []string{"1  2"}},
+		{line: `x$V"y"`, want: []string{"x1", "2y"}},
+		{line: `$EMPTY`, want: nil},
+		{line: `"$EMPTY"`, want: []string{""}},
+		{line: `$UNSET_VARIABLE_FOR_TEST`, want: nil},
+		{line: `${UNSET_VARIABLE_FOR_TEST:-a b}`, want: []string{"a", "b"}},
+		{line: `"${EMPTY:-a b}"`, want: []string{"a b"}},
+		{line: `${V:+set}`, want: []string{"set"}},
+		{line: `${#V}`, want: []string{"4"}},
+		{line: `~/x ~`, want: []string{"/home/test/x", "/home/test"}},
+		{line: `a~`, want: []string{"a~"}},
+		{line: `$# $1 ${2}`, args: []string{"x y", "z"}, want: []string{"2", "x", "y", "z"}},
+		{line: `"$@"`, args: []string{"x y", "z"}, want: []string{"x y", "z"}},
+		{line: `"$@"`, want: nil},
+		{line: `"""$@"`, want: []string{""}},
+		{line: `"$@"''`, want: []string{""}},
+		{line: `"pre$@post"`, args: []string{"a b", "c"}, want: []string{"prea b", "cpost"}},
+		{line: `"pre$@post"`, want: []string{"prepost"}},
+		{line: `"$@" "$@"`, args: []string{"a"}, want: []string{"a", "a"}},
+		{line: `$@`, args: []string{"x y", "z"}, want: []string{"x", "y", "z"}},
+		{line: `"$*"`, args: []string{"x y", "z"}, want: []string{"x y z"}},
+	}
+
+	for _, tt := range tests {
+		s, c := newTestShell(t.TempDir(), tt.args...)
+		if got := testExpand(t, s, c, tt.line); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s with args %q = %q, want %q", tt.line, tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestExpandAssignments(t *testing.T) {
+	s, c := newTestShell(t.TempDir(), "x y", "z")
+	for _, tt := range []struct {
+		line, want string
+	}{
+		{`A=$V`, "1  2"},
+		{`A="$@"`, "x y z"},
+		{`A=$@`, "x y z"},
+		{`A=`, ""},
+	} {
+		l := &lexer{input: tt.line}
+		tok, err := l.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, ok := parseAssignment(tok.word)
+		if !ok {
+			t.Fatalf("%s is not an assignment", tt.line)
+		}
+		vars, err := s.expandAssignments(c, []assignment{a})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if vars[0].value != tt.want {
+			t.Errorf("%s = %q, want %q", tt.line, vars[0].value, tt.want)
+		}
+	}
+}
+
+func TestGlob(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt", ".hidden.txt", "sub/c.txt", "x[1].log"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{`*.txt`, []string{"a.txt", "b.txt"}},
+		{`?.txt`, []string{"a.txt", "b.txt"}},
+		{`[a].txt`, []string{"a.txt"}},
+		{`./*.txt`, []string{"./a.txt", "./b.txt"}},
+		{`*/c.txt`, []string{"sub/c.txt"}},
+		{`.*.txt`, []string{".hidden.txt"}},
+		{`*.none`, []string{"*.none"}},
+		{`"*.txt"`, []string{"*.txt"}},
+		{`\*.txt`, []string{"*.txt"}},
+		{`"a"*`, []string{"a.txt"}},
+		{`"x["*`, []string{"x[1].log"}},
+		{`$G`, []string{"a.txt", "b.txt"}},
+		{`"$G"`, []string{"*.txt"}},
+		{dir + `/a*`, []string{filepath.Join(dir, "a.txt")}},
+	}
+
+	s, c := newTestShell(dir)
+	for _, tt := range tests {
+		if got := testExpand(t, s, c, tt.line); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
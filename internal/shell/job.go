@@ -0,0 +1,726 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cevin/shell-auditor/internal/audit"
+	"github.com/cevin/shell-auditor/internal/tty"
+)
+
+// jobState 作业状态
+type jobState int
+
+const (
+	jobRunning jobState = iota
+	jobStopped
+	jobDone
+)
+
+func (st jobState) String() string {
+	switch st {
+	case jobStopped:
+		return "Stopped"
+	case jobDone:
+		return "Done"
+	default:
+		return "Running"
+	}
+}
+
+// job 作业。前台执行时为一个管道，后台执行时为整个 && || 列表。作业中的外部命令加入同一个
+// 进程组，可以整体停止、继续，并在前台和后台之间切换
+type job struct {
+	line    string
+	started time.Time
+
+	mu         sync.Mutex
+	id         int          // 作业号，加入作业表时分配
+	pgid       int          // 进程组，为 0 时进程与 shell 在同一进程组
+	procs      map[int]bool // 未结束的进程，值为是否已停止
+	background bool
+	state      jobState
+	reported   jobState   // 最近一次通知用户的状态
+	signal     int        // 停止信号
+	status     int        // 结束后的退出状态
+	err        error      // exit 内置命令返回的 errExit
+	modes      *tty.State // 作业停止时的终端属性，fg 时恢复
+	changed    chan struct{}
+	hasProc    chan struct{} // 第一个外部命令启动或作业中的命令执行完时关闭
+}
+
+// newJob 创建作业
+func newJob(line string, background bool) *job {
+	return &job{
+		line:       line,
+		started:    time.Now(),
+		procs:      make(map[int]bool),
+		background: background,
+		changed:    make(chan struct{}),
+		hasProc:    make(chan struct{}),
+	}
+}
+
+// details 返回作业的审计详情
+func (j *job) details() audit.JobDetails {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	details := audit.JobDetails{
+		JobID:       j.id,
+		PGID:        j.pgid,
+		CommandLine: j.line,
+		Background:  j.background,
+	}
+	if j.state == jobStopped {
+		details.Signal = j.signal
+	}
+	return details
+}
+
+// notify 唤醒等待作业状态变化的 goroutine，调用时持有 j.mu
+func (j *job) notify() {
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+// start 启动作业中的外部命令。group 为 true 时命令加入作业的进程组（第一个命令创建进程组），
+// foreground 为 true 时同时把进程组设置为终端的前台进程组
+func (j *job) start(proc *exec.Cmd, group, foreground bool) error {
+	if !group {
+		if err := proc.Start(); err != nil {
+			return err
+		}
+		j.add(proc.Process.Pid, 0)
+		return nil
+	}
+
+	j.mu.Lock()
+	pgid := j.pgid
+	j.mu.Unlock()
+
+	attr := &syscall.SysProcAttr{Setpgid: true, Pgid: pgid}
+	if foreground {
+		attr.Foreground = true
+		attr.Ctty = syscall.Stdin
+	}
+	proc.SysProcAttr = attr
+	err := proc.Start()
+	if err != nil && pgid != 0 && errors.Is(err, syscall.EPERM) {
+		// 作业中之前的进程都已结束，进程组不复存在，改为创建新的进程组
+		retry := &exec.Cmd{Path: proc.Path, Args: proc.Args, Dir: proc.Dir, Env: proc.Env,
+			Stdin: proc.Stdin, Stdout: proc.Stdout, Stderr: proc.Stderr}
+		attr.Pgid = 0
+		retry.SysProcAttr = attr
+		if err = retry.Start(); err == nil {
+			*proc = *retry
+			pgid = 0
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if pgid == 0 {
+		pgid = proc.Process.Pid
+	}
+	j.add(proc.Process.Pid, pgid)
+	return nil
+}
+
+// add 登记作业中的进程
+func (j *job) add(pid, pgid int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if pgid != 0 {
+		j.pgid = pgid
+	}
+	j.procs[pid] = false
+	j.markStarted()
+}
+
+// markStarted 关闭 hasProc，调用时持有 j.mu
+func (j *job) markStarted() {
+	select {
+	case <-j.hasProc:
+	default:
+		close(j.hasProc)
+	}
+}
+
+// watch 等待进程结束，期间进程停止或继续时更新作业状态。进程由这里回收，不调用 exec.Cmd.Wait
+func (j *job) watch(pid int) (syscall.WaitStatus, syscall.Rusage) {
+	for {
+		var ws syscall.WaitStatus
+		var ru syscall.Rusage
+		_, err := syscall.Wait4(pid, &ws, syscall.WUNTRACED|syscall.WCONTINUED, &ru)
+		if err == syscall.EINTR {
+			continue
+		}
+		switch {
+		case err == nil && ws.Stopped():
+			j.setStopped(pid, true, int(ws.StopSignal()))
+		case err == nil && ws.Continued():
+			j.setStopped(pid, false, 0)
+		default:
+			j.mu.Lock()
+			delete(j.procs, pid)
+			j.update()
+			j.mu.Unlock()
+			return ws, ru
+		}
+	}
+}
+
+// setStopped 更新进程的停止状态
+func (j *job) setStopped(pid int, stopped bool, sig int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, ok := j.procs[pid]; !ok {
+		return
+	}
+	j.procs[pid] = stopped
+	if stopped {
+		j.signal = sig
+	}
+	j.update()
+}
+
+// update 根据进程状态更新作业状态：所有未结束的进程都停止时作业停止。调用时持有 j.mu
+func (j *job) update() {
+	if j.state == jobDone {
+		return
+	}
+	state := jobRunning
+	if len(j.procs) > 0 {
+		state = jobStopped
+		for _, stopped := range j.procs {
+			if !stopped {
+				state = jobRunning
+				break
+			}
+		}
+	}
+	if state != j.state {
+		j.state = state
+		j.notify()
+	}
+}
+
+// finish 标记作业结束
+func (j *job) finish(status int, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.state = jobDone
+	j.status = status
+	j.err = err
+	j.markStarted()
+	j.notify()
+}
+
+// resume 在发送 SIGCONT 之前把作业标记为运行中
+func (j *job) resume(background bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.background = background
+	if j.state == jobDone {
+		return
+	}
+	for pid := range j.procs {
+		j.procs[pid] = false
+	}
+	j.state = jobRunning
+	j.notify()
+}
+
+// waitChange 等待作业结束或停止，返回此时的状态
+func (j *job) waitChange() jobState {
+	for {
+		j.mu.Lock()
+		state, ch := j.state, j.changed
+		j.mu.Unlock()
+		if state != jobRunning {
+			return state
+		}
+		<-ch
+	}
+}
+
+// waitDone 等待作业结束，返回退出状态
+func (j *job) waitDone() int {
+	for {
+		j.mu.Lock()
+		state, status, ch := j.state, j.status, j.changed
+		j.mu.Unlock()
+		if state == jobDone {
+			return status
+		}
+		<-ch
+	}
+}
+
+// kill 向作业发送信号。作业有自己的进程组时发给整个进程组，否则逐个发给作业中的进程
+func (j *job) kill(sig syscall.Signal) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.pgid != 0 {
+		syscall.Kill(-j.pgid, sig)
+		return
+	}
+	for pid := range j.procs {
+		syscall.Kill(pid, sig)
+	}
+}
+
+// jobTable 作业表，保存后台作业和被停止的作业
+type jobTable struct {
+	mu     sync.Mutex
+	jobs   []*job
+	recent []*job // 最近停止或放到后台的作业在最后，用于确定当前作业（%+）
+}
+
+// add 把作业加入作业表并分配作业号，已在表中时只更新当前作业
+func (t *jobTable) add(j *job) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.touch(j)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.id != 0 {
+		return j.id
+	}
+	j.id = 1
+	if n := len(t.jobs); n > 0 {
+		j.id = t.jobs[n-1].id + 1
+	}
+	t.jobs = append(t.jobs, j)
+	return j.id
+}
+
+// touch 把作业设为当前作业，调用时持有 t.mu
+func (t *jobTable) touch(j *job) {
+	for i, r := range t.recent {
+		if r == j {
+			t.recent = append(t.recent[:i], t.recent[i+1:]...)
+			break
+		}
+	}
+	t.recent = append(t.recent, j)
+}
+
+// remove 从作业表中删除作业
+func (t *jobTable) remove(j *job) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, x := range t.jobs {
+		if x == j {
+			t.jobs = append(t.jobs[:i], t.jobs[i+1:]...)
+			break
+		}
+	}
+	for i, x := range t.recent {
+		if x == j {
+			t.recent = append(t.recent[:i], t.recent[i+1:]...)
+			break
+		}
+	}
+}
+
+// list 返回作业表中的作业，按作业号排列
+func (t *jobTable) list() []*job {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	jobs := append([]*job(nil), t.jobs...)
+	sort.Slice(jobs, func(a, b int) bool { return jobs[a].id < jobs[b].id })
+	return jobs
+}
+
+// current 返回当前作业：最近停止的作业，没有停止的作业时为最近放到后台的作业
+func (t *jobTable) current() *job {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := len(t.recent) - 1; i >= 0; i-- {
+		j := t.recent[i]
+		j.mu.Lock()
+		stopped := j.state == jobStopped
+		j.mu.Unlock()
+		if stopped {
+			return j
+		}
+	}
+	if len(t.recent) > 0 {
+		return t.recent[len(t.recent)-1]
+	}
+	return nil
+}
+
+// stopped 返回被停止的作业数
+func (t *jobTable) stopped() int {
+	n := 0
+	for _, j := range t.list() {
+		j.mu.Lock()
+		if j.state == jobStopped {
+			n++
+		}
+		j.mu.Unlock()
+	}
+	return n
+}
+
+// find 按作业说明查找作业：%N 或 N 为作业号，%% 或 %+ 为当前作业，%string 为以 string
+// 开头的作业，未指定时为当前作业
+func (t *jobTable) find(spec string) (*job, error) {
+	if spec == "" || spec == "%%" || spec == "%+" {
+		if j := t.current(); j != nil {
+			return j, nil
+		}
+		return nil, errors.New("no current job")
+	}
+
+	name := strings.TrimPrefix(spec, "%")
+	if n, err := strconv.Atoi(name); err == nil {
+		for _, j := range t.list() {
+			if j.id == n {
+				return j, nil
+			}
+		}
+		return nil, fmt.Errorf("%s: no such job", spec)
+	}
+
+	var found *job
+	for _, j := range t.list() {
+		if strings.HasPrefix(j.line, name) {
+			if found != nil {
+				return nil, fmt.Errorf("%s: ambiguous job spec", spec)
+			}
+			found = j
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%s: no such job", spec)
+	}
+	return found, nil
+}
+
+// startJobControl 启用作业控制：shell 成为自己的进程组的首进程并占有终端。
+// 只在交互式 shell 且标准输入是终端时启用
+func (s *Shell) startJobControl() {
+	fg, err := tty.Foreground(os.Stdin)
+	if err != nil || fg != syscall.Getpgrp() {
+		// 在后台启动，不能占有终端
+		return
+	}
+	pid := os.Getpid()
+	if syscall.Getpgrp() != pid {
+		if err := syscall.Setpgid(0, 0); err != nil {
+			return
+		}
+	}
+	if err := tty.SetForeground(os.Stdin, pid); err != nil {
+		return
+	}
+
+	s.jobControl = true
+	s.pgid = pid
+	s.origForeground = fg
+	s.modes, _ = tty.GetState(os.Stdin)
+}
+
+// stopJobControl 把终端交还给启动 shell 时的前台进程组
+func (s *Shell) stopJobControl() {
+	if s.jobControl {
+		tty.SetForeground(os.Stdin, s.origForeground)
+	}
+}
+
+// handsOff 判断作业是否可以成为终端的前台进程组。录制时命令在伪终端中执行，
+// 用户终端的属性由 shell 设置，shell 必须始终在前台
+func (s *Shell) handsOff() bool {
+	return s.jobControl && s.term == nil
+}
+
+// claimTerminal 取回终端。stopped 为作业停止时的情况：保存作业的终端属性并恢复 shell 的终端属性
+func (s *Shell) claimTerminal(j *job, stopped bool) {
+	if !s.handsOff() {
+		return
+	}
+	tty.SetForeground(os.Stdin, s.pgid)
+	if stopped && j != nil {
+		modes, _ := tty.GetState(os.Stdin)
+		j.mu.Lock()
+		j.modes = modes
+		j.mu.Unlock()
+		if s.modes != nil {
+			tty.SetState(os.Stdin, s.modes)
+		}
+	}
+}
+
+// waitForeground 等待前台作业结束或停止。停止的作业加入作业表，返回 128+信号
+func (s *Shell) waitForeground(j *job) (int, error) {
+	s.fg.Store(j)
+	state := j.waitChange()
+	s.fg.Store(nil)
+	s.claimTerminal(j, state == jobStopped)
+
+	if state == jobStopped {
+		id := s.jobs.add(j)
+		j.mu.Lock()
+		j.background = false
+		j.reported = jobStopped
+		sig := j.signal
+		j.mu.Unlock()
+		s.auditor.LogJobStop(os.Getpid(), s.uid, s.gid, s.username, j.details())
+		fmt.Fprintf(s.errOut, "\n[%d]+  %-22s %s\n", id, jobStopped, j.line)
+		return 128 + sig, nil
+	}
+
+	j.mu.Lock()
+	status, err, id := j.status, j.err, j.id
+	j.mu.Unlock()
+	if id != 0 {
+		// fg 继续的作业在前台结束，不再通知
+		s.jobs.remove(j)
+	}
+	return status, err
+}
+
+// runJob 在 goroutine 中等待作业中的命令结束，作业结束后记录 job_exit
+func (s *Shell) runJob(j *job, wait func() (int, error)) {
+	go func() {
+		status, err := wait()
+		j.finish(status, err)
+		if details := j.details(); details.JobID != 0 {
+			s.auditor.LogJobExit(os.Getpid(), s.uid, s.gid, s.username, details, status, time.Since(j.started))
+		}
+	}()
+}
+
+// runBackground 在后台执行命令，不等待结束
+func (s *Shell) runBackground(c *execCtx, ao *andOr) {
+	j := newJob(ao.text, true)
+	bc := c.with(c.frame.clone(), c.stdio)
+	bc.job = j
+
+	// 没有作业控制时后台作业不能读取终端，与 POSIX shell 一致从 /dev/null 读取
+	var devNull *os.File
+	if !s.jobControl && bc.stdio[0] == os.Stdin {
+		if f, err := os.Open(os.DevNull); err == nil {
+			devNull = f
+			bc.stdio[0] = f
+		}
+	}
+
+	id := s.jobs.add(j)
+	logged := make(chan struct{})
+	s.runJob(j, func() (int, error) {
+		status, _ := s.runAndOr(bc, ao)
+		if devNull != nil {
+			devNull.Close()
+		}
+		j.mu.Lock()
+		j.markStarted()
+		j.mu.Unlock()
+		// job_exit 在 job_start 之后记录
+		<-logged
+		// 后台作业中的 exit 只结束作业
+		return status, nil
+	})
+
+	// 等第一个外部命令启动后才知道进程组
+	<-j.hasProc
+	details := j.details()
+	s.lastBackground = details.PGID
+	s.auditor.LogJobStart(os.Getpid(), s.uid, s.gid, s.username, details)
+	close(logged)
+	if s.interactive {
+		fmt.Fprintf(c.stderr(), "[%d] %d\n", id, details.PGID)
+	}
+}
+
+// Terminate 终端断开或 shell 被终止时调用：把信号转发给所有作业（停止的作业随后收到
+// SIGCONT 以便处理信号），然后记录 session_end
+func (s *Shell) Terminate(sig syscall.Signal) {
+	reason := "terminated"
+	if sig == syscall.SIGHUP {
+		reason = "hangup"
+	}
+
+	jobs := s.jobs.list()
+	if j := s.fg.Load(); j != nil {
+		jobs = append(jobs, j)
+	}
+	for _, j := range jobs {
+		j.kill(sig)
+		j.kill(syscall.SIGCONT)
+	}
+	s.EndSession(reason)
+}
+
+// notifyJobs 报告后台作业的状态变化并从作业表中删除已结束的作业，在显示提示符前调用
+func (s *Shell) notifyJobs() {
+	current := s.jobs.current()
+	for _, j := range s.jobs.list() {
+		j.mu.Lock()
+		state, reported, status := j.state, j.reported, j.status
+		j.reported = state
+		j.mu.Unlock()
+
+		if state != reported && state != jobRunning {
+			fmt.Fprintln(s.errOut, jobLine(j, j == current, 0, state, status))
+		}
+		if state == jobDone {
+			s.jobs.remove(j)
+		}
+	}
+}
+
+// jobLine 格式化作业表中的一行，例如 "[1]+  Stopped    vi a.txt"，pgid 不为 0 时显示进程组
+func jobLine(j *job, current bool, pgid int, state jobState, status int) string {
+	mark := " "
+	if current {
+		mark = "+"
+	}
+	text := state.String()
+	if state == jobDone && status != 0 {
+		text = fmt.Sprintf("Exit %d", status)
+	}
+	if pgid != 0 {
+		return fmt.Sprintf("[%d]%s %d  %-22s %s", j.id, mark, pgid, text, j.line)
+	}
+	return fmt.Sprintf("[%d]%s  %-22s %s", j.id, mark, text, j.line)
+}
+
+// handleJobs 处理jobs命令，-l 同时显示进程组
+func (s *Shell) handleJobs(c *execCtx, args []string) error {
+	long := len(args) > 0 && args[0] == "-l"
+	current := s.jobs.current()
+	for _, j := range s.jobs.list() {
+		j.mu.Lock()
+		state, status, pgid := j.state, j.status, j.pgid
+		j.reported = state
+		j.mu.Unlock()
+
+		if !long {
+			pgid = 0
+		}
+		fmt.Fprintln(c.stdout(), jobLine(j, j == current, pgid, state, status))
+		if state == jobDone {
+			s.jobs.remove(j)
+		}
+	}
+	return nil
+}
+
+// handleFg 处理fg命令，把作业切换到前台继续运行并等待
+func (s *Shell) handleFg(c *execCtx, args []string) error {
+	j, err := s.jobs.find(firstArg(args))
+	if err != nil {
+		return fmt.Errorf("fg: %w", err)
+	}
+	fmt.Fprintln(c.stdout(), j.line)
+
+	j.mu.Lock()
+	pgid, modes := j.pgid, j.modes
+	j.mu.Unlock()
+	if s.handsOff() && pgid != 0 {
+		if modes != nil {
+			tty.SetState(os.Stdin, modes)
+		}
+		tty.SetForeground(os.Stdin, pgid)
+	}
+
+	j.resume(false)
+	s.jobs.add(j)
+	j.kill(syscall.SIGCONT)
+	s.auditor.LogJobContinue(os.Getpid(), s.uid, s.gid, s.username, j.details())
+
+	status, err := s.waitForeground(j)
+	if err != nil {
+		return err
+	}
+	return exitStatus(status)
+}
+
+// handleBg 处理bg命令，让停止的作业在后台继续运行
+func (s *Shell) handleBg(c *execCtx, args []string) error {
+	j, err := s.jobs.find(firstArg(args))
+	if err != nil {
+		return fmt.Errorf("bg: %w", err)
+	}
+	j.mu.Lock()
+	state, id := j.state, j.id
+	j.mu.Unlock()
+	if state != jobStopped {
+		return fmt.Errorf("bg: job %d already in background", id)
+	}
+
+	j.resume(true)
+	j.kill(syscall.SIGCONT)
+	s.auditor.LogJobContinue(os.Getpid(), s.uid, s.gid, s.username, j.details())
+	fmt.Fprintf(c.stdout(), "[%d]+ %s &\n", id, j.line)
+	return nil
+}
+
+// handleWait 处理wait命令，等待指定的作业或所有后台作业结束
+func (s *Shell) handleWait(c *execCtx, args []string) error {
+	if len(args) == 0 {
+		for _, j := range s.jobs.list() {
+			j.mu.Lock()
+			stopped := j.state == jobStopped
+			j.mu.Unlock()
+			if !stopped {
+				j.waitDone()
+				s.jobs.remove(j)
+			}
+		}
+		return nil
+	}
+
+	status := 0
+	for _, arg := range args {
+		j, err := s.jobs.find(arg)
+		if err != nil {
+			if pid, perr := strconv.Atoi(arg); perr == nil {
+				j = s.jobByPGID(pid)
+			}
+			if j == nil {
+				c.report(fmt.Errorf("wait: %w", err))
+				status = statusNotFound
+				continue
+			}
+		}
+		status = j.waitDone()
+		s.jobs.remove(j)
+	}
+	return exitStatus(status)
+}
+
+// jobByPGID 按进程组查找作业，用于 wait $!
+func (s *Shell) jobByPGID(pgid int) *job {
+	for _, j := range s.jobs.list() {
+		j.mu.Lock()
+		match := j.pgid == pgid
+		j.mu.Unlock()
+		if match {
+			return j
+		}
+	}
+	return nil
+}
+
+// firstArg 返回第一个参数，没有参数时返回空字符串
+func firstArg(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	return args[0]
+}
@@ -0,0 +1,736 @@
+package shell
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// tokenKind 词法单元类型
+type tokenKind int
+
+const (
+	tokWord     tokenKind = iota
+	tokPipe               // |
+	tokAnd                // &&
+	tokOr                 // ||
+	tokSemi               // ;
+	tokAmp                // &
+	tokLParen             // (
+	tokRParen             // )
+	tokRedirect           // <, >, >>, >&, <&，可带文件描述符前缀
+	tokNewline            // 换行，与 ; 一样分隔命令
+	tokEOF
+)
+
+// partKind 单词片段类型
+type partKind int
+
+const (
+	partLiteral partKind = iota // 普通文本
+	partParam                   // 参数展开，text 为 $ 之后的名称或 {} 中的内容
+	partCommand                 // 命令替换，text 为 $() 或 `` 中的命令
+)
+
+// wordPart 单词中的一段。quote 为该段的引用方式：未引用时为 0，单引号、双引号或反斜杠转义时
+// 为对应的字符。引用的片段不做字段分割和文件名匹配
+type wordPart struct {
+	kind  partKind
+	text  string
+	quote byte
+}
+
+// word 由若干相邻片段组成的单词，例如 a"b $c"'d'
+type word []wordPart
+
+// String 返回单词的原始形式，用于错误信息
+func (w word) String() string {
+	var b strings.Builder
+	for _, p := range w {
+		switch p.kind {
+		case partParam:
+			b.WriteString("${" + p.text + "}")
+		case partCommand:
+			b.WriteString("$(" + p.text + ")")
+		default:
+			b.WriteString(p.text)
+		}
+	}
+	return b.String()
+}
+
+// token 词法单元
+type token struct {
+	kind tokenKind
+	op   string // 运算符文本
+	fd   int    // 重定向的文件描述符，未指定时为 -1
+	word word
+	pos  int
+}
+
+// redirect 重定向
+type redirect struct {
+	fd     int    // 被重定向的文件描述符
+	op     string // <, >, >>, >&, <&
+	target word   // 文件名，或 >&/<& 的目标文件描述符
+}
+
+// assignment 变量赋值 NAME=value
+type assignment struct {
+	name  string
+	value word
+}
+
+// command 简单命令或子 shell。没有参数时赋值作用于当前 shell，否则只作用于该命令。
+// alias 为展开出该命令的别名
+type command struct {
+	assigns  []assignment
+	args     []word
+	redirs   []redirect
+	subshell *list
+	alias    string
+}
+
+// pipeline 管道，各命令的输出依次连接到下一个命令的输入
+type pipeline struct {
+	commands []*command
+	text     string // 源码文本，用于作业列表
+}
+
+// andOr 以 && 或 || 连接的管道，ops[i] 位于 pipelines[i] 与 pipelines[i+1] 之间。
+// 以 & 结束时作为后台作业执行
+type andOr struct {
+	pipelines  []*pipeline
+	ops        []tokenKind
+	background bool
+	text       string
+}
+
+// list 以 ;、& 或换行分隔的命令列表
+type list struct {
+	items []*andOr
+}
+
+// syntaxError 语法错误。incomplete 表示输入在命令结束前就结束了（例如引号未闭合），
+// 读取更多行后可能成为合法的命令
+type syntaxError struct {
+	pos        int
+	msg        string
+	incomplete bool
+}
+
+func (e *syntaxError) Error() string {
+	return fmt.Sprintf("syntax error at column %d: %s", e.pos+1, e.msg)
+}
+
+// lexer 词法分析器
+type lexer struct {
+	input string
+	pos   int
+}
+
+// isMeta 判断字符是否结束一个单词
+func isMeta(c byte) bool {
+	switch c {
+	case ' ', '\t', '\n', '|', '&', ';', '(', ')', '<', '>':
+		return true
+	}
+	return false
+}
+
+// next 返回下一个词法单元
+func (l *lexer) next() (token, error) {
+	l.skipBlank()
+	if l.pos >= len(l.input) {
+		return token{kind: tokEOF, pos: l.pos}, nil
+	}
+
+	start := l.pos
+	c := l.input[l.pos]
+
+	switch c {
+	case '\n':
+		l.pos++
+		return token{kind: tokNewline, op: "\n", pos: start}, nil
+	case '|':
+		if l.peekAt(1) == '|' {
+			l.pos += 2
+			return token{kind: tokOr, op: "||", pos: start}, nil
+		}
+		l.pos++
+		return token{kind: tokPipe, op: "|", pos: start}, nil
+	case '&':
+		if l.peekAt(1) == '&' {
+			l.pos += 2
+			return token{kind: tokAnd, op: "&&", pos: start}, nil
+		}
+		l.pos++
+		return token{kind: tokAmp, op: "&", pos: start}, nil
+	case ';':
+		l.pos++
+		return token{kind: tokSemi, op: ";", pos: start}, nil
+	case '(':
+		l.pos++
+		return token{kind: tokLParen, op: "(", pos: start}, nil
+	case ')':
+		l.pos++
+		return token{kind: tokRParen, op: ")", pos: start}, nil
+	case '<', '>':
+		return l.redirect(-1, start), nil
+	}
+
+	// 纯数字紧跟 < 或 > 时为文件描述符，例如 2>&1
+	end := l.pos
+	for end < len(l.input) && isDigit(l.input[end]) {
+		end++
+	}
+	if end > l.pos && end < len(l.input) && (l.input[end] == '<' || l.input[end] == '>') {
+		fd, err := strconv.Atoi(l.input[l.pos:end])
+		if err == nil {
+			l.pos = end
+			return l.redirect(fd, start), nil
+		}
+	}
+
+	return l.word(start)
+}
+
+// skipBlank 跳过空白、续行和注释，注释到行尾结束
+func (l *lexer) skipBlank() {
+	for l.pos < len(l.input) {
+		switch {
+		case l.input[l.pos] == ' ' || l.input[l.pos] == '\t':
+			l.pos++
+		case l.input[l.pos] == '\\' && l.peekAt(1) == '\n':
+			l.pos += 2
+		case l.input[l.pos] == '#':
+			for l.pos < len(l.input) && l.input[l.pos] != '\n' {
+				l.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// peekAt 返回当前位置之后第 n 个字符
+func (l *lexer) peekAt(n int) byte {
+	if l.pos+n < len(l.input) {
+		return l.input[l.pos+n]
+	}
+	return 0
+}
+
+// redirect 读取重定向运算符
+func (l *lexer) redirect(fd, start int) token {
+	c := l.input[l.pos]
+	op := string(c)
+	l.pos++
+	switch {
+	case c == '>' && l.peekAt(0) == '>':
+		op = ">>"
+		l.pos++
+	case l.peekAt(0) == '&':
+		op += "&"
+		l.pos++
+	}
+	if fd < 0 {
+		fd = 1
+		if c == '<' {
+			fd = 0
+		}
+	}
+	return token{kind: tokRedirect, op: op, fd: fd, pos: start}
+}
+
+// word 读取一个单词，相邻的引号片段合并为同一个单词
+func (l *lexer) word(start int) (token, error) {
+	var w word
+	var lit strings.Builder
+	flush := func() {
+		if lit.Len() > 0 {
+			w = append(w, wordPart{text: lit.String()})
+			lit.Reset()
+		}
+	}
+
+	for l.pos < len(l.input) && !isMeta(l.input[l.pos]) {
+		c := l.input[l.pos]
+		switch c {
+		case '\\':
+			flush()
+			if l.peekAt(1) == '\n' {
+				// 续行
+				l.pos += 2
+				continue
+			}
+			if l.pos+1 >= len(l.input) {
+				w = append(w, wordPart{text: "\\", quote: '\\'})
+				l.pos++
+				continue
+			}
+			w = append(w, wordPart{text: l.input[l.pos+1 : l.pos+2], quote: '\\'})
+			l.pos += 2
+		case '\'':
+			flush()
+			end := strings.IndexByte(l.input[l.pos+1:], '\'')
+			if end < 0 {
+				return token{}, &syntaxError{pos: l.pos, msg: "unterminated ' quote", incomplete: true}
+			}
+			w = append(w, wordPart{text: l.input[l.pos+1 : l.pos+1+end], quote: '\''})
+			l.pos += end + 2
+		case '"':
+			flush()
+			parts, err := l.doubleQuoted()
+			if err != nil {
+				return token{}, err
+			}
+			w = append(w, parts...)
+		case '$', '`':
+			part, ok, err := l.expansion(0)
+			if err != nil {
+				return token{}, err
+			}
+			if !ok {
+				lit.WriteByte(c)
+				l.pos++
+				continue
+			}
+			flush()
+			w = append(w, part)
+		default:
+			lit.WriteByte(c)
+			l.pos++
+		}
+	}
+	flush()
+
+	return token{kind: tokWord, word: w, pos: start}, nil
+}
+
+// doubleQuoted 读取双引号字符串，其中的 $ 和 ` 仍会展开，反斜杠只转义 $ ` " \\ 和换行
+func (l *lexer) doubleQuoted() (word, error) {
+	start := l.pos
+	l.pos++
+
+	// 空字符串 "" 也是一个参数
+	w := word{{text: "", quote: '"'}}
+	var lit strings.Builder
+	flush := func() {
+		if lit.Len() > 0 {
+			w = append(w, wordPart{text: lit.String(), quote: '"'})
+			lit.Reset()
+		}
+	}
+
+	for l.pos < len(l.input) {
+		c := l.input[l.pos]
+		switch {
+		case c == '"':
+			flush()
+			l.pos++
+			return w, nil
+		case c == '\\' && l.pos+1 < len(l.input) && strings.IndexByte("$`\"\\\n", l.input[l.pos+1]) >= 0:
+			lit.WriteByte(l.input[l.pos+1])
+			l.pos += 2
+		case c == '$' || c == '`':
+			part, ok, err := l.expansion('"')
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				lit.WriteByte(c)
+				l.pos++
+				continue
+			}
+			flush()
+			w = append(w, part)
+		default:
+			lit.WriteByte(c)
+			l.pos++
+		}
+	}
+	return nil, &syntaxError{pos: start, msg: "unterminated \" quote", incomplete: true}
+}
+
+// expansion 读取以 $ 或 ` 开头的参数展开或命令替换。$ 之后不是合法的名称时返回 ok=false，
+// 由调用方按普通字符处理
+func (l *lexer) expansion(quote byte) (part wordPart, ok bool, err error) {
+	start := l.pos
+	part.quote = quote
+
+	if l.input[l.pos] == '`' {
+		end := strings.IndexByte(l.input[l.pos+1:], '`')
+		if end < 0 {
+			return part, false, &syntaxError{pos: start, msg: "unterminated ` command substitution", incomplete: true}
+		}
+		part.kind = partCommand
+		part.text = l.input[l.pos+1 : l.pos+1+end]
+		l.pos += end + 2
+		return part, true, nil
+	}
+
+	c := l.peekAt(1)
+	switch {
+	case c == '(':
+		end, err := l.matching(l.pos+1, '(', ')')
+		if err != nil {
+			return part, false, err
+		}
+		part.kind = partCommand
+		part.text = l.input[l.pos+2 : end]
+		l.pos = end + 1
+	case c == '{':
+		end, err := l.matching(l.pos+1, '{', '}')
+		if err != nil {
+			return part, false, err
+		}
+		part.kind = partParam
+		part.text = l.input[l.pos+2 : end]
+		if part.text == "" {
+			return part, false, &syntaxError{pos: start, msg: "bad substitution"}
+		}
+		l.pos = end + 1
+	case c == '?' || c == '$' || c == '!' || c == '#' || c == '@' || c == '*' || isDigit(c):
+		part.kind = partParam
+		part.text = string(c)
+		l.pos += 2
+	case isNameStart(c):
+		end := l.pos + 1
+		for end < len(l.input) && isNameChar(l.input[end]) {
+			end++
+		}
+		part.kind = partParam
+		part.text = l.input[l.pos+1 : end]
+		l.pos = end
+	default:
+		return part, false, nil
+	}
+	return part, true, nil
+}
+
+// matching 返回与 pos 处的 open 配对的 close 的位置，跳过引号和转义的字符
+func (l *lexer) matching(pos int, open, close byte) (int, error) {
+	depth := 0
+	for i := pos; i < len(l.input); i++ {
+		switch c := l.input[i]; c {
+		case '\\':
+			i++
+		case '\'', '"':
+			end := strings.IndexByte(l.input[i+1:], c)
+			if end < 0 {
+				return 0, &syntaxError{pos: i, msg: fmt.Sprintf("unterminated %c quote", c), incomplete: true}
+			}
+			i += end + 1
+		case open:
+			depth++
+		case close:
+			depth--
+			if depth == 0 {
+				return i, nil
+			}
+		}
+	}
+	return 0, &syntaxError{pos: pos, msg: fmt.Sprintf("missing closing %q", close), incomplete: true}
+}
+
+// isNameStart 判断字符能否作为变量名的开头
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// isName 判断字符串是否为合法的变量名
+func isName(s string) bool {
+	if s == "" || !isNameStart(s[0]) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !isNameChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// isNameChar 判断字符能否出现在变量名中
+func isNameChar(c byte) bool {
+	return isNameStart(c) || isDigit(c)
+}
+
+// isDigit 判断字符是否为数字
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// parser 语法分析器
+type parser struct {
+	lex     *lexer
+	tok     token
+	aliases map[string]string
+
+	// 最外层别名展开的名称以及展开后的文本在输入中的结束位置，其中的命令都记为来自该别名
+	aliasName string
+	aliasEnd  int
+	expanding map[string]bool // 正在展开的别名，不再次展开
+}
+
+// parse 解析一行输入，aliases 为命令名位置上要展开的别名
+func parse(line string, aliases map[string]string) (*list, error) {
+	p := &parser{lex: &lexer{input: line}, aliases: aliases}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	l, err := p.list(tokEOF)
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.unexpected()
+	}
+	return l, nil
+}
+
+// advance 读取下一个词法单元
+func (p *parser) advance() error {
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+// unexpected 构造意外词法单元的错误
+func (p *parser) unexpected() error {
+	if p.tok.kind == tokEOF {
+		return &syntaxError{pos: p.tok.pos, msg: "unexpected end of input", incomplete: true}
+	}
+	if p.tok.kind == tokNewline {
+		return &syntaxError{pos: p.tok.pos, msg: "unexpected newline"}
+	}
+	text := p.tok.op
+	if p.tok.kind == tokWord {
+		text = p.tok.word.String()
+	}
+	return &syntaxError{pos: p.tok.pos, msg: fmt.Sprintf("unexpected %q", text)}
+}
+
+// source 返回从 start 到当前词法单元之前的源码
+func (p *parser) source(start int) string {
+	return strings.TrimSpace(p.lex.input[start:p.tok.pos])
+}
+
+// skipNewlines 跳过换行，&&、|| 和 | 之后以及命令列表中允许换行
+func (p *parser) skipNewlines() error {
+	for p.tok.kind == tokNewline {
+		if err := p.advance(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// list 解析以 ;、& 或换行分隔的命令列表，遇到 end 时结束
+func (p *parser) list(end tokenKind) (*list, error) {
+	l := &list{}
+	for {
+		if err := p.skipNewlines(); err != nil {
+			return nil, err
+		}
+		if p.tok.kind == end || p.tok.kind == tokEOF {
+			return l, nil
+		}
+
+		item, err := p.andOr()
+		if err != nil {
+			return nil, err
+		}
+		l.items = append(l.items, item)
+
+		switch p.tok.kind {
+		case tokSemi, tokNewline:
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+		case tokAmp:
+			item.background = true
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+		case end, tokEOF:
+		default:
+			return nil, p.unexpected()
+		}
+	}
+}
+
+// andOr 解析以 && 或 || 连接的管道
+func (p *parser) andOr() (*andOr, error) {
+	start := p.tok.pos
+	first, err := p.pipeline()
+	if err != nil {
+		return nil, err
+	}
+	ao := &andOr{pipelines: []*pipeline{first}}
+	for p.tok.kind == tokAnd || p.tok.kind == tokOr {
+		op := p.tok.kind
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if err := p.skipNewlines(); err != nil {
+			return nil, err
+		}
+		next, err := p.pipeline()
+		if err != nil {
+			return nil, err
+		}
+		ao.ops = append(ao.ops, op)
+		ao.pipelines = append(ao.pipelines, next)
+	}
+	ao.text = p.source(start)
+	return ao, nil
+}
+
+// pipeline 解析管道
+func (p *parser) pipeline() (*pipeline, error) {
+	pl := &pipeline{}
+	start := p.tok.pos
+	for {
+		cmd, err := p.command()
+		if err != nil {
+			return nil, err
+		}
+		pl.commands = append(pl.commands, cmd)
+		if p.tok.kind != tokPipe {
+			pl.text = p.source(start)
+			return pl, nil
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if err := p.skipNewlines(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// command 解析简单命令或 ( list ) 形式的子 shell
+func (p *parser) command() (*command, error) {
+	alias, err := p.expandAlias()
+	if err != nil {
+		return nil, err
+	}
+	if alias == "" && p.tok.pos < p.aliasEnd {
+		alias = p.aliasName
+	}
+	cmd := &command{alias: alias}
+
+	if p.tok.kind == tokLParen {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		sub, err := p.list(tokRParen)
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokRParen {
+			return nil, p.unexpected()
+		}
+		if len(sub.items) == 0 {
+			return nil, &syntaxError{pos: p.tok.pos, msg: "empty subshell"}
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		cmd.subshell = sub
+	}
+
+	for {
+		switch p.tok.kind {
+		case tokWord:
+			if cmd.subshell != nil {
+				return nil, p.unexpected()
+			}
+			if a, ok := parseAssignment(p.tok.word); ok && len(cmd.args) == 0 {
+				cmd.assigns = append(cmd.assigns, a)
+				break
+			}
+			cmd.args = append(cmd.args, p.tok.word)
+		case tokRedirect:
+			r := redirect{fd: p.tok.fd, op: p.tok.op}
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			if p.tok.kind != tokWord {
+				return nil, p.unexpected()
+			}
+			r.target = p.tok.word
+			cmd.redirs = append(cmd.redirs, r)
+		default:
+			// 值为空的别名展开为空命令
+			if cmd.subshell == nil && len(cmd.args) == 0 && len(cmd.assigns) == 0 && len(cmd.redirs) == 0 && cmd.alias == "" {
+				return nil, p.unexpected()
+			}
+			return cmd, nil
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// expandAlias 命令名是别名时把它替换为别名的值后重新读取，返回展开的最外层别名。与常见 shell
+// 一样按文本替换，别名的值可以包含管道和多个命令。正在展开的别名不再展开，因此
+// alias ls='ls -F' 不会无限递归
+func (p *parser) expandAlias() (string, error) {
+	expanded := ""
+	for p.tok.kind == tokWord && len(p.aliases) > 0 {
+		if p.tok.pos >= p.aliasEnd {
+			p.aliasName, p.expanding = "", nil
+		}
+		w := p.tok.word
+		if len(w) != 1 || w[0].kind != partLiteral || w[0].quote != 0 {
+			break
+		}
+		name := w[0].text
+		value, ok := p.aliases[name]
+		if !ok || p.expanding[name] {
+			break
+		}
+
+		l := p.lex
+		start, end := p.tok.pos, l.pos
+		l.input = l.input[:start] + value + l.input[end:]
+		l.pos = start
+		if p.aliasName == "" {
+			p.aliasName, p.aliasEnd = name, start+len(value)
+			p.expanding = make(map[string]bool)
+		} else {
+			p.aliasEnd += len(value) - (end - start)
+		}
+		p.expanding[name] = true
+		expanded = p.aliasName
+		if err := p.advance(); err != nil {
+			return expanded, err
+		}
+	}
+	return expanded, nil
+}
+
+// parseAssignment 判断单词是否为 NAME=value 形式的赋值，= 之前必须是未引用的变量名
+func parseAssignment(w word) (assignment, bool) {
+	if len(w) == 0 || w[0].kind != partLiteral || w[0].quote != 0 {
+		return assignment{}, false
+	}
+	name, rest, ok := strings.Cut(w[0].text, "=")
+	if !ok || !isName(name) {
+		return assignment{}, false
+	}
+
+	value := word{}
+	if rest != "" {
+		value = append(value, wordPart{kind: partLiteral, text: rest})
+	}
+	value = append(value, w[1:]...)
+	return assignment{name: name, value: value}, true
+}
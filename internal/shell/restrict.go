@@ -0,0 +1,173 @@
+package shell
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/cevin/shell-auditor/internal/audit"
+)
+
+// 默认的系统和用户启动文件
+const (
+	SystemProfile = "/etc/shell-auditor/profile"
+	UserRC        = ".shell-auditorrc" // 位于用户主目录
+)
+
+// restrictedVars 受限模式下不能修改的变量
+var restrictedVars = map[string]bool{
+	"PATH":     true,
+	"SHELL":    true,
+	"ENV":      true,
+	"BASH_ENV": true,
+}
+
+// runStartupFiles 依次执行系统和用户的启动文件，每个文件记录一条 startup_file 事件，
+// 其中的命令与输入的命令一样经过策略检查和审计。受限用户不执行用户启动文件，
+// 否则可以在限制生效前修改 PATH。文件中执行 exit 时返回 errExit
+func (s *Shell) runStartupFiles() error {
+	files := []string{s.profile}
+	if s.restriction == nil {
+		files = append(files, s.rcFile)
+	}
+
+	for _, path := range files {
+		if path == "" {
+			continue
+		}
+		src, err := readStartupFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(s.errOut, "Warning: %v\n", err)
+			continue
+		}
+
+		sum := sha256.Sum256(src)
+		s.auditor.LogStartupFile(os.Getpid(), s.uid, s.gid, s.username, audit.StartupFileDetails{
+			Path:   path,
+			SHA256: hex.EncodeToString(sum[:]),
+			Size:   len(src),
+		})
+		if _, err := s.runSource(s.newContext(), path, string(src)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startRestriction 执行启动文件后使受限模式生效，因此系统启动文件可以为受限用户设置 PATH。
+// 非交互执行时只有受限用户才执行启动文件，否则 ssh 执行的命令不受 PATH 的限制。
+// 启动文件中执行 exit 时返回 errExit
+func (s *Shell) startRestriction(startup bool) error {
+	if startup {
+		if err := s.runStartupFiles(); err != nil {
+			return err
+		}
+	}
+	s.restricted.Store(true)
+	return nil
+}
+
+// readStartupFile 读取启动文件。文件必须属于 root 或当前用户，并且其他用户不可写，
+// 否则其他用户可以借此在本用户的 shell 中执行命令
+func readStartupFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat startup file: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("startup file %s is not a regular file", path)
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok && st.Uid != 0 && int(st.Uid) != os.Getuid() {
+		return nil, fmt.Errorf("startup file %s is not owned by root or the current user", path)
+	}
+	if info.Mode().Perm()&0022 != 0 {
+		return nil, fmt.Errorf("startup file %s is writable by other users", path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read startup file: %w", err)
+	}
+	return data, nil
+}
+
+// restricting 判断受限模式是否已经生效
+func (s *Shell) restricting() bool {
+	return s.restriction != nil && s.restricted.Load()
+}
+
+// deny 拒绝受限模式下不允许的操作，记录 policy_violation 事件。argv 为执行的命令，
+// subject 为被拒绝的对象，例如目录、变量名或命令名
+func (s *Shell) deny(c *execCtx, argv []string, subject, msg string) error {
+	command, args := subject, []string(nil)
+	if len(argv) > 0 {
+		command, args = argv[0], argv[1:]
+	}
+	s.auditor.LogPolicyViolation(os.Getpid(), os.Getppid(), s.uid, s.gid, s.username, command, "", args, c.dir,
+		audit.PolicyDetails{
+			RuleID:  s.restriction.ID,
+			Action:  "restrict",
+			Outcome: "denied",
+			Message: subject + ": " + msg,
+		})
+	return fmt.Errorf("%s: restricted: %s", subject, msg)
+}
+
+// checkVariable 受限模式下不能修改 PATH、SHELL、ENV
+func (s *Shell) checkVariable(c *execCtx, argv []string, name string) error {
+	if !s.restricting() || !restrictedVars[name] {
+		return nil
+	}
+	return s.deny(c, argv, name, "read-only variable")
+}
+
+// checkDir 受限模式下只能进入允许的目录，符号链接解析后再判断
+func (s *Shell) checkDir(c *execCtx, argv []string, dir string) error {
+	if !s.restricting() {
+		return nil
+	}
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		resolved = dir
+	}
+	if s.restriction.AllowsDir(resolved, s.homeDir) {
+		return nil
+	}
+	return s.deny(c, argv, dir, "outside the allowed directories")
+}
+
+// checkCommandName 受限模式下命令名不能包含 /，只能执行 PATH 中的命令
+func (s *Shell) checkCommandName(c *execCtx, argv []string, name string) error {
+	if !s.restricting() || !strings.Contains(name, "/") {
+		return nil
+	}
+	return s.deny(c, argv, name, "cannot specify / in command names")
+}
+
+// checkRedirects 受限模式下不能把输出重定向到文件，>& 只复制文件描述符，不受限制
+func (s *Shell) checkRedirects(c *execCtx, argv []string, redirs []redirect) error {
+	if !s.restricting() {
+		return nil
+	}
+	for _, r := range redirs {
+		if r.op == ">" || r.op == ">>" {
+			return s.deny(c, argv, r.target.String(), "cannot redirect output")
+		}
+	}
+	return nil
+}
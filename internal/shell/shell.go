@@ -2,72 +2,188 @@ package shell
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"os/signal"
+	"os/user"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/cevin/shell-auditor/internal/audit"
+	"github.com/cevin/shell-auditor/internal/lineedit"
+	"github.com/cevin/shell-auditor/internal/policy"
+	"github.com/cevin/shell-auditor/internal/record"
+	"github.com/cevin/shell-auditor/internal/tty"
 )
 
+// errExit exit/logout 内置命令返回，用于结束主循环
+var errExit = errors.New("exit")
+
+// historySize 保留的历史条数
+const historySize = 1000
+
+// statusInterrupted 按 Ctrl-C 放弃输入后的退出状态，与常见 shell 一致
+const statusInterrupted = 130
+
+// Config shell 配置
+type Config struct {
+	// Policy 命令执行策略，为 nil 时允许所有命令
+	Policy *policy.Policy
+	// RecordDir 终端录制文件目录，为空时不录制。只有标准输入是终端时才录制
+	RecordDir string
+	// HistoryFile 历史文件，为空时历史只保存在内存中。只有标准输入是终端时才使用
+	HistoryFile string
+	// Profile、RCFile 系统和用户的启动文件，交互式 shell 启动时依次执行，为空或不存在时跳过。
+	// 受限用户只执行系统启动文件，非交互执行时也会执行
+	Profile string
+	RCFile  string
+	// LogPath 审计日志路径，audit 内置命令从中查询。为空时只查询内存中的事件
+	LogPath string
+}
+
 // Shell 交互式shell
 type Shell struct {
-	auditor    *audit.Auditor
-	uid        int
-	gid        int
-	username   string
-	homeDir    string
-	workingDir string
-	history    []string
-	historyIdx int
-	mu         sync.Mutex
+	auditor     *audit.Auditor
+	name        string   // $0
+	args        []string // 位置参数 $1...
+	interactive bool
+	policy      *policy.Policy
+	reader      *bufio.Reader
+	editor      *lineedit.Editor // 标准输入是终端时使用行编辑器读取输入
+	out         io.Writer        // shell 自身的输出，录制时同时写入录制文件
+	errOut      io.Writer
+	recordDir   string
+	rec         *record.Recorder
+	term        *record.Terminal
+	uid         int
+	gid         int
+	groups      []string
+	username    string
+	homeDir     string
+	root        *frame
+	history     *lineedit.History
+	historyFile string
+	sessionID   string
+	session     audit.SessionDetails
+	started     time.Time
+	endOnce     sync.Once
+	pipeSeq     atomic.Uint64
+	profile     string
+	rcFile      string
+	logPath     string
+
+	// 受限模式，为 nil 时不受限。启动文件执行完毕后 restricted 置位，限制开始生效
+	restriction *policy.Restriction
+	restricted  atomic.Bool
+
+	// 作业控制
+	jobControl     bool
+	pgid           int        // shell 的进程组
+	origForeground int        // 启动时终端的前台进程组，退出时交还
+	modes          *tty.State // shell 的终端属性，作业停止后恢复
+	jobs           jobTable
+	fg             atomic.Pointer[job] // 正在前台运行的作业
+	lastBackground int                 // $!
+	exitWarned     bool                // 有停止的作业时第一次 exit 只给出提示
+	exitConfirmed  bool
 }
 
 // NewShell 创建新的shell实例
-func NewShell(auditor *audit.Auditor) (*Shell, error) {
+func NewShell(auditor *audit.Auditor, cfg Config) (*Shell, error) {
 	uid := os.Getuid()
 	gid := os.Getgid()
 	username := getUsername(uid)
 	homeDir, _ := os.UserHomeDir()
 	workingDir, _ := os.Getwd()
+	groups := userGroups()
+
+	var restriction *policy.Restriction
+	if cfg.Policy != nil {
+		restriction = cfg.Policy.Restriction(username, uid, groups)
+	}
 
 	return &Shell{
-		auditor:    auditor,
-		uid:        uid,
-		gid:        gid,
-		username:   username,
-		homeDir:    homeDir,
-		workingDir: workingDir,
-		history:    make([]string, 0, 1000),
+		auditor:     auditor,
+		name:        "shell-auditor",
+		policy:      cfg.Policy,
+		reader:      bufio.NewReader(os.Stdin),
+		out:         os.Stdout,
+		errOut:      os.Stderr,
+		recordDir:   cfg.RecordDir,
+		uid:         uid,
+		gid:         gid,
+		groups:      groups,
+		username:    username,
+		homeDir:     homeDir,
+		root:        &frame{dir: workingDir, env: newEnviron(), aliases: make(map[string]string)},
+		history:     lineedit.NewHistory(historySize),
+		historyFile: cfg.HistoryFile,
+		sessionID:   audit.NewSessionID(),
+		profile:     cfg.Profile,
+		rcFile:      cfg.RCFile,
+		logPath:     cfg.LogPath,
+		restriction: restriction,
 	}, nil
 }
 
 // Run 运行shell
 func (s *Shell) Run() error {
-	// 设置信号处理
+	// shell 自身不响应终端产生的信号：作业控制下这些信号只发给前台作业的进程组，
+	// 否则命令与 shell 在同一进程组，Ctrl-C 只结束命令。这里注册处理函数而不是忽略信号，
+	// 忽略的信号会被子进程继承。SIGTERM、SIGHUP 由调用方处理，见 Terminate
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTSTP, syscall.SIGTTIN, syscall.SIGTTOU)
+	go func() {
+		for range sigChan {
+		}
+	}()
+	defer func() {
+		signal.Stop(sigChan)
+		close(sigChan)
+	}()
+
+	// 登记会话，本进程及其派生的所有进程的事件都归入该会话
+	s.interactive = true
+	s.startSession("")
+	defer s.EndSession("exit")
+
+	if tty.IsTerminal(os.Stdin) {
+		s.startJobControl()
+		s.startEditor()
+	}
 
 	// 打印欢迎信息
 	s.printWelcome()
 
+	if err := s.startRestriction(true); err != nil {
+		return nil
+	}
+
 	// 主循环
-	reader := bufio.NewReader(os.Stdin)
 	for {
-		// 显示提示符
-		prompt := s.buildPrompt()
-		fmt.Print(prompt)
+		s.notifyJobs()
 
-		// 读取输入
-		line, err := reader.ReadString('\n')
+		// 显示提示符并读取输入
+		line, err := s.readLine(s.buildPrompt())
+		if errors.Is(err, lineedit.ErrInterrupted) {
+			s.root.status = statusInterrupted
+			continue
+		}
+		if err != nil && s.editor != nil && !tty.IsTerminal(os.Stdin) {
+			// 终端已断开（例如 SSH 连接中断），读取返回 EOF 或 EIO
+			s.Terminate(syscall.SIGHUP)
+			return nil
+		}
 		if err != nil {
 			if err == io.EOF {
-				fmt.Println()
+				fmt.Fprintln(s.out)
 				return nil
 			}
 			return fmt.Errorf("read error: %w", err)
@@ -81,26 +197,246 @@ func (s *Shell) Run() error {
 
 		// 处理命令
 		if err := s.handleCommand(line); err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			if errors.Is(err, errExit) {
+				return nil
+			}
+			fmt.Fprintf(s.errOut, "Error: %v\n", err)
+		}
+	}
+}
+
+// RunCommand 执行 -c 指定的命令后退出，返回最后一个命令的退出状态。与 sh -c 一致，
+// args[0] 为 $0，其余为位置参数。命令与交互输入一样经过解析、策略检查和审计
+func (s *Shell) RunCommand(command string, args []string) int {
+	if len(args) > 0 {
+		s.name, s.args = args[0], args[1:]
+	}
+	s.startSession(command)
+	defer s.EndSession("exit")
+	if err := s.startRestriction(s.restriction != nil); err != nil {
+		return s.root.status
+	}
+	status, _ := s.runSource(s.newContext(), "-c", command)
+	return status
+}
+
+// RunScript 执行脚本文件后退出，返回最后一个命令的退出状态。args 为位置参数
+func (s *Shell) RunScript(path string, args []string) int {
+	s.name, s.args = path, args
+	s.startSession(path)
+	defer s.EndSession("exit")
+	if err := s.startRestriction(s.restriction != nil); err != nil {
+		return s.root.status
+	}
+
+	src, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(s.errOut, "%s: %v\n", path, errors.Unwrap(err))
+		return statusNotFound
+	}
+	status, _ := s.runSource(s.newContext(), path, string(src))
+	return status
+}
+
+// newContext 返回在 shell 自身环境中执行命令的上下文
+func (s *Shell) newContext() *execCtx {
+	return &execCtx{
+		frame: s.root,
+		stdio: [3]*os.File{os.Stdin, os.Stdout, os.Stderr},
+		rec:   s.rec,
+	}
+}
+
+// runSource 在上下文 c 中逐条执行源码中的命令，返回最后一个命令的退出状态。引号、括号、
+// 续行等跨行的命令读完整后再执行，每条命令的原始文本作为审计事件的 raw_line。
+// 遇到语法错误时停止，执行 exit 时返回 errExit
+func (s *Shell) runSource(c *execCtx, name, src string) (int, error) {
+	lines := strings.SplitAfter(src, "\n")
+	chunk, first := "", 0
+	for i, line := range lines {
+		if chunk == "" {
+			first = i + 1
+		}
+		chunk += line
+		if continued(line) && i < len(lines)-1 {
+			continue
+		}
+
+		l, err := parse(chunk, c.aliases)
+		if err != nil {
+			var se *syntaxError
+			if errors.As(err, &se) && se.incomplete && i < len(lines)-1 {
+				continue
+			}
+			fmt.Fprintf(c.stderr(), "%s: line %d: %v\n", name, first, err)
+			c.status = statusSyntaxError
+			return c.status, nil
+		}
+
+		raw := strings.TrimSpace(chunk)
+		chunk = ""
+		if raw == "" {
+			continue
+		}
+		lc := c.with(c.frame, c.stdio)
+		lc.rawLine = raw
+		if _, err := s.runList(lc, l); err != nil {
+			if errors.Is(err, errExit) {
+				return c.status, err
+			}
+			fmt.Fprintf(c.stderr(), "%s: %v\n", name, err)
+		}
+	}
+	return c.status, nil
+}
+
+// continued 判断行是否以续行符结束，即换行前有奇数个反斜杠
+func continued(line string) bool {
+	line = strings.TrimSuffix(line, "\n")
+	n := len(line) - len(strings.TrimRight(line, "\\"))
+	return n%2 == 1
+}
+
+// startEditor 启用行编辑器并加载历史文件
+func (s *Shell) startEditor() {
+	if s.historyFile != "" {
+		h, err := lineedit.OpenHistory(s.historyFile, historySize)
+		if err != nil {
+			fmt.Fprintf(s.errOut, "Warning: %v, history will not be saved\n", err)
+		} else {
+			s.history = h
+		}
+	}
+
+	s.editor = lineedit.New(os.Stdin, s.out)
+	s.editor.History = s.history
+	s.editor.Complete = s.complete
+	if s.rec != nil {
+		s.editor.OnInput = s.rec.Input
+	}
+}
+
+// readLine 显示提示符并读取一行输入。终端上使用行编辑器，编辑器的输出会被录制；
+// 否则按行读取，录制时终端处于规范模式，用户看到的是终端回显的输入，因此输入同时记录为输出。
+// 终端关闭了回显时不记录输入
+func (s *Shell) readLine(prompt string) (string, error) {
+	if s.editor != nil {
+		return s.editor.ReadLine(prompt)
+	}
+
+	fmt.Fprint(s.out, prompt)
+	echo := s.rec != nil && tty.Echo(os.Stdin)
+	line, err := s.reader.ReadString('\n')
+	if echo && line != "" {
+		s.rec.Input([]byte(line))
+		s.rec.Output([]byte(strings.ReplaceAll(line, "\n", "\r\n")))
+	}
+	return line, err
+}
+
+// startSession 登记会话，开始录制并记录 session_start。command 为非交互执行的命令或脚本，
+// 非交互执行时不录制
+func (s *Shell) startSession(command string) {
+	s.started = time.Now()
+	s.session = LoginDetails()
+	s.session.Command = command
+	if s.restriction != nil {
+		s.session.Restricted = s.restriction.ID
+	}
+	if s.interactive && s.recordDir != "" && tty.IsTerminal(os.Stdin) {
+		s.startRecording()
+	}
+
+	s.auditor.RegisterSession(os.Getpid(), s.sessionID)
+	s.auditor.LogSessionStart(os.Getpid(), s.uid, s.gid, s.username, s.sessionID, s.session)
+}
+
+// EndSession 记录 session_end 并取消会话登记，reason 为结束原因，重复调用无效
+func (s *Shell) EndSession(reason string) {
+	s.endOnce.Do(func() {
+		if s.started.IsZero() {
+			return
+		}
+		details := s.session
+		details.Reason = reason
+		details.DurationMs = time.Since(s.started).Milliseconds()
+		s.auditor.LogSessionEnd(os.Getpid(), s.uid, s.gid, s.username, s.sessionID, details)
+		s.auditor.UnregisterSession(os.Getpid())
+		s.stopJobControl()
+
+		if err := s.history.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to close history file: %v\n", err)
+		}
+		if s.rec != nil {
+			s.term.Restore()
+			if err := s.rec.Close(); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			}
+		}
+	})
+}
+
+// startRecording 创建会话录制文件，之后写到终端的命令在伪终端中执行
+func (s *Shell) startRecording() {
+	path := record.Path(s.recordDir, s.sessionID)
+	width, height := tty.Size(os.Stdin)
+	rec, err := record.Create(path, width, height, s.username+"@"+getHostname())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v, session will not be recorded\n", err)
+		return
+	}
+
+	s.rec = rec
+	s.term = record.NewTerminal(os.Stdin, os.Stdout, rec)
+	// 伪终端中的命令停止后无法继续转发输入，录制时不支持 Ctrl-Z
+	s.term.NoSuspend = true
+	s.out = rec.Tee(os.Stdout)
+	s.errOut = rec.Tee(os.Stderr)
+	s.session.Recording = path
+}
+
+// LoginDetails 根据终端和环境变量推断登录方式
+func LoginDetails() audit.SessionDetails {
+	details := audit.SessionDetails{LoginMethod: "local"}
+	if tty, err := os.Readlink("/proc/self/fd/0"); err == nil && strings.HasPrefix(tty, "/dev/") {
+		details.TTY = tty
+	}
+	if sid, err := os.ReadFile("/proc/self/sessionid"); err == nil {
+		if n, err := strconv.ParseUint(strings.TrimSpace(string(sid)), 10, 32); err == nil {
+			details.AuditSessionID = audit.AuditSessionID(uint32(n))
 		}
 	}
+
+	// SSH_CONNECTION 格式为 "客户端IP 客户端端口 服务端IP 服务端端口"
+	if fields := strings.Fields(os.Getenv("SSH_CONNECTION")); len(fields) == 4 {
+		details.LoginMethod = "ssh"
+		details.RemoteAddr = fields[0]
+		details.RemotePort, _ = strconv.Atoi(fields[1])
+		return details
+	}
+
+	switch {
+	case os.Getenv("SUDO_USER") != "":
+		details.LoginMethod = "sudo"
+	case strings.HasPrefix(details.TTY, "/dev/tty"):
+		details.LoginMethod = "console"
+	}
+	return details
 }
 
 // printWelcome 打印欢迎信息
 func (s *Shell) printWelcome() {
-	fmt.Printf("\n")
-	fmt.Printf("╔════════════════════════════════════════════════════════════╗\n")
-	fmt.Printf("║           Shell Auditor - 安全审计 Shell                    ║\n")
-	fmt.Printf("║           所有操作将被记录和审计                             ║\n")
-	fmt.Printf("╚════════════════════════════════════════════════════════════╝\n")
-	fmt.Printf("\n")
+	fmt.Fprintf(s.out, "\n")
+	fmt.Fprintf(s.out, "╔════════════════════════════════════════════════════════════╗\n")
+	fmt.Fprintf(s.out, "║           Shell Auditor - 安全审计 Shell                    ║\n")
+	fmt.Fprintf(s.out, "║           所有操作将被记录和审计                             ║\n")
+	fmt.Fprintf(s.out, "╚════════════════════════════════════════════════════════════╝\n")
+	fmt.Fprintf(s.out, "\n")
 }
 
 // buildPrompt 构建提示符
 func (s *Shell) buildPrompt() string {
-	s.mu.Lock()
-	wd := s.workingDir
-	s.mu.Unlock()
+	wd := s.root.dir
 
 	// 简化路径显示
 	if strings.HasPrefix(wd, s.homeDir) {
@@ -117,70 +453,123 @@ func (s *Shell) buildPrompt() string {
 // handleCommand 处理命令
 func (s *Shell) handleCommand(line string) error {
 	// 添加到历史记录
-	s.addToHistory(line)
+	s.history.Add(line)
+
+	// 有停止的作业时，只有紧接着再次输入 exit 才会退出
+	s.exitConfirmed, s.exitWarned = s.exitWarned, false
+
+	c := s.newContext()
+	c.rawLine = line
 
 	// 解析命令
-	parts := parseCommand(line)
-	if len(parts) == 0 {
-		return nil
+	l, err := parse(line, s.root.aliases)
+	if err != nil {
+		s.root.status = statusSyntaxError
+		return err
 	}
 
-	cmdName := parts[0]
-	args := parts[1:]
+	_, err = s.runList(c, l)
+	return err
+}
 
-	// 处理内置命令
-	if s.isBuiltinCommand(cmdName) {
-		return s.handleBuiltinCommand(cmdName, args)
-	}
+// newPipelineID 生成管道ID，同一管道中各命令的审计事件使用相同的ID
+func (s *Shell) newPipelineID() string {
+	return s.sessionID + "-" + strconv.FormatUint(s.pipeSeq.Add(1), 10)
+}
 
-	// 执行外部命令
-	return s.executeCommand(cmdName, args)
+// builtins 内置命令
+var builtins = map[string]bool{
+	"cd":      true,
+	"exit":    true,
+	"logout":  true,
+	"clear":   true,
+	"history": true,
+	"pwd":     true,
+	"export":  true,
+	"unset":   true,
+	"alias":   true,
+	"unalias": true,
+	"source":  true,
+	".":       true,
+	"umask":   true,
+	"type":    true,
+	"which":   true,
+	"exec":    true,
+	"audit":   true,
+	"jobs":    true,
+	"fg":      true,
+	"bg":      true,
+	"wait":    true,
 }
 
 // isBuiltinCommand 检查是否为内置命令
 func (s *Shell) isBuiltinCommand(cmd string) bool {
-	builtins := map[string]bool{
-		"cd":      true,
-		"exit":    true,
-		"logout":  true,
-		"clear":   true,
-		"history": true,
-		"pwd":     true,
-		"export":  true,
-		"unset":   true,
-		"alias":   true,
-		"audit":   true,
-	}
 	return builtins[cmd]
 }
 
-// handleBuiltinCommand 处理内置命令
-func (s *Shell) handleBuiltinCommand(cmd string, args []string) error {
+// handleBuiltinCommand 处理内置命令，输出写入上下文的标准输出
+func (s *Shell) handleBuiltinCommand(c *execCtx, cmd string, args []string) error {
 	switch cmd {
 	case "cd":
-		return s.handleCD(args)
+		return s.handleCD(c, args)
 	case "exit", "logout":
-		os.Exit(0)
+		if s.interactive && !s.exitConfirmed && s.jobs.stopped() > 0 {
+			// 退出后停止的作业会收到 SIGHUP
+			s.exitWarned = true
+			return errors.New("there are stopped jobs")
+		}
+		if len(args) > 0 {
+			status, err := strconv.Atoi(args[0])
+			if err != nil {
+				return fmt.Errorf("%s: %s: numeric argument required", cmd, args[0])
+			}
+			c.status = status & 0xff
+		}
+		return errExit
 	case "clear":
-		fmt.Print("\033[H\033[2J")
+		fmt.Fprint(c.stdout(), "\033[H\033[2J")
 		return nil
 	case "history":
-		return s.handleHistory(args)
+		return s.handleHistory(c, args)
 	case "pwd":
-		fmt.Println(s.workingDir)
+		fmt.Fprintln(c.stdout(), c.dir)
 		return nil
 	case "export":
-		return s.handleExport(args)
+		return s.handleExport(c, args)
+	case "unset":
+		return s.handleUnset(c, args)
+	case "alias":
+		return s.handleAlias(c, args)
+	case "unalias":
+		return s.handleUnalias(c, args)
+	case "source", ".":
+		return s.handleSource(c, cmd, args)
+	case "umask":
+		return s.handleUmask(c, args)
+	case "type":
+		return s.handleType(c, args)
+	case "which":
+		return s.handleWhich(c, args)
+	case "exec":
+		// 带命令或只有重定向的 exec 由 startCommand 处理，没有参数时什么都不做
+		return nil
 	case "audit":
-		return s.handleAudit(args)
+		return s.handleAudit(c, args)
+	case "jobs":
+		return s.handleJobs(c, args)
+	case "fg":
+		return s.handleFg(c, args)
+	case "bg":
+		return s.handleBg(c, args)
+	case "wait":
+		return s.handleWait(c, args)
 	default:
 		return fmt.Errorf("builtin command not implemented: %s", cmd)
 	}
-	return nil
 }
 
 // handleCD 处理cd命令
-func (s *Shell) handleCD(args []string) error {
+func (s *Shell) handleCD(c *execCtx, args []string) error {
 	var target string
 	if len(args) == 0 {
 		target = s.homeDir
@@ -188,18 +577,16 @@ func (s *Shell) handleCD(args []string) error {
 		target = args[0]
 	}
 
-	// 处理 ~
-	if strings.HasPrefix(target, "~") {
-		target = filepath.Join(s.homeDir, target[1:])
-	}
-
 	// 处理相对路径
 	if !filepath.IsAbs(target) {
-		target = filepath.Join(s.workingDir, target)
+		target = filepath.Join(c.dir, target)
 	}
 
 	// 规范化路径
 	target = filepath.Clean(target)
+	if err := s.checkDir(c, append([]string{"cd"}, args...), target); err != nil {
+		return err
+	}
 
 	// 检查目录是否存在
 	info, err := os.Stat(target)
@@ -210,169 +597,234 @@ func (s *Shell) handleCD(args []string) error {
 		return fmt.Errorf("cd: %s: Not a directory", target)
 	}
 
-	s.mu.Lock()
-	s.workingDir = target
-	s.mu.Unlock()
-
+	c.dir = target
 	return nil
 }
 
 // handleHistory 处理history命令
-func (s *Shell) handleHistory(args []string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	for i, cmd := range s.history {
-		fmt.Printf("  %4d  %s\n", i+1, cmd)
-	}
-	return nil
-}
-
-// handleExport 处理export命令
-func (s *Shell) handleExport(args []string) error {
-	for _, arg := range args {
-		parts := strings.SplitN(arg, "=", 2)
-		if len(parts) == 2 {
-			os.Setenv(parts[0], parts[1])
-		}
+func (s *Shell) handleHistory(c *execCtx, args []string) error {
+	for i, cmd := range s.history.Entries() {
+		fmt.Fprintf(c.stdout(), "  %4d  %s\n", i+1, cmd)
 	}
 	return nil
 }
 
-// handleAudit 处理audit命令
-func (s *Shell) handleAudit(args []string) error {
-	if len(args) == 0 {
-		// 显示最近的审计事件
-		events := s.auditor.GetEvents()
-		fmt.Printf("\n=== 最近 %d 条审计事件 ===\n\n", len(events))
-		for _, e := range events {
-			data, _ := e.ToJSON()
-			fmt.Println(string(data))
+// handleExport 处理export命令，没有参数或 -p 时列出导出的变量
+func (s *Shell) handleExport(c *execCtx, args []string) error {
+	if len(args) == 0 || (len(args) == 1 && args[0] == "-p") {
+		for _, kv := range c.env.List() {
+			name, value, _ := strings.Cut(kv, "=")
+			fmt.Fprintf(c.stdout(), "export %s=%s\n", name, quote(value))
 		}
 		return nil
 	}
 
-	switch args[0] {
-	case "clear":
-		// 清空审计日志
-		fmt.Println("审计日志已清空")
-		return nil
-	case "pid":
-		if len(args) < 2 {
-			return fmt.Errorf("usage: audit pid <pid>")
+	var status error
+	for _, arg := range args {
+		name, value, ok := strings.Cut(arg, "=")
+		if !isName(name) {
+			c.report(fmt.Errorf("export: `%s': not a valid identifier", arg))
+			status = exitStatus(1)
+			continue
 		}
-		var pid int
-		fmt.Sscanf(args[1], "%d", &pid)
-		events := s.auditor.GetEventsByPID(pid)
-		fmt.Printf("\n=== PID %d 的审计事件 ===\n\n", pid)
-		for _, e := range events {
-			data, _ := e.ToJSON()
-			fmt.Println(string(data))
+		if ok {
+			if err := s.checkVariable(c, append([]string{"export"}, args...), name); err != nil {
+				c.report(err)
+				status = exitStatus(1)
+				continue
+			}
+			c.env.Set(name, value)
 		}
-		return nil
-	default:
-		return fmt.Errorf("unknown audit command: %s", args[0])
+		c.env.Export(name)
 	}
+	return status
 }
 
-// executeCommand 执行外部命令
-func (s *Shell) executeCommand(name string, args []string) error {
-	// 查找命令路径
-	path, err := exec.LookPath(name)
-	if err != nil {
-		return fmt.Errorf("%s: command not found", name)
-	}
-
-	// 记录命令执行
-	s.auditor.LogCommand(
-		os.Getpid(),
-		os.Getppid(),
-		s.uid,
-		s.gid,
-		s.username,
-		name,
-		args,
-		s.workingDir,
-	)
-
-	// 创建命令
-	cmd := exec.Command(path, args...)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Dir = s.workingDir
-	cmd.Env = os.Environ()
-
-	// 执行命令
-	err = cmd.Run()
-
-	// 记录退出码
-	exitCode := 0
-	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			exitCode = exitErr.ExitCode()
-		} else {
-			exitCode = 1
-		}
+// printProcessTree 打印进程的祖先链和子进程树
+func (s *Shell) printProcessTree(out io.Writer, pid int) error {
+	procs := s.auditor.Processes()
+	self, ok := procs.Lookup(pid)
+	if !ok {
+		return fmt.Errorf("audit tree: process %d not found", pid)
 	}
-	s.auditor.LogCommandExit(os.Getpid(), exitCode)
 
+	// 祖先链从根向下打印
+	lineage := procs.Lineage(pid)
+	depth := 0
+	for i := len(lineage) - 1; i >= 0; i-- {
+		fmt.Fprintln(out, treeLine(depth, lineage[i], ""))
+		depth++
+	}
+
+	mark := ""
+	if root, ok := procs.SessionRoot(pid); ok && root.PID == pid {
+		mark = "  [session root]"
+	}
+	fmt.Fprintln(out, treeLine(depth, self, mark+"  <==="))
+	s.printChildren(out, procs, pid, depth+1)
 	return nil
 }
 
-// addToHistory 添加到历史记录
-func (s *Shell) addToHistory(cmd string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	// 避免重复
-	if len(s.history) > 0 && s.history[len(s.history)-1] == cmd {
+// printChildren 递归打印子进程
+func (s *Shell) printChildren(out io.Writer, procs *audit.ProcessTable, pid, depth int) {
+	if depth > 32 {
 		return
 	}
+	for _, child := range procs.Children(pid) {
+		fmt.Fprintln(out, treeLine(depth, child, ""))
+		s.printChildren(out, procs, child.PID, depth+1)
+	}
+}
+
+// treeLine 格式化进程树中的一行
+func treeLine(depth int, p audit.ProcessRef, suffix string) string {
+	prefix := ""
+	if depth > 0 {
+		prefix = strings.Repeat("   ", depth-1) + "└─ "
+	}
+	line := fmt.Sprintf("%s%d %s", prefix, p.PID, p.Comm)
+	if p.Exe != "" {
+		line += " (" + p.Exe + ")"
+	}
+	return line + suffix
+}
 
-	s.history = append(s.history, cmd)
-	s.historyIdx = len(s.history)
+// checkPolicy 按策略评估命令，拒绝执行时返回错误。命中 deny/warn/confirm 规则时
+// 记录 policy_violation 事件
+func (s *Shell) checkPolicy(c *execCtx, name, path string, args []string) error {
+	if s.policy == nil {
+		return nil
+	}
 
-	// 限制历史记录数量
-	if len(s.history) > 1000 {
-		s.history = s.history[1:]
+	decision := s.policy.Evaluate(policy.Request{
+		Command:    name,
+		Path:       path,
+		Args:       args,
+		WorkingDir: c.dir,
+		Username:   s.username,
+		UID:        s.uid,
+		Groups:     s.groups,
+	})
+
+	var outcome string
+	var err error
+	switch decision.Action {
+	case policy.ActionAllow:
+		return nil
+	case policy.ActionWarn:
+		outcome = "warned"
+		fmt.Fprintf(c.stderr(), "Warning: %s\n", policyMessage(name, decision))
+	case policy.ActionConfirm:
+		outcome = "confirmed"
+		if !s.confirm(fmt.Sprintf("%s, run anyway? [y/N] ", policyMessage(name, decision))) {
+			outcome = "declined"
+			err = fmt.Errorf("%s: cancelled", name)
+		}
+	default:
+		outcome = "denied"
+		err = fmt.Errorf("%s", policyMessage(name, decision))
 	}
+
+	s.auditor.LogPolicyViolation(os.Getpid(), os.Getppid(), s.uid, s.gid, s.username, name, path, args, c.dir,
+		audit.PolicyDetails{
+			RuleID:  decision.RuleID,
+			Action:  string(decision.Action),
+			Outcome: outcome,
+			Message: decision.Message,
+		})
+	return err
 }
 
-// parseCommand 解析命令
-func parseCommand(line string) []string {
-	var parts []string
-	var current strings.Builder
-	var inQuote bool
-	var quoteChar rune
+// policyMessage 构造策略提示信息
+func policyMessage(name string, decision policy.Decision) string {
+	var msg string
+	switch decision.Action {
+	case policy.ActionWarn:
+		msg = name + ": flagged by policy"
+	case policy.ActionConfirm:
+		msg = name + ": requires confirmation by policy"
+	default:
+		msg = name + ": denied by policy"
+	}
+	if decision.RuleID != "" {
+		msg += " (rule " + decision.RuleID + ")"
+	}
+	if decision.Message != "" {
+		msg += ": " + decision.Message
+	}
+	return msg
+}
 
-	for _, r := range line {
-		switch {
-		case (r == '\'' || r == '"') && !inQuote:
-			inQuote = true
-			quoteChar = r
-		case r == quoteChar && inQuote:
-			inQuote = false
-		case r == ' ' && !inQuote:
-			if current.Len() > 0 {
-				parts = append(parts, current.String())
-				current.Reset()
-			}
-		default:
-			current.WriteRune(r)
+// confirm 提示用户确认，只有输入 y/yes 时返回 true。非交互执行时标准输入属于命令，
+// 不提示并直接拒绝
+func (s *Shell) confirm(prompt string) bool {
+	if !s.interactive {
+		return false
+	}
+	// 管道中已经启动的命令可能占有终端
+	s.claimTerminal(nil, false)
+	answer, err := s.readLine(prompt)
+	if err != nil {
+		if !errors.Is(err, lineedit.ErrInterrupted) {
+			fmt.Fprintln(s.errOut)
 		}
+		return false
 	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
 
-	if current.Len() > 0 {
-		parts = append(parts, current.String())
+// exitInfo 根据进程的等待状态和资源使用构造退出信息，与内核观测到的退出采用相同的约定：
+// 被信号终止时退出码为 0，信号单独记录
+func exitInfo(ws syscall.WaitStatus, ru *syscall.Rusage, duration time.Duration) audit.ExitInfo {
+	info := audit.ExitInfo{
+		UserTimeMs: time.Duration(ru.Utime.Nano()).Milliseconds(),
+		SysTimeMs:  time.Duration(ru.Stime.Nano()).Milliseconds(),
+		DurationMs: duration.Milliseconds(),
 	}
+	if ws.Signaled() {
+		info.Signal = int(ws.Signal())
+		info.CoreDumped = ws.CoreDump()
+	} else {
+		info.ExitCode = ws.ExitStatus()
+	}
+	return info
+}
 
-	return parts
+// shellStatus 命令的退出状态 $?，与常见 shell 一致，被信号终止时为 128+信号
+func shellStatus(info audit.ExitInfo) int {
+	if info.Signal != 0 {
+		return 128 + info.Signal
+	}
+	return info.ExitCode
 }
 
-// getUsername 获取用户名
+// getUsername 按 UID 查找用户名，查不到时返回 UID。不使用 $USER 等环境变量，
+// 它们可以被调用者随意设置，不能作为身份依据
 func getUsername(uid int) string {
-	return os.Getenv("USER")
+	id := strconv.Itoa(uid)
+	u, err := user.LookupId(id)
+	if err != nil {
+		return id
+	}
+	return u.Username
+}
+
+// userGroups 返回当前用户所属组的 GID 和组名
+func userGroups() []string {
+	gids, _ := os.Getgroups()
+	if len(gids) == 0 {
+		gids = []int{os.Getgid()}
+	}
+	var groups []string
+	for _, gid := range gids {
+		id := strconv.Itoa(gid)
+		groups = append(groups, id)
+		if g, err := user.LookupGroupId(id); err == nil {
+			groups = append(groups, g.Name)
+		}
+	}
+	return groups
 }
 
 // getHostname 获取主机名
@@ -382,4 +834,4 @@ func getHostname() string {
 		return "localhost"
 	}
 	return hostname
-}
\ No newline at end of file
+}
@@ -0,0 +1,97 @@
+package shell
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cevin/shell-auditor/internal/audit"
+	"github.com/cevin/shell-auditor/internal/policy"
+)
+
+// TestPolicyIgnoresUserEnv 用户名按 UID 查找，伪造 $USER 不能换用其他用户的受限模式和规则
+func TestPolicyIgnoresUserEnv(t *testing.T) {
+	real := getUsername(os.Getuid())
+	t.Setenv("USER", "spoofed")
+	t.Setenv("LOGNAME", "spoofed")
+
+	p, err := policy.Parse([]byte(fmt.Sprintf(`{
+		"rules": [
+			{"id": "allow-spoofed", "action": "allow", "commands": ["rm"], "users": ["spoofed"]},
+			{"id": "deny-real", "action": "deny", "commands": ["rm"], "users": [%[1]q]}
+		],
+		"restricted": [
+			{"id": "spoofed", "users": ["spoofed"], "roots": ["/"]},
+			{"id": "real", "users": [%[1]q]}
+		]
+	}`, real)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auditor := audit.NewAuditor(nil, 0)
+	defer auditor.Close()
+	s, err := NewShell(auditor, Config{Policy: p})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.username != real {
+		t.Errorf("username = %q, want %q", s.username, real)
+	}
+	if s.restriction == nil || s.restriction.ID != "real" {
+		t.Errorf("restriction = %+v, want real", s.restriction)
+	}
+
+	c := &execCtx{frame: s.root, stdio: [3]*os.File{os.Stdin, os.Stdout, os.Stderr}}
+	if err := s.checkPolicy(c, "rm", "/bin/rm", []string{"-rf", "/tmp/x"}); err == nil {
+		t.Error("rm was allowed, want denied by deny-real")
+	}
+	events := auditor.GetEvents()
+	if len(events) != 1 {
+		t.Fatalf("got %d events, want 1 policy_violation", len(events))
+	}
+	details, _ := events[0].Details.(audit.PolicyDetails)
+	if events[0].Username != real || details.RuleID != "deny-real" {
+		t.Errorf("violation user %q rule %q, want %q deny-real", events[0].Username, details.RuleID, real)
+	}
+}
+
+// TestExecRedirectOnly 只有重定向的 exec 报错，不打开重定向的文件
+func TestExecRedirectOnly(t *testing.T) {
+	auditor := audit.NewAuditor(nil, 0)
+	defer auditor.Close()
+	s, err := NewShell(auditor, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	stderr, err := os.CreateTemp(dir, "stderr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stderr.Close()
+	c := &execCtx{frame: s.root, stdio: [3]*os.File{os.Stdin, os.Stdout, stderr}}
+	c.dir = dir
+
+	for _, line := range []string{"exec >out", "exec 2>&1", "exec <out"} {
+		status, err := s.runSource(c, "test", line)
+		if err != nil || status != 1 {
+			t.Errorf("%s: status %d, %v, want 1", line, status, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out")); !os.IsNotExist(err) {
+		t.Errorf("redirect target was opened: %v", err)
+	}
+	msg, _ := os.ReadFile(stderr.Name())
+	if !strings.Contains(string(msg), "not supported") {
+		t.Errorf("stderr = %q", msg)
+	}
+
+	if status, err := s.runSource(c, "test", "exec"); err != nil || status != 0 {
+		t.Errorf("exec without arguments: status %d, %v, want 0", status, err)
+	}
+}
@@ -0,0 +1,72 @@
+package shell
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/cevin/shell-auditor/internal/audit"
+)
+
+// detectTransfer 识别 SSH 文件传输在服务端执行的命令：scp -t/-f、sftp-server、
+// rsync --server 以及 git 的 upload-pack/receive-pack。这些命令通常由 ssh 客户端
+// 作为 SSH_ORIGINAL_COMMAND 或子系统发起
+func detectTransfer(argv []string) (audit.FileTransferDetails, bool) {
+	var details audit.FileTransferDetails
+	args := argv[1:]
+
+	switch filepath.Base(argv[0]) {
+	case "scp":
+		// -t 表示接收文件（上传到本机），-f 表示发送文件（从本机下载）
+		details.Protocol = "scp"
+		for _, arg := range args {
+			if !strings.HasPrefix(arg, "-") || arg == "-" {
+				details.Paths = append(details.Paths, arg)
+				continue
+			}
+			if strings.ContainsRune(arg, 't') {
+				details.Direction = "upload"
+			}
+			if strings.ContainsRune(arg, 'f') {
+				details.Direction = "download"
+			}
+		}
+		if details.Direction == "" {
+			// 本机上发起的普通 scp
+			return details, false
+		}
+	case "sftp-server":
+		// sftp 的读写在协议内部进行，无法从命令行判断方向
+		details.Protocol = "sftp"
+	case "rsync":
+		// rsync --server [--sender] <选项> . <路径...>
+		details.Protocol = "rsync"
+		server := false
+		details.Direction = "upload"
+		for i, arg := range args {
+			if arg == "." {
+				details.Paths = args[i+1:]
+				break
+			}
+			switch arg {
+			case "--server":
+				server = true
+			case "--sender":
+				details.Direction = "download"
+			}
+		}
+		if !server {
+			return details, false
+		}
+	case "git-upload-pack", "git-upload-archive":
+		details.Protocol = "git"
+		details.Direction = "download"
+		details.Paths = args
+	case "git-receive-pack":
+		details.Protocol = "git"
+		details.Direction = "upload"
+		details.Paths = args
+	default:
+		return details, false
+	}
+	return details, true
+}
@@ -0,0 +1,181 @@
+// Package tty 提供终端属性、终端大小和伪终端的操作
+package tty
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// 无法获取终端大小时使用的默认值
+const (
+	defaultWidth  = 80
+	defaultHeight = 24
+)
+
+// State 终端属性，用于恢复终端
+type State struct {
+	termios unix.Termios
+}
+
+// OpenPTY 打开一对伪终端，返回主设备和从设备
+func OpenPTY() (master, slave *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open pty: %w", err)
+	}
+
+	var n int
+	err = control(master, func(fd int) error {
+		if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+			return err
+		}
+		n, err = unix.IoctlGetInt(fd, unix.TIOCGPTN)
+		return err
+	})
+	if err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("failed to unlock pty: %w", err)
+	}
+
+	slave, err = os.OpenFile("/dev/pts/"+strconv.Itoa(n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("failed to open pty: %w", err)
+	}
+	return master, slave, nil
+}
+
+// control 在文件描述符上执行操作。File.Fd 会把文件切换为阻塞模式，
+// 之后 Close 无法唤醒阻塞中的 Read，因此这里通过 SyscallConn 访问
+func control(f *os.File, fn func(fd int) error) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var opErr error
+	if err := conn.Control(func(fd uintptr) { opErr = fn(int(fd)) }); err != nil {
+		return err
+	}
+	return opErr
+}
+
+// IsTerminal 判断文件是否为终端
+func IsTerminal(f *os.File) bool {
+	_, err := GetState(f)
+	return err == nil
+}
+
+// Size 返回终端的列数和行数，不是终端时返回默认的 80x24
+func Size(f *os.File) (width, height int) {
+	err := control(f, func(fd int) error {
+		ws, err := unix.IoctlGetWinsize(fd, unix.TIOCGWINSZ)
+		if err != nil {
+			return err
+		}
+		width, height = int(ws.Col), int(ws.Row)
+		return nil
+	})
+	if err != nil || width == 0 || height == 0 {
+		return defaultWidth, defaultHeight
+	}
+	return width, height
+}
+
+// SetSize 设置终端的列数和行数
+func SetSize(f *os.File, width, height int) error {
+	return control(f, func(fd int) error {
+		return unix.IoctlSetWinsize(fd, unix.TIOCSWINSZ, &unix.Winsize{Col: uint16(width), Row: uint16(height)})
+	})
+}
+
+// GetState 读取终端属性
+func GetState(f *os.File) (*State, error) {
+	var st State
+	err := control(f, func(fd int) error {
+		t, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+		if err != nil {
+			return err
+		}
+		st.termios = *t
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+// SetState 设置终端属性
+func SetState(f *os.File, st *State) error {
+	return control(f, func(fd int) error {
+		return unix.IoctlSetTermios(fd, unix.TCSETS, &st.termios)
+	})
+}
+
+// Echo 判断终端是否回显输入，读取失败时返回 false。对伪终端主设备调用时返回从设备的属性
+func Echo(f *os.File) bool {
+	st, err := GetState(f)
+	return err == nil && st.termios.Lflag&unix.ECHO != 0
+}
+
+// DisableSuspend 禁用挂起键（通常为 Ctrl-Z）
+func (s *State) DisableSuspend() {
+	s.termios.Cc[unix.VSUSP] = 0
+}
+
+// MakeRaw 把终端切换到原始模式，返回原来的属性
+func MakeRaw(f *os.File) (*State, error) {
+	old, err := GetState(f)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := old.termios
+	raw.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	raw.Oflag &^= unix.OPOST
+	raw.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	raw.Cflag &^= unix.CSIZE | unix.PARENB
+	raw.Cflag |= unix.CS8
+	raw.Cc[unix.VMIN] = 1
+	raw.Cc[unix.VTIME] = 0
+	if err := SetState(f, &State{termios: raw}); err != nil {
+		return nil, err
+	}
+	return old, nil
+}
+
+// Foreground 返回终端的前台进程组
+func Foreground(f *os.File) (int, error) {
+	var pgid int
+	err := control(f, func(fd int) error {
+		var err error
+		pgid, err = unix.IoctlGetInt(fd, unix.TIOCGPGRP)
+		return err
+	})
+	return pgid, err
+}
+
+// SetForeground 把进程组设置为终端的前台进程组。后台进程组设置时内核会发送 SIGTTOU，
+// 已注册处理函数的信号不能阻止这一点，因此在当前线程上暂时屏蔽 SIGTTOU。
+// 不使用 signal.Ignore，忽略的信号会被子进程继承
+func SetForeground(f *os.File, pgid int) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	var set, old unix.Sigset_t
+	sig := uint(unix.SIGTTOU) - 1
+	set.Val[sig/64] |= 1 << (sig % 64)
+	if err := unix.PthreadSigmask(unix.SIG_BLOCK, &set, &old); err != nil {
+		return fmt.Errorf("failed to block SIGTTOU: %w", err)
+	}
+	defer unix.PthreadSigmask(unix.SIG_SETMASK, &old, nil)
+
+	return control(f, func(fd int) error {
+		return unix.IoctlSetPointerInt(fd, unix.TIOCSPGRP, pgid)
+	})
+}